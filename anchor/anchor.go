@@ -0,0 +1,36 @@
+// Package anchor publishes the daily Merkle roots of attendance records, and
+// events about individual records, to a ledger outside the database, so that
+// records cannot be rewritten without the change showing up against what was
+// published.
+package anchor
+
+import (
+	"context"
+	"time"
+)
+
+// Receipt identifies a submitted root on the backend that stored it
+type Receipt struct {
+	Backend    string    `json:"backend"`
+	TxID       string    `json:"tx_id"`
+	AnchoredAt time.Time `json:"anchored_at"`
+}
+
+// Anchorer submits the Merkle root of a day's records, the day being a
+// YYYY-MM-DD date and the root a hex encoded hash
+type Anchorer interface {
+	Anchor(ctx context.Context, day, root string) (Receipt, error)
+}
+
+// Submitter delivers an event about a record, the topic naming the kind of
+// record and the key identifying it. It returns once the backend has recorded
+// the event, not merely accepted it.
+type Submitter interface {
+	Submit(ctx context.Context, topic, key, payload string) (Receipt, error)
+}
+
+// Client is a backend that takes both roots and events
+type Client interface {
+	Anchorer
+	Submitter
+}
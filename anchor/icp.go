@@ -0,0 +1,452 @@
+package anchor
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// How long the replica accepts a submitted call
+const ingressExpiry = 4 * time.Minute
+
+// Default time between reads of a submitted call's status
+const defaultPollInterval = time.Second
+
+// Calls are made by the anonymous principal, which needs no signature
+var anonymousPrincipal = []byte{0x04}
+
+var ErrInvalidPrincipal = errors.New("invalid principal")
+
+// Default canister method events are submitted to
+const defaultEventMethod = "record_event"
+
+// ICPAnchorer submits roots to the company registry canister as an update
+// call to Method with the day and root as two text arguments, and events as a
+// call to EventMethod with the topic, key and payload. Calls are anonymous;
+// the canister decides whether to accept them.
+//
+// A call succeeds once the canister has replied to it, which is learned by
+// reading the call's status from the replica every PollInterval. The
+// certificate the status comes in is not checked against the subnet's
+// signature, so the replica at Host is trusted to report it truthfully.
+type ICPAnchorer struct {
+	Host         string
+	CanisterID   string
+	Method       string
+	EventMethod  string
+	Client       *http.Client
+	PollInterval time.Duration
+
+	canister []byte
+}
+
+// NewICPAnchorer returns an anchorer calling method on the canister with the
+// textual ID canisterID through the replica at host
+func NewICPAnchorer(host, canisterID, method string) (*ICPAnchorer, error) {
+	canister, err := decodePrincipal(canisterID)
+	if err != nil {
+		return nil, err
+	}
+	return &ICPAnchorer{
+		Host:         strings.TrimRight(host, "/"),
+		CanisterID:   canisterID,
+		Method:       method,
+		EventMethod:  defaultEventMethod,
+		Client:       &http.Client{Timeout: 30 * time.Second},
+		PollInterval: defaultPollInterval,
+		canister:     canister,
+	}, nil
+}
+
+// Anchor submits the root of day
+func (a *ICPAnchorer) Anchor(ctx context.Context, day, root string) (Receipt, error) {
+	return a.call(ctx, a.Method, day, root)
+}
+
+// Submit submits an event
+func (a *ICPAnchorer) Submit(ctx context.Context, topic, key, payload string) (Receipt, error) {
+	return a.call(ctx, a.EventMethod, topic, key, payload)
+}
+
+// call makes an update call with text arguments and waits for the canister
+// to reply. The receipt carries the call's request ID.
+func (a *ICPAnchorer) call(ctx context.Context, method string, args ...string) (Receipt, error) {
+	nonce := make([]byte, 8)
+	if _, err := rand.Read(nonce); err != nil {
+		return Receipt{}, err
+	}
+
+	expiry := time.Now().Add(ingressExpiry)
+	content := map[string]interface{}{
+		"request_type":   "call",
+		"sender":         anonymousPrincipal,
+		"canister_id":    a.canister,
+		"method_name":    method,
+		"arg":            candidTexts(args...),
+		"ingress_expiry": uint64(expiry.UnixNano()),
+		"nonce":          nonce,
+	}
+	requestID := representationHash(content)
+
+	// Accepted only means the replica will try to execute the call
+	resp, err := a.post(ctx, "call", content)
+	if err != nil {
+		return Receipt{}, err
+	}
+	if resp.StatusCode != http.StatusAccepted {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		resp.Body.Close()
+		return Receipt{}, fmt.Errorf("canister call rejected with status %d: %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	resp.Body.Close()
+
+	if err := a.await(ctx, requestID, expiry); err != nil {
+		return Receipt{}, err
+	}
+	return Receipt{Backend: "icp", TxID: hex.EncodeToString(requestID), AnchoredAt: time.Now()}, nil
+}
+
+// post sends content in a self-described CBOR envelope to the canister
+// endpoint, call or read_state
+func (a *ICPAnchorer) post(ctx context.Context, endpoint string, content map[string]interface{}) (*http.Response, error) {
+	body := append([]byte{0xd9, 0xd9, 0xf7}, encodeCBOR(map[string]interface{}{"content": content})...)
+	url := fmt.Sprintf("%s/api/v2/canister/%s/%s", a.Host, a.CanisterID, endpoint)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/cbor")
+	return a.Client.Do(req)
+}
+
+// await polls the status of a submitted call until the canister has replied
+// to it. A rejected call is an error, as is one still unexecuted when its
+// ingress expiry passes, since the replica then drops it.
+func (a *ICPAnchorer) await(ctx context.Context, requestID []byte, expiry time.Time) error {
+	interval := a.PollInterval
+	if interval <= 0 {
+		interval = defaultPollInterval
+	}
+	for {
+		status, message, err := a.requestStatus(ctx, requestID)
+		if err != nil {
+			return err
+		}
+		switch status {
+		case "replied":
+			return nil
+		case "rejected":
+			return fmt.Errorf("canister call rejected: %s", message)
+		case "done":
+			return errors.New("canister call finished but its reply is no longer available")
+		}
+
+		// Received, processing or not known to the replica yet
+		if time.Now().After(expiry) {
+			return fmt.Errorf("canister call not executed before it expired (status %q)", status)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(interval):
+		}
+	}
+}
+
+// requestStatus reads the status of a call, and the reject message of a
+// rejected one, from the replica's certified state. The status is empty
+// while the replica has no record of the call.
+func (a *ICPAnchorer) requestStatus(ctx context.Context, requestID []byte) (string, string, error) {
+	content := map[string]interface{}{
+		"request_type":   "read_state",
+		"sender":         anonymousPrincipal,
+		"paths":          []interface{}{[]interface{}{[]byte("request_status"), requestID}},
+		"ingress_expiry": uint64(time.Now().Add(ingressExpiry).UnixNano()),
+	}
+	resp, err := a.post(ctx, "read_state", content)
+	if err != nil {
+		return "", "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return "", "", fmt.Errorf("reading canister call status failed with status %d: %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	raw, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return "", "", err
+	}
+
+	envelope, err := decodeCBOR(raw)
+	if err != nil {
+		return "", "", err
+	}
+	encoded, _ := field(envelope, "certificate").([]byte)
+	certificate, err := decodeCBOR(encoded)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid certificate: %w", err)
+	}
+	tree := field(certificate, "tree")
+
+	status, _ := lookupTree(tree, []byte("request_status"), requestID, []byte("status"))
+	message, _ := lookupTree(tree, []byte("request_status"), requestID, []byte("reject_message"))
+	return string(status), string(message), nil
+}
+
+// field returns a field of a decoded CBOR map, or nil
+func field(value interface{}, key string) interface{} {
+	m, _ := value.(map[string]interface{})
+	return m[key]
+}
+
+// lookupTree returns the leaf at path in a certificate's hash tree, whose
+// nodes are arrays tagged 0 empty, 1 fork, 2 labeled, 3 leaf and 4 pruned
+func lookupTree(node interface{}, path ...[]byte) ([]byte, bool) {
+	n, _ := node.([]interface{})
+	if len(n) == 0 {
+		return nil, false
+	}
+	tag, _ := n[0].(uint64)
+	if len(path) == 0 {
+		if tag != 3 || len(n) != 2 {
+			return nil, false
+		}
+		leaf, ok := n[1].([]byte)
+		return leaf, ok
+	}
+
+	switch {
+	case tag == 1 && len(n) == 3:
+		if leaf, ok := lookupTree(n[1], path...); ok {
+			return leaf, true
+		}
+		return lookupTree(n[2], path...)
+	case tag == 2 && len(n) == 3:
+		if label, _ := n[1].([]byte); bytes.Equal(label, path[0]) {
+			return lookupTree(n[2], path[1:]...)
+		}
+	}
+	return nil, false
+}
+
+// decodePrincipal turns the textual form of a principal, base32 groups
+// separated by dashes, into its bytes after checking the CRC32 prefix
+func decodePrincipal(text string) ([]byte, error) {
+	raw, err := base32.StdEncoding.WithPadding(base32.NoPadding).
+		DecodeString(strings.ToUpper(strings.ReplaceAll(text, "-", "")))
+	if err != nil || len(raw) < 4 {
+		return nil, ErrInvalidPrincipal
+	}
+	principal := raw[4:]
+	if binary.BigEndian.Uint32(raw[:4]) != crc32.ChecksumIEEE(principal) {
+		return nil, ErrInvalidPrincipal
+	}
+	return principal, nil
+}
+
+// candidTexts encodes the Candid arguments (text, text, ...)
+func candidTexts(values ...string) []byte {
+	b := []byte("DIDL")
+	b = append(b, 0) // No type definitions
+	b = appendLEB128(b, uint64(len(values)))
+	for range values {
+		b = append(b, 0x71) // text
+	}
+	for _, v := range values {
+		b = appendLEB128(b, uint64(len(v)))
+		b = append(b, v...)
+	}
+	return b
+}
+
+func appendLEB128(b []byte, n uint64) []byte {
+	for {
+		c := byte(n & 0x7f)
+		n >>= 7
+		if n == 0 {
+			return append(b, c)
+		}
+		b = append(b, c|0x80)
+	}
+}
+
+// representationHash is the request ID of call content: the hash of the
+// sorted hashes of each field name and value
+func representationHash(fields map[string]interface{}) []byte {
+	pairs := make([][]byte, 0, len(fields))
+	for key, value := range fields {
+		var raw []byte
+		switch v := value.(type) {
+		case []byte:
+			raw = v
+		case string:
+			raw = []byte(v)
+		case uint64:
+			raw = appendLEB128(nil, v)
+		}
+		k := sha256.Sum256([]byte(key))
+		h := sha256.Sum256(raw)
+		pairs = append(pairs, append(k[:], h[:]...))
+	}
+	sort.Slice(pairs, func(i, j int) bool { return bytes.Compare(pairs[i], pairs[j]) < 0 })
+
+	sum := sha256.Sum256(bytes.Join(pairs, nil))
+	return sum[:]
+}
+
+func cborHead(major byte, n uint64) []byte {
+	switch {
+	case n < 24:
+		return []byte{major<<5 | byte(n)}
+	case n <= 0xff:
+		return []byte{major<<5 | 24, byte(n)}
+	case n <= 0xffff:
+		return binary.BigEndian.AppendUint16([]byte{major<<5 | 25}, uint16(n))
+	case n <= 0xffffffff:
+		return binary.BigEndian.AppendUint32([]byte{major<<5 | 26}, uint32(n))
+	default:
+		return binary.BigEndian.AppendUint64([]byte{major<<5 | 27}, n)
+	}
+}
+
+// encodeCBOR encodes the few types an envelope uses, with map keys sorted
+func encodeCBOR(value interface{}) []byte {
+	switch v := value.(type) {
+	case uint64:
+		return cborHead(0, v)
+	case []byte:
+		return append(cborHead(2, uint64(len(v))), v...)
+	case string:
+		return append(cborHead(3, uint64(len(v))), v...)
+	case []interface{}:
+		b := cborHead(4, uint64(len(v)))
+		for _, item := range v {
+			b = append(b, encodeCBOR(item)...)
+		}
+		return b
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		b := cborHead(5, uint64(len(v)))
+		for _, k := range keys {
+			b = append(b, encodeCBOR(k)...)
+			b = append(b, encodeCBOR(v[k])...)
+		}
+		return b
+	}
+	panic(fmt.Sprintf("anchor: cannot encode %T as CBOR", value))
+}
+
+// decodeCBOR decodes a whole CBOR item of the types the replica answers with:
+// unsigned integers, byte and text strings, arrays and maps with text keys.
+// Tags, such as the self-describing one, are skipped.
+func decodeCBOR(data []byte) (interface{}, error) {
+	value, rest, err := decodeCBORItem(data, 0)
+	if err != nil {
+		return nil, err
+	}
+	if len(rest) > 0 {
+		return nil, errors.New("cbor: trailing data")
+	}
+	return value, nil
+}
+
+// Nesting beyond this is refused rather than recursed into
+const maxCBORDepth = 64
+
+var errCBORTruncated = errors.New("cbor: truncated data")
+
+func decodeCBORItem(data []byte, depth int) (interface{}, []byte, error) {
+	if depth > maxCBORDepth {
+		return nil, nil, errors.New("cbor: nested too deeply")
+	}
+	if len(data) == 0 {
+		return nil, nil, errCBORTruncated
+	}
+	major, info := data[0]>>5, data[0]&0x1f
+	data = data[1:]
+
+	var n uint64
+	switch {
+	case info < 24:
+		n = uint64(info)
+	case info <= 27:
+		size := 1 << (info - 24)
+		if len(data) < size {
+			return nil, nil, errCBORTruncated
+		}
+		for _, b := range data[:size] {
+			n = n<<8 | uint64(b)
+		}
+		data = data[size:]
+	default:
+		return nil, nil, fmt.Errorf("cbor: unsupported length encoding %d", info)
+	}
+
+	switch major {
+	case 0:
+		return n, data, nil
+	case 2, 3:
+		if uint64(len(data)) < n {
+			return nil, nil, errCBORTruncated
+		}
+		if major == 3 {
+			return string(data[:n]), data[n:], nil
+		}
+		return append([]byte(nil), data[:n]...), data[n:], nil
+	case 4:
+		if uint64(len(data)) < n {
+			return nil, nil, errCBORTruncated
+		}
+		items := make([]interface{}, 0, n)
+		for i := uint64(0); i < n; i++ {
+			item, rest, err := decodeCBORItem(data, depth+1)
+			if err != nil {
+				return nil, nil, err
+			}
+			items = append(items, item)
+			data = rest
+		}
+		return items, data, nil
+	case 5:
+		if uint64(len(data)) < 2*n {
+			return nil, nil, errCBORTruncated
+		}
+		m := make(map[string]interface{}, n)
+		for i := uint64(0); i < n; i++ {
+			key, rest, err := decodeCBORItem(data, depth+1)
+			if err != nil {
+				return nil, nil, err
+			}
+			k, ok := key.(string)
+			if !ok {
+				return nil, nil, errors.New("cbor: map key is not text")
+			}
+			value, rest, err := decodeCBORItem(rest, depth+1)
+			if err != nil {
+				return nil, nil, err
+			}
+			m[k] = value
+			data = rest
+		}
+		return m, data, nil
+	case 6:
+		return decodeCBORItem(data, depth+1)
+	}
+	return nil, nil, fmt.Errorf("cbor: unsupported major type %d", major)
+}
@@ -0,0 +1,159 @@
+package anchor
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"sync"
+	"time"
+)
+
+// localRecord is a line of the local anchor file, either a root or an event
+type localRecord struct {
+	Day        string    `json:"day,omitempty"`
+	Root       string    `json:"root,omitempty"`
+	Topic      string    `json:"topic,omitempty"`
+	Key        string    `json:"key,omitempty"`
+	Payload    string    `json:"payload,omitempty"`
+	TxID       string    `json:"tx_id"`
+	AnchoredAt time.Time `json:"anchored_at"`
+}
+
+// Event is an event submitted to a LocalAnchorer
+type Event struct {
+	Topic   string
+	Key     string
+	Payload string
+	TxID    string
+}
+
+// LocalAnchorer keeps roots and events in memory and, when it has a path,
+// appends them to a JSON lines file. It stands in for the canister in tests
+// and in deployments without one.
+type LocalAnchorer struct {
+	path   string
+	mu     sync.Mutex
+	roots  map[string]string
+	events []Event
+}
+
+// NewLocalAnchorer returns an anchorer backed by the file at path, loading
+// the roots and events already in it. An empty path keeps them in memory
+// only.
+func NewLocalAnchorer(path string) (*LocalAnchorer, error) {
+	a := &LocalAnchorer{path: path, roots: map[string]string{}}
+	if path == "" {
+		return a, nil
+	}
+
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return a, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var record localRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return nil, err
+		}
+		if record.Topic != "" {
+			a.events = append(a.events, Event{Topic: record.Topic, Key: record.Key, Payload: record.Payload, TxID: record.TxID})
+			continue
+		}
+		a.roots[record.Day] = record.Root
+	}
+	return a, scanner.Err()
+}
+
+// Anchor stores the root of day, replacing an earlier one
+func (a *LocalAnchorer) Anchor(ctx context.Context, day, root string) (Receipt, error) {
+	if err := ctx.Err(); err != nil {
+		return Receipt{}, err
+	}
+
+	sum := sha256.Sum256([]byte(day + ":" + root))
+	record := localRecord{
+		Day:        day,
+		Root:       root,
+		TxID:       hex.EncodeToString(sum[:]),
+		AnchoredAt: time.Now(),
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if err := a.write(record); err != nil {
+		return Receipt{}, err
+	}
+	a.roots[day] = root
+
+	return Receipt{Backend: "local", TxID: record.TxID, AnchoredAt: record.AnchoredAt}, nil
+}
+
+// Submit stores an event
+func (a *LocalAnchorer) Submit(ctx context.Context, topic, key, payload string) (Receipt, error) {
+	if err := ctx.Err(); err != nil {
+		return Receipt{}, err
+	}
+
+	sum := sha256.Sum256([]byte(topic + ":" + key + ":" + payload))
+	record := localRecord{
+		Topic:      topic,
+		Key:        key,
+		Payload:    payload,
+		TxID:       hex.EncodeToString(sum[:]),
+		AnchoredAt: time.Now(),
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if err := a.write(record); err != nil {
+		return Receipt{}, err
+	}
+	a.events = append(a.events, Event{Topic: topic, Key: key, Payload: payload, TxID: record.TxID})
+
+	return Receipt{Backend: "local", TxID: record.TxID, AnchoredAt: record.AnchoredAt}, nil
+}
+
+// write appends record to the file, if there is one
+func (a *LocalAnchorer) write(record localRecord) error {
+	if a.path == "" {
+		return nil
+	}
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(a.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Root returns the root stored for day
+func (a *LocalAnchorer) Root(day string) (string, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	root, ok := a.roots[day]
+	return root, ok
+}
+
+// Events returns the events submitted so far, oldest first
+func (a *LocalAnchorer) Events() []Event {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return append([]Event(nil), a.events...)
+}
@@ -0,0 +1,92 @@
+package anchor
+
+import (
+	"bytes"
+	"crypto/sha256"
+)
+
+// Leaves and inner nodes are hashed with different prefixes so that an inner
+// node can never be passed off as a leaf
+const (
+	leafPrefix = 0x00
+	nodePrefix = 0x01
+)
+
+// ProofStep is a sibling on the path from a leaf to the root. Left reports
+// whether the sibling is hashed on the left.
+type ProofStep struct {
+	Hash []byte
+	Left bool
+}
+
+// LeafHash returns the leaf of a record hash
+func LeafHash(data []byte) []byte {
+	sum := sha256.Sum256(append([]byte{leafPrefix}, data...))
+	return sum[:]
+}
+
+func nodeHash(left, right []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte{nodePrefix})
+	h.Write(left)
+	h.Write(right)
+	return h.Sum(nil)
+}
+
+// nextLevel pairs up the nodes of a level. An odd last node is carried up
+// unchanged rather than paired with itself.
+func nextLevel(level [][]byte) [][]byte {
+	next := make([][]byte, 0, (len(level)+1)/2)
+	for i := 0; i < len(level); i += 2 {
+		if i+1 == len(level) {
+			next = append(next, level[i])
+			continue
+		}
+		next = append(next, nodeHash(level[i], level[i+1]))
+	}
+	return next
+}
+
+// MerkleRoot returns the root of the tree over leaves, or nil when there are
+// none
+func MerkleRoot(leaves [][]byte) []byte {
+	if len(leaves) == 0 {
+		return nil
+	}
+	level := leaves
+	for len(level) > 1 {
+		level = nextLevel(level)
+	}
+	return level[0]
+}
+
+// MerkleProof returns the path from the leaf at index to the root
+func MerkleProof(leaves [][]byte, index int) []ProofStep {
+	if index < 0 || index >= len(leaves) {
+		return nil
+	}
+	var path []ProofStep
+	level := leaves
+	for len(level) > 1 {
+		sibling := index ^ 1
+		if sibling < len(level) {
+			path = append(path, ProofStep{Hash: level[sibling], Left: sibling < index})
+		}
+		level = nextLevel(level)
+		index /= 2
+	}
+	return path
+}
+
+// VerifyProof reports whether path leads from leaf to root
+func VerifyProof(leaf []byte, path []ProofStep, root []byte) bool {
+	node := leaf
+	for _, step := range path {
+		if step.Left {
+			node = nodeHash(step.Hash, node)
+		} else {
+			node = nodeHash(node, step.Hash)
+		}
+	}
+	return bytes.Equal(node, root)
+}
@@ -0,0 +1,99 @@
+// Package auth issues and verifies the access tokens of the API. Every token is
+// signed with the configured JWT secret and carries the same claims, which
+// the middleware stores in the request context for handlers to read.
+package auth
+
+import (
+	"errors"
+	"time"
+
+	"dapp_timekeeping/config"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+// Used when TOKEN_EXPIRY is not a valid duration
+const defaultTokenExpiry = 15 * time.Minute
+
+var ErrInvalidToken = errors.New("invalid or expired token")
+
+// Claims of an access token. The subject is the user ID.
+type Claims struct {
+	Role string `json:"role"`
+	jwt.RegisteredClaims
+}
+
+// UserID returns the user the token was issued to
+func (c *Claims) UserID() string {
+	return c.Subject
+}
+
+func secret() []byte {
+	return []byte(config.AppConfig.JWTSecret)
+}
+
+func tokenExpiry() time.Duration {
+	expiry, err := time.ParseDuration(config.AppConfig.TokenExpiryDuration)
+	if err != nil || expiry <= 0 {
+		return defaultTokenExpiry
+	}
+	return expiry
+}
+
+// IssueToken signs an access token for the user
+func IssueToken(userID, role string) (string, *Claims, error) {
+	return IssueTokenWithExpiry(userID, role, tokenExpiry())
+}
+
+// IssueTokenWithExpiry signs a token valid for expiry, for subjects such as
+// kiosks whose tokens outlive a user session
+func IssueTokenWithExpiry(subject, role string, expiry time.Duration) (string, *Claims, error) {
+	now := time.Now()
+	claims := &Claims{
+		Role: role,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   subject,
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(expiry)),
+			ID:        uuid.New().String(),
+		},
+	}
+
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(secret())
+	if err != nil {
+		return "", nil, err
+	}
+	return token, claims, nil
+}
+
+// ParseToken verifies an access token and returns its claims
+func ParseToken(token string) (*Claims, error) {
+	claims := &Claims{}
+	_, err := jwt.ParseWithClaims(token, claims, func(t *jwt.Token) (interface{}, error) {
+		return secret(), nil
+	},
+		jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+	)
+	if err != nil || claims.ID == "" {
+		return nil, ErrInvalidToken
+	}
+	return claims, nil
+}
+
+// SetClaims stores the caller's claims in the request context. The user ID
+// and role are also stored on their own for handlers that only need those.
+func SetClaims(c *fiber.Ctx, claims *Claims) {
+	c.Locals("claims", claims)
+	c.Locals("user_id", claims.UserID())
+	c.Locals("role", claims.Role)
+}
+
+// ClaimsFrom returns the claims of the authenticated caller
+func ClaimsFrom(c *fiber.Ctx) (*Claims, bool) {
+	claims, ok := c.Locals("claims").(*Claims)
+	return claims, ok && claims != nil
+}
@@ -0,0 +1,70 @@
+package auth
+
+import (
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/decred/dcrd/dcrec/secp256k1/v4/ecdsa"
+	"golang.org/x/crypto/sha3"
+)
+
+var ErrInvalidSignature = errors.New("invalid wallet signature")
+
+// NormalizeAddress returns the lower case 0x form of an Ethereum address, or
+// false when it is not one
+func NormalizeAddress(address string) (string, bool) {
+	address = strings.ToLower(strings.TrimSpace(address))
+	if !strings.HasPrefix(address, "0x") || len(address) != 42 {
+		return "", false
+	}
+	if _, err := hex.DecodeString(address[2:]); err != nil {
+		return "", false
+	}
+	return address, true
+}
+
+func keccak256(data ...[]byte) []byte {
+	h := sha3.NewLegacyKeccak256()
+	for _, d := range data {
+		h.Write(d)
+	}
+	return h.Sum(nil)
+}
+
+// PersonalMessageHash is the EIP-191 hash that personal_sign signs
+func PersonalMessageHash(message string) []byte {
+	prefix := "\x19Ethereum Signed Message:\n" + strconv.Itoa(len(message))
+	return keccak256([]byte(prefix), []byte(message))
+}
+
+// RecoverAddress returns the address whose key produced an EIP-191
+// personal_sign signature of message. The signature is the hex encoded
+// 65 bytes R || S || V, with V either 0/1 or 27/28.
+func RecoverAddress(message, signature string) (string, error) {
+	sig, err := hex.DecodeString(strings.TrimPrefix(strings.TrimSpace(signature), "0x"))
+	if err != nil || len(sig) != 65 {
+		return "", ErrInvalidSignature
+	}
+	v := sig[64]
+	if v >= 27 {
+		v -= 27
+	}
+	if v > 1 {
+		return "", ErrInvalidSignature
+	}
+
+	// ecdsa.RecoverCompact expects V first, offset by 27 for uncompressed keys
+	compact := make([]byte, 65)
+	compact[0] = 27 + v
+	copy(compact[1:], sig[:64])
+	key, _, err := ecdsa.RecoverCompact(compact, PersonalMessageHash(message))
+	if err != nil {
+		return "", ErrInvalidSignature
+	}
+
+	// The address is the last 20 bytes of the hash of the uncompressed key
+	// without its 0x04 prefix
+	return "0x" + hex.EncodeToString(keccak256(key.SerializeUncompressed()[1:])[12:]), nil
+}
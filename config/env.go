@@ -3,6 +3,7 @@ package config
 import (
 	"log"
 	"os"
+	"strconv"
 
 	"github.com/joho/godotenv"
 )
@@ -13,7 +14,31 @@ type Config struct {
 	DBPath              string
 	CanisterID          string
 	ICPHost             string
-	TokenExpiryDuration string
+	TokenExpiryDuration string  // Lifetime of access tokens
+	AnnualLeaveDays     float64 // Leave entitlement per year of service
+	LeaveCarryOverCap   float64 // Unused days that may carry into the next year
+	LatePenaltyAmount   float64 // Late penalty until a company rule version sets one
+	StatutoryRatesFile  string  // Optional JSON file overriding the built-in statutory rates
+	WageRegion          string  // Minimum wage region (I-IV) capping unemployment insurance
+	MaxFailedLogins     int     // Failed password attempts before an account is locked
+	LoginLockoutMinutes float64 // How long a locked account stays locked
+	ReferralCodeTTL     float64 // Hours a referral code stays valid
+	LoginCodeTTL        float64 // Minutes a login code stays valid
+	KioskCodeInterval   int     // Seconds between kiosk QR code rotations
+	KioskTokenTTL       float64 // Hours a kiosk token stays valid
+	RequireKioskCheckIn bool    // Refuse check-ins that do not scan a kiosk QR code
+	RefreshTokenTTL     float64 // Hours a refresh token stays valid
+	WalletChallengeTTL  float64 // Minutes a wallet login challenge stays valid
+	AnchorBackend       string  // Where daily attendance roots are anchored: icp or local
+	AnchorMethod        string  // Canister update method taking the day and root as text
+	AnchorEventMethod   string  // Canister update method taking an event's topic, key and payload as text
+	AnchorFile          string  // File the local backend appends roots to
+	AnchorInterval      float64 // Minutes between anchoring runs, 0 disables the job
+	OutboxInterval      float64 // Seconds between outbox deliveries, 0 disables the worker
+	OutboxMaxAttempts   int     // Delivery attempts before an outbox entry is marked failed
+	OutboxRetryBase     float64 // Seconds before the first retry, doubled on each later one
+	OutboxRetryMax      float64 // Longest wait between retries, in seconds
+	ViolationInterval   float64 // Hours between violation detection runs, 0 disables the job
 }
 
 var (
@@ -32,7 +57,37 @@ func LoadConfig() {
 		DBPath:              getEnvOrDefault("DB_PATH", "company.db"),
 		CanisterID:          mustGetEnv("COMPANY_REGISTRY_CANISTER_ID"),
 		ICPHost:             getEnvOrDefault("ICP_HOST", "https://ic0.app"),
-		TokenExpiryDuration: getEnvOrDefault("TOKEN_EXPIRY", "24h"),
+		TokenExpiryDuration: getEnvOrDefault("TOKEN_EXPIRY", "15m"),
+		AnnualLeaveDays:     getEnvFloatOrDefault("ANNUAL_LEAVE_DAYS", 12),
+		LeaveCarryOverCap:   getEnvFloatOrDefault("LEAVE_CARRY_OVER_CAP", 5),
+		LatePenaltyAmount:   getEnvFloatOrDefault("LATE_PENALTY_AMOUNT", 100000),
+		StatutoryRatesFile:  getEnvOrDefault("STATUTORY_RATES_FILE", ""),
+		WageRegion:          getEnvOrDefault("WAGE_REGION", "I"),
+		MaxFailedLogins:     getEnvIntOrDefault("MAX_FAILED_LOGINS", 5),
+		LoginLockoutMinutes: getEnvFloatOrDefault("LOGIN_LOCKOUT_MINUTES", 15),
+		ReferralCodeTTL:     getEnvFloatOrDefault("REFERRAL_CODE_TTL_HOURS", 72),
+		LoginCodeTTL:        getEnvFloatOrDefault("LOGIN_CODE_TTL_MINUTES", 10),
+		KioskCodeInterval:   getEnvIntOrDefault("KIOSK_CODE_INTERVAL_SECONDS", 30),
+		KioskTokenTTL:       getEnvFloatOrDefault("KIOSK_TOKEN_TTL_HOURS", 24*365),
+		RequireKioskCheckIn: getEnvBoolOrDefault("REQUIRE_KIOSK_CHECKIN", false),
+		RefreshTokenTTL:     getEnvFloatOrDefault("REFRESH_TOKEN_TTL_HOURS", 24*30),
+		WalletChallengeTTL:  getEnvFloatOrDefault("WALLET_CHALLENGE_TTL_MINUTES", 5),
+		AnchorBackend:       getEnvOrDefault("ANCHOR_BACKEND", "icp"),
+		AnchorMethod:        getEnvOrDefault("ANCHOR_METHOD", "anchor_attendance_root"),
+		AnchorEventMethod:   getEnvOrDefault("ANCHOR_EVENT_METHOD", "record_event"),
+		AnchorFile:          getEnvOrDefault("ANCHOR_FILE", "anchors.jsonl"),
+		AnchorInterval:      getEnvFloatOrDefault("ANCHOR_INTERVAL_MINUTES", 60),
+		OutboxInterval:      getEnvFloatOrDefault("OUTBOX_INTERVAL_SECONDS", 10),
+		OutboxMaxAttempts:   getEnvIntOrDefault("OUTBOX_MAX_ATTEMPTS", 8),
+		OutboxRetryBase:     getEnvFloatOrDefault("OUTBOX_RETRY_BASE_SECONDS", 30),
+		OutboxRetryMax:      getEnvFloatOrDefault("OUTBOX_RETRY_MAX_SECONDS", 3600),
+		ViolationInterval:   getEnvFloatOrDefault("VIOLATION_INTERVAL_HOURS", 24),
+	}
+
+	if AppConfig.StatutoryRatesFile != "" {
+		if err := LoadStatutoryRates(AppConfig.StatutoryRatesFile); err != nil {
+			log.Fatalf("Failed to load statutory rates: %v", err)
+		}
 	}
 }
 
@@ -51,3 +106,39 @@ func getEnvOrDefault(key, defaultValue string) string {
 	}
 	return value
 }
+
+func getEnvFloatOrDefault(key string, defaultValue float64) float64 {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	parsed, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		log.Fatalf("Environment variable %s must be a number", key)
+	}
+	return parsed
+}
+
+func getEnvIntOrDefault(key string, defaultValue int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	parsed, err := strconv.Atoi(value)
+	if err != nil {
+		log.Fatalf("Environment variable %s must be an integer", key)
+	}
+	return parsed
+}
+
+func getEnvBoolOrDefault(key string, defaultValue bool) bool {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		log.Fatalf("Environment variable %s must be true or false", key)
+	}
+	return parsed
+}
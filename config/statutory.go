@@ -0,0 +1,217 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+)
+
+// StatutoryRates is one version of the Vietnamese payroll rates: personal
+// income tax (PIT) and the social (BHXH), health (BHYT) and unemployment
+// (BHTN) insurance contributions. A version applies from EffectiveFrom until
+// the next version takes over.
+type StatutoryRates struct {
+	Version             string             `json:"version"`
+	EffectiveFrom       string             `json:"effective_from"`        // YYYY-MM-DD
+	PersonalAllowance   float64            `json:"personal_allowance"`    // Monthly family deduction for the taxpayer
+	DependentAllowance  float64            `json:"dependent_allowance"`   // Monthly family deduction per dependent
+	TaxBrackets         []TaxBracket       `json:"tax_brackets"`          // Progressive monthly brackets, ascending
+	BaseSalary          float64            `json:"base_salary"`           // Statutory base salary (lương cơ sở)
+	RegionalMinimumWage map[string]float64 `json:"regional_minimum_wage"` // Keyed by region I-IV
+	SalaryCapMultiple   float64            `json:"salary_cap_multiple"`   // BHXH/BHYT cap on base salary, BHTN cap on regional minimum
+	ExemptUnpaidDays    float64            `json:"exempt_unpaid_days"`    // No insurance for months with this many unpaid days
+	Employee            ContributionRates  `json:"employee"`
+	Employer            ContributionRates  `json:"employer"`
+}
+
+type TaxBracket struct {
+	UpTo float64 `json:"up_to"` // Upper bound of taxable income, 0 for the top bracket
+	Rate float64 `json:"rate"`
+}
+
+type ContributionRates struct {
+	SocialInsurance       float64 `json:"social_insurance"`
+	HealthInsurance       float64 `json:"health_insurance"`
+	UnemploymentInsurance float64 `json:"unemployment_insurance"`
+}
+
+var vietnamTaxBrackets = []TaxBracket{
+	{UpTo: 5000000, Rate: 0.05},
+	{UpTo: 10000000, Rate: 0.10},
+	{UpTo: 18000000, Rate: 0.15},
+	{UpTo: 32000000, Rate: 0.20},
+	{UpTo: 52000000, Rate: 0.25},
+	{UpTo: 80000000, Rate: 0.30},
+	{Rate: 0.35},
+}
+
+// vietnamTaxBrackets2026 is the five-bracket schedule of the amended Law on
+// Personal Income Tax (109/2025/QH15), which applies to salary income from
+// the 2026 tax period
+var vietnamTaxBrackets2026 = []TaxBracket{
+	{UpTo: 10000000, Rate: 0.05},
+	{UpTo: 30000000, Rate: 0.10},
+	{UpTo: 60000000, Rate: 0.20},
+	{UpTo: 100000000, Rate: 0.30},
+	{Rate: 0.35},
+}
+
+var vietnamEmployeeRates = ContributionRates{SocialInsurance: 0.08, HealthInsurance: 0.015, UnemploymentInsurance: 0.01}
+var vietnamEmployerRates = ContributionRates{SocialInsurance: 0.175, HealthInsurance: 0.03, UnemploymentInsurance: 0.01}
+
+// defaultStatutoryRates are used unless STATUTORY_RATES_FILE provides others
+var defaultStatutoryRates = []StatutoryRates{
+	{
+		Version:             "2020-07",
+		EffectiveFrom:       "2020-07-01",
+		PersonalAllowance:   11000000,
+		DependentAllowance:  4400000,
+		TaxBrackets:         vietnamTaxBrackets,
+		BaseSalary:          1490000,
+		RegionalMinimumWage: map[string]float64{"I": 4420000, "II": 3920000, "III": 3430000, "IV": 3070000},
+		SalaryCapMultiple:   20,
+		ExemptUnpaidDays:    14,
+		Employee:            vietnamEmployeeRates,
+		Employer:            vietnamEmployerRates,
+	},
+	{
+		Version:             "2022-07",
+		EffectiveFrom:       "2022-07-01",
+		PersonalAllowance:   11000000,
+		DependentAllowance:  4400000,
+		TaxBrackets:         vietnamTaxBrackets,
+		BaseSalary:          1490000,
+		RegionalMinimumWage: map[string]float64{"I": 4680000, "II": 4160000, "III": 3640000, "IV": 3250000},
+		SalaryCapMultiple:   20,
+		ExemptUnpaidDays:    14,
+		Employee:            vietnamEmployeeRates,
+		Employer:            vietnamEmployerRates,
+	},
+	{
+		Version:             "2023-07",
+		EffectiveFrom:       "2023-07-01",
+		PersonalAllowance:   11000000,
+		DependentAllowance:  4400000,
+		TaxBrackets:         vietnamTaxBrackets,
+		BaseSalary:          1800000,
+		RegionalMinimumWage: map[string]float64{"I": 4680000, "II": 4160000, "III": 3640000, "IV": 3250000},
+		SalaryCapMultiple:   20,
+		ExemptUnpaidDays:    14,
+		Employee:            vietnamEmployeeRates,
+		Employer:            vietnamEmployerRates,
+	},
+	{
+		Version:             "2024-07",
+		EffectiveFrom:       "2024-07-01",
+		PersonalAllowance:   11000000,
+		DependentAllowance:  4400000,
+		TaxBrackets:         vietnamTaxBrackets,
+		BaseSalary:          2340000,
+		RegionalMinimumWage: map[string]float64{"I": 4960000, "II": 4410000, "III": 3860000, "IV": 3450000},
+		SalaryCapMultiple:   20,
+		ExemptUnpaidDays:    14,
+		Employee:            vietnamEmployeeRates,
+		Employer:            vietnamEmployerRates,
+	},
+	{
+		// Family deductions: Resolution 110/2025/UBTVQH15, from the 2026 tax period.
+		// Regional minimum wages: Decree 293/2025/ND-CP, from 1 January 2026.
+		// Base salary unchanged from Decree 73/2024/ND-CP.
+		Version:             "2026-01",
+		EffectiveFrom:       "2026-01-01",
+		PersonalAllowance:   15500000,
+		DependentAllowance:  6200000,
+		TaxBrackets:         vietnamTaxBrackets2026,
+		BaseSalary:          2340000,
+		RegionalMinimumWage: map[string]float64{"I": 5310000, "II": 4730000, "III": 4140000, "IV": 3700000},
+		SalaryCapMultiple:   20,
+		ExemptUnpaidDays:    14,
+		Employee:            vietnamEmployeeRates,
+		Employer:            vietnamEmployerRates,
+	},
+}
+
+var statutoryRates = defaultStatutoryRates
+
+// LoadStatutoryRates replaces the built-in rate versions with those in a JSON
+// file holding an array of StatutoryRates
+func LoadStatutoryRates(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var versions []StatutoryRates
+	if err := json.Unmarshal(data, &versions); err != nil {
+		return fmt.Errorf("invalid statutory rates file: %w", err)
+	}
+	if len(versions) == 0 {
+		return fmt.Errorf("statutory rates file %s has no versions", path)
+	}
+	for _, v := range versions {
+		if err := v.validate(); err != nil {
+			return fmt.Errorf("version %q: %w", v.Version, err)
+		}
+	}
+
+	// ISO dates sort chronologically as strings
+	sort.Slice(versions, func(i, j int) bool {
+		return versions[i].EffectiveFrom < versions[j].EffectiveFrom
+	})
+	statutoryRates = versions
+	return nil
+}
+
+// validate checks that the version can be applied: a valid start date, tax
+// brackets ascending to an open top bracket, and every rate a fraction
+func (v StatutoryRates) validate() error {
+	if _, err := time.Parse("2006-01-02", v.EffectiveFrom); err != nil {
+		return fmt.Errorf("invalid effective_from %q", v.EffectiveFrom)
+	}
+	if len(v.TaxBrackets) == 0 || v.TaxBrackets[len(v.TaxBrackets)-1].UpTo != 0 {
+		return fmt.Errorf("tax brackets must end with an open top bracket")
+	}
+	previous := 0.0
+	for i, b := range v.TaxBrackets {
+		if b.Rate < 0 || b.Rate > 1 {
+			return fmt.Errorf("tax bracket %d: rate %v is not between 0 and 1", i+1, b.Rate)
+		}
+		if i < len(v.TaxBrackets)-1 && b.UpTo <= previous {
+			return fmt.Errorf("tax bracket %d: up_to %v must be above %v", i+1, b.UpTo, previous)
+		}
+		previous = b.UpTo
+	}
+	for _, r := range []struct {
+		name  string
+		rates ContributionRates
+	}{{"employee", v.Employee}, {"employer", v.Employer}} {
+		for scheme, rate := range map[string]float64{
+			"social_insurance":       r.rates.SocialInsurance,
+			"health_insurance":       r.rates.HealthInsurance,
+			"unemployment_insurance": r.rates.UnemploymentInsurance,
+		} {
+			if rate < 0 || rate > 1 {
+				return fmt.Errorf("%s %s rate %v is not between 0 and 1", r.name, scheme, rate)
+			}
+		}
+	}
+	return nil
+}
+
+// StatutoryRateVersions returns every known rate version, oldest first
+func StatutoryRateVersions() []StatutoryRates {
+	return statutoryRates
+}
+
+// StatutoryRatesFor returns the rate version in force on day
+func StatutoryRatesFor(day time.Time) (StatutoryRates, bool) {
+	date := day.Format("2006-01-02")
+	for i := len(statutoryRates) - 1; i >= 0; i-- {
+		if statutoryRates[i].EffectiveFrom <= date {
+			return statutoryRates[i], true
+		}
+	}
+	return StatutoryRates{}, false
+}
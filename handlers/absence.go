@@ -8,12 +8,16 @@ import (
 
 	"github.com/gofiber/fiber/v2"
 	"go.uber.org/zap"
+	"gorm.io/gorm"
 )
 
 type AbsenceResponse struct {
 	ID          string     `json:"id"`
+	UserID      string     `json:"user_id"`
 	FullName    string     `json:"full_name"`
 	Date        time.Time  `json:"date"`
+	StartDate   time.Time  `json:"start_date"`
+	EndDate     time.Time  `json:"end_date"`
 	Type        string     `json:"type"` // with_permission, without_permission
 	Reason      string     `json:"reason"`
 	Status      string     `json:"status"` // pending, processed
@@ -22,12 +26,16 @@ type AbsenceResponse struct {
 	ProcessedAt *time.Time `json:"processed_at,omitempty"`
 }
 
+type ProcessAbsenceRequest struct {
+	Status string `json:"status" validate:"required,oneof=approved rejected"`
+}
+
 // Statistics response structure
 type EmployeeStatistics struct {
 	LeaveStats struct {
-		WithPermission    int `json:"with_permission"`
-		WithoutPermission int `json:"without_permission"`
-		PendingLeaves     int `json:"pending_leaves"`
+		LeaveWithPermission    int `json:"leave_with_permission"`
+		LeaveWithoutPermission int `json:"leave_without_permission"`
+		PendingLeaves          int `json:"pending_leaves"`
 	} `json:"leave_stats"`
 	ResignStats struct {
 		Approved int `json:"approved"`
@@ -43,7 +51,7 @@ type EmployeeStatistics struct {
 
 func GetAbsences(c *fiber.Ctx) error {
 	// Get query parameters
-	absenceType := c.Query("type")      // with_permission, without_permission, or empty for all
+	absenceType := c.Query("type")      // leave_with_permission, leave_without_permission, ... or empty for all
 	status := c.Query("status")         // pending, processed, or empty for all
 	department := c.Query("department") // department filter
 	startDate := c.Query("start_date")
@@ -72,10 +80,7 @@ func GetAbsences(c *fiber.Ctx) error {
 	}
 
 	// Build the query
-	query := DB.Table("absences").
-		Select("absences.*, users.full_name, users.department, processors.full_name as processor_name").
-		Joins("LEFT JOIN users ON users.id = absences.user_id").
-		Joins("LEFT JOIN users processors ON processors.id = absences.processed_by")
+	query := absenceQuery()
 
 	// Apply filters
 	if absenceType != "" {
@@ -94,11 +99,38 @@ func GetAbsences(c *fiber.Ctx) error {
 		query = query.Where("absences.date <= ?", end)
 	}
 
-	// Execute query
+	response, err := fetchAbsences(query)
+	if err != nil {
+		utils.Logger.Error("Failed to fetch absences", zap.Error(err))
+		return c.Status(500).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrDatabaseError,
+		})
+	}
+
+	return c.JSON(types.APIResponse{
+		Success: true,
+		Data:    response,
+	})
+}
+
+// absenceQuery joins absences with the employee and processor names
+func absenceQuery() *gorm.DB {
+	return DB.Table("absences").
+		Select("absences.*, users.full_name, users.department, processors.full_name as processor_name").
+		Joins("LEFT JOIN users ON users.id = absences.user_id").
+		Joins("LEFT JOIN users processors ON processors.id = absences.processed_by")
+}
+
+// fetchAbsences runs an absenceQuery and transforms the rows to the response format
+func fetchAbsences(query *gorm.DB) ([]AbsenceResponse, error) {
 	var absences []struct {
 		ID            string
+		UserID        string
 		FullName      string
 		Date          time.Time
+		StartDate     time.Time
+		EndDate       time.Time
 		Type          string
 		Reason        string
 		Status        string
@@ -108,11 +140,7 @@ func GetAbsences(c *fiber.Ctx) error {
 	}
 
 	if err := query.Find(&absences).Error; err != nil {
-		utils.Logger.Error("Failed to fetch absences", zap.Error(err))
-		return c.Status(500).JSON(types.APIResponse{
-			Success: false,
-			Error:   types.ErrDatabaseError,
-		})
+		return nil, err
 	}
 
 	// Transform to response format
@@ -120,8 +148,11 @@ func GetAbsences(c *fiber.Ctx) error {
 	for i, abs := range absences {
 		response[i] = AbsenceResponse{
 			ID:          abs.ID,
+			UserID:      abs.UserID,
 			FullName:    abs.FullName,
 			Date:        abs.Date,
+			StartDate:   abs.StartDate,
+			EndDate:     abs.EndDate,
 			Type:        abs.Type,
 			Reason:      abs.Reason,
 			Status:      abs.Status,
@@ -130,6 +161,39 @@ func GetAbsences(c *fiber.Ctx) error {
 			ProcessedAt: abs.ProcessedAt,
 		}
 	}
+	return response, nil
+}
+
+// GetUnprocessedAbsences returns the queue of pending absences, oldest first
+func GetUnprocessedAbsences(c *fiber.Ctx) error {
+	scope, _, err := permissionScope(c, "attendance_approval")
+	if err != nil {
+		utils.Logger.Error("Failed to resolve permissions", zap.Error(err))
+		return c.Status(500).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrDatabaseError,
+		})
+	}
+
+	query := scope.restrict(absenceQuery(), "users.department").
+		Where("absences.status = ?", "pending").
+		Order("absences.created_at ASC")
+
+	if department := c.Query("department"); department != "" {
+		query = query.Where("users.department = ?", department)
+	}
+	if absenceType := c.Query("type"); absenceType != "" {
+		query = query.Where("absences.type = ?", absenceType)
+	}
+
+	response, err := fetchAbsences(query)
+	if err != nil {
+		utils.Logger.Error("Failed to fetch pending absences", zap.Error(err))
+		return c.Status(500).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrDatabaseError,
+		})
+	}
 
 	return c.JSON(types.APIResponse{
 		Success: true,
@@ -137,6 +201,201 @@ func GetAbsences(c *fiber.Ctx) error {
 	})
 }
 
+// GetPendingLeaves returns pending leave and late requests made with permission
+func GetPendingLeaves(c *fiber.Ctx) error {
+	scope, _, err := permissionScope(c, "leave_approval")
+	if err != nil {
+		utils.Logger.Error("Failed to resolve permissions", zap.Error(err))
+		return c.Status(500).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrDatabaseError,
+		})
+	}
+
+	query := scope.restrict(absenceQuery(), "users.department").
+		Where("absences.status = ?", "pending").
+		Where("absences.type IN ?", []string{"leave_with_permission", "late_with_permission"}).
+		Order("absences.start_date ASC")
+
+	response, err := fetchAbsences(query)
+	if err != nil {
+		utils.Logger.Error("Failed to fetch pending leaves", zap.Error(err))
+		return c.Status(500).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrDatabaseError,
+		})
+	}
+
+	return c.JSON(types.APIResponse{
+		Success: true,
+		Data:    response,
+	})
+}
+
+// ProcessAbsence approves or rejects a pending absence
+func ProcessAbsence(c *fiber.Ctx) error {
+	var req ProcessAbsenceRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrInvalidInput,
+		})
+	}
+	if req.Status != "approved" && req.Status != "rejected" {
+		return c.Status(400).JSON(types.APIResponse{
+			Success: false,
+			Error:   "Status must be 'approved' or 'rejected'",
+		})
+	}
+
+	return processAbsence(c, c.Params("id"), req.Status, "attendance_approval")
+}
+
+// ApproveLeave approves a pending absence
+func ApproveLeave(c *fiber.Ctx) error {
+	return processAbsence(c, c.Params("id"), "approved", "leave_approval")
+}
+
+// RejectLeave rejects a pending absence
+func RejectLeave(c *fiber.Ctx) error {
+	return processAbsence(c, c.Params("id"), "rejected", "leave_approval")
+}
+
+// processAbsence moves a pending absence to approved or rejected, recording
+// the authenticated user as the processor. The user must hold permission in
+// the department of the absence's owner.
+func processAbsence(c *fiber.Ctx, id string, status string, permission string) error {
+	processorID, ok := c.Locals("user_id").(string)
+	if !ok || processorID == "" {
+		return c.Status(401).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrUnauthorized,
+		})
+	}
+
+	tx := DB.Begin()
+
+	var absence models.Absence
+	if err := tx.First(&absence, "id = ?", id).Error; err != nil {
+		tx.Rollback()
+		if err == gorm.ErrRecordNotFound {
+			return c.Status(404).JSON(types.APIResponse{
+				Success: false,
+				Error:   "Absence not found",
+			})
+		}
+		utils.Logger.Error("Failed to fetch absence", zap.Error(err))
+		return c.Status(500).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrDatabaseError,
+		})
+	}
+
+	if absence.UserID == processorID {
+		tx.Rollback()
+		return c.Status(403).JSON(types.APIResponse{
+			Success: false,
+			Error:   "Cannot process your own absence",
+		})
+	}
+
+	var owner models.User
+	if err := tx.Select("department").First(&owner, "id = ?", absence.UserID).Error; err != nil {
+		tx.Rollback()
+		utils.Logger.Error("Failed to fetch absence owner", zap.Error(err))
+		return c.Status(500).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrDatabaseError,
+		})
+	}
+	scope, _, err := permissionScope(c, permission)
+	if err != nil {
+		tx.Rollback()
+		utils.Logger.Error("Failed to resolve permissions", zap.Error(err))
+		return c.Status(500).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrDatabaseError,
+		})
+	}
+	if !scope.Allows(owner.Department) {
+		tx.Rollback()
+		return c.Status(403).JSON(types.APIResponse{
+			Success: false,
+			Error:   "Absence is outside your departments",
+		})
+	}
+
+	// Only pending absences can be processed
+	if absence.Status != "pending" {
+		tx.Rollback()
+		return c.Status(409).JSON(types.APIResponse{
+			Success: false,
+			Error:   "Absence has already been " + absence.Status,
+		})
+	}
+
+	now := time.Now()
+	absence.Status = status
+	absence.ProcessedBy = &processorID
+	absence.ProcessedAt = &now
+	absence.UpdatedAt = now
+
+	// Guard on the old status so concurrent processors cannot both succeed
+	result := tx.Model(&absence).
+		Where("status = ?", "pending").
+		Select("status", "processed_by", "processed_at", "updated_at").
+		Updates(&absence)
+	if result.Error != nil {
+		tx.Rollback()
+		utils.Logger.Error("Failed to process absence", zap.Error(result.Error))
+		return c.Status(500).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrDatabaseError,
+		})
+	}
+	if result.RowsAffected == 0 {
+		tx.Rollback()
+		return c.Status(409).JSON(types.APIResponse{
+			Success: false,
+			Error:   "Absence has already been processed",
+		})
+	}
+	if err := appendLedger(tx, "update", absence); err != nil {
+		tx.Rollback()
+		utils.Logger.Error("Failed to record absence in ledger", zap.Error(err))
+		return c.Status(500).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrDatabaseError,
+		})
+	}
+
+	// Approved paid leave is charged against the employee's balance
+	if status == "approved" && absence.Type == "leave_with_permission" {
+		if err := debitLeave(tx, absence); err != nil {
+			tx.Rollback()
+			if err == errInsufficientLeave {
+				return c.Status(409).JSON(types.APIResponse{
+					Success: false,
+					Error:   "Insufficient leave balance",
+				})
+			}
+			utils.Logger.Error("Failed to debit leave balance", zap.Error(err))
+			return c.Status(500).JSON(types.APIResponse{
+				Success: false,
+				Error:   types.ErrDatabaseError,
+			})
+		}
+	}
+
+	tx.Commit()
+
+	return c.JSON(types.APIResponse{
+		Success: true,
+		Message: "Absence " + status + " successfully",
+		Data:    absence,
+	})
+}
+
 // GetEmployeeStatistics returns statistics for root user
 func GetEmployeeStatistics(c *fiber.Ctx) error {
 	var stats EmployeeStatistics
@@ -144,8 +403,8 @@ func GetEmployeeStatistics(c *fiber.Ctx) error {
 	// Get leave statistics from absence table
 	DB.Model(&models.Absence{}).
 		Select(`
-			COUNT(CASE WHEN type = 'with_permission' AND status = 'approved' THEN 1 END) as with_permission,
-			COUNT(CASE WHEN type = 'without_permission' THEN 1 END) as without_permission,
+			COUNT(CASE WHEN type = 'leave_with_permission' AND status = 'approved' THEN 1 END) as leave_with_permission,
+			COUNT(CASE WHEN type = 'leave_without_permission' THEN 1 END) as leave_without_permission,
 			COUNT(CASE WHEN status = 'pending' THEN 1 END) as pending_leaves
 		`).
 		Scan(&stats.LeaveStats)
@@ -0,0 +1,366 @@
+package handlers
+
+import (
+	"context"
+	"encoding/hex"
+	"sync"
+	"time"
+
+	"dapp_timekeeping/anchor"
+	"dapp_timekeeping/models"
+	"dapp_timekeeping/types"
+	"dapp_timekeeping/utils"
+
+	"github.com/gofiber/fiber/v2"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const anchorDayLayout = "2006-01-02"
+
+var (
+	anchorer anchor.Anchorer
+	// Serializes the background job and runs started from the API
+	anchorMu sync.Mutex
+)
+
+type ProofStepResponse struct {
+	Hash     string `json:"hash"`
+	Position string `json:"position"` // left or right, the side the sibling is hashed on
+}
+
+// AttendanceProof shows that an attendance record is a leaf of its day's
+// anchored Merkle root. To verify it, hash the record text with SHA-256 to get
+// record_hash, then leaf_hash = SHA-256(0x00 || record_hash), and fold in each
+// step of path with SHA-256(0x01 || left || right) to arrive at root.
+type AttendanceProof struct {
+	AttendanceID    string              `json:"attendance_id"`
+	Day             string              `json:"day"`
+	Record          string              `json:"record"` // The record's current content, exactly as hashed
+	RecordHash      string              `json:"record_hash"`
+	LeafHash        string              `json:"leaf_hash"`
+	Index           int                 `json:"index"`
+	LeafCount       int                 `json:"leaf_count"`
+	Path            []ProofStepResponse `json:"path"`
+	Root            string              `json:"root"`
+	Backend         string              `json:"backend"`
+	TxID            string              `json:"tx_id"`
+	AnchoredAt      *time.Time          `json:"anchored_at"`
+	RecordUnchanged bool                `json:"record_unchanged"` // Whether the record still hashes to the anchored leaf
+}
+
+// SetAnchorer sets the backend daily roots are submitted to
+func SetAnchorer(a anchor.Anchorer) {
+	anchorer = a
+}
+
+// lastFinishedDay is the latest day whose shifts have all ended, so that
+// its records no longer change
+func lastFinishedDay(now time.Time) time.Time {
+	return startOfDay(now.Add(-maxShiftDuration)).AddDate(0, 0, -1)
+}
+
+func leafHash(recordHash string) ([]byte, error) {
+	raw, err := hex.DecodeString(recordHash)
+	if err != nil {
+		return nil, err
+	}
+	return anchor.LeafHash(raw), nil
+}
+
+// anchorDay builds the Merkle tree of the attendance records checked in on
+// day and submits its root. Days without records are marked empty.
+func anchorDay(ctx context.Context, day time.Time) error {
+	key := day.Format(anchorDayLayout)
+
+	var records []models.Attendance
+	if err := DB.Where("check_in_time >= ? AND check_in_time < ?", day, day.AddDate(0, 0, 1)).
+		Order("check_in_time, id").
+		Find(&records).Error; err != nil {
+		return err
+	}
+
+	leaves := make([]models.AttendanceAnchorLeaf, len(records))
+	hashes := make([][]byte, len(records))
+	for i, record := range records {
+		_, _, recordHash, err := hashLedgerRecord(record)
+		if err != nil {
+			return err
+		}
+		leaves[i] = models.AttendanceAnchorLeaf{Day: key, Position: i, AttendanceID: record.ID, RecordHash: recordHash}
+		if hashes[i], err = leafHash(recordHash); err != nil {
+			return err
+		}
+	}
+
+	now := time.Now()
+	record := models.AttendanceAnchor{
+		Day:       key,
+		LeafCount: len(records),
+		Status:    "pending",
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	if len(records) == 0 {
+		record.Status = "empty"
+	} else {
+		record.Root = hex.EncodeToString(anchor.MerkleRoot(hashes))
+	}
+
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "day"}},
+			DoUpdates: clause.AssignmentColumns([]string{"root", "leaf_count", "status", "error", "updated_at"}),
+		}).Create(&record).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("day = ?", key).Delete(&models.AttendanceAnchorLeaf{}).Error; err != nil {
+			return err
+		}
+		if len(leaves) == 0 {
+			return nil
+		}
+		return tx.Omit(clause.Associations).CreateInBatches(leaves, ledgerBatchSize).Error
+	})
+	if err != nil || record.Status == "empty" {
+		return err
+	}
+
+	receipt, err := anchorer.Anchor(ctx, key, record.Root)
+	if err != nil {
+		if updateErr := DB.Model(&models.AttendanceAnchor{}).Where("day = ?", key).Updates(map[string]interface{}{
+			"status":     "failed",
+			"error":      err.Error(),
+			"updated_at": time.Now(),
+		}).Error; updateErr != nil {
+			utils.Logger.Error("Failed to record anchoring failure", zap.Error(updateErr))
+		}
+		return err
+	}
+
+	return DB.Model(&models.AttendanceAnchor{}).Where("day = ?", key).Updates(map[string]interface{}{
+		"status":      "anchored",
+		"backend":     receipt.Backend,
+		"tx_id":       receipt.TxID,
+		"error":       "",
+		"anchored_at": receipt.AnchoredAt,
+		"updated_at":  time.Now(),
+	}).Error
+}
+
+// anchorDays anchors every finished day that is not anchored yet, starting
+// from the earliest failed one. It stops at the first failure so that days
+// are anchored in order.
+func anchorDays(ctx context.Context, now time.Time) (int, error) {
+	anchorMu.Lock()
+	defer anchorMu.Unlock()
+
+	var start time.Time
+	var open, latest models.AttendanceAnchor
+	if err := DB.Where("status IN ?", []string{"pending", "failed"}).Order("day").Limit(1).Find(&open).Error; err != nil {
+		return 0, err
+	}
+	if err := DB.Order("day DESC").Limit(1).Find(&latest).Error; err != nil {
+		return 0, err
+	}
+	switch {
+	case open.Day != "":
+		day, err := time.ParseInLocation(anchorDayLayout, open.Day, time.Local)
+		if err != nil {
+			return 0, err
+		}
+		start = day
+	case latest.Day != "":
+		day, err := time.ParseInLocation(anchorDayLayout, latest.Day, time.Local)
+		if err != nil {
+			return 0, err
+		}
+		start = day.AddDate(0, 0, 1)
+	default:
+		var first models.Attendance
+		if err := DB.Order("check_in_time").Limit(1).Find(&first).Error; err != nil {
+			return 0, err
+		}
+		if first.ID == "" {
+			return 0, nil
+		}
+		start = startOfDay(first.CheckInTime.In(time.Local))
+	}
+
+	anchored := 0
+	for day := start; !day.After(lastFinishedDay(now)); day = day.AddDate(0, 0, 1) {
+		var done int64
+		if err := DB.Model(&models.AttendanceAnchor{}).
+			Where("day = ? AND status IN ?", day.Format(anchorDayLayout), []string{"anchored", "empty"}).
+			Count(&done).Error; err != nil {
+			return anchored, err
+		}
+		if done > 0 {
+			continue
+		}
+		if err := anchorDay(ctx, day); err != nil {
+			return anchored, err
+		}
+		anchored++
+	}
+	return anchored, nil
+}
+
+// RunAnchorJob anchors finished days every interval until ctx is done
+func RunAnchorJob(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if n, err := anchorDays(ctx, time.Now()); err != nil {
+			utils.Logger.Error("Failed to anchor attendance", zap.Int("anchored", n), zap.Error(err))
+		} else if n > 0 {
+			utils.Logger.Info("Anchored attendance days", zap.Int("days", n))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RunAnchoring anchors the finished days that are not anchored yet
+func RunAnchoring(c *fiber.Ctx) error {
+	if anchorer == nil {
+		return c.Status(503).JSON(types.APIResponse{
+			Success: false,
+			Error:   "Anchoring is not configured",
+		})
+	}
+
+	n, err := anchorDays(c.UserContext(), time.Now())
+	if err != nil {
+		utils.Logger.Error("Failed to anchor attendance", zap.Int("anchored", n), zap.Error(err))
+		return c.Status(502).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrBlockchainError,
+			Data:    fiber.Map{"anchored": n},
+		})
+	}
+
+	return c.JSON(types.APIResponse{
+		Success: true,
+		Data:    fiber.Map{"anchored": n},
+	})
+}
+
+// ListAnchors lists up to limit anchored days, newest first, optionally with
+// the status query parameter
+func ListAnchors(c *fiber.Ctx) error {
+	query := DB.Order("day DESC").Limit(listLimit(c))
+	if status := c.Query("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+
+	var anchors []models.AttendanceAnchor
+	if err := query.Find(&anchors).Error; err != nil {
+		utils.Logger.Error("Failed to fetch anchors", zap.Error(err))
+		return c.Status(500).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrDatabaseError,
+		})
+	}
+
+	return c.JSON(types.APIResponse{
+		Success: true,
+		Data:    anchors,
+	})
+}
+
+// GetAttendanceProof returns the Merkle path of an attendance record to its
+// day's anchored root. Employees can only fetch proofs of their own records.
+func GetAttendanceProof(c *fiber.Ctx) error {
+	userID, _ := c.Locals("user_id").(string)
+	role, _ := c.Locals("role").(string)
+
+	notFound := func(msg string) error {
+		return c.Status(404).JSON(types.APIResponse{
+			Success: false,
+			Error:   msg,
+		})
+	}
+	dbError := func(msg string, err error) error {
+		utils.Logger.Error(msg, zap.Error(err))
+		return c.Status(500).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrDatabaseError,
+		})
+	}
+
+	var attendance models.Attendance
+	if err := DB.First(&attendance, "id = ?", c.Params("id")).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return notFound("Attendance not found")
+		}
+		return dbError("Failed to fetch attendance", err)
+	}
+	if role != "root" && attendance.UserID != userID {
+		return notFound("Attendance not found")
+	}
+
+	var leaf models.AttendanceAnchorLeaf
+	if err := DB.Where("attendance_id = ?", attendance.ID).Limit(1).Find(&leaf).Error; err != nil {
+		return dbError("Failed to fetch anchor leaf", err)
+	}
+	var record models.AttendanceAnchor
+	if leaf.Day != "" {
+		if err := DB.First(&record, "day = ?", leaf.Day).Error; err != nil {
+			return dbError("Failed to fetch anchor", err)
+		}
+	}
+	if record.Status != "anchored" {
+		return notFound("Attendance has not been anchored yet")
+	}
+
+	var leaves []models.AttendanceAnchorLeaf
+	if err := DB.Where("day = ?", record.Day).Order("position").Find(&leaves).Error; err != nil {
+		return dbError("Failed to fetch anchor leaves", err)
+	}
+	hashes := make([][]byte, len(leaves))
+	for i, l := range leaves {
+		var err error
+		if hashes[i], err = leafHash(l.RecordHash); err != nil {
+			return dbError("Invalid anchor leaf", err)
+		}
+	}
+
+	_, _, content, err := ledgerRecordContent(attendance)
+	if err != nil {
+		return dbError("Failed to hash attendance", err)
+	}
+
+	path := []ProofStepResponse{}
+	for _, step := range anchor.MerkleProof(hashes, leaf.Position) {
+		position := "right"
+		if step.Left {
+			position = "left"
+		}
+		path = append(path, ProofStepResponse{Hash: hex.EncodeToString(step.Hash), Position: position})
+	}
+
+	return c.JSON(types.APIResponse{
+		Success: true,
+		Data: AttendanceProof{
+			AttendanceID:    attendance.ID,
+			Day:             record.Day,
+			Record:          string(content),
+			RecordHash:      leaf.RecordHash,
+			LeafHash:        hex.EncodeToString(hashes[leaf.Position]),
+			Index:           leaf.Position,
+			LeafCount:       record.LeafCount,
+			Path:            path,
+			Root:            record.Root,
+			Backend:         record.Backend,
+			TxID:            record.TxID,
+			AnchoredAt:      record.AnchoredAt,
+			RecordUnchanged: sha256Hex(content) == leaf.RecordHash,
+		},
+	})
+}
@@ -0,0 +1,391 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"dapp_timekeeping/models"
+	"dapp_timekeeping/types"
+	"dapp_timekeeping/utils"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type AppealViolationRequest struct {
+	Reason string `json:"reason" validate:"required"`
+}
+
+type ReviewAppealRequest struct {
+	Note string `json:"note"`
+}
+
+// AppealReviewResult is the outcome of accepting an appeal
+type AppealReviewResult struct {
+	Appeal    models.ViolationAppeal `json:"appeal"`
+	Withdrawn int                    `json:"withdrawn_actions"`
+	// Recomputed is set when the pending salary of the month was recalculated
+	Recomputed bool `json:"salary_recomputed"`
+	// Refund is the bonus returning a penalty already paid out
+	Refund *models.PayrollBonus `json:"refund,omitempty"`
+}
+
+var errAppealNotPending = errors.New("appeal not pending")
+
+// AppealViolation lets an employee appeal one of their active violations
+func AppealViolation(c *fiber.Ctx) error {
+	userID, ok := c.Locals("user_id").(string)
+	if !ok || userID == "" {
+		return c.Status(401).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrUnauthorized,
+		})
+	}
+
+	var req AppealViolationRequest
+	if err := c.BodyParser(&req); err != nil || req.Reason == "" {
+		return c.Status(400).JSON(types.APIResponse{
+			Success: false,
+			Error:   "A reason is required",
+		})
+	}
+
+	var violation models.Violation
+	if err := DB.Preload("Appeal").First(&violation, "id = ? AND user_id = ?", c.Params("id"), userID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return c.Status(404).JSON(types.APIResponse{
+				Success: false,
+				Error:   "Violation not found",
+			})
+		}
+		utils.Logger.Error("Failed to fetch violation", zap.Error(err))
+		return c.Status(500).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrDatabaseError,
+		})
+	}
+	if violation.Appeal != nil {
+		return c.Status(409).JSON(types.APIResponse{
+			Success: false,
+			Error:   "This violation has already been appealed",
+		})
+	}
+	if violation.Status != "active" {
+		return c.Status(409).JSON(types.APIResponse{
+			Success: false,
+			Error:   "Only active violations can be appealed",
+		})
+	}
+
+	now := time.Now()
+	appeal := models.ViolationAppeal{
+		ID:          uuid.New().String(),
+		ViolationID: violation.ID,
+		UserID:      userID,
+		Reason:      req.Reason,
+		Status:      "pending",
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+	// The unique violation_id index settles concurrent appeals
+	result := DB.Omit(clause.Associations).Clauses(clause.OnConflict{DoNothing: true}).Create(&appeal)
+	if result.Error != nil {
+		utils.Logger.Error("Failed to create appeal", zap.Error(result.Error))
+		return c.Status(500).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrDatabaseError,
+		})
+	}
+	if result.RowsAffected == 0 {
+		return c.Status(409).JSON(types.APIResponse{
+			Success: false,
+			Error:   "This violation has already been appealed",
+		})
+	}
+
+	return c.JSON(types.APIResponse{
+		Success: true,
+		Message: "Appeal submitted successfully",
+		Data:    appeal,
+	})
+}
+
+// ListAppeals lists appeals of employees in the caller's departments,
+// filtered by the optional status and user_id query parameters
+func ListAppeals(c *fiber.Ctx) error {
+	scope, _, err := permissionScope(c, "violation_management")
+	if err != nil {
+		utils.Logger.Error("Failed to resolve permissions", zap.Error(err))
+		return c.Status(500).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrDatabaseError,
+		})
+	}
+
+	query := DB.Order("created_at")
+	if !scope.All {
+		query = query.Where("user_id IN (?)", scope.restrict(DB.Model(&models.User{}).Select("id"), "department"))
+	}
+	if status := c.Query("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+	if userID := c.Query("user_id"); userID != "" {
+		query = query.Where("user_id = ?", userID)
+	}
+
+	var appeals []models.ViolationAppeal
+	if err := query.Find(&appeals).Error; err != nil {
+		utils.Logger.Error("Failed to fetch appeals", zap.Error(err))
+		return c.Status(500).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrDatabaseError,
+		})
+	}
+
+	return c.JSON(types.APIResponse{
+		Success: true,
+		Data:    appeals,
+	})
+}
+
+// reviewableAppeal loads an appeal the caller may review, writing the error
+// response when there is none
+func reviewableAppeal(c *fiber.Ctx, reviewerID string) (models.ViolationAppeal, bool, error) {
+	var appeal models.ViolationAppeal
+	if err := DB.First(&appeal, "id = ?", c.Params("id")).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return appeal, false, c.Status(404).JSON(types.APIResponse{
+				Success: false,
+				Error:   "Appeal not found",
+			})
+		}
+		utils.Logger.Error("Failed to fetch appeal", zap.Error(err))
+		return appeal, false, c.Status(500).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrDatabaseError,
+		})
+	}
+	if appeal.UserID == reviewerID {
+		return appeal, false, c.Status(403).JSON(types.APIResponse{
+			Success: false,
+			Error:   "You cannot review your own appeal",
+		})
+	}
+
+	var employee models.User
+	if err := DB.First(&employee, "id = ?", appeal.UserID).Error; err != nil {
+		utils.Logger.Error("Failed to fetch employee", zap.Error(err))
+		return appeal, false, c.Status(500).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrDatabaseError,
+		})
+	}
+	scope, _, err := permissionScope(c, "violation_management")
+	if err != nil {
+		utils.Logger.Error("Failed to resolve permissions", zap.Error(err))
+		return appeal, false, c.Status(500).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrDatabaseError,
+		})
+	}
+	if !scope.Allows(employee.Department) {
+		return appeal, false, c.Status(403).JSON(types.APIResponse{
+			Success: false,
+			Error:   "Employee is outside your departments",
+		})
+	}
+	return appeal, true, nil
+}
+
+// closeAppeal moves a pending appeal to status, failing with
+// errAppealNotPending when it was already reviewed
+func closeAppeal(tx *gorm.DB, appeal *models.ViolationAppeal, status, reviewerID, note string) error {
+	now := time.Now()
+	result := tx.Model(&models.ViolationAppeal{}).
+		Where("id = ? AND status = ?", appeal.ID, "pending").
+		Updates(map[string]interface{}{
+			"status":      status,
+			"reviewed_by": reviewerID,
+			"review_note": note,
+			"reviewed_at": now,
+			"updated_at":  now,
+		})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return errAppealNotPending
+	}
+	appeal.Status = status
+	appeal.ReviewedBy = &reviewerID
+	appeal.ReviewNote = note
+	appeal.ReviewedAt = &now
+	appeal.UpdatedAt = now
+	return nil
+}
+
+// refundPenalty takes a voided violation's penalty off the employee's pay.
+// A pending salary for the month is recalculated; when it was already
+// approved the penalty is returned as a bonus in the first month still open.
+func refundPenalty(tx *gorm.DB, violation models.Violation, result *AppealReviewResult) error {
+	day := violation.Date.In(time.Local)
+	monthStart := time.Date(day.Year(), day.Month(), 1, 0, 0, 0, 0, time.Local)
+
+	var salary models.SalaryApproval
+	err := tx.Where("user_id = ? AND month = ?", violation.UserID, monthStart).Limit(1).Find(&salary).Error
+	if err != nil {
+		return err
+	}
+	if salary.Status != "approved" {
+		result.Recomputed, err = recomputeSalary(tx, violation.UserID, monthStart)
+		return err
+	}
+	if violation.Penalty <= 0 {
+		return nil
+	}
+
+	var latest models.SalaryApproval
+	if err := tx.Where("user_id = ? AND status = ?", violation.UserID, "approved").
+		Order("month DESC").
+		Limit(1).
+		Find(&latest).Error; err != nil {
+		return err
+	}
+	month := startOfDay(latest.Month.In(time.Local)).AddDate(0, 1, 0)
+
+	now := time.Now()
+	userID := violation.UserID
+	refund := models.PayrollBonus{
+		ID:        uuid.New().String(),
+		Name:      fmt.Sprintf("Refund of %s penalty on %s (appeal accepted)", violation.Type, day.Format("2006-01-02")),
+		Amount:    violation.Penalty,
+		UserID:    &userID,
+		Month:     &month,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	if err := tx.Create(&refund).Error; err != nil {
+		return err
+	}
+	result.Refund = &refund
+	result.Recomputed, err = recomputeSalary(tx, violation.UserID, month)
+	return err
+}
+
+// AcceptAppeal voids the appealed violation, takes its penalty off payroll
+// and withdraws the disciplinary actions it no longer supports
+func AcceptAppeal(c *fiber.Ctx) error {
+	reviewerID, ok := c.Locals("user_id").(string)
+	if !ok || reviewerID == "" {
+		return c.Status(401).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrUnauthorized,
+		})
+	}
+
+	var req ReviewAppealRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrInvalidInput,
+		})
+	}
+
+	appeal, ok, err := reviewableAppeal(c, reviewerID)
+	if !ok {
+		return err
+	}
+
+	var result AppealReviewResult
+	err = DB.Transaction(func(tx *gorm.DB) error {
+		if err := closeAppeal(tx, &appeal, "accepted", reviewerID, req.Note); err != nil {
+			return err
+		}
+
+		var violation models.Violation
+		if err := tx.First(&violation, "id = ?", appeal.ViolationID).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&models.Violation{}).
+			Where("id = ?", violation.ID).
+			Updates(map[string]interface{}{"status": "voided", "updated_at": time.Now()}).Error; err != nil {
+			return err
+		}
+		violation.Status = "voided"
+
+		var err error
+		if result.Withdrawn, err = withdrawEscalations(tx, violation); err != nil {
+			return err
+		}
+		return refundPenalty(tx, violation, &result)
+	})
+	switch {
+	case errors.Is(err, errAppealNotPending):
+		return c.Status(409).JSON(types.APIResponse{
+			Success: false,
+			Error:   "Appeal has already been reviewed",
+		})
+	case err != nil:
+		utils.Logger.Error("Failed to accept appeal", zap.String("appeal_id", appeal.ID), zap.Error(err))
+		return c.Status(500).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrDatabaseError,
+		})
+	}
+
+	result.Appeal = appeal
+	return c.JSON(types.APIResponse{
+		Success: true,
+		Message: "Appeal accepted",
+		Data:    result,
+	})
+}
+
+// DenyAppeal rejects an appeal; the violation stands
+func DenyAppeal(c *fiber.Ctx) error {
+	reviewerID, ok := c.Locals("user_id").(string)
+	if !ok || reviewerID == "" {
+		return c.Status(401).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrUnauthorized,
+		})
+	}
+
+	var req ReviewAppealRequest
+	if err := c.BodyParser(&req); err != nil || req.Note == "" {
+		return c.Status(400).JSON(types.APIResponse{
+			Success: false,
+			Error:   "A note explaining the decision is required",
+		})
+	}
+
+	appeal, ok, err := reviewableAppeal(c, reviewerID)
+	if !ok {
+		return err
+	}
+
+	err = closeAppeal(DB, &appeal, "denied", reviewerID, req.Note)
+	switch {
+	case errors.Is(err, errAppealNotPending):
+		return c.Status(409).JSON(types.APIResponse{
+			Success: false,
+			Error:   "Appeal has already been reviewed",
+		})
+	case err != nil:
+		utils.Logger.Error("Failed to deny appeal", zap.String("appeal_id", appeal.ID), zap.Error(err))
+		return c.Status(500).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrDatabaseError,
+		})
+	}
+
+	return c.JSON(types.APIResponse{
+		Success: true,
+		Message: "Appeal denied",
+		Data:    appeal,
+	})
+}
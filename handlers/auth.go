@@ -1,125 +1,303 @@
 package handlers
 
 import (
-	"crypto/rand"
-	"encoding/base64"
-	"sync"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
 	"time"
 
+	"dapp_timekeeping/config"
+	"dapp_timekeeping/models"
 	"dapp_timekeeping/types"
 	"dapp_timekeeping/utils"
 
 	"github.com/gofiber/fiber/v2"
-	"github.com/golang-jwt/jwt/v4"
+	"github.com/google/uuid"
 	"go.uber.org/zap"
+	"gorm.io/gorm"
 )
 
-// ActiveCode represents the current active login code
-type ActiveCode struct {
-	Code      string `json:"code"`
-	CreatedAt int64  `json:"created_at"`
+type IssueLoginCodeRequest struct {
+	UserID     string  `json:"user_id" validate:"required"`
+	Device     string  `json:"device"`      // Optional device label, a new code replaces the unused one for the same device
+	TTLMinutes float64 `json:"ttl_minutes"` // Omit for the configured default
 }
 
-// In-memory cache for login code
-var (
-	activeCode ActiveCode
-	codeMutex  sync.RWMutex
-)
+type LoginWithCodeRequest struct {
+	Nickname string `json:"nickname" validate:"required"`
+	Code     string `json:"code" validate:"required"`
+}
 
-// generateNewCode creates a new random code and updates the active code
-func generateNewCode() error {
-	bytes := make([]byte, 6)
-	if _, err := rand.Read(bytes); err != nil {
-		return err
-	}
-	code := base64.URLEncoding.EncodeToString(bytes)[:8]
+// LoginCodeResponse describes an issued code. The code itself is only
+// returned when it is issued.
+type LoginCodeResponse struct {
+	ID        string     `json:"id"`
+	Code      string     `json:"code,omitempty"`
+	UserID    string     `json:"user_id"`
+	Device    string     `json:"device"`
+	Status    string     `json:"status"` // active, used or expired
+	CreatedBy string     `json:"created_by"`
+	ExpiresAt time.Time  `json:"expires_at"`
+	UsedAt    *time.Time `json:"used_at"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// hashLoginCode keys the hash with the JWT secret so that stored hashes of
+// these short codes cannot be brute forced offline
+func hashLoginCode(code string) string {
+	mac := hmac.New(sha256.New, []byte(config.AppConfig.JWTSecret))
+	mac.Write([]byte(strings.ToUpper(strings.TrimSpace(code))))
+	return hex.EncodeToString(mac.Sum(nil))
+}
 
-	codeMutex.Lock()
-	activeCode = ActiveCode{
-		Code:      code,
-		CreatedAt: time.Now().Unix(),
+func loginCodeStatus(code models.LoginCode, now time.Time) string {
+	switch {
+	case code.UsedAt != nil:
+		return "used"
+	case !code.ExpiresAt.After(now):
+		return "expired"
+	default:
+		return "active"
 	}
-	codeMutex.Unlock()
-	return nil
 }
 
-// GetActiveCode returns or generates the active code (root only)
-func GetActiveCode(c *fiber.Ctx) error {
-	if c.Locals("claims").(jwt.MapClaims)["role"] != "root" {
-		return c.Status(403).JSON(types.APIResponse{
+// IssueLoginCode creates a one-time login code for a user (root only)
+func IssueLoginCode(c *fiber.Ctx) error {
+	creatorID, _ := c.Locals("user_id").(string)
+
+	var req IssueLoginCodeRequest
+	if err := c.BodyParser(&req); err != nil || req.UserID == "" || req.TTLMinutes < 0 {
+		return c.Status(400).JSON(types.APIResponse{
 			Success: false,
-			Error:   "Only root can view active code",
+			Error:   types.ErrInvalidInput,
 		})
 	}
+	ttl := req.TTLMinutes
+	if ttl == 0 {
+		ttl = config.AppConfig.LoginCodeTTL
+	}
 
-	codeMutex.RLock()
-	code := activeCode
-	codeMutex.RUnlock()
-
-	if code.Code == "" {
-		if err := generateNewCode(); err != nil {
-			return c.Status(500).JSON(types.APIResponse{
+	var user models.User
+	if err := DB.First(&user, "id = ?", req.UserID).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return c.Status(404).JSON(types.APIResponse{
 				Success: false,
-				Error:   "internal server error",
+				Error:   "User not found",
 			})
 		}
-		code = activeCode
+		utils.Logger.Error("Failed to fetch user", zap.Error(err))
+		return c.Status(500).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrDatabaseError,
+		})
+	}
+	if !canLogIn(user) {
+		return c.Status(409).JSON(types.APIResponse{
+			Success: false,
+			Error:   "User cannot log in",
+		})
+	}
+
+	value, err := randomCode()
+	if err != nil {
+		utils.Logger.Error("Failed to generate login code", zap.Error(err))
+		return c.Status(500).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrInternalError,
+		})
+	}
+
+	now := time.Now()
+	code := models.LoginCode{
+		ID:        uuid.New().String(),
+		UserID:    user.ID,
+		Device:    strings.TrimSpace(req.Device),
+		CodeHash:  hashLoginCode(value),
+		CreatedBy: creatorID,
+		ExpiresAt: now.Add(time.Duration(ttl * float64(time.Minute))),
+		CreatedAt: now,
+	}
+	err = DB.Transaction(func(tx *gorm.DB) error {
+		// Drop the unused code for the same device and any expired ones
+		if err := tx.Where("user_id = ? AND used_at IS NULL AND (device = ? OR expires_at <= ?)", user.ID, code.Device, now).
+			Delete(&models.LoginCode{}).Error; err != nil {
+			return err
+		}
+		return tx.Omit("User").Create(&code).Error
+	})
+	if err != nil {
+		utils.Logger.Error("Failed to save login code", zap.Error(err))
+		return c.Status(500).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrDatabaseError,
+		})
 	}
 
 	return c.JSON(types.APIResponse{
 		Success: true,
-		Data:    code,
+		Message: "Login code issued, it is shown only once",
+		Data: LoginCodeResponse{
+			ID:        code.ID,
+			Code:      value,
+			UserID:    code.UserID,
+			Device:    code.Device,
+			Status:    loginCodeStatus(code, now),
+			CreatedBy: code.CreatedBy,
+			ExpiresAt: code.ExpiresAt,
+			CreatedAt: code.CreatedAt,
+		},
 	})
 }
 
-// LoginWithCode handles employee login using generated code
-func LoginWithCode(c *fiber.Ctx) error {
-	var req struct {
-		Code string `json:"code" validate:"required"`
+// ListLoginCodes lists issued login codes, newest first, optionally for the
+// user_id query parameter
+func ListLoginCodes(c *fiber.Ctx) error {
+	query := DB.Order("created_at DESC")
+	if userID := c.Query("user_id"); userID != "" {
+		query = query.Where("user_id = ?", userID)
 	}
-	if err := c.BodyParser(&req); err != nil {
+
+	var codes []models.LoginCode
+	if err := query.Find(&codes).Error; err != nil {
+		utils.Logger.Error("Failed to fetch login codes", zap.Error(err))
+		return c.Status(500).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrDatabaseError,
+		})
+	}
+
+	now := time.Now()
+	response := make([]LoginCodeResponse, len(codes))
+	for i, code := range codes {
+		response[i] = LoginCodeResponse{
+			ID:        code.ID,
+			UserID:    code.UserID,
+			Device:    code.Device,
+			Status:    loginCodeStatus(code, now),
+			CreatedBy: code.CreatedBy,
+			ExpiresAt: code.ExpiresAt.In(time.Local),
+			UsedAt:    code.UsedAt,
+			CreatedAt: code.CreatedAt.In(time.Local),
+		}
+	}
+
+	return c.JSON(types.APIResponse{
+		Success: true,
+		Data:    response,
+	})
+}
+
+// RevokeLoginCode deletes an issued login code
+func RevokeLoginCode(c *fiber.Ctx) error {
+	result := DB.Where("id = ?", c.Params("id")).Delete(&models.LoginCode{})
+	if result.Error != nil {
+		utils.Logger.Error("Failed to delete login code", zap.Error(result.Error))
+		return c.Status(500).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrDatabaseError,
+		})
+	}
+	if result.RowsAffected == 0 {
+		return c.Status(404).JSON(types.APIResponse{
+			Success: false,
+			Error:   "Login code not found",
+		})
+	}
+
+	return c.JSON(types.APIResponse{
+		Success: true,
+		Message: "Login code revoked",
+	})
+}
+
+// LoginWithCode signs a user in with one of their login codes. Wrong codes
+// count towards the same lockout as wrong passwords.
+func LoginWithCode(c *fiber.Ctx) error {
+	var req LoginWithCodeRequest
+	if err := c.BodyParser(&req); err != nil || req.Nickname == "" || req.Code == "" {
 		return c.Status(400).JSON(types.APIResponse{
 			Success: false,
 			Error:   "Invalid request body",
 		})
 	}
 
-	codeMutex.RLock()
-	valid := req.Code == activeCode.Code
-	codeMutex.RUnlock()
-
-	if !valid {
+	invalid := func() error {
 		return c.Status(401).JSON(types.APIResponse{
 			Success: false,
-			Error:   "Invalid login code",
+			Error:   "Invalid or expired login code",
 		})
 	}
 
-	// Generate JWT token
-	token := jwt.New(jwt.SigningMethodHS256)
-	claims := token.Claims.(jwt.MapClaims)
-	claims["role"] = "employee"
-	t, err := token.SignedString([]byte(utils.Config.JWTSecret))
-	if err != nil {
+	var user models.User
+	if err := DB.First(&user, "nickname = ?", req.Nickname).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return invalid()
+		}
+		utils.Logger.Error("Failed to fetch user", zap.Error(err))
 		return c.Status(500).JSON(types.APIResponse{
 			Success: false,
-			Error:   "internal server error",
+			Error:   types.ErrDatabaseError,
 		})
 	}
+	if !canLogIn(user) {
+		return invalid()
+	}
 
-	// Generate new code after successful login
-	if err := generateNewCode(); err != nil {
-		utils.Logger.Error("Failed to generate new code", zap.Error(err))
+	now := time.Now()
+	if isLocked(user, now) {
+		return c.Status(423).JSON(types.APIResponse{
+			Success: false,
+			Error:   "Account is locked after too many failed logins, try again later",
+		})
+	}
+
+	var code models.LoginCode
+	err := DB.Where("user_id = ? AND code_hash = ?", user.ID, hashLoginCode(req.Code)).First(&code).Error
+	if err != nil && err != gorm.ErrRecordNotFound {
+		utils.Logger.Error("Failed to fetch login code", zap.Error(err))
+		return c.Status(500).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrDatabaseError,
+		})
+	}
+
+	// Guard on used_at so the code cannot be used twice concurrently
+	used := false
+	if err == nil && loginCodeStatus(code, now) == "active" {
+		result := DB.Model(&models.LoginCode{}).
+			Where("id = ? AND used_at IS NULL", code.ID).
+			Update("used_at", now)
+		if result.Error != nil {
+			utils.Logger.Error("Failed to use login code", zap.Error(result.Error))
+			return c.Status(500).JSON(types.APIResponse{
+				Success: false,
+				Error:   types.ErrDatabaseError,
+			})
+		}
+		used = result.RowsAffected == 1
+	}
+	if !used {
+		if err := recordFailedLogin(user.ID); err != nil {
+			utils.Logger.Error("Failed to record login attempt", zap.Error(err))
+		}
+		return invalid()
+	}
+	if err := clearFailedLogins(user); err != nil {
+		utils.Logger.Error("Failed to clear failed logins", zap.Error(err))
+	}
+
+	session, err := issueSession(DB, user, "")
+	if err != nil {
+		utils.Logger.Error("Failed to issue token", zap.Error(err))
+		return c.Status(500).JSON(types.APIResponse{
+			Success: false,
+			Error:   "internal server error",
+		})
 	}
 
 	return c.JSON(types.APIResponse{
 		Success: true,
-		Data: map[string]interface{}{
-			"token": t,
-			"newCode": map[string]interface{}{
-				"code":      activeCode.Code,
-				"createdAt": activeCode.CreatedAt,
-			},
-		},
+		Data:    session,
 	})
 }
@@ -0,0 +1,516 @@
+package handlers
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+	_ "time/tzdata" // TZIDs in imported calendars
+
+	"dapp_timekeeping/lunar"
+	"dapp_timekeeping/models"
+	"dapp_timekeeping/types"
+	"dapp_timekeeping/utils"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type CreateCalendarDayRequest struct {
+	Date       string `json:"date" validate:"required"` // YYYY-MM-DD
+	Kind       string `json:"kind" validate:"required"` // holiday, closure or working_day
+	Name       string `json:"name" validate:"required"`
+	Department string `json:"department"` // Company-wide when empty
+}
+
+type LoadDefaultHolidaysRequest struct {
+	Year int `json:"year" validate:"required"`
+}
+
+// CalendarImportResult counts the days of an import
+type CalendarImportResult struct {
+	Created int `json:"created"`
+	Skipped int `json:"skipped"` // Already on the calendar
+}
+
+var calendarKinds = map[string]bool{"holiday": true, "closure": true, "working_day": true}
+
+// Longest event accepted from an iCalendar file, so a malformed end date
+// cannot flood the calendar
+const maxImportedEventDays = 31
+
+// calendarKey indexes calendar days by date and department
+func calendarKey(day time.Time, department string) string {
+	return day.Format("2006-01-02") + "|" + department
+}
+
+// loadCalendar indexes the calendar days between from and to
+func loadCalendar(tx *gorm.DB, from, to time.Time) (map[string]models.CalendarDay, error) {
+	var days []models.CalendarDay
+	if err := tx.Where("date >= ? AND date < ?", startOfDay(from), startOfDay(to).AddDate(0, 0, 1)).
+		Find(&days).Error; err != nil {
+		return nil, err
+	}
+	calendar := make(map[string]models.CalendarDay, len(days))
+	for _, d := range days {
+		calendar[calendarKey(startOfDay(d.Date.In(time.Local)), d.Department)] = d
+	}
+	return calendar, nil
+}
+
+// vietnamHolidays returns the public holidays of the Labour Code for a
+// year. Tết is the last day of the lunar year and the first four of the
+// next; National Day is 2 September and the day before. A holiday on a
+// weekly rest day of the default shift is made up on the next working day
+// (Article 111(3)). The government sets the exact days each year, which can
+// be adjusted on the calendar.
+func vietnamHolidays(year int) []models.CalendarDay {
+	day := func(month time.Month, d int) time.Time {
+		return time.Date(year, month, d, 0, 0, 0, 0, time.Local)
+	}
+	holiday := func(date time.Time, name string) models.CalendarDay {
+		return models.CalendarDay{Date: date, Kind: "holiday", Name: name, Source: "default"}
+	}
+
+	days := []models.CalendarDay{holiday(day(time.January, 1), "New Year's Day")}
+	tet := lunar.NewYear(year, time.Local)
+	days = append(days, holiday(tet.AddDate(0, 0, -1), "Tết Nguyên Đán (New Year's Eve)"))
+	for i := 0; i < 4; i++ {
+		days = append(days, holiday(tet.AddDate(0, 0, i), fmt.Sprintf("Tết Nguyên Đán (day %d)", i+1)))
+	}
+	if hungKings, ok := lunar.ToSolar(year, 3, 10, false, time.Local); ok {
+		days = append(days, holiday(hungKings, "Hùng Kings Commemoration"))
+	}
+	days = append(days,
+		holiday(day(time.April, 30), "Reunification Day"),
+		holiday(day(time.May, 1), "International Labour Day"),
+		holiday(day(time.September, 1), "National Day"),
+		holiday(day(time.September, 2), "National Day"),
+	)
+	sort.Slice(days, func(i, j int) bool { return days[i].Date.Before(days[j].Date) })
+
+	workDays, err := parseWorkDays(defaultShift.WorkDays)
+	if err != nil {
+		return days
+	}
+	taken := make(map[string]bool, len(days))
+	for _, d := range days {
+		taken[d.Date.Format("2006-01-02")] = true
+	}
+	for _, d := range days {
+		if workDays[d.Date.Weekday()] {
+			continue
+		}
+		substitute := d.Date.AddDate(0, 0, 1)
+		for !workDays[substitute.Weekday()] || taken[substitute.Format("2006-01-02")] {
+			substitute = substitute.AddDate(0, 0, 1)
+		}
+		taken[substitute.Format("2006-01-02")] = true
+		days = append(days, holiday(substitute, d.Name+" (substitute day)"))
+	}
+	return days
+}
+
+// icalEvent is an all-day event read from an iCalendar file
+type icalEvent struct {
+	Summary string
+	Start   time.Time
+	End     time.Time // Exclusive
+}
+
+// icalTime parses a DTSTART or DTEND value with its parameters. Dates and
+// floating times are local; UTC times (ending in Z) and times with a TZID are
+// converted to local time, which can move them to another day.
+func icalTime(value, params string) (time.Time, error) {
+	if len(value) == 8 {
+		return time.ParseInLocation("20060102", value, time.Local)
+	}
+	if strings.HasSuffix(value, "Z") {
+		t, err := time.Parse("20060102T150405Z", value)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid date %q", value)
+		}
+		return t.In(time.Local), nil
+	}
+
+	location := time.Local
+	for _, param := range strings.Split(params, ";") {
+		name, tzid, _ := strings.Cut(param, "=")
+		if !strings.EqualFold(name, "TZID") {
+			continue
+		}
+		var err error
+		if location, err = time.LoadLocation(strings.Trim(tzid, `"`)); err != nil {
+			return time.Time{}, fmt.Errorf("unknown time zone %q", tzid)
+		}
+	}
+	t, err := time.ParseInLocation("20060102T150405", value, location)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid date %q", value)
+	}
+	return t.In(time.Local), nil
+}
+
+// icalText unescapes a TEXT value
+func icalText(value string) string {
+	return strings.NewReplacer(`\n`, " ", `\N`, " ", `\,`, ",", `\;`, ";", `\\`, `\`).Replace(value)
+}
+
+// parseICalendar reads the events of an iCalendar (RFC 5545) file as whole
+// days. Events without an end last one day.
+func parseICalendar(r io.Reader) ([]icalEvent, error) {
+	// Unfold continuation lines first
+	var lines []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) && len(lines) > 0 {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		lines = append(lines, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	var events []icalEvent
+	var event *icalEvent
+	for _, line := range lines {
+		name, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		name, params, _ := strings.Cut(name, ";")
+		name = strings.ToUpper(name)
+
+		switch {
+		case name == "BEGIN" && strings.EqualFold(value, "VEVENT"):
+			event = &icalEvent{}
+		case name == "END" && strings.EqualFold(value, "VEVENT") && event != nil:
+			if event.Start.IsZero() {
+				return nil, errors.New("event without DTSTART")
+			}
+			if !event.End.After(event.Start) {
+				event.End = event.Start.AddDate(0, 0, 1)
+			}
+			if event.End.Sub(event.Start) > maxImportedEventDays*24*time.Hour {
+				return nil, fmt.Errorf("event %q is longer than %d days", event.Summary, maxImportedEventDays)
+			}
+			events = append(events, *event)
+			event = nil
+		case event == nil:
+		case name == "SUMMARY":
+			event.Summary = icalText(value)
+		case name == "DTSTART":
+			start, err := icalTime(value, params)
+			if err != nil {
+				return nil, err
+			}
+			event.Start = startOfDay(start)
+		case name == "DTEND":
+			end, err := icalTime(value, params)
+			if err != nil {
+				return nil, err
+			}
+			// The end is exclusive: a timed event ending after midnight covers that day
+			event.End = startOfDay(end)
+			if end.After(event.End) {
+				event.End = event.End.AddDate(0, 0, 1)
+			}
+		}
+	}
+	if len(events) == 0 {
+		return nil, errors.New("no events found")
+	}
+	return events, nil
+}
+
+// addCalendarDays inserts days, skipping dates already on the calendar for
+// the same department
+func addCalendarDays(tx *gorm.DB, days []models.CalendarDay) (CalendarImportResult, error) {
+	var result CalendarImportResult
+	now := time.Now()
+	for i := range days {
+		days[i].ID = uuid.New().String()
+		days[i].CreatedAt = now
+		created := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&days[i])
+		if created.Error != nil {
+			return result, created.Error
+		}
+		if created.RowsAffected == 0 {
+			result.Skipped++
+		} else {
+			result.Created++
+		}
+	}
+	return result, nil
+}
+
+// ListCalendar returns the calendar days of the year query parameter, the
+// current year by default, optionally for one department
+func ListCalendar(c *fiber.Ctx) error {
+	year := c.QueryInt("year", time.Now().Year())
+	from := time.Date(year, 1, 1, 0, 0, 0, 0, time.Local)
+
+	query := DB.Where("date >= ? AND date < ?", from, from.AddDate(1, 0, 0)).Order("date, department")
+	if department := c.Query("department"); department != "" {
+		query = query.Where("department = ?", department)
+	}
+
+	var days []models.CalendarDay
+	if err := query.Find(&days).Error; err != nil {
+		utils.Logger.Error("Failed to fetch calendar", zap.Error(err))
+		return c.Status(500).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrDatabaseError,
+		})
+	}
+
+	return c.JSON(types.APIResponse{
+		Success: true,
+		Data:    days,
+	})
+}
+
+// GetMyCalendar returns the calendar days that apply to the authenticated
+// user's department in the year query parameter
+func GetMyCalendar(c *fiber.Ctx) error {
+	userID, ok := c.Locals("user_id").(string)
+	if !ok || userID == "" {
+		return c.Status(401).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrUnauthorized,
+		})
+	}
+
+	var user models.User
+	if err := DB.First(&user, "id = ?", userID).Error; err != nil {
+		utils.Logger.Error("Failed to fetch user", zap.Error(err))
+		return c.Status(500).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrDatabaseError,
+		})
+	}
+
+	year := c.QueryInt("year", time.Now().Year())
+	from := time.Date(year, 1, 1, 0, 0, 0, 0, time.Local)
+	var all []models.CalendarDay
+	if err := DB.Where("date >= ? AND date < ?", from, from.AddDate(1, 0, 0)).
+		Where("department IN ?", []string{"", user.Department}).
+		Order("date").
+		Find(&all).Error; err != nil {
+		utils.Logger.Error("Failed to fetch calendar", zap.Error(err))
+		return c.Status(500).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrDatabaseError,
+		})
+	}
+
+	// The department's own entry replaces the company-wide one
+	days := make([]models.CalendarDay, 0, len(all))
+	index := make(map[string]int, len(all))
+	for _, d := range all {
+		key := d.Date.In(time.Local).Format("2006-01-02")
+		if i, ok := index[key]; ok {
+			if d.Department != "" {
+				days[i] = d
+			}
+			continue
+		}
+		index[key] = len(days)
+		days = append(days, d)
+	}
+
+	return c.JSON(types.APIResponse{
+		Success: true,
+		Data:    days,
+	})
+}
+
+// CreateCalendarDay adds a holiday, closure or compensatory working day
+func CreateCalendarDay(c *fiber.Ctx) error {
+	var req CreateCalendarDayRequest
+	if err := c.BodyParser(&req); err != nil || req.Name == "" {
+		return c.Status(400).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrInvalidInput,
+		})
+	}
+	if !calendarKinds[req.Kind] {
+		return c.Status(400).JSON(types.APIResponse{
+			Success: false,
+			Error:   "Kind must be holiday, closure or working_day",
+		})
+	}
+	date, err := time.ParseInLocation("2006-01-02", req.Date, time.Local)
+	if err != nil {
+		return c.Status(400).JSON(types.APIResponse{
+			Success: false,
+			Error:   "Invalid date format. Use YYYY-MM-DD",
+		})
+	}
+
+	days := []models.CalendarDay{{
+		Date:       date,
+		Department: req.Department,
+		Kind:       req.Kind,
+		Name:       req.Name,
+		Source:     "manual",
+	}}
+	result, err := addCalendarDays(DB, days)
+	if err != nil {
+		utils.Logger.Error("Failed to create calendar day", zap.Error(err))
+		return c.Status(500).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrDatabaseError,
+		})
+	}
+	if result.Created == 0 {
+		return c.Status(409).JSON(types.APIResponse{
+			Success: false,
+			Error:   "The calendar already has an entry on that date",
+		})
+	}
+
+	return c.JSON(types.APIResponse{
+		Success: true,
+		Message: "Calendar day created successfully",
+		Data:    days[0],
+	})
+}
+
+// DeleteCalendarDay removes a calendar day
+func DeleteCalendarDay(c *fiber.Ctx) error {
+	result := DB.Delete(&models.CalendarDay{}, "id = ?", c.Params("id"))
+	if result.Error != nil {
+		utils.Logger.Error("Failed to delete calendar day", zap.Error(result.Error))
+		return c.Status(500).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrDatabaseError,
+		})
+	}
+	if result.RowsAffected == 0 {
+		return c.Status(404).JSON(types.APIResponse{
+			Success: false,
+			Error:   "Calendar day not found",
+		})
+	}
+
+	return c.JSON(types.APIResponse{
+		Success: true,
+		Message: "Calendar day deleted successfully",
+	})
+}
+
+// LoadDefaultHolidays adds Vietnam's public holidays for a year, keeping
+// any entry already on those dates
+func LoadDefaultHolidays(c *fiber.Ctx) error {
+	var req LoadDefaultHolidaysRequest
+	if err := c.BodyParser(&req); err != nil || req.Year < 1900 || req.Year > 2199 {
+		return c.Status(400).JSON(types.APIResponse{
+			Success: false,
+			Error:   "year must be between 1900 and 2199",
+		})
+	}
+
+	var result CalendarImportResult
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		result, err = addCalendarDays(tx, vietnamHolidays(req.Year))
+		return err
+	})
+	if err != nil {
+		utils.Logger.Error("Failed to load default holidays", zap.Error(err))
+		return c.Status(500).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrDatabaseError,
+		})
+	}
+
+	return c.JSON(types.APIResponse{
+		Success: true,
+		Message: "Default holidays loaded",
+		Data:    result,
+	})
+}
+
+// ImportCalendar adds the events of an iCalendar file, uploaded as the file
+// form field or sent as the request body. Every day an event covers becomes
+// a calendar day of the kind query parameter, holiday by default, for the
+// optional department.
+func ImportCalendar(c *fiber.Ctx) error {
+	kind := c.Query("kind", "holiday")
+	if !calendarKinds[kind] {
+		return c.Status(400).JSON(types.APIResponse{
+			Success: false,
+			Error:   "Kind must be holiday, closure or working_day",
+		})
+	}
+	department := c.Query("department")
+
+	var data io.Reader = bytes.NewReader(c.Body())
+	if header, err := c.FormFile("file"); err == nil {
+		file, err := header.Open()
+		if err != nil {
+			return c.Status(400).JSON(types.APIResponse{
+				Success: false,
+				Error:   types.ErrInvalidInput,
+			})
+		}
+		defer file.Close()
+		data = file
+	}
+
+	events, err := parseICalendar(data)
+	if err != nil {
+		return c.Status(400).JSON(types.APIResponse{
+			Success: false,
+			Error:   "Invalid iCalendar file: " + err.Error(),
+		})
+	}
+
+	var days []models.CalendarDay
+	for _, e := range events {
+		name := e.Summary
+		if name == "" {
+			name = "Imported"
+		}
+		for d := e.Start; d.Before(e.End); d = d.AddDate(0, 0, 1) {
+			days = append(days, models.CalendarDay{
+				Date:       d,
+				Department: department,
+				Kind:       kind,
+				Name:       name,
+				Source:     "ical",
+			})
+		}
+	}
+
+	var result CalendarImportResult
+	err = DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		result, err = addCalendarDays(tx, days)
+		return err
+	})
+	if err != nil {
+		utils.Logger.Error("Failed to import calendar", zap.Error(err))
+		return c.Status(500).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrDatabaseError,
+		})
+	}
+
+	return c.JSON(types.APIResponse{
+		Success: true,
+		Message: "Calendar imported",
+		Data:    result,
+	})
+}
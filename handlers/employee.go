@@ -1,17 +1,20 @@
 package handlers
 
 import (
+	"dapp_timekeeping/config"
 	"dapp_timekeeping/models"
 	"dapp_timekeeping/types"
 	"dapp_timekeeping/utils"
 
+	"math"
+	"sort"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
-	"github.com/golang-jwt/jwt/v4"
 	"github.com/google/uuid"
 	"go.uber.org/zap"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type AddEmployeeRequest struct {
@@ -31,13 +34,25 @@ type AddEmployeeRequest struct {
 	Nickname      string    `json:"nickname" validate:"required"`
 }
 
-type UpdateEmployeeRequest struct {
-	FullName    string  `json:"full_name"`
-	Email       string  `json:"email"`
-	PhoneNumber string  `json:"phone_number"`
-	Position    string  `json:"position"`
-	Department  string  `json:"department"`
-	Salary      float64 `json:"salary"`
+// updatableEmployeeFields maps each field UpdateEmployee accepts to the
+// permission it needs. Credentials, lockout state and the wallet address are
+// deliberately absent.
+var updatableEmployeeFields = map[string]string{
+	"full_name":            "employee_management",
+	"email":                "employee_management",
+	"phone_number":         "employee_management",
+	"address":              "employee_management",
+	"date_of_birth":        "employee_management",
+	"gender":               "employee_management",
+	"tax_id":               "employee_management",
+	"health_insurance_id":  "employee_management",
+	"social_insurance_id":  "employee_management",
+	"number_of_dependents": "employee_management",
+	"position":             "employee_management",
+	"location":             "employee_management",
+	"department":           "employee_management",
+	"status":               "employee_management",
+	"salary":               "salary_management",
 }
 
 // EmployeeFilters represents the available filter options
@@ -60,18 +75,12 @@ type EmployeeReportData struct {
 }
 
 type EmployeeWorkHoursStats struct {
-	Department   string `json:"department"`
-	EmployeeID   string `json:"employee_id"`
-	EmployeeName string `json:"employee_name"`
-	WorkHours    string `json:"work_hours"` // Format: HH:MM:SS
-}
-
-type CheckInRequest struct {
-	UserID string `json:"user_id" validate:"required"`
-}
-
-type CheckOutRequest struct {
-	UserID string `json:"user_id" validate:"required"`
+	Department    string `json:"department"`
+	EmployeeID    string `json:"employee_id"`
+	EmployeeName  string `json:"employee_name"`
+	WorkHours     string `json:"work_hours"`     // Format: HH:MM:SS, time worked within scheduled shifts
+	ExpectedHours string `json:"expected_hours"` // Format: HH:MM:SS, scheduled time on attended days
+	OvertimeHours string `json:"overtime_hours"` // Format: HH:MM:SS, time worked outside scheduled shifts
 }
 
 type TimeRange string
@@ -83,22 +92,24 @@ const (
 )
 
 type CompanyWorkStats struct {
-	TotalWorkHours  float64 `json:"total_work_hours"` // Total hours for all employees
-	AvgCheckInTime  string  `json:"avg_check_in"`     // Format HH:MM:SS
-	AvgCheckOutTime string  `json:"avg_check_out"`    // Format HH:MM:SS
-	TimeRange       string  `json:"time_range"`       // week/month/year
-	StartDate       string  `json:"start_date"`       // YYYY-MM-DD
-	EndDate         string  `json:"end_date"`         // YYYY-MM-DD
+	TotalWorkHours    float64 `json:"total_work_hours"`                          // Total hours for all employees
+	ExpectedWorkHours float64 `json:"expected_work_hours" gorm:"-"`              // Scheduled hours for all employees
+	AvgCheckInTime    string  `json:"avg_check_in" gorm:"column:avg_check_in"`   // Format HH:MM:SS
+	AvgCheckOutTime   string  `json:"avg_check_out" gorm:"column:avg_check_out"` // Format HH:MM:SS
+	TimeRange         string  `json:"time_range"`                                // week/month/year
+	StartDate         string  `json:"start_date"`                                // YYYY-MM-DD
+	EndDate           string  `json:"end_date"`                                  // YYYY-MM-DD
 }
 
 type TopEmployeeStats struct {
-	EmployeeID      string  `json:"employee_id"`
-	FullName        string  `json:"full_name"`
-	Position        string  `json:"position"`
-	Department      string  `json:"department"`
-	TotalWorkHours  float64 `json:"total_work_hours"`
-	AvgCheckInTime  string  `json:"avg_check_in"`
-	AvgCheckOutTime string  `json:"avg_check_out"`
+	EmployeeID        string  `json:"employee_id"`
+	FullName          string  `json:"full_name"`
+	Position          string  `json:"position"`
+	Department        string  `json:"department"`
+	TotalWorkHours    float64 `json:"total_work_hours"`
+	ExpectedWorkHours float64 `json:"expected_work_hours" gorm:"-"`
+	AvgCheckInTime    string  `json:"avg_check_in" gorm:"column:avg_check_in"`
+	AvgCheckOutTime   string  `json:"avg_check_out" gorm:"column:avg_check_out"`
 }
 
 type EmployeeReportResponse struct {
@@ -115,11 +126,21 @@ func GetAllEmployees(c *fiber.Ctx) error {
 		})
 	}
 
-	query := DB.Model(&models.User{})
+	scope, _, err := permissionScope(c, "employee_management")
+	if err != nil {
+		utils.Logger.Error("Failed to resolve permissions", zap.Error(err))
+		return c.Status(500).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrDatabaseError,
+		})
+	}
+
+	// Only employees of departments the user manages are listed
+	query := scope.restrict(DB.Model(&models.User{}), "users.department")
 
 	// Apply department filter
 	if filters.Department != "" {
-		query = query.Where("department = ?", filters.Department)
+		query = query.Where("users.department = ?", filters.Department)
 	}
 
 	// Apply salary range filter
@@ -173,11 +194,9 @@ func GetAllEmployees(c *fiber.Ctx) error {
 	})
 }
 
+// AddEmployee creates the initial, pending record of an employee. Only root
+// can create them, which the route guard enforces.
 func AddEmployee(c *fiber.Ctx) error {
-	// Get claims from context
-	claims := c.Locals("claims").(jwt.MapClaims)
-	userRole := claims["role"].(string)
-
 	var req AddEmployeeRequest
 	if err := c.BodyParser(&req); err != nil {
 		return c.Status(400).JSON(types.APIResponse{
@@ -186,14 +205,6 @@ func AddEmployee(c *fiber.Ctx) error {
 		})
 	}
 
-	// Only root can create initial employee records
-	if userRole != "root" {
-		return c.Status(403).JSON(types.APIResponse{
-			Success: false,
-			Error:   "Only root can create initial employee records",
-		})
-	}
-
 	// Create new employee with minimal info
 	employee := models.User{
 		ID:          uuid.New().String(),
@@ -220,11 +231,10 @@ func AddEmployee(c *fiber.Ctx) error {
 	})
 }
 
+// UpdateEmployee updates an employee's profile. Only the fields in
+// updatableEmployeeFields are accepted. Salary changes need salary_management
+// and other fields employee_management, in the employee's department.
 func UpdateEmployee(c *fiber.Ctx) error {
-	// Get claims from context
-	claims := c.Locals("claims").(jwt.MapClaims)
-	userRole := claims["role"].(string)
-
 	// Parse employee ID
 	id := c.Params("id")
 	userID, err := uuid.Parse(id)
@@ -244,33 +254,30 @@ func UpdateEmployee(c *fiber.Ctx) error {
 		})
 	}
 
-	// Check for protected fields if not root
-	if userRole != "root" {
-		protectedFields := []string{"nickname", "role", "salary"}
-		for _, field := range protectedFields {
-			if _, exists := updateData[field]; exists {
-				return c.Status(403).JSON(types.APIResponse{
-					Success: false,
-					Error:   "Cannot update protected fields (nickname, role, salary)",
-				})
-			}
+	// The ID, nickname and role identify the account and are never updated here
+	for _, field := range []string{"id", "nickname", "role"} {
+		if _, exists := updateData[field]; exists {
+			return c.Status(403).JSON(types.APIResponse{
+				Success: false,
+				Error:   "Cannot update protected fields (nickname, role)",
+			})
 		}
-	} else {
-		// Root can only update salary
-		allowedFields := []string{"salary"}
-		for field := range updateData {
-			isAllowed := false
-			for _, allowed := range allowedFields {
-				if field == allowed {
-					isAllowed = true
-					break
-				}
-			}
-			if !isAllowed {
-				delete(updateData, field)
-			}
+	}
+	for field := range updateData {
+		if _, ok := updatableEmployeeFields[field]; !ok {
+			return c.Status(400).JSON(types.APIResponse{
+				Success: false,
+				Error:   "Field cannot be updated: " + field,
+			})
 		}
 	}
+	status, statusSet := updateData["status"]
+	if statusSet && status != "active" && status != "left_company" {
+		return c.Status(400).JSON(types.APIResponse{
+			Success: false,
+			Error:   "Status must be active or left_company",
+		})
+	}
 
 	// Start transaction
 	tx := DB.Begin()
@@ -291,6 +298,41 @@ func UpdateEmployee(c *fiber.Ctx) error {
 		})
 	}
 
+	// Pending employees are activated only by redeeming their referral code
+	if statusSet && employee.Status == "pending" {
+		tx.Rollback()
+		return c.Status(409).JSON(types.APIResponse{
+			Success: false,
+			Error:   "Pending employees are activated by redeeming their referral code",
+		})
+	}
+
+	for field, value := range updateData {
+		permission := updatableEmployeeFields[field]
+		scope, _, err := permissionScope(c, permission)
+		if err != nil {
+			tx.Rollback()
+			utils.Logger.Error("Failed to resolve permissions", zap.Error(err))
+			return c.Status(500).JSON(types.APIResponse{
+				Success: false,
+				Error:   types.ErrDatabaseError,
+			})
+		}
+
+		// Moving an employee needs permission in both departments
+		allowed := scope.Allows(employee.Department)
+		if department, ok := value.(string); ok && field == "department" {
+			allowed = allowed && scope.Allows(department)
+		}
+		if !allowed {
+			tx.Rollback()
+			return c.Status(403).JSON(types.APIResponse{
+				Success: false,
+				Error:   "Permission required: " + permission,
+			})
+		}
+	}
+
 	// Update employee
 	if err := tx.Model(&employee).Updates(updateData).Error; err != nil {
 		tx.Rollback()
@@ -301,6 +343,18 @@ func UpdateEmployee(c *fiber.Ctx) error {
 		})
 	}
 
+	// Employees who leave are logged out of every session
+	if status == "left_company" {
+		if err := revokeUserSessions(tx, employee.ID, "left company"); err != nil {
+			tx.Rollback()
+			utils.Logger.Error("Failed to revoke sessions", zap.Error(err))
+			return c.Status(500).JSON(types.APIResponse{
+				Success: false,
+				Error:   types.ErrDatabaseError,
+			})
+		}
+	}
+
 	tx.Commit()
 
 	return c.JSON(types.APIResponse{
@@ -368,47 +422,21 @@ func GetEmployeeTimeStats(c *fiber.Ctx) error {
 }
 
 func GetEmployeeWorkHoursRanking(c *fiber.Ctx) error {
-	var stats []EmployeeWorkHoursStats
-
-	query := `
-		WITH time_seconds AS (
-			SELECT 
-				u.department,
-				u.id as employee_id,
-				u.full_name as employee_name,
-				-- Get actual work duration in seconds
-				(
-					strftime('%s', check_out_time) - 
-					strftime('%s', check_in_time)
-				) as work_duration_seconds,
-				-- Calculate late penalty if any
-				CASE WHEN is_late THEN
-					(
-						strftime('%s', check_in_time) - 
-						strftime('%s', expected_time)
-					)
-				ELSE 0 
-				END as late_duration_seconds
-			FROM users u
-			LEFT JOIN attendances a ON u.id = a.user_id
-			WHERE u.status = 'active'
-		)
-		SELECT 
-			department,
-			employee_id,
-			employee_name,
-			-- Sum total work hours (not average)
-			printf('%02d:%02d:%02d',
-				sum(work_duration_seconds - late_duration_seconds) / 3600,
-				(sum(work_duration_seconds - late_duration_seconds) % 3600) / 60,
-				sum(work_duration_seconds - late_duration_seconds) % 60
-			) as work_hours
-		FROM time_seconds
-		GROUP BY department, employee_id, employee_name
-		ORDER BY sum(work_duration_seconds - late_duration_seconds) DESC
-	`
+	var employees []models.User
+	if err := DB.Where("status = ?", "active").Find(&employees).Error; err != nil {
+		utils.Logger.Error("Failed to fetch employees", zap.Error(err))
+		return c.Status(500).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrDatabaseError,
+		})
+	}
 
-	if err := DB.Raw(query).Scan(&stats).Error; err != nil {
+	// Only completed attendance records count towards work hours
+	var attendances []models.Attendance
+	if err := DB.Joins("JOIN users ON users.id = attendances.user_id").
+		Where("users.status = ? AND attendances.check_out_time > attendances.check_in_time", "active").
+		Order("attendances.check_in_time").
+		Find(&attendances).Error; err != nil {
 		utils.Logger.Error("Failed to fetch work hours ranking", zap.Error(err))
 		return c.Status(500).JSON(types.APIResponse{
 			Success: false,
@@ -416,12 +444,95 @@ func GetEmployeeWorkHoursRanking(c *fiber.Ctx) error {
 		})
 	}
 
+	var resolver *scheduleResolver
+	if len(attendances) > 0 {
+		var err error
+		first := attendances[0].CheckInTime.AddDate(0, 0, -1)
+		last := attendances[len(attendances)-1].CheckInTime
+		if resolver, err = loadScheduleResolver(DB, first, last); err != nil {
+			utils.Logger.Error("Failed to load schedule", zap.Error(err))
+			return c.Status(500).JSON(types.APIResponse{
+				Success: false,
+				Error:   types.ErrDatabaseError,
+			})
+		}
+	}
+
+	departments := make(map[string]string, len(employees))
+	for _, e := range employees {
+		departments[e.ID] = e.Department
+	}
+
+	worked := make(map[string]time.Duration)
+	expected := make(map[string]time.Duration)
+	overtime := make(map[string]time.Duration)
+	for _, a := range attendances {
+		shift, ok := resolver.shiftAt(a.UserID, departments[a.UserID], a.CheckInTime)
+		if !ok {
+			overtime[a.UserID] += a.CheckOutTime.Sub(a.CheckInTime)
+			continue
+		}
+		worked[a.UserID] += shift.WorkedDuration(a.CheckInTime, a.CheckOutTime)
+		expected[a.UserID] += shift.ExpectedDuration()
+		overtime[a.UserID] += a.CheckOutTime.Sub(a.CheckInTime) - shift.Window.overlap(a.CheckInTime, a.CheckOutTime)
+	}
+
+	sort.SliceStable(employees, func(i, j int) bool {
+		wi, wj := worked[employees[i].ID], worked[employees[j].ID]
+		if wi != wj {
+			return wi > wj
+		}
+		return employees[i].FullName < employees[j].FullName
+	})
+
+	stats := make([]EmployeeWorkHoursStats, len(employees))
+	for i, e := range employees {
+		stats[i] = EmployeeWorkHoursStats{
+			Department:    e.Department,
+			EmployeeID:    e.ID,
+			EmployeeName:  e.FullName,
+			WorkHours:     formatHMS(worked[e.ID]),
+			ExpectedHours: formatHMS(expected[e.ID]),
+			OvertimeHours: formatHMS(overtime[e.ID]),
+		}
+	}
+
 	return c.JSON(types.APIResponse{
 		Success: true,
 		Data:    stats,
 	})
 }
 
+// expectedWorkHours sums the scheduled hours of each active employee between
+// start and end, counting only days on or after their onboard date
+func expectedWorkHours(start, end time.Time) (map[string]float64, error) {
+	var employees []models.User
+	if err := DB.Where("status = ?", "active").Find(&employees).Error; err != nil {
+		return nil, err
+	}
+
+	resolver, err := loadScheduleResolver(DB, start, end)
+	if err != nil {
+		return nil, err
+	}
+
+	hours := make(map[string]float64, len(employees))
+	for _, e := range employees {
+		from := startOfDay(start)
+		if onboard := startOfDay(e.OnboardDate.In(time.Local)); onboard.After(from) {
+			from = onboard
+		}
+		var total time.Duration
+		for day := from; !day.After(end); day = day.AddDate(0, 0, 1) {
+			if shift, ok := resolver.shiftOn(e.ID, e.Department, day); ok {
+				total += shift.ExpectedDuration()
+			}
+		}
+		hours[e.ID] = math.Round(total.Hours()*100) / 100
+	}
+	return hours, nil
+}
+
 func GetEmployeeReport(c *fiber.Ctx) error {
 	timeRange := c.Query("time_range", "week")
 
@@ -455,6 +566,7 @@ func GetEmployeeReport(c *fiber.Ctx) error {
 		FROM attendances a
 		JOIN users u ON a.user_id = u.id
 		WHERE u.status = 'active'
+			AND a.check_out_time > a.check_in_time
 			AND date(a.check_in_time) BETWEEN date(?) AND date(?)
 	),
 	time_seconds AS (
@@ -519,13 +631,28 @@ func GetEmployeeReport(c *fiber.Ctx) error {
 		zap.String("raw_check_outs", stats.DebugCheckOuts),
 	)
 
+	// Expected hours come from each employee's schedule, not from punches
+	expectedHours, err := expectedWorkHours(startDate, endDate)
+	if err != nil {
+		utils.Logger.Error("Failed to calculate expected work hours", zap.Error(err))
+		return c.Status(500).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrDatabaseError,
+		})
+	}
+	var totalExpected float64
+	for _, h := range expectedHours {
+		totalExpected += h
+	}
+
 	companyStats := CompanyWorkStats{
-		TotalWorkHours:  stats.TotalWorkHours,
-		AvgCheckInTime:  stats.AvgCheckInTime,
-		AvgCheckOutTime: stats.AvgCheckOutTime,
-		TimeRange:       timeRange,
-		StartDate:       startDate.Format("2006-01-02"),
-		EndDate:         endDate.Format("2006-01-02"),
+		TotalWorkHours:    stats.TotalWorkHours,
+		ExpectedWorkHours: math.Round(totalExpected*100) / 100,
+		AvgCheckInTime:    stats.AvgCheckInTime,
+		AvgCheckOutTime:   stats.AvgCheckOutTime,
+		TimeRange:         timeRange,
+		StartDate:         startDate.Format("2006-01-02"),
+		EndDate:           endDate.Format("2006-01-02"),
 	}
 
 	// Top employees query with similar debug approach
@@ -542,6 +669,7 @@ func GetEmployeeReport(c *fiber.Ctx) error {
 		FROM users u
 		JOIN attendances a ON u.id = a.user_id
 		WHERE u.status = 'active'
+			AND a.check_out_time > a.check_in_time
 			AND date(a.check_in_time) BETWEEN date(?) AND date(?)
 	),
 	time_seconds AS (
@@ -622,6 +750,7 @@ func GetEmployeeReport(c *fiber.Ctx) error {
 	regularTopEmployees := make([]TopEmployeeStats, len(topEmployees))
 	for i, emp := range topEmployees {
 		regularTopEmployees[i] = emp.TopEmployeeStats
+		regularTopEmployees[i].ExpectedWorkHours = expectedHours[emp.EmployeeID]
 	}
 
 	response := EmployeeReportResponse{
@@ -635,120 +764,250 @@ func GetEmployeeReport(c *fiber.Ctx) error {
 	})
 }
 
-// // CheckIn handles employee check-in
-// func CheckIn(c *fiber.Ctx) error {
-// 	var req CheckInRequest
-// 	if err := c.BodyParser(&req); err != nil {
-// 		return c.Status(400).JSON(types.APIResponse{
-// 			Success: false,
-// 			Error:   types.ErrInvalidInput,
-// 		})
-// 	}
-
-// 	// Get current time
-// 	now := time.Now()
-// 	today := now.Format("2006-01-02")
-
-// 	// Check if already checked in today
-// 	var existingAttendance models.Attendance
-// 	err := DB.Where("user_id = ? AND DATE(created_at) = ?", req.UserID, today).First(&existingAttendance).Error
-// 	if err == nil {
-// 		return c.Status(400).JSON(types.APIResponse{
-// 			Success: false,
-// 			Error:   "Already checked in today",
-// 		})
-// 	} else if err != gorm.ErrRecordNotFound { // Only log if it's an unexpected error
-// 		utils.Logger.Error("Failed to check existing attendance", zap.Error(err))
-// 		return c.Status(500).JSON(types.APIResponse{
-// 			Success: false,
-// 			Error:   types.ErrDatabaseError,
-// 		})
-// 	}
-
-// 	// Get expected time (e.g., 9:00 AM)
-// 	expectedTime := time.Date(now.Year(), now.Month(), now.Day(), 9, 0, 0, 0, now.Location())
-
-// 	// Create new attendance record
-// 	attendance := models.Attendance{
-// 		ID:           uuid.New().String(),
-// 		UserID:       req.UserID,
-// 		CheckInTime: now,
-// 		ExpectedTime: expectedTime,
-// 		IsLate:       now.After(expectedTime),
-// 		CreatedAt:    now,
-// 		UpdatedAt:    now,
-// 	}
-
-// 	if err := DB.Create(&attendance).Error; err != nil {
-// 		utils.Logger.Error("Failed to create attendance record", zap.Error(err))
-// 		return c.Status(500).JSON(types.APIResponse{
-// 			Success: false,
-// 			Error:   types.ErrDatabaseError,
-// 		})
-// 	}
-
-// 	return c.JSON(types.APIResponse{
-// 		Success: true,
-// 		Message: "Check-in successful",
-// 		Data:    attendance,
-// 	})
-// }
-
-// // CheckOut handles employee check-out
-// func CheckOut(c *fiber.Ctx) error {
-// 	var req CheckOutRequest
-// 	if err := c.BodyParser(&req); err != nil {
-// 		return c.Status(400).JSON(types.APIResponse{
-// 			Success: false,
-// 			Error:   types.ErrInvalidInput,
-// 		})
-// 	}
-
-// 	// Get current time
-// 	now := time.Now()
-// 	today := now.Format("2006-01-02")
-
-// 	// Find today's attendance record
-// 	var attendance models.Attendance
-// 	err := DB.Where("user_id = ? AND DATE(created_at) = ?", req.UserID, today).First(&attendance).Error
-// 	if err != nil {
-// 		if err == gorm.ErrRecordNotFound {
-// 			// Don't log this expected case
-// 			return c.Status(400).JSON(types.APIResponse{
-// 				Success: false,
-// 				Error:   "No check-in record found for today",
-// 			})
-// 		}
-// 		utils.Logger.Error("Failed to find attendance record", zap.Error(err))
-// 		return c.Status(500).JSON(types.APIResponse{
-// 			Success: false,
-// 			Error:   types.ErrDatabaseError,
-// 		})
-// 	}
-
-// 	// Check if already checked out
-// 	if !attendance.CheckOutTime.IsZero() {
-// 		return c.Status(400).JSON(types.APIResponse{
-// 			Success: false,
-// 			Error:   "Already checked out today",
-// 		})
-// 	}
-
-// 	// Update check-out time
-// 	attendance.CheckOutTime = now
-// 	attendance.UpdatedAt = now
-
-// 	if err := DB.Save(&attendance).Error; err != nil {
-// 		utils.Logger.Error("Failed to update attendance record", zap.Error(err))
-// 		return c.Status(500).JSON(types.APIResponse{
-// 			Success: false,
-// 			Error:   types.ErrDatabaseError,
-// 		})
-// 	}
-
-// 	return c.JSON(types.APIResponse{
-// 		Success: true,
-// 		Message: "Check-out successful",
-// 		Data:    attendance,
-// 	})
-// }
+// maxShiftDuration bounds how long an attendance record may stay open.
+// An open check-in older than this is treated as a missed check-out.
+const maxShiftDuration = 16 * time.Hour
+
+// findOpenAttendance returns the user's latest attendance that has a check-in
+// but no check-out yet.
+func findOpenAttendance(tx *gorm.DB, userID string) (models.Attendance, error) {
+	var attendance models.Attendance
+	err := tx.Where("user_id = ? AND (check_out_time IS NULL OR check_out_time <= ?)", userID, time.Time{}).
+		Order("check_in_time DESC").
+		First(&attendance).Error
+	return attendance, err
+}
+
+// CheckIn handles employee check-in for the authenticated user. It is refused
+// when the company requires check-in at a kiosk.
+func CheckIn(c *fiber.Ctx) error {
+	userID, ok := c.Locals("user_id").(string)
+	if !ok || userID == "" {
+		return c.Status(401).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrUnauthorized,
+		})
+	}
+
+	if config.AppConfig.RequireKioskCheckIn {
+		return c.Status(403).JSON(types.APIResponse{
+			Success: false,
+			Error:   "Check in by scanning the QR code at a kiosk",
+		})
+	}
+
+	return checkIn(c, userID, nil)
+}
+
+// checkIn opens today's attendance record for the user. A kiosk scan, when
+// given, is saved with it and marks the check-in as made at a kiosk.
+func checkIn(c *fiber.Ctx, userID string, scan *models.KioskScan) error {
+	now := time.Now()
+	dayStart := startOfDay(now)
+
+	tx := DB.Begin()
+
+	var user models.User
+	if err := tx.First(&user, "id = ?", userID).Error; err != nil {
+		tx.Rollback()
+		if err == gorm.ErrRecordNotFound {
+			return c.Status(404).JSON(types.APIResponse{
+				Success: false,
+				Error:   "Employee not found",
+			})
+		}
+		return c.Status(500).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrDatabaseError,
+		})
+	}
+	if user.Status != "active" {
+		tx.Rollback()
+		return c.Status(403).JSON(types.APIResponse{
+			Success: false,
+			Error:   "Only active employees can check in",
+		})
+	}
+
+	// Reject a second punch while a check-in is still open
+	open, err := findOpenAttendance(tx, userID)
+	if err == nil {
+		if now.Sub(open.CheckInTime) < maxShiftDuration {
+			tx.Rollback()
+			return c.Status(400).JSON(types.APIResponse{
+				Success: false,
+				Error:   "Already checked in",
+			})
+		}
+		// The previous shift was never closed; leave it without a check-out
+		utils.Logger.Warn("Missed check-out",
+			zap.String("user_id", userID),
+			zap.String("attendance_id", open.ID),
+			zap.Time("check_in_time", open.CheckInTime),
+		)
+	} else if err != gorm.ErrRecordNotFound {
+		tx.Rollback()
+		utils.Logger.Error("Failed to check open attendance", zap.Error(err))
+		return c.Status(500).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrDatabaseError,
+		})
+	}
+
+	// Only one attendance record per day
+	var count int64
+	if err := tx.Model(&models.Attendance{}).
+		Where("user_id = ? AND check_in_time >= ? AND check_in_time < ?", userID, dayStart, dayStart.AddDate(0, 0, 1)).
+		Count(&count).Error; err != nil {
+		tx.Rollback()
+		utils.Logger.Error("Failed to check existing attendance", zap.Error(err))
+		return c.Status(500).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrDatabaseError,
+		})
+	}
+	if count > 0 {
+		tx.Rollback()
+		return c.Status(400).JSON(types.APIResponse{
+			Success: false,
+			Error:   "Already checked in today",
+		})
+	}
+
+	// Expected time comes from the shift in force; off-schedule punches are never late
+	resolver, err := loadScheduleResolver(tx, now.AddDate(0, 0, -1), now)
+	if err != nil {
+		tx.Rollback()
+		utils.Logger.Error("Failed to load schedule", zap.Error(err))
+		return c.Status(500).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrDatabaseError,
+		})
+	}
+	expectedTime := now
+	if shift, ok := resolver.shiftAt(user.ID, user.Department, now); ok {
+		expectedTime = shift.Window.Start
+	}
+	// Lateness is judged by the rules in force on the shift's day
+	rule, err := companyRuleOn(tx, expectedTime)
+	if err != nil {
+		tx.Rollback()
+		utils.Logger.Error("Failed to load company rules", zap.Error(err))
+		return c.Status(500).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrDatabaseError,
+		})
+	}
+
+	method := "app"
+	if scan != nil {
+		method = "kiosk"
+	}
+	attendance := models.Attendance{
+		ID:           uuid.New().String(),
+		UserID:       userID,
+		CheckInTime:  now,
+		ExpectedTime: expectedTime,
+		OnTime:       !now.After(expectedTime.Add(lateGrace(rule))),
+		Method:       method,
+		CreatedAt:    now,
+		UpdatedAt:    now,
+	}
+
+	if err := tx.Create(&attendance).Error; err != nil {
+		tx.Rollback()
+		utils.Logger.Error("Failed to create attendance record", zap.Error(err))
+		return c.Status(500).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrDatabaseError,
+		})
+	}
+	if err := appendLedger(tx, "create", attendance); err != nil {
+		tx.Rollback()
+		utils.Logger.Error("Failed to record attendance in ledger", zap.Error(err))
+		return c.Status(500).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrDatabaseError,
+		})
+	}
+
+	if scan != nil {
+		scan.AttendanceID = attendance.ID
+		scan.CreatedAt = now
+		if err := tx.Omit(clause.Associations).Create(scan).Error; err != nil {
+			tx.Rollback()
+			utils.Logger.Error("Failed to record kiosk scan", zap.Error(err))
+			return c.Status(500).JSON(types.APIResponse{
+				Success: false,
+				Error:   types.ErrDatabaseError,
+			})
+		}
+	}
+
+	tx.Commit()
+
+	return c.JSON(types.APIResponse{
+		Success: true,
+		Message: "Check-in successful",
+		Data:    attendance,
+	})
+}
+
+// CheckOut handles employee check-out for the authenticated user
+func CheckOut(c *fiber.Ctx) error {
+	userID, ok := c.Locals("user_id").(string)
+	if !ok || userID == "" {
+		return c.Status(401).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrUnauthorized,
+		})
+	}
+
+	now := time.Now()
+
+	attendance, err := findOpenAttendance(DB, userID)
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return c.Status(400).JSON(types.APIResponse{
+				Success: false,
+				Error:   "No open check-in found",
+			})
+		}
+		utils.Logger.Error("Failed to find attendance record", zap.Error(err))
+		return c.Status(500).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrDatabaseError,
+		})
+	}
+
+	// A check-in left open past the shift limit cannot be closed by the employee
+	if now.Sub(attendance.CheckInTime) >= maxShiftDuration {
+		return c.Status(400).JSON(types.APIResponse{
+			Success: false,
+			Error:   "Check-in has expired, please contact HR to correct the missed check-out",
+		})
+	}
+
+	attendance.CheckOutTime = now
+	attendance.UpdatedAt = now
+
+	err = DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(&attendance).Error; err != nil {
+			return err
+		}
+		return appendLedger(tx, "update", attendance)
+	})
+	if err != nil {
+		utils.Logger.Error("Failed to update attendance record", zap.Error(err))
+		return c.Status(500).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrDatabaseError,
+		})
+	}
+
+	return c.JSON(types.APIResponse{
+		Success: true,
+		Message: "Check-out successful",
+		Data:    attendance,
+	})
+}
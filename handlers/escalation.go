@@ -0,0 +1,379 @@
+package handlers
+
+import (
+	"errors"
+	"time"
+
+	"dapp_timekeeping/models"
+	"dapp_timekeeping/types"
+	"dapp_timekeeping/utils"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type EscalationStepRequest struct {
+	ViolationType string `json:"violation_type"` // Any type when empty
+	Threshold     int    `json:"threshold" validate:"required"`
+	WindowDays    int    `json:"window_days" validate:"required"`
+	Action        string `json:"action" validate:"required"`
+}
+
+type ResolveDisciplinaryActionRequest struct {
+	Note string `json:"note"`
+}
+
+var disciplinaryActions = map[string]bool{"written_warning": true, "review_flag": true}
+
+// escalationWindow is the window of a step ending on day
+func escalationWindow(step models.EscalationStep, day time.Time) (time.Time, time.Time) {
+	end := startOfDay(day.In(time.Local))
+	return end.AddDate(0, 0, 1-step.WindowDays), end
+}
+
+// countViolations counts the user's active violations of violationType, or
+// of any type when empty, from start to end inclusive
+func countViolations(tx *gorm.DB, userID, violationType string, start, end time.Time) (int, error) {
+	query := tx.Model(&models.Violation{}).
+		Where("user_id = ? AND status = ?", userID, "active").
+		Where("date >= ? AND date < ?", start, end.AddDate(0, 0, 1))
+	if violationType != "" {
+		query = query.Where("type = ?", violationType)
+	}
+	var count int64
+	err := query.Count(&count).Error
+	return int(count), err
+}
+
+// escalate raises the disciplinary actions a new violation brings the
+// employee to. A step fires once per window: nothing is raised while an
+// action for the same step is still open or resolved within it.
+func escalate(tx *gorm.DB, violation models.Violation) ([]models.DisciplinaryAction, error) {
+	var steps []models.EscalationStep
+	if err := tx.Where("violation_type IN ?", []string{"", violation.Type}).
+		Order("threshold, created_at").
+		Find(&steps).Error; err != nil {
+		return nil, err
+	}
+
+	var raised []models.DisciplinaryAction
+	now := time.Now()
+	for _, step := range steps {
+		start, end := escalationWindow(step, violation.Date)
+		count, err := countViolations(tx, violation.UserID, step.ViolationType, start, end)
+		if err != nil {
+			return raised, err
+		}
+		if count < step.Threshold {
+			continue
+		}
+
+		var existing int64
+		if err := tx.Model(&models.DisciplinaryAction{}).
+			Where("user_id = ? AND step_id = ? AND status <> ?", violation.UserID, step.ID, "withdrawn").
+			Where("window_end >= ?", start).
+			Count(&existing).Error; err != nil {
+			return raised, err
+		}
+		if existing > 0 {
+			continue
+		}
+
+		stepID := step.ID
+		action := models.DisciplinaryAction{
+			ID:             uuid.New().String(),
+			UserID:         violation.UserID,
+			StepID:         &stepID,
+			Action:         step.Action,
+			ViolationType:  step.ViolationType,
+			Threshold:      step.Threshold,
+			ViolationCount: count,
+			WindowStart:    start,
+			WindowEnd:      end,
+			ViolationID:    violation.ID,
+			Status:         "open",
+			CreatedAt:      now,
+			UpdatedAt:      now,
+		}
+		if err := tx.Omit(clause.Associations).Create(&action).Error; err != nil {
+			return raised, err
+		}
+		raised = append(raised, action)
+	}
+	return raised, nil
+}
+
+// withdrawEscalations withdraws the open actions whose window held the
+// voided violation and that no longer reach their threshold. The later
+// violations are then escalated again, since a window ending after the
+// voided day may still reach the step on its own.
+func withdrawEscalations(tx *gorm.DB, violation models.Violation) (int, error) {
+	day := startOfDay(violation.Date.In(time.Local))
+	var actions []models.DisciplinaryAction
+	if err := tx.Where("user_id = ? AND status = ?", violation.UserID, "open").
+		Where("window_start <= ? AND window_end >= ?", day, day).
+		Where("violation_type IN ?", []string{"", violation.Type}).
+		Find(&actions).Error; err != nil {
+		return 0, err
+	}
+
+	withdrawn := 0
+	for _, a := range actions {
+		count, err := countViolations(tx, a.UserID, a.ViolationType, a.WindowStart, a.WindowEnd)
+		if err != nil {
+			return withdrawn, err
+		}
+		if count >= a.Threshold {
+			continue
+		}
+		result := tx.Model(&models.DisciplinaryAction{}).
+			Where("id = ? AND status = ?", a.ID, "open").
+			Updates(map[string]interface{}{
+				"status":          "withdrawn",
+				"violation_count": count,
+				"resolution_note": "Violation voided on appeal",
+				"updated_at":      time.Now(),
+			})
+		if result.Error != nil {
+			return withdrawn, result.Error
+		}
+		withdrawn += int(result.RowsAffected)
+	}
+	if withdrawn == 0 {
+		return 0, nil
+	}
+
+	var later []models.Violation
+	if err := tx.Where("user_id = ? AND status = ? AND date >= ?", violation.UserID, "active", day).
+		Order("date, created_at").
+		Find(&later).Error; err != nil {
+		return withdrawn, err
+	}
+	for _, v := range later {
+		if _, err := escalate(tx, v); err != nil {
+			return withdrawn, err
+		}
+	}
+	return withdrawn, nil
+}
+
+// ListEscalationSteps returns the escalation ladder
+func ListEscalationSteps(c *fiber.Ctx) error {
+	var steps []models.EscalationStep
+	if err := DB.Order("violation_type, threshold").Find(&steps).Error; err != nil {
+		utils.Logger.Error("Failed to fetch escalation steps", zap.Error(err))
+		return c.Status(500).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrDatabaseError,
+		})
+	}
+
+	return c.JSON(types.APIResponse{
+		Success: true,
+		Data:    steps,
+	})
+}
+
+// CreateEscalationStep adds a step to the escalation ladder. It applies to
+// violations raised from then on.
+func CreateEscalationStep(c *fiber.Ctx) error {
+	var req EscalationStepRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrInvalidInput,
+		})
+	}
+	if _, ok := violationLineItem[req.ViolationType]; req.ViolationType != "" && !ok {
+		return c.Status(400).JSON(types.APIResponse{
+			Success: false,
+			Error:   "Unknown violation type",
+		})
+	}
+	if !disciplinaryActions[req.Action] {
+		return c.Status(400).JSON(types.APIResponse{
+			Success: false,
+			Error:   "Action must be written_warning or review_flag",
+		})
+	}
+	if req.Threshold < 1 || req.WindowDays < 1 {
+		return c.Status(400).JSON(types.APIResponse{
+			Success: false,
+			Error:   "threshold and window_days must be positive",
+		})
+	}
+
+	step := models.EscalationStep{
+		ID:            uuid.New().String(),
+		ViolationType: req.ViolationType,
+		Threshold:     req.Threshold,
+		WindowDays:    req.WindowDays,
+		Action:        req.Action,
+		CreatedAt:     time.Now(),
+	}
+	if err := DB.Create(&step).Error; err != nil {
+		utils.Logger.Error("Failed to create escalation step", zap.Error(err))
+		return c.Status(500).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrDatabaseError,
+		})
+	}
+
+	return c.JSON(types.APIResponse{
+		Success: true,
+		Message: "Escalation step created successfully",
+		Data:    step,
+	})
+}
+
+// DeleteEscalationStep removes a step. Actions it raised are kept.
+func DeleteEscalationStep(c *fiber.Ctx) error {
+	result := DB.Delete(&models.EscalationStep{}, "id = ?", c.Params("id"))
+	if result.Error != nil {
+		utils.Logger.Error("Failed to delete escalation step", zap.Error(result.Error))
+		return c.Status(500).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrDatabaseError,
+		})
+	}
+	if result.RowsAffected == 0 {
+		return c.Status(404).JSON(types.APIResponse{
+			Success: false,
+			Error:   "Escalation step not found",
+		})
+	}
+
+	return c.JSON(types.APIResponse{
+		Success: true,
+		Message: "Escalation step deleted successfully",
+	})
+}
+
+// ListDisciplinaryActions lists actions for employees in the caller's
+// departments, filtered by the optional user_id, action and status query
+// parameters
+func ListDisciplinaryActions(c *fiber.Ctx) error {
+	scope, _, err := permissionScope(c, "violation_management")
+	if err != nil {
+		utils.Logger.Error("Failed to resolve permissions", zap.Error(err))
+		return c.Status(500).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrDatabaseError,
+		})
+	}
+
+	query := DB.Order("created_at DESC")
+	if !scope.All {
+		query = query.Where("user_id IN (?)", scope.restrict(DB.Model(&models.User{}).Select("id"), "department"))
+	}
+	if userID := c.Query("user_id"); userID != "" {
+		query = query.Where("user_id = ?", userID)
+	}
+	if action := c.Query("action"); action != "" {
+		query = query.Where("action = ?", action)
+	}
+	if status := c.Query("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+
+	var actions []models.DisciplinaryAction
+	if err := query.Find(&actions).Error; err != nil {
+		utils.Logger.Error("Failed to fetch disciplinary actions", zap.Error(err))
+		return c.Status(500).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrDatabaseError,
+		})
+	}
+
+	return c.JSON(types.APIResponse{
+		Success: true,
+		Data:    actions,
+	})
+}
+
+// ResolveDisciplinaryAction closes an open action once HR has followed up
+func ResolveDisciplinaryAction(c *fiber.Ctx) error {
+	resolverID, ok := c.Locals("user_id").(string)
+	if !ok || resolverID == "" {
+		return c.Status(401).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrUnauthorized,
+		})
+	}
+
+	var req ResolveDisciplinaryActionRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrInvalidInput,
+		})
+	}
+
+	var action models.DisciplinaryAction
+	if err := DB.Preload("User").First(&action, "id = ?", c.Params("id")).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return c.Status(404).JSON(types.APIResponse{
+				Success: false,
+				Error:   "Disciplinary action not found",
+			})
+		}
+		utils.Logger.Error("Failed to fetch disciplinary action", zap.Error(err))
+		return c.Status(500).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrDatabaseError,
+		})
+	}
+
+	scope, _, err := permissionScope(c, "violation_management")
+	if err != nil {
+		utils.Logger.Error("Failed to resolve permissions", zap.Error(err))
+		return c.Status(500).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrDatabaseError,
+		})
+	}
+	if !scope.Allows(action.User.Department) {
+		return c.Status(403).JSON(types.APIResponse{
+			Success: false,
+			Error:   "Employee is outside your departments",
+		})
+	}
+
+	now := time.Now()
+	result := DB.Model(&models.DisciplinaryAction{}).
+		Where("id = ? AND status = ?", action.ID, "open").
+		Updates(map[string]interface{}{
+			"status":          "resolved",
+			"resolved_by":     resolverID,
+			"resolution_note": req.Note,
+			"resolved_at":     now,
+			"updated_at":      now,
+		})
+	if result.Error != nil {
+		utils.Logger.Error("Failed to resolve disciplinary action", zap.Error(result.Error))
+		return c.Status(500).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrDatabaseError,
+		})
+	}
+	if result.RowsAffected == 0 {
+		return c.Status(409).JSON(types.APIResponse{
+			Success: false,
+			Error:   "Disciplinary action is not open",
+		})
+	}
+
+	action.Status = "resolved"
+	action.ResolvedBy = &resolverID
+	action.ResolutionNote = req.Note
+	action.ResolvedAt = &now
+	action.UpdatedAt = now
+	return c.JSON(types.APIResponse{
+		Success: true,
+		Message: "Disciplinary action resolved successfully",
+		Data:    action,
+	})
+}
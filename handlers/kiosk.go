@@ -0,0 +1,304 @@
+package handlers
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"dapp_timekeeping/auth"
+	"dapp_timekeeping/config"
+	"dapp_timekeeping/models"
+	"dapp_timekeeping/types"
+	"dapp_timekeeping/utils"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+var (
+	errInvalidKioskCode = errors.New("invalid kiosk code")
+	errExpiredKioskCode = errors.New("kiosk code has expired")
+)
+
+type CreateKioskRequest struct {
+	Name     string `json:"name" validate:"required"`
+	Location string `json:"location" validate:"required"`
+}
+
+type KioskCheckInRequest struct {
+	Payload string `json:"payload" validate:"required"` // The scanned QR code
+}
+
+type CreateKioskResponse struct {
+	Kiosk     models.Kiosk `json:"kiosk"`
+	Token     string       `json:"token"` // Shown only once, the kiosk authenticates with it
+	ExpiresAt time.Time    `json:"expires_at"`
+}
+
+type KioskCodeResponse struct {
+	Payload   string    `json:"payload"` // Encode as a QR code
+	ExpiresAt time.Time `json:"expires_at"`
+	RefreshIn int       `json:"refresh_in"` // Seconds until the next code
+}
+
+// kioskCode is the signed content of a kiosk QR code. A code belongs to one
+// rotation window and is accepted until the end of the following window, so
+// that a code scanned just before rotating still works.
+type kioskCode struct {
+	KioskID   string `json:"k"`
+	Location  string `json:"l"`
+	Window    int64  `json:"w"`
+	ExpiresAt int64  `json:"e"`
+}
+
+func kioskInterval() time.Duration {
+	return time.Duration(config.AppConfig.KioskCodeInterval) * time.Second
+}
+
+func kioskCodeSignature(body string) []byte {
+	mac := hmac.New(sha256.New, []byte(config.AppConfig.JWTSecret))
+	mac.Write([]byte("kiosk-code:" + body))
+	return mac.Sum(nil)
+}
+
+// signKioskCode returns the QR payload for the kiosk's current window
+func signKioskCode(kiosk models.Kiosk, now time.Time) (string, kioskCode) {
+	interval := kioskInterval()
+	window := now.UnixNano() / int64(interval)
+	code := kioskCode{
+		KioskID:   kiosk.ID,
+		Location:  kiosk.Location,
+		Window:    window,
+		ExpiresAt: time.Unix(0, (window+2)*int64(interval)).Unix(),
+	}
+	raw, _ := json.Marshal(code)
+	body := base64.RawURLEncoding.EncodeToString(raw)
+	return body + "." + base64.RawURLEncoding.EncodeToString(kioskCodeSignature(body)), code
+}
+
+// parseKioskCode verifies the signature and expiry of a QR payload
+func parseKioskCode(payload string, now time.Time) (kioskCode, error) {
+	var code kioskCode
+	body, signature, found := strings.Cut(payload, ".")
+	if !found {
+		return code, errInvalidKioskCode
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil || !hmac.Equal(sig, kioskCodeSignature(body)) {
+		return code, errInvalidKioskCode
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(body)
+	if err != nil || json.Unmarshal(raw, &code) != nil {
+		return code, errInvalidKioskCode
+	}
+	if now.Unix() >= code.ExpiresAt {
+		return code, errExpiredKioskCode
+	}
+	return code, nil
+}
+
+// CreateKiosk registers a kiosk and issues the token it authenticates with
+func CreateKiosk(c *fiber.Ctx) error {
+	creatorID, _ := c.Locals("user_id").(string)
+
+	var req CreateKioskRequest
+	if err := c.BodyParser(&req); err != nil || strings.TrimSpace(req.Name) == "" || strings.TrimSpace(req.Location) == "" {
+		return c.Status(400).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrInvalidInput,
+		})
+	}
+
+	now := time.Now()
+	kiosk := models.Kiosk{
+		ID:        uuid.New().String(),
+		Name:      strings.TrimSpace(req.Name),
+		Location:  strings.TrimSpace(req.Location),
+		Active:    true,
+		CreatedBy: creatorID,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	if err := DB.Create(&kiosk).Error; err != nil {
+		utils.Logger.Error("Failed to create kiosk", zap.Error(err))
+		return c.Status(500).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrDatabaseError,
+		})
+	}
+
+	ttl := time.Duration(config.AppConfig.KioskTokenTTL * float64(time.Hour))
+	token, claims, err := auth.IssueTokenWithExpiry(kiosk.ID, "kiosk", ttl)
+	if err != nil {
+		utils.Logger.Error("Failed to issue kiosk token", zap.Error(err))
+		return c.Status(500).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrInternalError,
+		})
+	}
+
+	return c.JSON(types.APIResponse{
+		Success: true,
+		Message: "Kiosk registered",
+		Data: CreateKioskResponse{
+			Kiosk:     kiosk,
+			Token:     token,
+			ExpiresAt: claims.ExpiresAt.Time,
+		},
+	})
+}
+
+// ListKiosks returns every registered kiosk
+func ListKiosks(c *fiber.Ctx) error {
+	var kiosks []models.Kiosk
+	if err := DB.Order("name").Find(&kiosks).Error; err != nil {
+		utils.Logger.Error("Failed to fetch kiosks", zap.Error(err))
+		return c.Status(500).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrDatabaseError,
+		})
+	}
+
+	return c.JSON(types.APIResponse{
+		Success: true,
+		Data:    kiosks,
+	})
+}
+
+// DeactivateKiosk stops a kiosk from issuing codes; codes it already showed
+// are rejected too
+func DeactivateKiosk(c *fiber.Ctx) error {
+	result := DB.Model(&models.Kiosk{}).
+		Where("id = ?", c.Params("id")).
+		Updates(map[string]interface{}{"active": false, "updated_at": time.Now()})
+	if result.Error != nil {
+		utils.Logger.Error("Failed to deactivate kiosk", zap.Error(result.Error))
+		return c.Status(500).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrDatabaseError,
+		})
+	}
+	if result.RowsAffected == 0 {
+		return c.Status(404).JSON(types.APIResponse{
+			Success: false,
+			Error:   "Kiosk not found",
+		})
+	}
+
+	return c.JSON(types.APIResponse{
+		Success: true,
+		Message: "Kiosk deactivated",
+	})
+}
+
+// GetKioskCode returns the current QR payload of the authenticated kiosk
+func GetKioskCode(c *fiber.Ctx) error {
+	kioskID, _ := c.Locals("user_id").(string)
+
+	var kiosk models.Kiosk
+	if err := DB.First(&kiosk, "id = ? AND active = ?", kioskID, true).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return c.Status(403).JSON(types.APIResponse{
+				Success: false,
+				Error:   "Kiosk is not active",
+			})
+		}
+		utils.Logger.Error("Failed to fetch kiosk", zap.Error(err))
+		return c.Status(500).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrDatabaseError,
+		})
+	}
+
+	now := time.Now()
+	payload, code := signKioskCode(kiosk, now)
+	interval := kioskInterval()
+	nextWindow := time.Unix(0, (code.Window+1)*int64(interval))
+
+	return c.JSON(types.APIResponse{
+		Success: true,
+		Data: KioskCodeResponse{
+			Payload:   payload,
+			ExpiresAt: time.Unix(code.ExpiresAt, 0),
+			RefreshIn: int(nextWindow.Sub(now).Seconds()) + 1,
+		},
+	})
+}
+
+// KioskCheckIn checks the caller in with a QR code scanned at a kiosk
+func KioskCheckIn(c *fiber.Ctx) error {
+	userID, ok := c.Locals("user_id").(string)
+	if !ok || userID == "" {
+		return c.Status(401).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrUnauthorized,
+		})
+	}
+
+	var req KioskCheckInRequest
+	if err := c.BodyParser(&req); err != nil || req.Payload == "" {
+		return c.Status(400).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrInvalidInput,
+		})
+	}
+
+	code, err := parseKioskCode(req.Payload, time.Now())
+	if err == errExpiredKioskCode {
+		return c.Status(410).JSON(types.APIResponse{
+			Success: false,
+			Error:   "QR code has expired, scan the current one",
+		})
+	}
+	if err != nil {
+		return c.Status(400).JSON(types.APIResponse{
+			Success: false,
+			Error:   "Invalid QR code",
+		})
+	}
+
+	var kiosk models.Kiosk
+	if err := DB.First(&kiosk, "id = ?", code.KioskID).Error; err != nil && err != gorm.ErrRecordNotFound {
+		utils.Logger.Error("Failed to fetch kiosk", zap.Error(err))
+		return c.Status(500).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrDatabaseError,
+		})
+	}
+	if !kiosk.Active || kiosk.Location != code.Location {
+		return c.Status(400).JSON(types.APIResponse{
+			Success: false,
+			Error:   "Invalid QR code",
+		})
+	}
+
+	var used int64
+	if err := DB.Model(&models.KioskScan{}).
+		Where("kiosk_id = ? AND code_window = ? AND user_id = ?", code.KioskID, code.Window, userID).
+		Count(&used).Error; err != nil {
+		utils.Logger.Error("Failed to check kiosk scans", zap.Error(err))
+		return c.Status(500).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrDatabaseError,
+		})
+	}
+	if used > 0 {
+		return c.Status(409).JSON(types.APIResponse{
+			Success: false,
+			Error:   "QR code has already been used",
+		})
+	}
+
+	return checkIn(c, userID, &models.KioskScan{
+		ID:         uuid.New().String(),
+		KioskID:    code.KioskID,
+		CodeWindow: code.Window,
+		UserID:     userID,
+	})
+}
@@ -0,0 +1,287 @@
+package handlers
+
+import (
+	"dapp_timekeeping/models"
+	"dapp_timekeeping/types"
+	"dapp_timekeeping/utils"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+type LeaveRequest struct {
+	Type      string `json:"type" validate:"required,oneof=leave_with_permission late_with_permission"`
+	StartDate string `json:"start_date" validate:"required"` // YYYY-MM-DD
+	EndDate   string `json:"end_date"`                       // YYYY-MM-DD, defaults to start_date
+	Reason    string `json:"reason" validate:"required"`
+}
+
+// RequestLeave lets the authenticated employee request leave or permission to be late
+func RequestLeave(c *fiber.Ctx) error {
+	userID, ok := c.Locals("user_id").(string)
+	if !ok || userID == "" {
+		return c.Status(401).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrUnauthorized,
+		})
+	}
+
+	var req LeaveRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrInvalidInput,
+		})
+	}
+
+	if req.Type != "leave_with_permission" && req.Type != "late_with_permission" {
+		return c.Status(400).JSON(types.APIResponse{
+			Success: false,
+			Error:   "Type must be 'leave_with_permission' or 'late_with_permission'",
+		})
+	}
+	if req.Reason == "" {
+		return c.Status(400).JSON(types.APIResponse{
+			Success: false,
+			Error:   "Reason is required",
+		})
+	}
+
+	startDate, err := time.ParseInLocation("2006-01-02", req.StartDate, time.Local)
+	if err != nil {
+		return c.Status(400).JSON(types.APIResponse{
+			Success: false,
+			Error:   "Invalid start date format. Use YYYY-MM-DD",
+		})
+	}
+	endDate := startDate
+	if req.EndDate != "" {
+		endDate, err = time.ParseInLocation("2006-01-02", req.EndDate, time.Local)
+		if err != nil {
+			return c.Status(400).JSON(types.APIResponse{
+				Success: false,
+				Error:   "Invalid end date format. Use YYYY-MM-DD",
+			})
+		}
+	}
+
+	if endDate.Before(startDate) {
+		return c.Status(400).JSON(types.APIResponse{
+			Success: false,
+			Error:   "End date must not be before start date",
+		})
+	}
+	if startDate.Before(startOfDay(time.Now())) {
+		return c.Status(400).JSON(types.APIResponse{
+			Success: false,
+			Error:   "Cannot request leave for past dates",
+		})
+	}
+	if req.Type == "late_with_permission" && !endDate.Equal(startDate) {
+		return c.Status(400).JSON(types.APIResponse{
+			Success: false,
+			Error:   "Late requests must cover a single day",
+		})
+	}
+
+	tx := DB.Begin()
+
+	// Reject ranges overlapping the employee's pending or approved absences
+	var overlapping int64
+	if err := tx.Model(&models.Absence{}).
+		Where("user_id = ? AND status IN ?", userID, []string{"pending", "approved"}).
+		Where("start_date <= ? AND end_date >= ?", endDate, startDate).
+		Count(&overlapping).Error; err != nil {
+		tx.Rollback()
+		utils.Logger.Error("Failed to check overlapping absences", zap.Error(err))
+		return c.Status(500).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrDatabaseError,
+		})
+	}
+	if overlapping > 0 {
+		tx.Rollback()
+		return c.Status(409).JSON(types.APIResponse{
+			Success: false,
+			Error:   "Request overlaps an existing absence",
+		})
+	}
+
+	// Paid leave must be covered by the balance left after other pending requests
+	if req.Type == "leave_with_permission" {
+		if status, msg := checkLeaveAvailable(tx, userID, startDate, endDate); status != 0 {
+			tx.Rollback()
+			return c.Status(status).JSON(types.APIResponse{
+				Success: false,
+				Error:   msg,
+			})
+		}
+	}
+
+	now := time.Now()
+	absence := models.Absence{
+		ID:        uuid.New().String(),
+		UserID:    userID,
+		Date:      startDate,
+		StartDate: startDate,
+		EndDate:   endDate,
+		Type:      req.Type,
+		Reason:    req.Reason,
+		Status:    "pending",
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+
+	if err := tx.Omit("User", "Processor").Create(&absence).Error; err != nil {
+		tx.Rollback()
+		utils.Logger.Error("Failed to create leave request", zap.Error(err))
+		return c.Status(500).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrDatabaseError,
+		})
+	}
+	if err := appendLedger(tx, "create", absence); err != nil {
+		tx.Rollback()
+		utils.Logger.Error("Failed to record leave request in ledger", zap.Error(err))
+		return c.Status(500).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrDatabaseError,
+		})
+	}
+
+	tx.Commit()
+
+	return c.JSON(types.APIResponse{
+		Success: true,
+		Message: "Leave request submitted successfully",
+		Data:    absence,
+	})
+}
+
+// checkLeaveAvailable verifies the employee can afford a paid leave between
+// start and end. It returns a non-zero HTTP status and message when they cannot.
+func checkLeaveAvailable(tx *gorm.DB, userID string, start, end time.Time) (int, string) {
+	var user models.User
+	if err := tx.First(&user, "id = ?", userID).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return 404, "Employee not found"
+		}
+		return 500, types.ErrDatabaseError
+	}
+
+	if err := syncLeaveAccruals(tx, user, time.Now()); err != nil {
+		utils.Logger.Error("Failed to sync leave accruals", zap.Error(err))
+		return 500, types.ErrDatabaseError
+	}
+	requested, err := leaveDays(tx, user, start, end)
+	if err != nil {
+		utils.Logger.Error("Failed to count leave days", zap.Error(err))
+		return 500, types.ErrDatabaseError
+	}
+	if requested == 0 {
+		return 400, "No working days in the requested range"
+	}
+	balance, err := leaveBalance(tx, user.ID)
+	if err != nil {
+		return 500, types.ErrDatabaseError
+	}
+	pending, err := pendingLeaveDays(tx, user)
+	if err != nil {
+		return 500, types.ErrDatabaseError
+	}
+	if balance-pending < requested {
+		return 409, "Insufficient leave balance"
+	}
+	return 0, ""
+}
+
+// GetMyLeaveRequests lists the authenticated employee's absences, newest first
+func GetMyLeaveRequests(c *fiber.Ctx) error {
+	userID, ok := c.Locals("user_id").(string)
+	if !ok || userID == "" {
+		return c.Status(401).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrUnauthorized,
+		})
+	}
+
+	query := absenceQuery().
+		Where("absences.user_id = ?", userID).
+		Order("absences.start_date DESC")
+	if status := c.Query("status"); status != "" {
+		query = query.Where("absences.status = ?", status)
+	}
+
+	response, err := fetchAbsences(query)
+	if err != nil {
+		utils.Logger.Error("Failed to fetch leave requests", zap.Error(err))
+		return c.Status(500).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrDatabaseError,
+		})
+	}
+
+	return c.JSON(types.APIResponse{
+		Success: true,
+		Data:    response,
+	})
+}
+
+// CancelLeaveRequest withdraws one of the authenticated employee's pending requests
+func CancelLeaveRequest(c *fiber.Ctx) error {
+	userID, ok := c.Locals("user_id").(string)
+	if !ok || userID == "" {
+		return c.Status(401).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrUnauthorized,
+		})
+	}
+
+	var absence models.Absence
+	if err := DB.First(&absence, "id = ? AND user_id = ?", c.Params("id"), userID).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return c.Status(404).JSON(types.APIResponse{
+				Success: false,
+				Error:   "Leave request not found",
+			})
+		}
+		utils.Logger.Error("Failed to fetch leave request", zap.Error(err))
+		return c.Status(500).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrDatabaseError,
+		})
+	}
+
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		// Guard on status so a request approved in the meantime is kept
+		result := tx.Where("id = ? AND status = ?", absence.ID, "pending").Delete(&models.Absence{})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		return appendLedger(tx, "delete", absence)
+	})
+	if err == gorm.ErrRecordNotFound {
+		return c.Status(409).JSON(types.APIResponse{
+			Success: false,
+			Error:   "Only pending requests can be cancelled",
+		})
+	}
+	if err != nil {
+		utils.Logger.Error("Failed to cancel leave request", zap.Error(err))
+		return c.Status(500).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrDatabaseError,
+		})
+	}
+
+	return c.JSON(types.APIResponse{
+		Success: true,
+		Message: "Leave request cancelled successfully",
+	})
+}
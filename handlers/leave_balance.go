@@ -0,0 +1,338 @@
+package handlers
+
+import (
+	"dapp_timekeeping/config"
+	"dapp_timekeeping/models"
+	"dapp_timekeeping/types"
+	"dapp_timekeeping/utils"
+	"errors"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var errInsufficientLeave = errors.New("insufficient leave balance")
+
+type LeaveBalanceResponse struct {
+	UserID            string  `json:"user_id"`
+	Balance           float64 `json:"balance"`      // Days accrued minus days taken
+	PendingDays       float64 `json:"pending_days"` // Days in leave requests awaiting approval
+	Available         float64 `json:"available"`    // Balance minus pending days
+	AnnualEntitlement float64 `json:"annual_entitlement"`
+	CarryOverCap      float64 `json:"carry_over_cap"`
+	AsOf              string  `json:"as_of"` // YYYY-MM-DD
+}
+
+type LeaveLedgerLine struct {
+	models.LeaveLedgerEntry
+	Balance float64 `json:"balance"` // Running balance after this entry
+}
+
+func roundDays(days float64) float64 {
+	return math.Round(days*100) / 100
+}
+
+// addMonths adds n months to t, clamping the day to the end of the target month
+func addMonths(t time.Time, n int) time.Time {
+	first := time.Date(t.Year(), t.Month(), 1, t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), t.Location())
+	target := first.AddDate(0, n, 0)
+	lastDay := target.AddDate(0, 1, -1).Day()
+	day := t.Day()
+	if day > lastDay {
+		day = lastDay
+	}
+	return target.AddDate(0, 0, day-1)
+}
+
+// syncLeaveAccruals writes any accruals and year-end expiries due up to asOf.
+// Each month of service from the onboard date accrues a twelfth of the annual
+// entitlement. On 1 January, days above the carry-over cap expire. Entries are
+// keyed by user, kind and reference, so running this repeatedly is safe.
+func syncLeaveAccruals(tx *gorm.DB, user models.User, asOf time.Time) error {
+	if user.OnboardDate.IsZero() {
+		return nil
+	}
+	onboard := startOfDay(user.OnboardDate.In(time.Local))
+
+	var entries []models.LeaveLedgerEntry
+	if err := tx.Where("user_id = ?", user.ID).Order("effective_date").Find(&entries).Error; err != nil {
+		return err
+	}
+	recorded := make(map[string]bool, len(entries))
+	for _, e := range entries {
+		recorded[e.Kind+":"+e.Reference] = true
+	}
+
+	now := time.Now()
+	monthly := roundDays(config.AppConfig.AnnualLeaveDays / 12)
+	var added []models.LeaveLedgerEntry
+	for m := 1; ; m++ {
+		due := addMonths(onboard, m)
+		if due.After(asOf) {
+			break
+		}
+		ref := due.Format("2006-01")
+		if recorded["accrual:"+ref] {
+			continue
+		}
+		added = append(added, models.LeaveLedgerEntry{
+			ID:            uuid.New().String(),
+			UserID:        user.ID,
+			Kind:          "accrual",
+			Reference:     ref,
+			Days:          monthly,
+			EffectiveDate: due,
+			Note:          "Monthly accrual",
+			CreatedAt:     now,
+		})
+	}
+	entries = append(entries, added...)
+
+	for year := onboard.Year() + 1; ; year++ {
+		yearStart := time.Date(year, 1, 1, 0, 0, 0, 0, time.Local)
+		if yearStart.After(asOf) {
+			break
+		}
+		ref := strconv.Itoa(year)
+		if recorded["expiry:"+ref] {
+			continue
+		}
+
+		var balance float64
+		for _, e := range entries {
+			if e.EffectiveDate.Before(yearStart) {
+				balance += e.Days
+			}
+		}
+		excess := roundDays(balance - config.AppConfig.LeaveCarryOverCap)
+		if excess <= 0 {
+			continue
+		}
+
+		expiry := models.LeaveLedgerEntry{
+			ID:            uuid.New().String(),
+			UserID:        user.ID,
+			Kind:          "expiry",
+			Reference:     ref,
+			Days:          -excess,
+			EffectiveDate: yearStart,
+			Note:          "Unused days above carry-over cap",
+			CreatedAt:     now,
+		}
+		added = append(added, expiry)
+		entries = append(entries, expiry)
+	}
+
+	if len(added) == 0 {
+		return nil
+	}
+	return tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&added).Error
+}
+
+// leaveBalance returns the sum of the user's ledger entries
+func leaveBalance(tx *gorm.DB, userID string) (float64, error) {
+	var balance float64
+	err := tx.Model(&models.LeaveLedgerEntry{}).
+		Where("user_id = ?", userID).
+		Select("COALESCE(SUM(days), 0)").
+		Scan(&balance).Error
+	return roundDays(balance), err
+}
+
+// leaveDays counts the days between start and end (inclusive) on which the
+// user is scheduled to work
+func leaveDays(tx *gorm.DB, user models.User, start, end time.Time) (float64, error) {
+	resolver, err := loadScheduleResolver(tx, start, end)
+	if err != nil {
+		return 0, err
+	}
+	var days float64
+	for day := startOfDay(start.In(time.Local)); !day.After(end); day = day.AddDate(0, 0, 1) {
+		if _, ok := resolver.shiftOn(user.ID, user.Department, day); ok {
+			days++
+		}
+	}
+	return days, nil
+}
+
+// pendingLeaveDays sums the working days of the user's pending paid leave requests
+func pendingLeaveDays(tx *gorm.DB, user models.User) (float64, error) {
+	var pending []models.Absence
+	if err := tx.Where("user_id = ? AND type = ? AND status = ?", user.ID, "leave_with_permission", "pending").
+		Find(&pending).Error; err != nil {
+		return 0, err
+	}
+	var total float64
+	for _, a := range pending {
+		days, err := leaveDays(tx, user, a.StartDate, a.EndDate)
+		if err != nil {
+			return 0, err
+		}
+		total += days
+	}
+	return total, nil
+}
+
+// debitLeave charges an approved leave_with_permission absence against the
+// employee's balance. It returns errInsufficientLeave when the balance is short.
+func debitLeave(tx *gorm.DB, absence models.Absence) error {
+	var user models.User
+	if err := tx.First(&user, "id = ?", absence.UserID).Error; err != nil {
+		return err
+	}
+
+	now := time.Now()
+	if err := syncLeaveAccruals(tx, user, now); err != nil {
+		return err
+	}
+
+	days, err := leaveDays(tx, user, absence.StartDate, absence.EndDate)
+	if err != nil {
+		return err
+	}
+	if days == 0 {
+		return nil
+	}
+
+	balance, err := leaveBalance(tx, user.ID)
+	if err != nil {
+		return err
+	}
+	if balance < days {
+		return errInsufficientLeave
+	}
+
+	return tx.Create(&models.LeaveLedgerEntry{
+		ID:            uuid.New().String(),
+		UserID:        user.ID,
+		Kind:          "debit",
+		Reference:     absence.ID,
+		Days:          -days,
+		EffectiveDate: now,
+		Note:          "Leave " + absence.StartDate.Format("2006-01-02") + " to " + absence.EndDate.Format("2006-01-02"),
+		CreatedAt:     now,
+	}).Error
+}
+
+// leaveTarget resolves whose balance is requested: the :id route parameter
+// for root, otherwise the authenticated user
+func leaveTarget(c *fiber.Ctx) (models.User, error) {
+	userID := c.Params("id")
+	if userID == "" {
+		userID, _ = c.Locals("user_id").(string)
+	}
+
+	var user models.User
+	err := DB.First(&user, "id = ?", userID).Error
+	return user, err
+}
+
+// GetLeaveBalance returns the current leave balance of a user
+func GetLeaveBalance(c *fiber.Ctx) error {
+	user, err := leaveTarget(c)
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return c.Status(404).JSON(types.APIResponse{
+				Success: false,
+				Error:   "Employee not found",
+			})
+		}
+		return c.Status(500).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrDatabaseError,
+		})
+	}
+
+	now := time.Now()
+	if err := syncLeaveAccruals(DB, user, now); err != nil {
+		utils.Logger.Error("Failed to sync leave accruals", zap.Error(err))
+		return c.Status(500).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrDatabaseError,
+		})
+	}
+
+	balance, err := leaveBalance(DB, user.ID)
+	if err != nil {
+		utils.Logger.Error("Failed to fetch leave balance", zap.Error(err))
+		return c.Status(500).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrDatabaseError,
+		})
+	}
+	pending, err := pendingLeaveDays(DB, user)
+	if err != nil {
+		utils.Logger.Error("Failed to fetch pending leave", zap.Error(err))
+		return c.Status(500).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrDatabaseError,
+		})
+	}
+
+	return c.JSON(types.APIResponse{
+		Success: true,
+		Data: LeaveBalanceResponse{
+			UserID:            user.ID,
+			Balance:           balance,
+			PendingDays:       pending,
+			Available:         roundDays(balance - pending),
+			AnnualEntitlement: config.AppConfig.AnnualLeaveDays,
+			CarryOverCap:      config.AppConfig.LeaveCarryOverCap,
+			AsOf:              now.Format("2006-01-02"),
+		},
+	})
+}
+
+// GetLeaveLedger lists a user's accruals, expiries and debits with a running balance
+func GetLeaveLedger(c *fiber.Ctx) error {
+	user, err := leaveTarget(c)
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return c.Status(404).JSON(types.APIResponse{
+				Success: false,
+				Error:   "Employee not found",
+			})
+		}
+		return c.Status(500).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrDatabaseError,
+		})
+	}
+
+	if err := syncLeaveAccruals(DB, user, time.Now()); err != nil {
+		utils.Logger.Error("Failed to sync leave accruals", zap.Error(err))
+		return c.Status(500).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrDatabaseError,
+		})
+	}
+
+	var entries []models.LeaveLedgerEntry
+	if err := DB.Where("user_id = ?", user.ID).
+		Order("effective_date ASC, created_at ASC").
+		Find(&entries).Error; err != nil {
+		utils.Logger.Error("Failed to fetch leave ledger", zap.Error(err))
+		return c.Status(500).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrDatabaseError,
+		})
+	}
+
+	lines := make([]LeaveLedgerLine, len(entries))
+	var running float64
+	for i, e := range entries {
+		running = roundDays(running + e.Days)
+		lines[i] = LeaveLedgerLine{LeaveLedgerEntry: e, Balance: running}
+	}
+
+	return c.JSON(types.APIResponse{
+		Success: true,
+		Data:    lines,
+	})
+}
@@ -0,0 +1,330 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"dapp_timekeeping/models"
+	"dapp_timekeeping/types"
+	"dapp_timekeeping/utils"
+
+	"github.com/gofiber/fiber/v2"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// Records loaded per query while verifying the ledger
+const ledgerBatchSize = 500
+
+// LedgerVerification is the result of walking the ledger. When the chain is
+// broken, BrokenAt is the sequence number of the first entry that does not
+// check out.
+type LedgerVerification struct {
+	Valid      bool    `json:"valid"`
+	Entries    int     `json:"entries"`
+	Records    int     `json:"records"`
+	BrokenAt   *uint64 `json:"broken_at,omitempty"`
+	RecordType string  `json:"record_type,omitempty"`
+	RecordID   string  `json:"record_id,omitempty"`
+	Reason     string  `json:"reason,omitempty"`
+}
+
+func ledgerTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+func ledgerTimePtr(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return ledgerTime(*t)
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// ledgerRecordContent returns the type, ID and hashed content of an
+// attendance or absence record. Only the fields that matter to an audit are
+// hashed, in a fixed layout, so the hash can be recomputed from the stored row.
+//
+// Changing a layout changes the hash of every existing record: ledger entries
+// and anchored days written before the attendance method was added no longer
+// verify. Such a database needs its ledger rehashed, or the layout versioned
+// by the entry, before it is verified again.
+func ledgerRecordContent(record interface{}) (string, string, []byte, error) {
+	var recordType, recordID string
+	var content interface{}
+	switch r := record.(type) {
+	case models.Attendance:
+		recordType, recordID = "attendance", r.ID
+		content = struct {
+			ID           string `json:"id"`
+			UserID       string `json:"user_id"`
+			CheckInTime  string `json:"check_in_time"`
+			CheckOutTime string `json:"check_out_time"`
+			ExpectedTime string `json:"expected_time"`
+			OnTime       bool   `json:"on_time"`
+			Method       string `json:"method"`
+		}{r.ID, r.UserID, ledgerTime(r.CheckInTime), ledgerTime(r.CheckOutTime), ledgerTime(r.ExpectedTime), r.OnTime, r.Method}
+	case models.Absence:
+		processedBy := ""
+		if r.ProcessedBy != nil {
+			processedBy = *r.ProcessedBy
+		}
+		recordType, recordID = "absence", r.ID
+		content = struct {
+			ID          string `json:"id"`
+			UserID      string `json:"user_id"`
+			Date        string `json:"date"`
+			StartDate   string `json:"start_date"`
+			EndDate     string `json:"end_date"`
+			Type        string `json:"type"`
+			Reason      string `json:"reason"`
+			Status      string `json:"status"`
+			ProcessedBy string `json:"processed_by"`
+			ProcessedAt string `json:"processed_at"`
+		}{r.ID, r.UserID, ledgerTime(r.Date), ledgerTime(r.StartDate), ledgerTime(r.EndDate), r.Type, r.Reason, r.Status, processedBy, ledgerTimePtr(r.ProcessedAt)}
+	default:
+		return "", "", nil, fmt.Errorf("unsupported ledger record %T", record)
+	}
+
+	raw, err := json.Marshal(content)
+	return recordType, recordID, raw, err
+}
+
+// hashLedgerRecord returns the type, ID and content hash of a record
+func hashLedgerRecord(record interface{}) (string, string, string, error) {
+	recordType, recordID, raw, err := ledgerRecordContent(record)
+	if err != nil {
+		return "", "", "", err
+	}
+	return recordType, recordID, sha256Hex(raw), nil
+}
+
+func ledgerEntryHash(entry models.LedgerEntry) string {
+	return sha256Hex([]byte(strings.Join([]string{
+		strconv.FormatUint(entry.Seq, 10),
+		entry.RecordType,
+		entry.RecordID,
+		entry.Action,
+		entry.RecordHash,
+		entry.PrevHash,
+		ledgerTime(entry.CreatedAt),
+	}, "\n")))
+}
+
+// appendLedger records a write to an attendance or absence record and queues
+// it for the chain. Call it in the transaction of the write. Seq is the
+// primary key, so two writers that read the same last entry cannot both
+// append.
+func appendLedger(tx *gorm.DB, action string, record interface{}) error {
+	recordType, recordID, recordHash, err := hashLedgerRecord(record)
+	if err != nil {
+		return err
+	}
+
+	var last models.LedgerEntry
+	if err := tx.Order("seq DESC").Limit(1).Find(&last).Error; err != nil {
+		return err
+	}
+
+	entry := models.LedgerEntry{
+		Seq:        last.Seq + 1,
+		RecordType: recordType,
+		RecordID:   recordID,
+		Action:     action,
+		RecordHash: recordHash,
+		PrevHash:   last.EntryHash,
+		CreatedAt:  time.Now(),
+	}
+	entry.EntryHash = ledgerEntryHash(entry)
+	if err := tx.Create(&entry).Error; err != nil {
+		return err
+	}
+
+	// Only hashes go on chain; the record itself stays in the database
+	return enqueueOutbox(tx, recordType, recordID, fiber.Map{
+		"seq":         entry.Seq,
+		"action":      action,
+		"record_hash": recordHash,
+		"entry_hash":  entry.EntryHash,
+	})
+}
+
+// verifyLedger walks the chain checking every link and entry hash, then
+// checks that each record still matches its latest entry
+func verifyLedger(tx *gorm.DB) (LedgerVerification, error) {
+	var result LedgerVerification
+	broken := func(entry models.LedgerEntry, reason string) {
+		if result.BrokenAt != nil && *result.BrokenAt <= entry.Seq {
+			return
+		}
+		seq := entry.Seq
+		result.BrokenAt = &seq
+		result.RecordType = entry.RecordType
+		result.RecordID = entry.RecordID
+		result.Reason = reason
+	}
+
+	latest := map[string]map[string]models.LedgerEntry{}
+	var prev models.LedgerEntry
+	var batch []models.LedgerEntry
+	err := tx.Order("seq").FindInBatches(&batch, ledgerBatchSize, func(_ *gorm.DB, _ int) error {
+		for _, entry := range batch {
+			if result.BrokenAt != nil {
+				return nil
+			}
+			result.Entries++
+			switch {
+			case entry.Seq != prev.Seq+1:
+				broken(entry, fmt.Sprintf("entry %d is missing", prev.Seq+1))
+			case entry.PrevHash != prev.EntryHash:
+				broken(entry, "previous hash does not match the previous entry")
+			case entry.EntryHash != ledgerEntryHash(entry):
+				broken(entry, "entry hash does not match its content")
+			}
+			if latest[entry.RecordType] == nil {
+				latest[entry.RecordType] = map[string]models.LedgerEntry{}
+			}
+			latest[entry.RecordType][entry.RecordID] = entry
+			prev = entry
+		}
+		return nil
+	}).Error
+	if err != nil {
+		return result, err
+	}
+	// Past a broken link the latest entry of a record is unknown
+	if result.BrokenAt != nil {
+		return result, nil
+	}
+
+	for recordType, entries := range latest {
+		result.Records += len(entries)
+		ids := make([]string, 0, len(entries))
+		for id := range entries {
+			ids = append(ids, id)
+		}
+
+		for start := 0; start < len(ids); start += ledgerBatchSize {
+			end := min(start+ledgerBatchSize, len(ids))
+			current := map[string]interface{}{}
+			switch recordType {
+			case "attendance":
+				var rows []models.Attendance
+				if err := tx.Where("id IN ?", ids[start:end]).Find(&rows).Error; err != nil {
+					return result, err
+				}
+				for _, row := range rows {
+					current[row.ID] = row
+				}
+			case "absence":
+				var rows []models.Absence
+				if err := tx.Where("id IN ?", ids[start:end]).Find(&rows).Error; err != nil {
+					return result, err
+				}
+				for _, row := range rows {
+					current[row.ID] = row
+				}
+			}
+
+			for _, id := range ids[start:end] {
+				entry := entries[id]
+				row, exists := current[id]
+				if entry.Action == "delete" {
+					if exists {
+						broken(entry, "record exists after it was deleted")
+					}
+					continue
+				}
+				if !exists {
+					broken(entry, "record was deleted without a ledger entry")
+					continue
+				}
+				_, _, hash, err := hashLedgerRecord(row)
+				if err != nil {
+					return result, err
+				}
+				if hash != entry.RecordHash {
+					broken(entry, "record was changed without a ledger entry")
+				}
+			}
+		}
+	}
+
+	result.Valid = result.BrokenAt == nil
+	return result, nil
+}
+
+// VerifyLedger walks the attendance and absence ledger and reports the first
+// broken link
+func VerifyLedger(c *fiber.Ctx) error {
+	result, err := verifyLedger(DB)
+	if err != nil {
+		utils.Logger.Error("Failed to verify ledger", zap.Error(err))
+		return c.Status(500).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrDatabaseError,
+		})
+	}
+	if !result.Valid {
+		utils.Logger.Warn("Ledger verification failed",
+			zap.Uint64("seq", *result.BrokenAt),
+			zap.String("record_id", result.RecordID),
+			zap.String("reason", result.Reason),
+		)
+	}
+
+	return c.JSON(types.APIResponse{
+		Success: true,
+		Data:    result,
+	})
+}
+
+// Rows a list returns unless the limit query parameter asks for fewer or more
+const (
+	defaultListLimit = 100
+	maxListLimit     = 1000
+)
+
+// listLimit returns the limit query parameter clamped to between 1 and
+// maxListLimit. GORM treats a limit below 1 as none at all.
+func listLimit(c *fiber.Ctx) int {
+	return min(max(c.QueryInt("limit", defaultListLimit), 1), maxListLimit)
+}
+
+// GetLedgerEntries lists ledger entries in order, optionally for the
+// record_type and record_id query parameters. Pass after=<seq> to page.
+func GetLedgerEntries(c *fiber.Ctx) error {
+	query := DB.Order("seq").Limit(listLimit(c))
+	if after := c.QueryInt("after", 0); after > 0 {
+		query = query.Where("seq > ?", after)
+	}
+	if recordType := c.Query("record_type"); recordType != "" {
+		query = query.Where("record_type = ?", recordType)
+	}
+	if recordID := c.Query("record_id"); recordID != "" {
+		query = query.Where("record_id = ?", recordID)
+	}
+
+	var entries []models.LedgerEntry
+	if err := query.Find(&entries).Error; err != nil {
+		utils.Logger.Error("Failed to fetch ledger entries", zap.Error(err))
+		return c.Status(500).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrDatabaseError,
+		})
+	}
+
+	return c.JSON(types.APIResponse{
+		Success: true,
+		Data:    entries,
+	})
+}
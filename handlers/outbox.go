@@ -0,0 +1,317 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"dapp_timekeeping/anchor"
+	"dapp_timekeeping/config"
+	"dapp_timekeeping/models"
+	"dapp_timekeeping/types"
+	"dapp_timekeeping/utils"
+
+	"github.com/gofiber/fiber/v2"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// Entries delivered per run of the worker
+const outboxBatchSize = 100
+
+var (
+	submitter anchor.Submitter
+	// Serializes the worker and deliveries started from the API
+	outboxMu sync.Mutex
+)
+
+// SetSubmitter sets the backend outbox entries are delivered to. Submit must
+// return only once the event is recorded.
+func SetSubmitter(s anchor.Submitter) {
+	submitter = s
+}
+
+// enqueueOutbox queues an event for delivery. Call it in the transaction of
+// the change the event describes.
+func enqueueOutbox(tx *gorm.DB, topic, recordID string, payload interface{}) error {
+	raw, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	return tx.Create(&models.OutboxEntry{
+		Topic:         topic,
+		RecordID:      recordID,
+		Payload:       string(raw),
+		Status:        "pending",
+		NextAttemptAt: now,
+		CreatedAt:     now,
+		UpdatedAt:     now,
+	}).Error
+}
+
+// salaryEvent is the outbox payload of a processed salary. Amounts stay off
+// the chain; only their hash is published.
+func salaryEvent(approval models.SalaryApproval) interface{} {
+	approvedBy := ""
+	if approval.ApprovedBy != nil {
+		approvedBy = *approval.ApprovedBy
+	}
+	content, _ := json.Marshal(struct {
+		ID          string  `json:"id"`
+		UserID      string  `json:"user_id"`
+		Month       string  `json:"month"`
+		BaseSalary  float64 `json:"base_salary"`
+		Deductions  float64 `json:"deductions"`
+		Bonus       float64 `json:"bonus"`
+		FinalSalary float64 `json:"final_salary"`
+		Status      string  `json:"status"`
+		ApprovedBy  string  `json:"approved_by"`
+		ApprovedAt  string  `json:"approved_at"`
+	}{approval.ID, approval.UserID, approval.Month.Format("2006-01"), approval.BaseSalary, approval.Deductions,
+		approval.Bonus, approval.FinalSalary, approval.Status, approvedBy, ledgerTimePtr(approval.ApprovedAt)})
+
+	return fiber.Map{
+		"user_id":     approval.UserID,
+		"month":       approval.Month.Format("2006-01"),
+		"status":      approval.Status,
+		"record_hash": sha256Hex(content),
+	}
+}
+
+// outboxBackoff is how long to wait after the given number of failed
+// attempts: the base delay doubled per attempt, up to the maximum
+func outboxBackoff(attempts int) time.Duration {
+	base := time.Duration(config.AppConfig.OutboxRetryBase * float64(time.Second))
+	limit := time.Duration(config.AppConfig.OutboxRetryMax * float64(time.Second))
+	delay := base
+	for i := 1; i < attempts && delay < limit; i++ {
+		delay *= 2
+	}
+	return min(delay, limit)
+}
+
+// deliverOutbox delivers the pending entries that are due, oldest first. An
+// entry is delivered once the backend has recorded the event: for the
+// canister, once it has replied to the call rather than when the replica
+// accepted it, so a call rejected afterwards is a failed attempt. A failed
+// delivery is retried with backoff until the entry runs out of attempts; it
+// does not hold up the entries after it.
+func deliverOutbox(ctx context.Context, now time.Time) (delivered, failed int, err error) {
+	outboxMu.Lock()
+	defer outboxMu.Unlock()
+	if submitter == nil {
+		return 0, 0, nil
+	}
+
+	var entries []models.OutboxEntry
+	if err := DB.Where("status = ? AND next_attempt_at <= ?", "pending", now).
+		Order("id").
+		Limit(outboxBatchSize).
+		Find(&entries).Error; err != nil {
+		return 0, 0, err
+	}
+
+	for _, entry := range entries {
+		if ctx.Err() != nil {
+			return delivered, failed, ctx.Err()
+		}
+
+		attempts := entry.Attempts + 1
+		updates := map[string]interface{}{"attempts": attempts, "updated_at": time.Now()}
+		receipt, submitErr := submitter.Submit(ctx, entry.Topic, entry.RecordID, entry.Payload)
+		switch {
+		case submitErr == nil:
+			updates["status"] = "delivered"
+			updates["backend"] = receipt.Backend
+			updates["tx_id"] = receipt.TxID
+			updates["last_error"] = ""
+			updates["delivered_at"] = receipt.AnchoredAt
+			delivered++
+		case attempts >= config.AppConfig.OutboxMaxAttempts:
+			updates["status"] = "failed"
+			updates["last_error"] = submitErr.Error()
+			failed++
+		default:
+			updates["next_attempt_at"] = time.Now().Add(outboxBackoff(attempts))
+			updates["last_error"] = submitErr.Error()
+			failed++
+		}
+		if submitErr != nil {
+			utils.Logger.Warn("Failed to deliver outbox entry",
+				zap.Uint64("id", entry.ID), zap.Int("attempts", attempts), zap.Error(submitErr))
+		}
+
+		if err := DB.Model(&models.OutboxEntry{}).
+			Where("id = ? AND status = ?", entry.ID, "pending").
+			Updates(updates).Error; err != nil {
+			return delivered, failed, err
+		}
+	}
+	return delivered, failed, nil
+}
+
+// RunOutboxWorker delivers due outbox entries every interval until ctx is done
+func RunOutboxWorker(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if delivered, failed, err := deliverOutbox(ctx, time.Now()); err != nil {
+			utils.Logger.Error("Failed to deliver outbox", zap.Int("delivered", delivered), zap.Error(err))
+		} else if delivered > 0 || failed > 0 {
+			utils.Logger.Info("Delivered outbox entries", zap.Int("delivered", delivered), zap.Int("failed", failed))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// DeliverOutbox delivers the due outbox entries now rather than waiting for
+// the worker
+func DeliverOutbox(c *fiber.Ctx) error {
+	if submitter == nil {
+		return c.Status(503).JSON(types.APIResponse{
+			Success: false,
+			Error:   "Chain delivery is not configured",
+		})
+	}
+
+	delivered, failed, err := deliverOutbox(c.UserContext(), time.Now())
+	if err != nil {
+		utils.Logger.Error("Failed to deliver outbox", zap.Error(err))
+		return c.Status(500).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrDatabaseError,
+		})
+	}
+
+	return c.JSON(types.APIResponse{
+		Success: true,
+		Data:    fiber.Map{"delivered": delivered, "failed": failed},
+	})
+}
+
+// ListOutbox lists up to limit outbox entries, newest first, optionally
+// filtered by the status, topic and record_id query parameters
+func ListOutbox(c *fiber.Ctx) error {
+	query := DB.Order("id DESC").Limit(listLimit(c))
+	if status := c.Query("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+	if topic := c.Query("topic"); topic != "" {
+		query = query.Where("topic = ?", topic)
+	}
+	if recordID := c.Query("record_id"); recordID != "" {
+		query = query.Where("record_id = ?", recordID)
+	}
+
+	var entries []models.OutboxEntry
+	if err := query.Find(&entries).Error; err != nil {
+		utils.Logger.Error("Failed to fetch outbox", zap.Error(err))
+		return c.Status(500).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrDatabaseError,
+		})
+	}
+
+	return c.JSON(types.APIResponse{
+		Success: true,
+		Data:    entries,
+	})
+}
+
+// GetOutboxEntry returns an outbox entry with its delivery status
+func GetOutboxEntry(c *fiber.Ctx) error {
+	var entry models.OutboxEntry
+	if err := DB.First(&entry, "id = ?", c.Params("id")).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return c.Status(404).JSON(types.APIResponse{
+				Success: false,
+				Error:   "Outbox entry not found",
+			})
+		}
+		utils.Logger.Error("Failed to fetch outbox entry", zap.Error(err))
+		return c.Status(500).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrDatabaseError,
+		})
+	}
+
+	return c.JSON(types.APIResponse{
+		Success: true,
+		Data:    entry,
+	})
+}
+
+// requeueOutbox puts failed entries back in the queue with fresh attempts
+func requeueOutbox(query *gorm.DB) *gorm.DB {
+	now := time.Now()
+	return query.Model(&models.OutboxEntry{}).
+		Where("status = ?", "failed").
+		Updates(map[string]interface{}{
+			"status":          "pending",
+			"attempts":        0,
+			"next_attempt_at": now,
+			"updated_at":      now,
+		})
+}
+
+// ReplayOutboxEntry queues a failed outbox entry for delivery again
+func ReplayOutboxEntry(c *fiber.Ctx) error {
+	result := requeueOutbox(DB.Where("id = ?", c.Params("id")))
+	if result.Error != nil {
+		utils.Logger.Error("Failed to replay outbox entry", zap.Error(result.Error))
+		return c.Status(500).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrDatabaseError,
+		})
+	}
+	if result.RowsAffected == 0 {
+		var count int64
+		DB.Model(&models.OutboxEntry{}).Where("id = ?", c.Params("id")).Count(&count)
+		if count == 0 {
+			return c.Status(404).JSON(types.APIResponse{
+				Success: false,
+				Error:   "Outbox entry not found",
+			})
+		}
+		return c.Status(409).JSON(types.APIResponse{
+			Success: false,
+			Error:   "Only failed entries can be replayed",
+		})
+	}
+
+	return c.JSON(types.APIResponse{
+		Success: true,
+		Message: "Outbox entry queued for delivery",
+	})
+}
+
+// ReplayFailedOutbox queues every failed outbox entry, or those of the topic
+// query parameter, for delivery again
+func ReplayFailedOutbox(c *fiber.Ctx) error {
+	query := DB
+	if topic := c.Query("topic"); topic != "" {
+		query = query.Where("topic = ?", topic)
+	}
+
+	result := requeueOutbox(query)
+	if result.Error != nil {
+		utils.Logger.Error("Failed to replay outbox", zap.Error(result.Error))
+		return c.Status(500).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrDatabaseError,
+		})
+	}
+
+	return c.JSON(types.APIResponse{
+		Success: true,
+		Message: "Failed outbox entries queued for delivery",
+		Data:    fiber.Map{"replayed": result.RowsAffected},
+	})
+}
@@ -0,0 +1,601 @@
+package handlers
+
+import (
+	"errors"
+	"math"
+	"time"
+
+	"dapp_timekeeping/models"
+	"dapp_timekeeping/types"
+	"dapp_timekeeping/utils"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type RequestOvertimeRequest struct {
+	Date   string  `json:"date" validate:"required"` // YYYY-MM-DD, today or later
+	Hours  float64 `json:"hours" validate:"required"`
+	Reason string  `json:"reason" validate:"required"`
+}
+
+type ReviewOvertimeRequest struct {
+	Hours *float64 `json:"hours"` // Approve fewer hours than requested
+	Note  string   `json:"note"`
+}
+
+// OvertimeEntry is the overtime of one attendance record
+type OvertimeEntry struct {
+	AttendanceID  string  `json:"attendance_id"`
+	Date          string  `json:"date"`           // YYYY-MM-DD of the shift
+	Category      string  `json:"category"`       // weekday, weekend or holiday
+	WorkedHours   float64 `json:"worked_hours"`   // Beyond the schedule
+	ApprovedHours float64 `json:"approved_hours"` // Covered by pre-approval
+	PaidHours     float64 `json:"paid_hours"`     // Within the monthly cap
+	Rate          float64 `json:"rate"`           // Multiplier of the hourly rate
+}
+
+// OvertimeSummary is an employee's overtime for a month
+type OvertimeSummary struct {
+	UserID    string             `json:"user_id"`
+	Month     string             `json:"month"` // YYYY-MM
+	CapHours  float64            `json:"cap_hours"`
+	PaidHours map[string]float64 `json:"paid_hours"` // By category
+	Entries   []OvertimeEntry    `json:"entries"`
+}
+
+var (
+	errOvertimeNotPending = errors.New("overtime request not pending")
+	errOvertimeMonthPaid  = errors.New("overtime month already paid")
+)
+
+var overtimeLabel = map[string]string{"weekday": "Weekday", "weekend": "Weekend", "holiday": "Holiday"}
+
+// roundHours rounds to hundredths of an hour
+func roundHours(h float64) float64 {
+	return math.Round(h*100) / 100
+}
+
+// overtimeRate is the multiplier rule sets for an overtime category
+func overtimeRate(rule models.CompanyRule, category string) float64 {
+	switch category {
+	case "holiday":
+		return rule.OvertimeHolidayRate
+	case "weekend":
+		return rule.OvertimeWeekendRate
+	}
+	return rule.OvertimeWeekdayRate
+}
+
+// monthlyOvertime computes the user's overtime for each attendance record
+// of the month starting at monthStart. Work beyond the scheduled shift
+// counts on working days; everything worked on a day off, closure or holiday
+// does. Overtime is paid up to the hours approved for the day, in check-in
+// order, until the month's cap is reached.
+func monthlyOvertime(tx *gorm.DB, user models.User, monthStart time.Time) (OvertimeSummary, error) {
+	monthEnd := monthStart.AddDate(0, 1, -1)
+	summary := OvertimeSummary{
+		UserID:    user.ID,
+		Month:     monthStart.Format("2006-01"),
+		PaidHours: map[string]float64{},
+		Entries:   []OvertimeEntry{},
+	}
+
+	var attendances []models.Attendance
+	if err := tx.Where("user_id = ? AND check_out_time > check_in_time", user.ID).
+		Where("check_in_time >= ? AND check_in_time < ?", monthStart.AddDate(0, 0, -1), monthEnd.AddDate(0, 0, 1)).
+		Order("check_in_time").
+		Find(&attendances).Error; err != nil {
+		return summary, err
+	}
+	resolver, err := loadScheduleResolver(tx, monthStart.AddDate(0, 0, -1), monthEnd)
+	if err != nil {
+		return summary, err
+	}
+	rules, err := loadCompanyRules(tx)
+	if err != nil {
+		return summary, err
+	}
+
+	var requests []models.OvertimeRequest
+	if err := tx.Where("user_id = ? AND status = ?", user.ID, "approved").
+		Where("date >= ? AND date < ?", monthStart, monthEnd.AddDate(0, 0, 1)).
+		Find(&requests).Error; err != nil {
+		return summary, err
+	}
+	approved := make(map[string]float64)
+	for _, r := range requests {
+		approved[r.Date.In(time.Local).Format("2006-01-02")] += r.ApprovedHours
+	}
+
+	summary.CapHours = rules.on(monthStart).OvertimeMonthlyCap
+	capLeft := summary.CapHours
+	for _, a := range attendances {
+		in, out := a.CheckInTime.In(time.Local), a.CheckOutTime.In(time.Local)
+		if out.Sub(in) > maxShiftDuration {
+			out = in.Add(maxShiftDuration)
+		}
+
+		shift, scheduled := resolver.shiftAt(user.ID, user.Department, in)
+		day := startOfDay(in)
+		if scheduled {
+			day = startOfDay(shift.Window.Start)
+		}
+		if day.Before(monthStart) || day.After(monthEnd) {
+			continue
+		}
+		key := day.Format("2006-01-02")
+
+		entry := OvertimeEntry{AttendanceID: a.ID, Date: key, Category: "weekday"}
+		extra := out.Sub(in)
+		special, onCalendar := resolver.calendarOn(user.Department, day)
+		switch {
+		case onCalendar && special.Kind == "holiday":
+			entry.Category = "holiday"
+		case !scheduled:
+			entry.Category = "weekend"
+		default:
+			extra -= shift.Window.overlap(in, out)
+		}
+		if extra < time.Minute {
+			continue
+		}
+
+		entry.WorkedHours = roundHours(extra.Truncate(time.Minute).Hours())
+		entry.ApprovedHours = math.Min(entry.WorkedHours, approved[key])
+		approved[key] -= entry.ApprovedHours
+		entry.PaidHours = roundHours(math.Min(entry.ApprovedHours, math.Max(0, capLeft)))
+		capLeft -= entry.PaidHours
+		entry.Rate = overtimeRate(rules.on(day), entry.Category)
+
+		summary.PaidHours[entry.Category] = roundHours(summary.PaidHours[entry.Category] + entry.PaidHours)
+		summary.Entries = append(summary.Entries, entry)
+	}
+	return summary, nil
+}
+
+// RequestOvertime asks for overtime on a day to be pre-approved
+func RequestOvertime(c *fiber.Ctx) error {
+	userID, ok := c.Locals("user_id").(string)
+	if !ok || userID == "" {
+		return c.Status(401).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrUnauthorized,
+		})
+	}
+
+	var req RequestOvertimeRequest
+	if err := c.BodyParser(&req); err != nil || req.Reason == "" {
+		return c.Status(400).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrInvalidInput,
+		})
+	}
+	if req.Hours <= 0 || req.Hours > maxShiftDuration.Hours() {
+		return c.Status(400).JSON(types.APIResponse{
+			Success: false,
+			Error:   "hours must be between 0 and 16",
+		})
+	}
+	day, err := time.ParseInLocation("2006-01-02", req.Date, time.Local)
+	if err != nil {
+		return c.Status(400).JSON(types.APIResponse{
+			Success: false,
+			Error:   "Invalid date format. Use YYYY-MM-DD",
+		})
+	}
+	if day.Before(startOfDay(time.Now())) {
+		return c.Status(400).JSON(types.APIResponse{
+			Success: false,
+			Error:   "Overtime must be requested in advance",
+		})
+	}
+
+	var open int64
+	if err := DB.Model(&models.OvertimeRequest{}).
+		Where("user_id = ? AND date = ? AND status IN ?", userID, day, []string{"pending", "approved"}).
+		Count(&open).Error; err != nil {
+		utils.Logger.Error("Failed to check overtime requests", zap.Error(err))
+		return c.Status(500).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrDatabaseError,
+		})
+	}
+	if open > 0 {
+		return c.Status(409).JSON(types.APIResponse{
+			Success: false,
+			Error:   "Overtime is already requested for that day",
+		})
+	}
+
+	now := time.Now()
+	request := models.OvertimeRequest{
+		ID:        uuid.New().String(),
+		UserID:    userID,
+		Date:      day,
+		Hours:     roundHours(req.Hours),
+		Reason:    req.Reason,
+		Status:    "pending",
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	if err := DB.Omit(clause.Associations).Create(&request).Error; err != nil {
+		utils.Logger.Error("Failed to create overtime request", zap.Error(err))
+		return c.Status(500).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrDatabaseError,
+		})
+	}
+
+	return c.JSON(types.APIResponse{
+		Success: true,
+		Message: "Overtime requested successfully",
+		Data:    request,
+	})
+}
+
+// GetMyOvertimeRequests lists the authenticated user's overtime requests
+func GetMyOvertimeRequests(c *fiber.Ctx) error {
+	userID, ok := c.Locals("user_id").(string)
+	if !ok || userID == "" {
+		return c.Status(401).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrUnauthorized,
+		})
+	}
+
+	var requests []models.OvertimeRequest
+	if err := DB.Where("user_id = ?", userID).Order("date DESC").Find(&requests).Error; err != nil {
+		utils.Logger.Error("Failed to fetch overtime requests", zap.Error(err))
+		return c.Status(500).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrDatabaseError,
+		})
+	}
+
+	return c.JSON(types.APIResponse{
+		Success: true,
+		Data:    requests,
+	})
+}
+
+// CancelOvertimeRequest withdraws one of the user's pending requests
+func CancelOvertimeRequest(c *fiber.Ctx) error {
+	userID, ok := c.Locals("user_id").(string)
+	if !ok || userID == "" {
+		return c.Status(401).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrUnauthorized,
+		})
+	}
+
+	result := DB.Model(&models.OvertimeRequest{}).
+		Where("id = ? AND user_id = ? AND status = ?", c.Params("id"), userID, "pending").
+		Updates(map[string]interface{}{"status": "cancelled", "updated_at": time.Now()})
+	if result.Error != nil {
+		utils.Logger.Error("Failed to cancel overtime request", zap.Error(result.Error))
+		return c.Status(500).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrDatabaseError,
+		})
+	}
+	if result.RowsAffected == 0 {
+		return c.Status(404).JSON(types.APIResponse{
+			Success: false,
+			Error:   "Pending overtime request not found",
+		})
+	}
+
+	return c.JSON(types.APIResponse{
+		Success: true,
+		Message: "Overtime request cancelled",
+	})
+}
+
+// ListOvertimeRequests lists requests of employees in the caller's
+// departments, filtered by the optional status and user_id query parameters
+func ListOvertimeRequests(c *fiber.Ctx) error {
+	scope, _, err := permissionScope(c, "overtime_approval")
+	if err != nil {
+		utils.Logger.Error("Failed to resolve permissions", zap.Error(err))
+		return c.Status(500).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrDatabaseError,
+		})
+	}
+
+	query := DB.Order("date, created_at")
+	if !scope.All {
+		query = query.Where("user_id IN (?)", scope.restrict(DB.Model(&models.User{}).Select("id"), "department"))
+	}
+	if status := c.Query("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+	if userID := c.Query("user_id"); userID != "" {
+		query = query.Where("user_id = ?", userID)
+	}
+
+	var requests []models.OvertimeRequest
+	if err := query.Find(&requests).Error; err != nil {
+		utils.Logger.Error("Failed to fetch overtime requests", zap.Error(err))
+		return c.Status(500).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrDatabaseError,
+		})
+	}
+
+	return c.JSON(types.APIResponse{
+		Success: true,
+		Data:    requests,
+	})
+}
+
+// reviewOvertime approves or rejects a pending request in the caller's
+// departments. Approval is only possible until the requested day ends and
+// while the month's salary is not approved; it recomputes the employee's
+// pending salary for the month.
+func reviewOvertime(c *fiber.Ctx, approve bool) error {
+	reviewerID, ok := c.Locals("user_id").(string)
+	if !ok || reviewerID == "" {
+		return c.Status(401).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrUnauthorized,
+		})
+	}
+
+	var req ReviewOvertimeRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrInvalidInput,
+		})
+	}
+
+	var request models.OvertimeRequest
+	if err := DB.Preload("User").First(&request, "id = ?", c.Params("id")).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return c.Status(404).JSON(types.APIResponse{
+				Success: false,
+				Error:   "Overtime request not found",
+			})
+		}
+		utils.Logger.Error("Failed to fetch overtime request", zap.Error(err))
+		return c.Status(500).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrDatabaseError,
+		})
+	}
+	if request.UserID == reviewerID {
+		return c.Status(403).JSON(types.APIResponse{
+			Success: false,
+			Error:   "You cannot review your own overtime",
+		})
+	}
+
+	scope, _, err := permissionScope(c, "overtime_approval")
+	if err != nil {
+		utils.Logger.Error("Failed to resolve permissions", zap.Error(err))
+		return c.Status(500).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrDatabaseError,
+		})
+	}
+	if !scope.Allows(request.User.Department) {
+		return c.Status(403).JSON(types.APIResponse{
+			Success: false,
+			Error:   "Employee is outside your departments",
+		})
+	}
+
+	now := time.Now()
+	day := request.Date.In(time.Local)
+	monthStart := time.Date(day.Year(), day.Month(), 1, 0, 0, 0, 0, time.Local)
+
+	status, hours := "rejected", 0.0
+	if approve {
+		// Approval is ahead of the work, not a record of it afterwards
+		if !now.Before(startOfDay(day).AddDate(0, 0, 1)) {
+			return c.Status(409).JSON(types.APIResponse{
+				Success: false,
+				Error:   "Overtime can only be approved before the day it is worked ends",
+			})
+		}
+		status, hours = "approved", request.Hours
+		if req.Hours != nil {
+			if *req.Hours <= 0 || *req.Hours > request.Hours {
+				return c.Status(400).JSON(types.APIResponse{
+					Success: false,
+					Error:   "Approved hours must be positive and no more than requested",
+				})
+			}
+			hours = roundHours(*req.Hours)
+		}
+	}
+
+	err = DB.Transaction(func(tx *gorm.DB) error {
+		// An approved salary is not recomputed, so the hours would go unpaid
+		if approve {
+			var paid int64
+			if err := tx.Model(&models.SalaryApproval{}).
+				Where("user_id = ? AND month = ? AND status = ?", request.UserID, monthStart, "approved").
+				Count(&paid).Error; err != nil {
+				return err
+			}
+			if paid > 0 {
+				return errOvertimeMonthPaid
+			}
+		}
+
+		result := tx.Model(&models.OvertimeRequest{}).
+			Where("id = ? AND status = ?", request.ID, "pending").
+			Updates(map[string]interface{}{
+				"status":         status,
+				"approved_hours": hours,
+				"reviewed_by":    reviewerID,
+				"review_note":    req.Note,
+				"reviewed_at":    now,
+				"updated_at":     now,
+			})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errOvertimeNotPending
+		}
+		if !approve {
+			return nil
+		}
+		_, err := recomputeSalary(tx, request.UserID, monthStart)
+		return err
+	})
+	switch {
+	case errors.Is(err, errOvertimeNotPending):
+		return c.Status(409).JSON(types.APIResponse{
+			Success: false,
+			Error:   "Overtime request has already been reviewed",
+		})
+	case errors.Is(err, errOvertimeMonthPaid):
+		return c.Status(409).JSON(types.APIResponse{
+			Success: false,
+			Error:   "Salary for that month is already approved",
+		})
+	case err != nil:
+		utils.Logger.Error("Failed to review overtime request", zap.String("request_id", request.ID), zap.Error(err))
+		return c.Status(500).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrDatabaseError,
+		})
+	}
+
+	request.Status = status
+	request.ApprovedHours = hours
+	request.ReviewedBy = &reviewerID
+	request.ReviewNote = req.Note
+	request.ReviewedAt = &now
+	request.UpdatedAt = now
+	return c.JSON(types.APIResponse{
+		Success: true,
+		Message: "Overtime request " + status,
+		Data:    request,
+	})
+}
+
+// ApproveOvertime pre-approves a request, optionally for fewer hours
+func ApproveOvertime(c *fiber.Ctx) error {
+	return reviewOvertime(c, true)
+}
+
+// RejectOvertime rejects a request
+func RejectOvertime(c *fiber.Ctx) error {
+	return reviewOvertime(c, false)
+}
+
+// overtimeMonth parses the month query parameter, the current month by default
+func overtimeMonth(c *fiber.Ctx) (time.Time, error) {
+	if month := c.Query("month"); month != "" {
+		return parseMonth(month)
+	}
+	now := time.Now()
+	return time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.Local), nil
+}
+
+// GetMyOvertime returns the authenticated user's overtime for the month
+// query parameter (YYYY-MM)
+func GetMyOvertime(c *fiber.Ctx) error {
+	userID, ok := c.Locals("user_id").(string)
+	if !ok || userID == "" {
+		return c.Status(401).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrUnauthorized,
+		})
+	}
+	monthStart, err := overtimeMonth(c)
+	if err != nil {
+		return c.Status(400).JSON(types.APIResponse{
+			Success: false,
+			Error:   "Invalid month format. Use YYYY-MM",
+		})
+	}
+
+	var user models.User
+	if err := DB.First(&user, "id = ?", userID).Error; err != nil {
+		utils.Logger.Error("Failed to fetch user", zap.Error(err))
+		return c.Status(500).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrDatabaseError,
+		})
+	}
+
+	summary, err := monthlyOvertime(DB, user, monthStart)
+	if err != nil {
+		utils.Logger.Error("Failed to compute overtime", zap.Error(err))
+		return c.Status(500).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrDatabaseError,
+		})
+	}
+
+	return c.JSON(types.APIResponse{
+		Success: true,
+		Data:    summary,
+	})
+}
+
+// GetEmployeeOvertime returns an employee's overtime for the month query
+// parameter (YYYY-MM)
+func GetEmployeeOvertime(c *fiber.Ctx) error {
+	monthStart, err := overtimeMonth(c)
+	if err != nil {
+		return c.Status(400).JSON(types.APIResponse{
+			Success: false,
+			Error:   "Invalid month format. Use YYYY-MM",
+		})
+	}
+
+	var user models.User
+	if err := DB.First(&user, "id = ?", c.Params("id")).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return c.Status(404).JSON(types.APIResponse{
+				Success: false,
+				Error:   "Employee not found",
+			})
+		}
+		utils.Logger.Error("Failed to fetch employee", zap.Error(err))
+		return c.Status(500).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrDatabaseError,
+		})
+	}
+
+	scope, _, err := permissionScope(c, "overtime_approval")
+	if err != nil {
+		utils.Logger.Error("Failed to resolve permissions", zap.Error(err))
+		return c.Status(500).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrDatabaseError,
+		})
+	}
+	if !scope.Allows(user.Department) {
+		return c.Status(403).JSON(types.APIResponse{
+			Success: false,
+			Error:   "Employee is outside your departments",
+		})
+	}
+
+	summary, err := monthlyOvertime(DB, user, monthStart)
+	if err != nil {
+		utils.Logger.Error("Failed to compute overtime", zap.Error(err))
+		return c.Status(500).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrDatabaseError,
+		})
+	}
+
+	return c.JSON(types.APIResponse{
+		Success: true,
+		Data:    summary,
+	})
+}
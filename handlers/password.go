@@ -0,0 +1,386 @@
+package handlers
+
+import (
+	"strings"
+	"time"
+
+	"dapp_timekeeping/auth"
+	"dapp_timekeeping/config"
+	"dapp_timekeeping/models"
+	"dapp_timekeeping/types"
+	"dapp_timekeeping/utils"
+
+	"github.com/gofiber/fiber/v2"
+	"go.uber.org/zap"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+// bcrypt accepts at most 72 bytes of password
+const (
+	minPasswordLength = 8
+	maxPasswordLength = 72
+)
+
+// Compared against when the nickname is unknown, so that the response takes
+// as long as for a wrong password
+var dummyPasswordHash, _ = bcrypt.GenerateFromPassword([]byte("not a password"), bcrypt.DefaultCost)
+
+// RegisterRequest proves the caller is the new hire with the referral code
+// root issued for the employee record; the code stays redeemable
+type RegisterRequest struct {
+	Nickname string `json:"nickname" validate:"required"`
+	Code     string `json:"code" validate:"required"`
+	Password string `json:"password" validate:"required"`
+}
+
+type LoginRequest struct {
+	Nickname string `json:"nickname" validate:"required"`
+	Password string `json:"password" validate:"required"`
+}
+
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password" validate:"required"`
+	NewPassword     string `json:"new_password" validate:"required"`
+}
+
+type LoginResponse struct {
+	Token            string      `json:"token"`
+	ExpiresAt        time.Time   `json:"expires_at"`
+	RefreshToken     string      `json:"refresh_token"`
+	RefreshExpiresAt time.Time   `json:"refresh_expires_at"`
+	User             models.User `json:"user"`
+}
+
+func validPassword(password string) bool {
+	return len(password) >= minPasswordLength && len(password) <= maxPasswordLength
+}
+
+func hashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	return string(hash), err
+}
+
+// canLogIn reports whether the account may sign in. Pending users become
+// active by redeeming their referral code, which needs no session.
+func canLogIn(user models.User) bool {
+	return user.Status == "active"
+}
+
+func isLocked(user models.User, now time.Time) bool {
+	return user.LockedUntil != nil && user.LockedUntil.After(now)
+}
+
+// checkPassword compares password with the user's hash. Failures count
+// towards locking the account; a success clears the count.
+func checkPassword(user models.User, password string) (bool, error) {
+	if bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)) != nil {
+		return false, recordFailedLogin(user.ID)
+	}
+	return true, clearFailedLogins(user)
+}
+
+// recordFailedLogin counts a failed login and locks the account once
+// MaxFailedLogins is reached
+func recordFailedLogin(userID string) error {
+	return DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.User{}).Where("id = ?", userID).
+			Update("failed_logins", gorm.Expr("failed_logins + 1")).Error; err != nil {
+			return err
+		}
+
+		var failed int
+		if err := tx.Model(&models.User{}).Where("id = ?", userID).Pluck("failed_logins", &failed).Error; err != nil {
+			return err
+		}
+		if failed < config.AppConfig.MaxFailedLogins {
+			return nil
+		}
+
+		lockout := time.Duration(config.AppConfig.LoginLockoutMinutes * float64(time.Minute))
+		utils.Logger.Warn("Account locked after failed logins", zap.String("user_id", userID), zap.Int("attempts", failed))
+		return tx.Model(&models.User{}).Where("id = ?", userID).
+			Updates(map[string]interface{}{"failed_logins": 0, "locked_until": time.Now().Add(lockout)}).Error
+	})
+}
+
+func clearFailedLogins(user models.User) error {
+	if user.FailedLogins == 0 && user.LockedUntil == nil {
+		return nil
+	}
+	return DB.Model(&models.User{}).Where("id = ?", user.ID).
+		Updates(map[string]interface{}{"failed_logins": 0, "locked_until": nil}).Error
+}
+
+// Register sets the password of an employee record created by root. The
+// record must still be pending and have no password, and the caller must
+// hold an active referral code issued for it.
+func Register(c *fiber.Ctx) error {
+	var req RegisterRequest
+	if err := c.BodyParser(&req); err != nil || req.Nickname == "" || req.Code == "" {
+		return c.Status(400).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrInvalidInput,
+		})
+	}
+	if !validPassword(req.Password) {
+		return c.Status(400).JSON(types.APIResponse{
+			Success: false,
+			Error:   "Password must be 8 to 72 characters",
+		})
+	}
+
+	var user models.User
+	if err := DB.First(&user, "nickname = ?", req.Nickname).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return c.Status(404).JSON(types.APIResponse{
+				Success: false,
+				Error:   "No employee record for this nickname",
+			})
+		}
+		utils.Logger.Error("Failed to fetch user", zap.Error(err))
+		return c.Status(500).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrDatabaseError,
+		})
+	}
+	if user.PasswordHash != "" {
+		return c.Status(409).JSON(types.APIResponse{
+			Success: false,
+			Error:   "Account is already registered",
+		})
+	}
+	if user.Status != "pending" {
+		return c.Status(409).JSON(types.APIResponse{
+			Success: false,
+			Error:   "Only pending employee records can be registered",
+		})
+	}
+
+	// Knowing the nickname is not enough to claim the record
+	var code models.ReferralCode
+	err := DB.First(&code, "code = ? AND user_id = ?", strings.ToUpper(req.Code), user.ID).Error
+	if err != nil && err != gorm.ErrRecordNotFound {
+		utils.Logger.Error("Failed to fetch referral code", zap.Error(err))
+		return c.Status(500).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrDatabaseError,
+		})
+	}
+	if err == gorm.ErrRecordNotFound || referralStatus(code, time.Now()) != "active" {
+		return c.Status(403).JSON(types.APIResponse{
+			Success: false,
+			Error:   "Invalid or expired referral code",
+		})
+	}
+
+	hash, err := hashPassword(req.Password)
+	if err != nil {
+		utils.Logger.Error("Failed to hash password", zap.Error(err))
+		return c.Status(500).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrInternalError,
+		})
+	}
+
+	// Guard on the empty hash so two registrations cannot both succeed
+	now := time.Now()
+	result := DB.Model(&models.User{}).
+		Where("id = ? AND password_hash = ''", user.ID).
+		Updates(map[string]interface{}{
+			"password_hash":       hash,
+			"password_changed_at": now,
+			"updated_at":          now,
+		})
+	if result.Error != nil {
+		utils.Logger.Error("Failed to register user", zap.Error(result.Error))
+		return c.Status(500).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrDatabaseError,
+		})
+	}
+	if result.RowsAffected == 0 {
+		return c.Status(409).JSON(types.APIResponse{
+			Success: false,
+			Error:   "Account is already registered",
+		})
+	}
+
+	return c.JSON(types.APIResponse{
+		Success: true,
+		Message: "Registration successful, you can now log in",
+	})
+}
+
+// Login checks a nickname and password and returns an access token
+func Login(c *fiber.Ctx) error {
+	var req LoginRequest
+	if err := c.BodyParser(&req); err != nil || req.Nickname == "" || req.Password == "" {
+		return c.Status(400).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrInvalidInput,
+		})
+	}
+
+	invalid := func() error {
+		return c.Status(401).JSON(types.APIResponse{
+			Success: false,
+			Error:   "Invalid nickname or password",
+		})
+	}
+
+	var user models.User
+	if err := DB.First(&user, "nickname = ?", req.Nickname).Error; err != nil {
+		if err != gorm.ErrRecordNotFound {
+			utils.Logger.Error("Failed to fetch user", zap.Error(err))
+			return c.Status(500).JSON(types.APIResponse{
+				Success: false,
+				Error:   types.ErrDatabaseError,
+			})
+		}
+		bcrypt.CompareHashAndPassword(dummyPasswordHash, []byte(req.Password))
+		return invalid()
+	}
+	if user.PasswordHash == "" || !canLogIn(user) {
+		bcrypt.CompareHashAndPassword(dummyPasswordHash, []byte(req.Password))
+		return invalid()
+	}
+
+	if isLocked(user, time.Now()) {
+		return c.Status(423).JSON(types.APIResponse{
+			Success: false,
+			Error:   "Account is locked after too many failed logins, try again later",
+		})
+	}
+
+	ok, err := checkPassword(user, req.Password)
+	if err != nil {
+		utils.Logger.Error("Failed to record login attempt", zap.Error(err))
+		return c.Status(500).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrDatabaseError,
+		})
+	}
+	if !ok {
+		return invalid()
+	}
+
+	session, err := issueSession(DB, user, "")
+	if err != nil {
+		utils.Logger.Error("Failed to issue token", zap.Error(err))
+		return c.Status(500).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrInternalError,
+		})
+	}
+
+	return c.JSON(types.APIResponse{
+		Success: true,
+		Data:    session,
+	})
+}
+
+// ChangePassword replaces the caller's password after checking the current one
+// and logs the caller out of their other sessions
+func ChangePassword(c *fiber.Ctx) error {
+	userID, ok := c.Locals("user_id").(string)
+	if !ok || userID == "" {
+		return c.Status(401).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrUnauthorized,
+		})
+	}
+
+	var req ChangePasswordRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrInvalidInput,
+		})
+	}
+	if !validPassword(req.NewPassword) {
+		return c.Status(400).JSON(types.APIResponse{
+			Success: false,
+			Error:   "Password must be 8 to 72 characters",
+		})
+	}
+
+	var user models.User
+	if err := DB.First(&user, "id = ?", userID).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return c.Status(404).JSON(types.APIResponse{
+				Success: false,
+				Error:   "User not found",
+			})
+		}
+		utils.Logger.Error("Failed to fetch user", zap.Error(err))
+		return c.Status(500).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrDatabaseError,
+		})
+	}
+	if user.PasswordHash == "" {
+		return c.Status(409).JSON(types.APIResponse{
+			Success: false,
+			Error:   "Account has no password, register first",
+		})
+	}
+	if isLocked(user, time.Now()) {
+		return c.Status(423).JSON(types.APIResponse{
+			Success: false,
+			Error:   "Account is locked after too many failed logins, try again later",
+		})
+	}
+
+	ok, err := checkPassword(user, req.CurrentPassword)
+	if err != nil {
+		utils.Logger.Error("Failed to record login attempt", zap.Error(err))
+		return c.Status(500).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrDatabaseError,
+		})
+	}
+	if !ok {
+		return c.Status(401).JSON(types.APIResponse{
+			Success: false,
+			Error:   "Current password is incorrect",
+		})
+	}
+
+	hash, err := hashPassword(req.NewPassword)
+	if err != nil {
+		utils.Logger.Error("Failed to hash password", zap.Error(err))
+		return c.Status(500).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrInternalError,
+		})
+	}
+
+	// Sessions opened elsewhere, possibly with the old password, are ended
+	var currentJTI string
+	if claims, ok := auth.ClaimsFrom(c); ok {
+		currentJTI = claims.ID
+	}
+	now := time.Now()
+	if err := DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.User{}).Where("id = ?", user.ID).Updates(map[string]interface{}{
+			"password_hash":       hash,
+			"password_changed_at": now,
+			"updated_at":          now,
+		}).Error; err != nil {
+			return err
+		}
+		return revokeOtherSessions(tx, user.ID, currentJTI, "password changed")
+	}); err != nil {
+		utils.Logger.Error("Failed to change password", zap.Error(err))
+		return c.Status(500).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrDatabaseError,
+		})
+	}
+
+	return c.JSON(types.APIResponse{
+		Success: true,
+		Message: "Password changed successfully",
+	})
+}
@@ -0,0 +1,599 @@
+package handlers
+
+import (
+	"dapp_timekeeping/models"
+	"dapp_timekeeping/types"
+	"dapp_timekeeping/utils"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type RunPayrollRequest struct {
+	Month string `json:"month" validate:"required"` // YYYY-MM
+}
+
+type PayrollRunResult struct {
+	Month      string                  `json:"month"`
+	Created    int                     `json:"created"`
+	Recomputed int                     `json:"recomputed"`
+	Skipped    int                     `json:"skipped"` // Already approved
+	Records    []models.SalaryApproval `json:"records"`
+}
+
+type CreatePayrollBonusRequest struct {
+	Name       string  `json:"name" validate:"required"`
+	Amount     float64 `json:"amount" validate:"required,gt=0"`
+	UserID     string  `json:"user_id"`
+	Department string  `json:"department"`
+	Month      string  `json:"month"` // YYYY-MM, empty for every month
+}
+
+func roundMoney(amount float64) float64 {
+	return math.Round(amount*100) / 100
+}
+
+// parseMonth parses YYYY-MM into the first day of that month
+func parseMonth(value string) (time.Time, error) {
+	return time.ParseInLocation("2006-01", value, time.Local)
+}
+
+// employmentPeriod is the part of a pay month an employee was on staff
+type employmentPeriod struct {
+	From time.Time
+	To   time.Time
+}
+
+func (p employmentPeriod) contains(day time.Time) bool {
+	return !day.Before(p.From) && !day.After(p.To)
+}
+
+// resignationDates maps users to the last day of employment recorded by
+// their approved resign absence
+func resignationDates(tx *gorm.DB) (map[string]time.Time, error) {
+	var resignations []models.Absence
+	if err := tx.Where("type = ? AND status = ?", "resign", "approved").Find(&resignations).Error; err != nil {
+		return nil, err
+	}
+	dates := make(map[string]time.Time, len(resignations))
+	for _, r := range resignations {
+		last := r.StartDate
+		if last.IsZero() {
+			last = r.Date
+		}
+		dates[r.UserID] = startOfDay(last.In(time.Local))
+	}
+	return dates, nil
+}
+
+// bonusApplies reports whether a configured bonus is due to the user for the month
+func bonusApplies(bonus models.PayrollBonus, user models.User, monthStart time.Time) bool {
+	if bonus.UserID != nil && *bonus.UserID != user.ID {
+		return false
+	}
+	if bonus.Department != "" && bonus.Department != user.Department {
+		return false
+	}
+	if bonus.Month != nil && !startOfDay(bonus.Month.In(time.Local)).Equal(monthStart) {
+		return false
+	}
+	return true
+}
+
+// calculateSalary computes one employee's pay for the month starting at
+// monthStart. The monthly salary is prorated over the scheduled working days
+// the employee was on staff; unpaid absence days and violation penalties are
+// deducted, approved overtime and configured bonuses added and statutory
+// deductions applied to the result. It returns false when the employee was
+// not employed during the month.
+func calculateSalary(tx *gorm.DB, user models.User, monthStart time.Time, resignedOn *time.Time, bonuses []models.PayrollBonus) (models.SalaryApproval, bool, error) {
+	monthEnd := monthStart.AddDate(0, 1, -1)
+
+	period := employmentPeriod{From: monthStart, To: monthEnd}
+	if !user.OnboardDate.IsZero() {
+		if onboard := startOfDay(user.OnboardDate.In(time.Local)); onboard.After(period.From) {
+			period.From = onboard
+		}
+	}
+	if resignedOn != nil && resignedOn.Before(period.To) {
+		period.To = *resignedOn
+	}
+	if period.From.After(period.To) {
+		return models.SalaryApproval{}, false, nil
+	}
+
+	resolver, err := loadScheduleResolver(tx, monthStart, monthEnd)
+	if err != nil {
+		return models.SalaryApproval{}, false, err
+	}
+
+	var scheduledDays, employedDays float64
+	workingDays := make(map[string]bool)
+	for day := monthStart; !day.After(monthEnd); day = day.AddDate(0, 0, 1) {
+		if _, ok := resolver.shiftOn(user.ID, user.Department, day); !ok {
+			continue
+		}
+		scheduledDays++
+		if period.contains(day) {
+			employedDays++
+			workingDays[day.Format("2006-01-02")] = true
+		}
+	}
+	if scheduledDays == 0 {
+		return models.SalaryApproval{}, false, nil
+	}
+	dailyRate := user.Salary / scheduledDays
+
+	var items []models.SalaryLineItem
+	base := roundMoney(dailyRate * employedDays)
+	items = append(items, models.SalaryLineItem{
+		Kind:        "earning",
+		Code:        "base_salary",
+		Description: fmt.Sprintf("Base salary for %.0f of %.0f working days", employedDays, scheduledDays),
+		Quantity:    employedDays,
+		Unit:        "day",
+		Amount:      base,
+	})
+
+	// Unpaid absence days, counted once per working day
+	var unpaid []models.Absence
+	if err := tx.Where("user_id = ? AND type = ? AND status <> ?", user.ID, "leave_without_permission", "rejected").
+		Where("(start_date <= ? AND end_date >= ?) OR (date >= ? AND date < ?)", monthEnd, monthStart, monthStart, monthEnd.AddDate(0, 0, 1)).
+		Find(&unpaid).Error; err != nil {
+		return models.SalaryApproval{}, false, err
+	}
+	unpaidDays := make(map[string]bool)
+	for _, a := range unpaid {
+		start, end := a.StartDate, a.EndDate
+		if start.IsZero() {
+			start, end = a.Date, a.Date
+		}
+		for day := startOfDay(start.In(time.Local)); !day.After(end); day = day.AddDate(0, 0, 1) {
+			if key := day.Format("2006-01-02"); workingDays[key] {
+				unpaidDays[key] = true
+			}
+		}
+	}
+	// Scheduled days nobody turned up to are unpaid as well, unless appealed
+	var noShows []models.Violation
+	if err := tx.Where("user_id = ? AND type = ? AND source_type = ? AND status = ?", user.ID, "unauthorized_absence", "schedule", "active").
+		Where("date >= ? AND date < ?", monthStart, monthEnd.AddDate(0, 0, 1)).
+		Find(&noShows).Error; err != nil {
+		return models.SalaryApproval{}, false, err
+	}
+	for _, v := range noShows {
+		if key := v.Date.In(time.Local).Format("2006-01-02"); workingDays[key] {
+			unpaidDays[key] = true
+		}
+	}
+	if n := float64(len(unpaidDays)); n > 0 {
+		items = append(items, models.SalaryLineItem{
+			Kind:        "deduction",
+			Code:        "unpaid_absence",
+			Description: "Absence without permission",
+			Quantity:    n,
+			Unit:        "day",
+			Amount:      roundMoney(dailyRate * n),
+		})
+	}
+
+	// Violation penalties, fixed by the rules in force on the day of each
+	var violations []models.Violation
+	if err := tx.Where("user_id = ? AND status = ? AND penalty > 0", user.ID, "active").
+		Where("date >= ? AND date < ?", period.From, period.To.AddDate(0, 0, 1)).
+		Order("date, created_at").
+		Find(&violations).Error; err != nil {
+		return models.SalaryApproval{}, false, err
+	}
+	type penaltyGroup struct {
+		violationType string
+		penalty       float64
+	}
+	var groups []penaltyGroup
+	counts := make(map[penaltyGroup]int)
+	for _, v := range violations {
+		g := penaltyGroup{v.Type, v.Penalty}
+		if counts[g] == 0 {
+			groups = append(groups, g)
+		}
+		counts[g]++
+	}
+	for _, g := range groups {
+		n := float64(counts[g])
+		item := violationLineItem[g.violationType]
+		items = append(items, models.SalaryLineItem{
+			Kind:        "deduction",
+			Code:        item.code,
+			Description: item.description,
+			Quantity:    n,
+			Unit:        "incident",
+			Amount:      roundMoney(g.penalty * n),
+		})
+	}
+
+	// Approved overtime, at the hourly rate of a standard working day
+	overtime, err := monthlyOvertime(tx, user, monthStart)
+	if err != nil {
+		return models.SalaryApproval{}, false, err
+	}
+	rules, err := loadCompanyRules(tx)
+	if err != nil {
+		return models.SalaryApproval{}, false, err
+	}
+	type overtimeGroup struct {
+		category string
+		rate     float64
+	}
+	var overtimeGroups []overtimeGroup
+	overtimeHours := make(map[overtimeGroup]float64)
+	overtimePay := make(map[overtimeGroup]float64)
+	// The premium over normal pay for the hours is exempt from income tax
+	var overtimePremium float64
+	for _, e := range overtime.Entries {
+		day, _ := time.ParseInLocation("2006-01-02", e.Date, time.Local)
+		if e.PaidHours == 0 || !period.contains(day) {
+			continue
+		}
+		g := overtimeGroup{e.Category, e.Rate}
+		if _, ok := overtimeHours[g]; !ok {
+			overtimeGroups = append(overtimeGroups, g)
+		}
+		hourly := dailyRate / rules.on(day).StandardDailyHours
+		overtimeHours[g] += e.PaidHours
+		overtimePay[g] += e.PaidHours * hourly * e.Rate
+		overtimePremium += e.PaidHours * hourly * math.Max(0, e.Rate-1)
+	}
+	for _, g := range overtimeGroups {
+		items = append(items, models.SalaryLineItem{
+			Kind:        "bonus",
+			Code:        "overtime_" + g.category,
+			Description: fmt.Sprintf("%s overtime at %.0f%%", overtimeLabel[g.category], g.rate*100),
+			Quantity:    roundHours(overtimeHours[g]),
+			Unit:        "hour",
+			Amount:      roundMoney(overtimePay[g]),
+		})
+	}
+
+	for _, b := range bonuses {
+		if !bonusApplies(b, user, monthStart) {
+			continue
+		}
+		items = append(items, models.SalaryLineItem{
+			Kind:        "bonus",
+			Code:        "bonus",
+			Description: b.Name,
+			Quantity:    1,
+			Amount:      roundMoney(b.Amount),
+		})
+	}
+
+	approval := models.SalaryApproval{
+		UserID:     user.ID,
+		Month:      monthStart,
+		BaseSalary: base,
+		Status:     "pending",
+		LineItems:  items,
+	}
+	totalSalary(&approval)
+
+	// Statutory insurance and tax are charged on what was actually earned
+	approval.LineItems = append(approval.LineItems, statutoryItems(StatutoryInput{
+		User:       user,
+		Month:      monthStart,
+		Gross:      approval.FinalSalary,
+		NonTaxable: roundMoney(overtimePremium),
+		UnpaidDays: scheduledDays - employedDays + float64(len(unpaidDays)),
+	})...)
+	totalSalary(&approval)
+	return approval, true, nil
+}
+
+// totalSalary sums the line items into the approval's totals
+func totalSalary(approval *models.SalaryApproval) {
+	approval.Deductions, approval.Bonus = 0, 0
+	for _, item := range approval.LineItems {
+		switch item.Kind {
+		case "deduction":
+			approval.Deductions += item.Amount
+		case "bonus":
+			approval.Bonus += item.Amount
+		}
+	}
+	approval.Deductions = roundMoney(approval.Deductions)
+	approval.Bonus = roundMoney(approval.Bonus)
+	approval.FinalSalary = roundMoney(math.Max(0, approval.BaseSalary-approval.Deductions+approval.Bonus))
+}
+
+// savePayroll creates or recomputes the approval for the user and month.
+// It returns the stored record and whether it already existed.
+func savePayroll(tx *gorm.DB, approval models.SalaryApproval) (models.SalaryApproval, bool, error) {
+	now := time.Now()
+	items := approval.LineItems
+	approval.LineItems = nil
+	approval.UpdatedAt = now
+
+	var existing models.SalaryApproval
+	err := tx.Where("user_id = ? AND month = ?", approval.UserID, approval.Month).First(&existing).Error
+	existed := err == nil
+	switch {
+	case existed:
+		approval.ID = existing.ID
+		approval.CreatedAt = existing.CreatedAt
+		if err := tx.Where("salary_approval_id = ?", existing.ID).Delete(&models.SalaryLineItem{}).Error; err != nil {
+			return approval, existed, err
+		}
+		if err := tx.Omit(clause.Associations).Save(&approval).Error; err != nil {
+			return approval, existed, err
+		}
+	case err == gorm.ErrRecordNotFound:
+		approval.ID = uuid.New().String()
+		approval.CreatedAt = now
+		if err := tx.Omit(clause.Associations).Create(&approval).Error; err != nil {
+			return approval, existed, err
+		}
+	default:
+		return approval, existed, err
+	}
+
+	for i := range items {
+		items[i].ID = uuid.New().String()
+		items[i].SalaryApprovalID = approval.ID
+	}
+	if len(items) > 0 {
+		if err := tx.Create(&items).Error; err != nil {
+			return approval, existed, err
+		}
+	}
+	approval.LineItems = items
+	return approval, existed, nil
+}
+
+// RunPayroll calculates salaries for every employee on staff during a month.
+// Running it again recomputes pending and rejected records; approved records
+// are left untouched.
+func RunPayroll(c *fiber.Ctx) error {
+	var req RunPayrollRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrInvalidInput,
+		})
+	}
+	monthStart, err := parseMonth(req.Month)
+	if err != nil {
+		return c.Status(400).JSON(types.APIResponse{
+			Success: false,
+			Error:   "Invalid month format. Use YYYY-MM",
+		})
+	}
+
+	tx := DB.Begin()
+
+	result, err := runPayroll(tx, monthStart)
+	if err != nil {
+		tx.Rollback()
+		utils.Logger.Error("Payroll run failed", zap.String("month", req.Month), zap.Error(err))
+		return c.Status(500).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrDatabaseError,
+		})
+	}
+
+	tx.Commit()
+
+	return c.JSON(types.APIResponse{
+		Success: true,
+		Message: "Payroll calculated successfully",
+		Data:    result,
+	})
+}
+
+func runPayroll(tx *gorm.DB, monthStart time.Time) (PayrollRunResult, error) {
+	result := PayrollRunResult{Month: monthStart.Format("2006-01")}
+
+	var users []models.User
+	if err := tx.Where("status IN ?", []string{"active", "left_company"}).Find(&users).Error; err != nil {
+		return result, err
+	}
+	resignations, err := resignationDates(tx)
+	if err != nil {
+		return result, err
+	}
+	var bonuses []models.PayrollBonus
+	if err := tx.Find(&bonuses).Error; err != nil {
+		return result, err
+	}
+
+	// Violations of the month's finished days are charged, so make sure
+	// they have all been raised
+	last := lastFinishedDay(time.Now())
+	if monthEnd := monthStart.AddDate(0, 1, -1); monthEnd.Before(last) {
+		last = monthEnd
+	}
+	if !last.Before(monthStart) {
+		if _, err := detectViolations(tx, monthStart, last); err != nil {
+			return result, err
+		}
+	}
+
+	var approved []string
+	if err := tx.Model(&models.SalaryApproval{}).
+		Where("month = ? AND status = ?", monthStart, "approved").
+		Pluck("user_id", &approved).Error; err != nil {
+		return result, err
+	}
+	locked := make(map[string]bool, len(approved))
+	for _, id := range approved {
+		locked[id] = true
+	}
+
+	for _, user := range users {
+		if locked[user.ID] {
+			result.Skipped++
+			continue
+		}
+
+		var resignedOn *time.Time
+		if d, ok := resignations[user.ID]; ok {
+			resignedOn = &d
+		} else if user.Status == "left_company" {
+			// No recorded last day; nothing to pay
+			continue
+		}
+
+		approval, employed, err := calculateSalary(tx, user, monthStart, resignedOn, bonuses)
+		if err != nil {
+			return result, err
+		}
+		if !employed {
+			continue
+		}
+
+		saved, existed, err := savePayroll(tx, approval)
+		if err != nil {
+			return result, err
+		}
+		if existed {
+			result.Recomputed++
+		} else {
+			result.Created++
+		}
+		result.Records = append(result.Records, saved)
+	}
+
+	return result, nil
+}
+
+// recomputeSalary recalculates one employee's pending or rejected salary for
+// the month after their records changed. Approved and missing records are
+// left alone; it reports whether a record was recomputed.
+func recomputeSalary(tx *gorm.DB, userID string, monthStart time.Time) (bool, error) {
+	var existing models.SalaryApproval
+	err := tx.Where("user_id = ? AND month = ?", userID, monthStart).First(&existing).Error
+	if err == gorm.ErrRecordNotFound || (err == nil && existing.Status == "approved") {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	var user models.User
+	if err := tx.First(&user, "id = ?", userID).Error; err != nil {
+		return false, err
+	}
+	resignations, err := resignationDates(tx)
+	if err != nil {
+		return false, err
+	}
+	var resignedOn *time.Time
+	if d, ok := resignations[user.ID]; ok {
+		resignedOn = &d
+	}
+	var bonuses []models.PayrollBonus
+	if err := tx.Find(&bonuses).Error; err != nil {
+		return false, err
+	}
+
+	approval, employed, err := calculateSalary(tx, user, monthStart, resignedOn, bonuses)
+	if err != nil || !employed {
+		return false, err
+	}
+	if _, _, err := savePayroll(tx, approval); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// CreatePayrollBonus configures a bonus for the payroll run
+func CreatePayrollBonus(c *fiber.Ctx) error {
+	var req CreatePayrollBonusRequest
+	if err := c.BodyParser(&req); err != nil || req.Name == "" || req.Amount <= 0 {
+		return c.Status(400).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrInvalidInput,
+		})
+	}
+
+	bonus := models.PayrollBonus{
+		ID:         uuid.New().String(),
+		Name:       req.Name,
+		Amount:     req.Amount,
+		Department: req.Department,
+		CreatedAt:  time.Now(),
+		UpdatedAt:  time.Now(),
+	}
+	if req.UserID != "" {
+		bonus.UserID = &req.UserID
+	}
+	if req.Month != "" {
+		month, err := parseMonth(req.Month)
+		if err != nil {
+			return c.Status(400).JSON(types.APIResponse{
+				Success: false,
+				Error:   "Invalid month format. Use YYYY-MM",
+			})
+		}
+		bonus.Month = &month
+	}
+
+	if err := DB.Create(&bonus).Error; err != nil {
+		utils.Logger.Error("Failed to create payroll bonus", zap.Error(err))
+		return c.Status(500).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrDatabaseError,
+		})
+	}
+
+	return c.JSON(types.APIResponse{
+		Success: true,
+		Message: "Bonus created successfully",
+		Data:    bonus,
+	})
+}
+
+// ListPayrollBonuses returns all configured bonuses
+func ListPayrollBonuses(c *fiber.Ctx) error {
+	var bonuses []models.PayrollBonus
+	if err := DB.Order("created_at DESC").Find(&bonuses).Error; err != nil {
+		utils.Logger.Error("Failed to fetch payroll bonuses", zap.Error(err))
+		return c.Status(500).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrDatabaseError,
+		})
+	}
+
+	return c.JSON(types.APIResponse{
+		Success: true,
+		Data:    bonuses,
+	})
+}
+
+// DeletePayrollBonus removes a configured bonus. Existing salary records keep it
+// until they are recomputed.
+func DeletePayrollBonus(c *fiber.Ctx) error {
+	result := DB.Delete(&models.PayrollBonus{}, "id = ?", c.Params("id"))
+	if result.Error != nil {
+		utils.Logger.Error("Failed to delete payroll bonus", zap.Error(result.Error))
+		return c.Status(500).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrDatabaseError,
+		})
+	}
+	if result.RowsAffected == 0 {
+		return c.Status(404).JSON(types.APIResponse{
+			Success: false,
+			Error:   "Bonus not found",
+		})
+	}
+
+	return c.JSON(types.APIResponse{
+		Success: true,
+		Message: "Bonus deleted successfully",
+	})
+}
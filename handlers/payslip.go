@@ -0,0 +1,425 @@
+package handlers
+
+import (
+	"archive/zip"
+	"bytes"
+	"dapp_timekeeping/models"
+	"dapp_timekeeping/types"
+	"dapp_timekeeping/utils"
+	"fmt"
+	"html/template"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go.uber.org/zap"
+)
+
+type PayslipSummary struct {
+	ID          string     `json:"id"`
+	Month       string     `json:"month"` // YYYY-MM
+	FinalSalary float64    `json:"final_salary"`
+	ApprovedAt  *time.Time `json:"approved_at"`
+}
+
+// payslip is an approved salary with the employee it belongs to
+type payslip struct {
+	User     models.User
+	Approval models.SalaryApproval
+}
+
+type payslipSection struct {
+	Title string
+	Items []models.SalaryLineItem
+}
+
+// payslipSectionOrder lists line item kinds in the order they are printed
+var payslipSectionOrder = []struct{ kind, title string }{
+	{"earning", "Earnings"},
+	{"bonus", "Bonuses"},
+	{"deduction", "Deductions"},
+	{"employer", "Employer contributions (not deducted)"},
+}
+
+func (p payslip) Month() string {
+	return p.Approval.Month.In(time.Local).Format("January 2006")
+}
+
+func (p payslip) Sections() []payslipSection {
+	var sections []payslipSection
+	for _, s := range payslipSectionOrder {
+		section := payslipSection{Title: s.title}
+		for _, item := range p.Approval.LineItems {
+			if item.Kind == s.kind {
+				section.Items = append(section.Items, item)
+			}
+		}
+		if len(section.Items) > 0 {
+			sections = append(sections, section)
+		}
+	}
+	return sections
+}
+
+// Details are the employee fields printed in the payslip header
+func (p payslip) Details() [][2]string {
+	u := p.User
+	name := u.FullName
+	if name == "" {
+		name = u.Nickname
+	}
+	details := [][2]string{
+		{"Employee", name},
+		{"Employee ID", u.Nickname},
+		{"Position", u.Position},
+		{"Department", u.Department},
+		{"Tax ID", u.TaxID},
+		{"Social insurance ID", u.SocialInsuranceID},
+		{"Dependents", strconv.Itoa(u.NumberOfDependents)},
+	}
+	var shown [][2]string
+	for _, d := range details {
+		if d[1] != "" {
+			shown = append(shown, d)
+		}
+	}
+	return shown
+}
+
+func (p payslip) filename(ext string) string {
+	return fmt.Sprintf("payslip-%s-%s.%s", p.Approval.Month.In(time.Local).Format("2006-01"),
+		unsafeFilenameChars.ReplaceAllString(p.User.Nickname, "_"), ext)
+}
+
+var unsafeFilenameChars = regexp.MustCompile(`[^A-Za-z0-9_-]`)
+
+// formatMoney renders an amount with thousands separators, keeping cents only
+// when there are any
+func formatMoney(amount float64) string {
+	sign := ""
+	if amount < 0 {
+		sign, amount = "-", -amount
+	}
+	cents := int64(math.Round(amount * 100))
+	whole := strconv.FormatInt(cents/100, 10)
+
+	var b strings.Builder
+	for i, r := range whole {
+		if i > 0 && (len(whole)-i)%3 == 0 {
+			b.WriteByte(',')
+		}
+		b.WriteRune(r)
+	}
+	if rest := cents % 100; rest != 0 {
+		fmt.Fprintf(&b, ".%02d", rest)
+	}
+	return sign + b.String()
+}
+
+// formatQuantity renders a line item quantity by its unit, blank when it
+// has none
+func formatQuantity(item models.SalaryLineItem) string {
+	switch item.Unit {
+	case "":
+		return ""
+	case "vnd":
+		// Insurance and tax lines carry the base they are charged on
+		return formatMoney(item.Quantity)
+	default:
+		return strconv.FormatFloat(item.Quantity, 'f', -1, 64)
+	}
+}
+
+var payslipTemplate = template.Must(template.New("payslip").Funcs(template.FuncMap{
+	"money":    formatMoney,
+	"quantity": formatQuantity,
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Payslip {{.Month}}</title>
+<style>
+body { font-family: Helvetica, Arial, sans-serif; margin: 40px; }
+table { border-collapse: collapse; width: 100%; margin-top: 16px; }
+td, th { padding: 4px 8px; text-align: left; }
+td.amount, th.amount { text-align: right; font-family: monospace; }
+tr.section th { border-bottom: 1px solid #999; padding-top: 12px; }
+tr.net td { border-top: 2px solid #000; font-weight: bold; }
+</style>
+</head>
+<body>
+<h1>Payslip</h1>
+<p>{{.Month}}</p>
+<table>
+{{range .Details}}<tr><th>{{index . 0}}</th><td>{{index . 1}}</td></tr>
+{{end}}</table>
+<table>
+{{range .Sections}}<tr class="section"><th colspan="2">{{.Title}}</th><th class="amount">Quantity</th><th class="amount">Amount</th></tr>
+{{range .Items}}<tr><td colspan="2">{{.Description}}</td><td class="amount">{{quantity .}}</td><td class="amount">{{money .Amount}}</td></tr>
+{{end}}{{end}}<tr class="net"><td colspan="3">Net pay</td><td class="amount">{{money .Approval.FinalSalary}}</td></tr>
+</table>
+{{if .Approval.ApprovedAt}}<p>Approved on {{.Approval.ApprovedAt.Format "2006-01-02"}}</p>{{end}}
+</body>
+</html>
+`))
+
+func renderPayslipHTML(p payslip) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := payslipTemplate.Execute(&buf, p); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func renderPayslipPDF(p payslip) []byte {
+	const (
+		left     = 50.0
+		right    = utils.PDFPageWidth - 50
+		qtyRight = right - 130
+		bottom   = 60.0
+	)
+	doc := utils.NewPDFDocument()
+	y := utils.PDFPageHeight - 60
+	next := func(step float64) {
+		y -= step
+		if y < bottom {
+			doc.AddPage()
+			y = utils.PDFPageHeight - 60
+		}
+	}
+
+	doc.Text(utils.FontBold, 20, left, y, "Payslip")
+	next(20)
+	doc.Text(utils.FontRegular, 12, left, y, p.Month())
+	next(30)
+
+	for _, d := range p.Details() {
+		doc.Text(utils.FontBold, 10, left, y, d[0])
+		doc.Text(utils.FontRegular, 10, left+130, y, d[1])
+		next(15)
+	}
+
+	for _, section := range p.Sections() {
+		next(15)
+		doc.Text(utils.FontBold, 11, left, y, section.Title)
+		next(4)
+		doc.Line(left, y, right, y)
+		next(14)
+		for _, item := range section.Items {
+			doc.Text(utils.FontRegular, 10, left, y, item.Description)
+			doc.TextRight(10, qtyRight, y, formatQuantity(item))
+			doc.TextRight(10, right, y, formatMoney(item.Amount))
+			next(14)
+		}
+	}
+
+	next(6)
+	doc.Line(left, y+10, right, y+10)
+	doc.Text(utils.FontBold, 12, left, y-4, "Net pay")
+	doc.TextRight(12, right, y-4, formatMoney(p.Approval.FinalSalary))
+	next(30)
+	if p.Approval.ApprovedAt != nil {
+		doc.Text(utils.FontRegular, 9, left, y, "Approved on "+p.Approval.ApprovedAt.In(time.Local).Format("2006-01-02"))
+	}
+
+	return doc.Bytes()
+}
+
+// renderPayslip renders p in the requested format, returning the content type
+func renderPayslip(p payslip, format string) ([]byte, string, error) {
+	if format == "html" {
+		body, err := renderPayslipHTML(p)
+		return body, "text/html; charset=utf-8", err
+	}
+	return renderPayslipPDF(p), "application/pdf", nil
+}
+
+// approvedPayslips loads the approved salaries for a month, optionally for one user
+func approvedPayslips(month time.Time, userID string) ([]payslip, error) {
+	query := DB.Preload("LineItems").Preload("User").
+		Where("month = ? AND status = ?", month, "approved").
+		Order("user_id")
+	if userID != "" {
+		query = query.Where("user_id = ?", userID)
+	}
+
+	var approvals []models.SalaryApproval
+	if err := query.Find(&approvals).Error; err != nil {
+		return nil, err
+	}
+	payslips := make([]payslip, len(approvals))
+	for i, a := range approvals {
+		payslips[i] = payslip{User: a.User, Approval: a}
+	}
+	return payslips, nil
+}
+
+func payslipFormat(c *fiber.Ctx) (string, bool) {
+	format := c.Query("format", "html")
+	return format, format == "pdf" || format == "html"
+}
+
+// GetMyPayslips lists the months with an approved salary for the authenticated employee
+func GetMyPayslips(c *fiber.Ctx) error {
+	userID, ok := c.Locals("user_id").(string)
+	if !ok || userID == "" {
+		return c.Status(401).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrUnauthorized,
+		})
+	}
+
+	var approvals []models.SalaryApproval
+	if err := DB.Where("user_id = ? AND status = ?", userID, "approved").
+		Order("month DESC").
+		Find(&approvals).Error; err != nil {
+		utils.Logger.Error("Failed to fetch payslips", zap.Error(err))
+		return c.Status(500).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrDatabaseError,
+		})
+	}
+
+	summaries := make([]PayslipSummary, len(approvals))
+	for i, a := range approvals {
+		summaries[i] = PayslipSummary{
+			ID:          a.ID,
+			Month:       a.Month.In(time.Local).Format("2006-01"),
+			FinalSalary: a.FinalSalary,
+			ApprovedAt:  a.ApprovedAt,
+		}
+	}
+
+	return c.JSON(types.APIResponse{
+		Success: true,
+		Data:    summaries,
+	})
+}
+
+// GetMyPayslip downloads the authenticated employee's payslip for a month
+// (YYYY-MM) as HTML, or as PDF with ?format=pdf. The PDF only uses the
+// standard fonts, which have no Vietnamese glyphs, so its text loses accents.
+func GetMyPayslip(c *fiber.Ctx) error {
+	userID, ok := c.Locals("user_id").(string)
+	if !ok || userID == "" {
+		return c.Status(401).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrUnauthorized,
+		})
+	}
+
+	month, err := parseMonth(c.Params("month"))
+	if err != nil {
+		return c.Status(400).JSON(types.APIResponse{
+			Success: false,
+			Error:   "Invalid month format. Use YYYY-MM",
+		})
+	}
+	format, ok := payslipFormat(c)
+	if !ok {
+		return c.Status(400).JSON(types.APIResponse{
+			Success: false,
+			Error:   "Format must be 'pdf' or 'html'",
+		})
+	}
+
+	payslips, err := approvedPayslips(month, userID)
+	if err != nil {
+		utils.Logger.Error("Failed to fetch payslip", zap.Error(err))
+		return c.Status(500).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrDatabaseError,
+		})
+	}
+	if len(payslips) == 0 {
+		return c.Status(404).JSON(types.APIResponse{
+			Success: false,
+			Error:   "No approved payslip for this month",
+		})
+	}
+
+	body, contentType, err := renderPayslip(payslips[0], format)
+	if err != nil {
+		utils.Logger.Error("Failed to render payslip", zap.Error(err))
+		return c.Status(500).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrInternalError,
+		})
+	}
+
+	c.Set(fiber.HeaderContentType, contentType)
+	c.Set(fiber.HeaderContentDisposition, `attachment; filename="`+payslips[0].filename(format)+`"`)
+	return c.Send(body)
+}
+
+// ExportPayslips downloads every approved payslip of a month as a ZIP archive
+// of HTML files, or PDFs with ?format=pdf
+func ExportPayslips(c *fiber.Ctx) error {
+	month, err := parseMonth(c.Params("month"))
+	if err != nil {
+		return c.Status(400).JSON(types.APIResponse{
+			Success: false,
+			Error:   "Invalid month format. Use YYYY-MM",
+		})
+	}
+	format, ok := payslipFormat(c)
+	if !ok {
+		return c.Status(400).JSON(types.APIResponse{
+			Success: false,
+			Error:   "Format must be 'pdf' or 'html'",
+		})
+	}
+
+	payslips, err := approvedPayslips(month, "")
+	if err != nil {
+		utils.Logger.Error("Failed to fetch payslips", zap.Error(err))
+		return c.Status(500).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrDatabaseError,
+		})
+	}
+	if len(payslips) == 0 {
+		return c.Status(404).JSON(types.APIResponse{
+			Success: false,
+			Error:   "No approved payslips for this month",
+		})
+	}
+
+	archive, err := zipPayslips(payslips, format)
+	if err != nil {
+		utils.Logger.Error("Failed to build payslip archive", zap.Error(err))
+		return c.Status(500).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrInternalError,
+		})
+	}
+
+	c.Set(fiber.HeaderContentType, "application/zip")
+	c.Set(fiber.HeaderContentDisposition, `attachment; filename="payslips-`+month.Format("2006-01")+`.zip"`)
+	return c.Send(archive)
+}
+
+func zipPayslips(payslips []payslip, format string) ([]byte, error) {
+	var buf bytes.Buffer
+	archive := zip.NewWriter(&buf)
+	for _, p := range payslips {
+		body, _, err := renderPayslip(p, format)
+		if err != nil {
+			return nil, err
+		}
+		w, err := archive.Create(p.filename(format))
+		if err != nil {
+			return nil, err
+		}
+		if _, err := w.Write(body); err != nil {
+			return nil, err
+		}
+	}
+	if err := archive.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -1,22 +1,514 @@
 package handlers
 
 import (
+	"dapp_timekeeping/models"
 	"dapp_timekeeping/types"
+	"dapp_timekeeping/utils"
+	"sort"
+	"strings"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
+// permissionCatalog lists the permissions root can delegate
+var permissionCatalog = map[string]string{
+	"attendance_approval":  "Process absences and lateness records",
+	"leave_approval":       "Approve or reject leave requests",
+	"salary_management":    "Update employee salaries",
+	"employee_management":  "Add and update employees",
+	"violation_management": "Record and review violations",
+	"overtime_approval":    "Pre-approve overtime",
+}
+
+// rolePermissions are held by every active user with the role. Root holds
+// every permission in every department; HR managers hold theirs in the
+// departments they manage and their own.
+var rolePermissions = map[string][]string{
+	"hr_manager": {"attendance_approval", "leave_approval", "violation_management", "overtime_approval"},
+}
+
+// PermissionScope is where a permission applies: everywhere, or only to
+// employees of the listed departments
+type PermissionScope struct {
+	All         bool
+	Departments map[string]bool
+}
+
+// Allows reports whether the scope covers employees of department
+func (s PermissionScope) Allows(department string) bool {
+	return s.All || s.Departments[department]
+}
+
+func (s *PermissionScope) add(departments []string) {
+	if s.Departments == nil {
+		s.Departments = make(map[string]bool)
+	}
+	for _, d := range departments {
+		if d != "" {
+			s.Departments[d] = true
+		}
+	}
+}
+
+// restrict limits query to rows whose department column the scope covers
+func (s PermissionScope) restrict(query *gorm.DB, column string) *gorm.DB {
+	if s.All {
+		return query
+	}
+	departments := make([]string, 0, len(s.Departments))
+	for d := range s.Departments {
+		departments = append(departments, d)
+	}
+	return query.Where(column+" IN ?", departments)
+}
+
+// PermissionSet maps the permissions a user holds to their scope
+type PermissionSet map[string]PermissionScope
+
+type GrantPermissionRequest struct {
+	UserID      string     `json:"user_id" validate:"required"`
+	Permission  string     `json:"permission" validate:"required"`
+	Departments []string   `json:"departments"` // Limit the grant to these departments, omit for all
+	ExpiresAt   *time.Time `json:"expires_at"`  // RFC 3339, omit for a permanent grant
+}
+
+type RevokePermissionRequest struct {
+	UserID     string `json:"user_id" validate:"required"`
+	Permission string `json:"permission" validate:"required"`
+}
+
+type PermissionResponse struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+type UserPermissionResponse struct {
+	Permission  string     `json:"permission"`
+	Description string     `json:"description"`
+	GrantedBy   string     `json:"granted_by"`
+	GrantedAt   time.Time  `json:"granted_at"`
+	Departments string     `json:"departments"`
+	ExpiresAt   *time.Time `json:"expires_at"`
+}
+
+// EffectivePermissionResponse is a permission a user holds and where it
+// applies, combining their role with any delegated grant
+type EffectivePermissionResponse struct {
+	Permission  string                  `json:"permission"`
+	Description string                  `json:"description"`
+	All         bool                    `json:"all"`         // Applies in every department
+	Departments []string                `json:"departments"` // Covered departments when not all
+	FromRole    bool                    `json:"from_role"`
+	Grant       *UserPermissionResponse `json:"grant,omitempty"` // The active delegated grant, if any
+}
+
+// ensurePermission returns the named permission, creating it from the catalog
+// the first time it is granted
+func ensurePermission(tx *gorm.DB, name string) (models.Permission, error) {
+	// The ID goes in Attrs: set on the destination it would also be matched,
+	// missing the stored row and failing on the unique name
+	var permission models.Permission
+	err := tx.Where(models.Permission{Name: name}).
+		Attrs(models.Permission{ID: uuid.New().String(), Description: permissionCatalog[name]}).
+		FirstOrCreate(&permission).Error
+	return permission, err
+}
+
+// activePermissions lists the permissions a user holds at the given time.
+// Grants past their ExpiresAt have lapsed and are left out.
+func activePermissions(tx *gorm.DB, userID string, at time.Time) ([]UserPermissionResponse, error) {
+	var permissions []UserPermissionResponse
+	err := tx.Table("user_permissions").
+		Select("permissions.name AS permission, permissions.description, user_permissions.granted_by, "+
+			"user_permissions.updated_at AS granted_at, user_permissions.departments, user_permissions.expires_at").
+		Joins("JOIN permissions ON permissions.id = user_permissions.permission_id").
+		Where("user_permissions.user_id = ?", userID).
+		Where("user_permissions.expires_at IS NULL OR user_permissions.expires_at > ?", at).
+		Order("permissions.name").
+		Scan(&permissions).Error
+	return permissions, err
+}
+
+// splitDepartments parses a comma separated department list
+func splitDepartments(value string) []string {
+	var departments []string
+	for _, d := range strings.Split(value, ",") {
+		if d = strings.TrimSpace(d); d != "" {
+			departments = append(departments, d)
+		}
+	}
+	return departments
+}
+
+// ResolvePermissions combines the permissions of a user's role with their
+// active delegated grants. Users who are not active hold no permissions.
+func ResolvePermissions(tx *gorm.DB, userID string) (PermissionSet, error) {
+	set := make(PermissionSet)
+
+	var user models.User
+	if err := tx.First(&user, "id = ?", userID).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return set, nil
+		}
+		return nil, err
+	}
+	if user.Status != "active" {
+		return set, nil
+	}
+
+	if user.Role == "root" {
+		for name := range permissionCatalog {
+			set[name] = PermissionScope{All: true}
+		}
+		return set, nil
+	}
+
+	if names := rolePermissions[user.Role]; len(names) > 0 {
+		var managed []string
+		if err := tx.Model(&models.Department{}).Where("manager_id = ?", user.ID).Pluck("name", &managed).Error; err != nil {
+			return nil, err
+		}
+		managed = append(managed, user.Department)
+		for _, name := range names {
+			scope := set[name]
+			scope.add(managed)
+			set[name] = scope
+		}
+	}
+
+	grants, err := activePermissions(tx, user.ID, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	for _, g := range grants {
+		scope := set[g.Permission]
+		if departments := splitDepartments(g.Departments); len(departments) > 0 {
+			scope.add(departments)
+		} else {
+			scope.All = true
+		}
+		set[g.Permission] = scope
+	}
+
+	return set, nil
+}
+
+// permissionScope returns where the acting user holds a permission. It uses
+// the permissions RequirePermission stored in the context, resolving them for
+// routes guarded by role.
+func permissionScope(c *fiber.Ctx, name string) (PermissionScope, bool, error) {
+	set, ok := c.Locals("permissions").(PermissionSet)
+	if !ok {
+		userID, _ := c.Locals("user_id").(string)
+		var err error
+		if set, err = ResolvePermissions(DB, userID); err != nil {
+			return PermissionScope{}, false, err
+		}
+		c.Locals("permissions", set)
+	}
+	scope, held := set[name]
+	return scope, held, nil
+}
+
+// ListPermissions returns the permissions that can be granted
+func ListPermissions(c *fiber.Ctx) error {
+	permissions := make([]PermissionResponse, 0, len(permissionCatalog))
+	for name, description := range permissionCatalog {
+		permissions = append(permissions, PermissionResponse{Name: name, Description: description})
+	}
+	sort.Slice(permissions, func(i, j int) bool {
+		return permissions[i].Name < permissions[j].Name
+	})
+
+	return c.JSON(types.APIResponse{
+		Success: true,
+		Data:    permissions,
+	})
+}
+
 // Permissions
 func GrantPermission(c *fiber.Ctx) error {
+	granterID, ok := c.Locals("user_id").(string)
+	if !ok || granterID == "" {
+		return c.Status(401).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrUnauthorized,
+		})
+	}
+
+	var req GrantPermissionRequest
+	if err := c.BodyParser(&req); err != nil || req.UserID == "" {
+		return c.Status(400).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrInvalidInput,
+		})
+	}
+	if _, ok := permissionCatalog[req.Permission]; !ok {
+		return c.Status(400).JSON(types.APIResponse{
+			Success: false,
+			Error:   "Unknown permission",
+		})
+	}
+
+	now := time.Now()
+	var expiresAt *time.Time
+	if req.ExpiresAt != nil {
+		if !req.ExpiresAt.After(now) {
+			return c.Status(400).JSON(types.APIResponse{
+				Success: false,
+				Error:   "Expiry must be in the future",
+			})
+		}
+		local := req.ExpiresAt.In(time.Local)
+		expiresAt = &local
+	}
+
+	tx := DB.Begin()
+
+	var user models.User
+	if err := tx.First(&user, "id = ?", req.UserID).Error; err != nil {
+		tx.Rollback()
+		if err == gorm.ErrRecordNotFound {
+			return c.Status(404).JSON(types.APIResponse{
+				Success: false,
+				Error:   "Employee not found",
+			})
+		}
+		return c.Status(500).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrDatabaseError,
+		})
+	}
+
+	permission, err := ensurePermission(tx, req.Permission)
+	if err != nil {
+		tx.Rollback()
+		utils.Logger.Error("Failed to load permission", zap.Error(err))
+		return c.Status(500).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrDatabaseError,
+		})
+	}
+
+	departments := strings.Join(splitDepartments(strings.Join(req.Departments, ",")), ",")
+
+	// Granting again replaces the scope and expiry of the held permission
+	held := models.UserPermission{
+		UserID:       user.ID,
+		PermissionID: permission.ID,
+		GrantedBy:    granterID,
+		Departments:  departments,
+		ExpiresAt:    expiresAt,
+		CreatedAt:    now,
+		UpdatedAt:    now,
+	}
+	if err := tx.Omit(clause.Associations).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "user_id"}, {Name: "permission_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"granted_by", "departments", "expires_at", "updated_at"}),
+	}).Create(&held).Error; err != nil {
+		tx.Rollback()
+		utils.Logger.Error("Failed to grant permission", zap.Error(err))
+		return c.Status(500).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrDatabaseError,
+		})
+	}
+
+	grant := models.PermissionGrant{
+		ID:           uuid.New().String(),
+		GrantedBy:    granterID,
+		GrantedTo:    user.ID,
+		PermissionID: permission.ID,
+		Departments:  departments,
+		ExpiresAt:    expiresAt,
+		CreatedAt:    now,
+		UpdatedAt:    now,
+	}
+	if err := tx.Omit(clause.Associations).Create(&grant).Error; err != nil {
+		tx.Rollback()
+		utils.Logger.Error("Failed to record permission grant", zap.Error(err))
+		return c.Status(500).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrDatabaseError,
+		})
+	}
+
+	tx.Commit()
+
+	utils.Logger.Info("Permission granted",
+		zap.String("permission", req.Permission),
+		zap.String("user_id", user.ID),
+		zap.String("granted_by", granterID))
+
 	return c.JSON(types.APIResponse{
 		Success: true,
-		Message: "Not implemented",
+		Message: "Permission granted successfully",
+		Data: UserPermissionResponse{
+			Permission:  permission.Name,
+			Description: permission.Description,
+			GrantedBy:   granterID,
+			GrantedAt:   now,
+			Departments: departments,
+			ExpiresAt:   expiresAt,
+		},
 	})
 }
 
 func RevokePermission(c *fiber.Ctx) error {
+	revokerID, ok := c.Locals("user_id").(string)
+	if !ok || revokerID == "" {
+		return c.Status(401).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrUnauthorized,
+		})
+	}
+
+	var req RevokePermissionRequest
+	if err := c.BodyParser(&req); err != nil || req.UserID == "" || req.Permission == "" {
+		return c.Status(400).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrInvalidInput,
+		})
+	}
+
+	now := time.Now()
+	tx := DB.Begin()
+
+	var held models.UserPermission
+	err := tx.Joins("JOIN permissions ON permissions.id = user_permissions.permission_id").
+		Where("user_permissions.user_id = ? AND permissions.name = ?", req.UserID, req.Permission).
+		First(&held).Error
+	if err == gorm.ErrRecordNotFound {
+		tx.Rollback()
+		return c.Status(404).JSON(types.APIResponse{
+			Success: false,
+			Error:   "Permission is not held by this user",
+		})
+	}
+	if err == nil {
+		err = tx.Where("user_id = ? AND permission_id = ?", held.UserID, held.PermissionID).
+			Delete(&models.UserPermission{}).Error
+	}
+	if err != nil {
+		tx.Rollback()
+		utils.Logger.Error("Failed to revoke permission", zap.Error(err))
+		return c.Status(500).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrDatabaseError,
+		})
+	}
+
+	// A lapsed permission is cleared but there is nothing left to revoke
+	if held.ExpiresAt != nil && !held.ExpiresAt.After(now) {
+		tx.Commit()
+		return c.Status(404).JSON(types.APIResponse{
+			Success: false,
+			Error:   "Permission is not held by this user",
+		})
+	}
+
+	if err := tx.Model(&models.PermissionGrant{}).
+		Where("granted_to = ? AND permission_id = ? AND revoked_at IS NULL", held.UserID, held.PermissionID).
+		Updates(map[string]interface{}{
+			"revoked_by": revokerID,
+			"revoked_at": now,
+			"updated_at": now,
+		}).Error; err != nil {
+		tx.Rollback()
+		utils.Logger.Error("Failed to record permission revocation", zap.Error(err))
+		return c.Status(500).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrDatabaseError,
+		})
+	}
+
+	tx.Commit()
+
+	utils.Logger.Info("Permission revoked",
+		zap.String("permission", req.Permission),
+		zap.String("user_id", req.UserID),
+		zap.String("revoked_by", revokerID))
+
+	return c.JSON(types.APIResponse{
+		Success: true,
+		Message: "Permission revoked successfully",
+	})
+}
+
+// GetUserPermissions lists the permissions a user currently holds and where
+// they apply, through their role as well as delegated grants
+func GetUserPermissions(c *fiber.Ctx) error {
+	var user models.User
+	if err := DB.First(&user, "id = ?", c.Params("id")).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return c.Status(404).JSON(types.APIResponse{
+				Success: false,
+				Error:   "Employee not found",
+			})
+		}
+		return c.Status(500).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrDatabaseError,
+		})
+	}
+
+	set, err := ResolvePermissions(DB, user.ID)
+	if err != nil {
+		utils.Logger.Error("Failed to resolve user permissions", zap.Error(err))
+		return c.Status(500).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrDatabaseError,
+		})
+	}
+	grants, err := activePermissions(DB, user.ID, time.Now())
+	if err != nil {
+		utils.Logger.Error("Failed to fetch user permissions", zap.Error(err))
+		return c.Status(500).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrDatabaseError,
+		})
+	}
+	granted := make(map[string]UserPermissionResponse, len(grants))
+	for _, g := range grants {
+		granted[g.Permission] = g
+	}
+
+	fromRole := make(map[string]bool)
+	for _, name := range rolePermissions[user.Role] {
+		fromRole[name] = true
+	}
+
+	permissions := make([]EffectivePermissionResponse, 0, len(set))
+	for name, scope := range set {
+		permission := EffectivePermissionResponse{
+			Permission:  name,
+			Description: permissionCatalog[name],
+			All:         scope.All,
+			Departments: []string{},
+			FromRole:    user.Role == "root" || fromRole[name],
+		}
+		if !scope.All {
+			for d := range scope.Departments {
+				permission.Departments = append(permission.Departments, d)
+			}
+			sort.Strings(permission.Departments)
+		}
+		if g, ok := granted[name]; ok {
+			permission.Grant = &g
+		}
+		permissions = append(permissions, permission)
+	}
+	sort.Slice(permissions, func(i, j int) bool {
+		return permissions[i].Permission < permissions[j].Permission
+	})
+
 	return c.JSON(types.APIResponse{
 		Success: true,
-		Message: "Not implemented",
+		Data:    permissions,
 	})
-}
\ No newline at end of file
+}
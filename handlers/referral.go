@@ -1,22 +1,477 @@
 package handlers
 
 import (
+	"crypto/rand"
+	"encoding/base32"
+	"net/mail"
+	"strings"
+	"time"
+
+	"dapp_timekeeping/config"
+	"dapp_timekeeping/models"
 	"dapp_timekeeping/types"
+	"dapp_timekeeping/utils"
 
 	"github.com/gofiber/fiber/v2"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
-// Referral Management
+type GenerateReferralRequest struct {
+	UserID   string  `json:"user_id" validate:"required"`
+	TTLHours float64 `json:"ttl_hours"` // Omit for the configured default
+}
+
+// RedeemReferralRequest carries the personal details of the new hire. The
+// nickname, role, salary, department, position and location are set by root
+// and ignored here. The password is required when the user has not registered
+// yet, and replaces one set at registration.
+type RedeemReferralRequest struct {
+	FullName      string    `json:"full_name"`
+	Email         string    `json:"email"`
+	PhoneNumber   string    `json:"phone_number"`
+	Address       string    `json:"address"`
+	DateOfBirth   time.Time `json:"date_of_birth"`
+	Gender        string    `json:"gender"`
+	TaxID         string    `json:"tax_id"`
+	WalletAddress string    `json:"wallet_address"`
+	Password      string    `json:"password"`
+}
+
+type ReferralCodeResponse struct {
+	Code      string     `json:"code"`
+	UserID    string     `json:"user_id"`
+	Nickname  string     `json:"nickname"`
+	Status    string     `json:"status"` // active, used or expired
+	CreatedBy string     `json:"created_by"`
+	ExpiresAt time.Time  `json:"expires_at"`
+	UsedAt    *time.Time `json:"used_at"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+func referralStatus(code models.ReferralCode, now time.Time) string {
+	switch {
+	case code.UsedAt != nil:
+		return "used"
+	case !code.ExpiresAt.After(now):
+		return "expired"
+	default:
+		return "active"
+	}
+}
+
+// randomCode returns 8 random characters of unpadded base32, used for
+// referral and login codes
+func randomCode() (string, error) {
+	b := make([]byte, 5)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base32.StdEncoding.EncodeToString(b), nil
+}
+
+// missingProfileField returns the first required profile field that is empty
+// or invalid, or "" when the profile is complete
+func missingProfileField(req RedeemReferralRequest) string {
+	fields := []struct {
+		name  string
+		valid bool
+	}{
+		{"full_name", strings.TrimSpace(req.FullName) != ""},
+		{"email", req.Email != ""},
+		{"phone_number", req.PhoneNumber != ""},
+		{"address", req.Address != ""},
+		{"date_of_birth", !req.DateOfBirth.IsZero()},
+		{"gender", req.Gender == "male" || req.Gender == "female" || req.Gender == "other"},
+		{"tax_id", req.TaxID != ""},
+		{"wallet_address", req.WalletAddress != ""},
+	}
+	for _, f := range fields {
+		if !f.valid {
+			return f.name
+		}
+	}
+	if _, err := mail.ParseAddress(req.Email); err != nil {
+		return "email"
+	}
+	return ""
+}
+
+// redemptionLocked reports whether clientIP is locked out after too many
+// failed redemptions
+func redemptionLocked(clientIP string, now time.Time) (bool, error) {
+	var attempt models.ReferralAttempt
+	if err := DB.First(&attempt, "client_ip = ?", clientIP).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return false, nil
+		}
+		return false, err
+	}
+	return attempt.LockedUntil != nil && attempt.LockedUntil.After(now), nil
+}
+
+// recordFailedRedemption counts a redemption of an unknown code and locks
+// clientIP out once MaxFailedLogins is reached
+func recordFailedRedemption(clientIP string) error {
+	return DB.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		if err := tx.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "client_ip"}},
+			DoUpdates: clause.Assignments(map[string]interface{}{"failed": gorm.Expr("failed + 1"), "updated_at": now}),
+		}).Create(&models.ReferralAttempt{ClientIP: clientIP, Failed: 1, UpdatedAt: now}).Error; err != nil {
+			return err
+		}
+
+		var failed int
+		if err := tx.Model(&models.ReferralAttempt{}).Where("client_ip = ?", clientIP).Pluck("failed", &failed).Error; err != nil {
+			return err
+		}
+		if failed < config.AppConfig.MaxFailedLogins {
+			return nil
+		}
+
+		lockout := time.Duration(config.AppConfig.LoginLockoutMinutes * float64(time.Minute))
+		utils.Logger.Warn("Referral redemption locked after failed attempts", zap.String("client_ip", clientIP), zap.Int("attempts", failed))
+		return tx.Model(&models.ReferralAttempt{}).Where("client_ip = ?", clientIP).
+			Updates(map[string]interface{}{"failed": 0, "locked_until": now.Add(lockout), "updated_at": now}).Error
+	})
+}
+
+// GenerateReferralCode issues a code for a pending employee. Unused codes
+// issued earlier for the same employee stop working.
 func GenerateReferralCode(c *fiber.Ctx) error {
+	creatorID, _ := c.Locals("user_id").(string)
+
+	var req GenerateReferralRequest
+	if err := c.BodyParser(&req); err != nil || req.UserID == "" || req.TTLHours < 0 {
+		return c.Status(400).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrInvalidInput,
+		})
+	}
+	ttl := req.TTLHours
+	if ttl == 0 {
+		ttl = config.AppConfig.ReferralCodeTTL
+	}
+
+	var user models.User
+	if err := DB.First(&user, "id = ?", req.UserID).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return c.Status(404).JSON(types.APIResponse{
+				Success: false,
+				Error:   "User not found",
+			})
+		}
+		utils.Logger.Error("Failed to fetch user", zap.Error(err))
+		return c.Status(500).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrDatabaseError,
+		})
+	}
+	if user.Status != "pending" {
+		return c.Status(409).JSON(types.APIResponse{
+			Success: false,
+			Error:   "Referral codes can only be issued for pending employees",
+		})
+	}
+
+	value, err := randomCode()
+	if err != nil {
+		utils.Logger.Error("Failed to generate referral code", zap.Error(err))
+		return c.Status(500).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrInternalError,
+		})
+	}
+
+	now := time.Now()
+	code := models.ReferralCode{
+		Code:      value,
+		UserID:    user.ID,
+		CreatedBy: creatorID,
+		ExpiresAt: now.Add(time.Duration(ttl * float64(time.Hour))),
+		CreatedAt: now,
+	}
+	err = DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ? AND used_at IS NULL", user.ID).Delete(&models.ReferralCode{}).Error; err != nil {
+			return err
+		}
+		return tx.Omit("User").Create(&code).Error
+	})
+	if err != nil {
+		utils.Logger.Error("Failed to save referral code", zap.Error(err))
+		return c.Status(500).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrDatabaseError,
+		})
+	}
+
 	return c.JSON(types.APIResponse{
 		Success: true,
-		Message: "Not implemented",
+		Message: "Referral code generated",
+		Data: ReferralCodeResponse{
+			Code:      code.Code,
+			UserID:    user.ID,
+			Nickname:  user.Nickname,
+			Status:    referralStatus(code, now),
+			CreatedBy: code.CreatedBy,
+			ExpiresAt: code.ExpiresAt,
+			CreatedAt: code.CreatedAt,
+		},
 	})
 }
 
+// ListReferralCodes lists codes, newest first, optionally filtered by the
+// user_id and status (active, used, expired) query parameters
 func ListReferralCodes(c *fiber.Ctx) error {
+	query := DB.Preload("User").Order("created_at DESC")
+	if userID := c.Query("user_id"); userID != "" {
+		query = query.Where("user_id = ?", userID)
+	}
+	status := c.Query("status")
+	if status != "" && status != "active" && status != "used" && status != "expired" {
+		return c.Status(400).JSON(types.APIResponse{
+			Success: false,
+			Error:   "Status must be 'active', 'used' or 'expired'",
+		})
+	}
+
+	var codes []models.ReferralCode
+	if err := query.Find(&codes).Error; err != nil {
+		utils.Logger.Error("Failed to fetch referral codes", zap.Error(err))
+		return c.Status(500).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrDatabaseError,
+		})
+	}
+
+	now := time.Now()
+	response := []ReferralCodeResponse{}
+	for _, code := range codes {
+		s := referralStatus(code, now)
+		if status != "" && s != status {
+			continue
+		}
+		response = append(response, ReferralCodeResponse{
+			Code:      code.Code,
+			UserID:    code.UserID,
+			Nickname:  code.User.Nickname,
+			Status:    s,
+			CreatedBy: code.CreatedBy,
+			ExpiresAt: code.ExpiresAt.In(time.Local),
+			UsedAt:    code.UsedAt,
+			CreatedAt: code.CreatedAt.In(time.Local),
+		})
+	}
+
 	return c.JSON(types.APIResponse{
 		Success: true,
-		Message: "Not implemented",
+		Data:    response,
 	})
-}
\ No newline at end of file
+}
+
+// DeleteReferralCode removes a code so that it can no longer be redeemed
+func DeleteReferralCode(c *fiber.Ctx) error {
+	result := DB.Where("code = ?", strings.ToUpper(c.Params("code"))).Delete(&models.ReferralCode{})
+	if result.Error != nil {
+		utils.Logger.Error("Failed to delete referral code", zap.Error(result.Error))
+		return c.Status(500).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrDatabaseError,
+		})
+	}
+	if result.RowsAffected == 0 {
+		return c.Status(404).JSON(types.APIResponse{
+			Success: false,
+			Error:   "Referral code not found",
+		})
+	}
+
+	return c.JSON(types.APIResponse{
+		Success: true,
+		Message: "Referral code deleted",
+	})
+}
+
+// RedeemReferralCode completes the profile of the pending employee the code
+// was issued for and activates them. A client that keeps trying unknown codes
+// is locked out for a while.
+func RedeemReferralCode(c *fiber.Ctx) error {
+	var req RedeemReferralRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrInvalidInput,
+		})
+	}
+	if field := missingProfileField(req); field != "" {
+		return c.Status(400).JSON(types.APIResponse{
+			Success: false,
+			Error:   "Missing or invalid field: " + field,
+		})
+	}
+	if req.Password != "" && !validPassword(req.Password) {
+		return c.Status(400).JSON(types.APIResponse{
+			Success: false,
+			Error:   "Password must be 8 to 72 characters",
+		})
+	}
+
+	// Guessing codes is throttled like guessing passwords
+	locked, err := redemptionLocked(c.IP(), time.Now())
+	if err != nil {
+		utils.Logger.Error("Failed to check redemption attempts", zap.Error(err))
+		return c.Status(500).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrDatabaseError,
+		})
+	}
+	if locked {
+		return c.Status(429).JSON(types.APIResponse{
+			Success: false,
+			Error:   "Too many failed attempts, try again later",
+		})
+	}
+
+	tx := DB.Begin()
+
+	var code models.ReferralCode
+	if err := tx.First(&code, "code = ?", strings.ToUpper(c.Params("code"))).Error; err != nil {
+		tx.Rollback()
+		if err == gorm.ErrRecordNotFound {
+			if err := recordFailedRedemption(c.IP()); err != nil {
+				utils.Logger.Error("Failed to record redemption attempt", zap.Error(err))
+			}
+			return c.Status(404).JSON(types.APIResponse{
+				Success: false,
+				Error:   "Referral code not found",
+			})
+		}
+		utils.Logger.Error("Failed to fetch referral code", zap.Error(err))
+		return c.Status(500).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrDatabaseError,
+		})
+	}
+
+	now := time.Now()
+	switch referralStatus(code, now) {
+	case "used":
+		tx.Rollback()
+		return c.Status(409).JSON(types.APIResponse{
+			Success: false,
+			Error:   "Referral code has already been used",
+		})
+	case "expired":
+		tx.Rollback()
+		return c.Status(410).JSON(types.APIResponse{
+			Success: false,
+			Error:   "Referral code has expired",
+		})
+	}
+
+	var user models.User
+	if err := tx.First(&user, "id = ?", code.UserID).Error; err != nil {
+		tx.Rollback()
+		utils.Logger.Error("Failed to fetch referred user", zap.Error(err))
+		return c.Status(500).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrDatabaseError,
+		})
+	}
+	if user.Status != "pending" {
+		tx.Rollback()
+		return c.Status(409).JSON(types.APIResponse{
+			Success: false,
+			Error:   "Employee profile is already complete",
+		})
+	}
+	if user.PasswordHash == "" && req.Password == "" {
+		tx.Rollback()
+		return c.Status(400).JSON(types.APIResponse{
+			Success: false,
+			Error:   "Missing or invalid field: password",
+		})
+	}
+
+	// Guard on used_at so the code cannot be redeemed twice concurrently
+	result := tx.Model(&models.ReferralCode{}).
+		Where("code = ? AND used_at IS NULL", code.Code).
+		Update("used_at", now)
+	if result.Error != nil {
+		tx.Rollback()
+		utils.Logger.Error("Failed to redeem referral code", zap.Error(result.Error))
+		return c.Status(500).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrDatabaseError,
+		})
+	}
+	if result.RowsAffected == 0 {
+		tx.Rollback()
+		return c.Status(409).JSON(types.APIResponse{
+			Success: false,
+			Error:   "Referral code has already been used",
+		})
+	}
+
+	profile := map[string]interface{}{
+		"full_name":      strings.TrimSpace(req.FullName),
+		"email":          req.Email,
+		"phone_number":   req.PhoneNumber,
+		"address":        req.Address,
+		"date_of_birth":  req.DateOfBirth.In(time.Local),
+		"gender":         req.Gender,
+		"tax_id":         req.TaxID,
+		"wallet_address": req.WalletAddress,
+		"status":         "active",
+		"updated_at":     now,
+	}
+	if req.Password != "" {
+		hash, err := hashPassword(req.Password)
+		if err != nil {
+			tx.Rollback()
+			utils.Logger.Error("Failed to hash password", zap.Error(err))
+			return c.Status(500).JSON(types.APIResponse{
+				Success: false,
+				Error:   types.ErrInternalError,
+			})
+		}
+		profile["password_hash"] = hash
+		profile["password_changed_at"] = now
+
+		// Whoever registered before the code was redeemed is logged out
+		if user.PasswordHash != "" {
+			if err := revokeUserSessions(tx, user.ID, "password replaced at onboarding"); err != nil {
+				tx.Rollback()
+				utils.Logger.Error("Failed to revoke sessions", zap.Error(err))
+				return c.Status(500).JSON(types.APIResponse{
+					Success: false,
+					Error:   types.ErrDatabaseError,
+				})
+			}
+		}
+	}
+	if err := tx.Model(&user).Updates(profile).Error; err != nil {
+		tx.Rollback()
+		utils.Logger.Error("Failed to complete employee profile", zap.Error(err))
+		return c.Status(500).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrDatabaseError,
+		})
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		utils.Logger.Error("Failed to commit referral redemption", zap.Error(err))
+		return c.Status(500).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrDatabaseError,
+		})
+	}
+
+	return c.JSON(types.APIResponse{
+		Success: true,
+		Message: "Profile completed, your account is now active",
+		Data:    user,
+	})
+}
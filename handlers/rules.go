@@ -1,18 +1,289 @@
 package handlers
 
 import (
+	"errors"
+	"time"
+
+	"dapp_timekeeping/config"
+	"dapp_timekeeping/models"
+	"dapp_timekeeping/types"
+	"dapp_timekeeping/utils"
+
 	"github.com/gofiber/fiber/v2"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
+// CompanyRuleRequest creates a rule version. Omitted rules keep the value of
+// the version in force on EffectiveFrom.
+type CompanyRuleRequest struct {
+	EffectiveFrom          string   `json:"effective_from"` // YYYY-MM-DD, today when empty
+	LateGraceMinutes       *int     `json:"late_grace_minutes"`
+	LatePenalty            *float64 `json:"late_penalty"`
+	MaxUnpaidAbsences      *int     `json:"max_unpaid_absences"`
+	StandardDailyHours     *float64 `json:"standard_daily_hours"`
+	EarlyLeavePenalty      *float64 `json:"early_leave_penalty"`
+	MissingCheckOutPenalty *float64 `json:"missing_check_out_penalty"`
+	AbsencePenalty         *float64 `json:"absence_penalty"`
+	OvertimeWeekdayRate    *float64 `json:"overtime_weekday_rate"`
+	OvertimeWeekendRate    *float64 `json:"overtime_weekend_rate"`
+	OvertimeHolidayRate    *float64 `json:"overtime_holiday_rate"`
+	OvertimeMonthlyCap     *float64 `json:"overtime_monthly_cap"`
+	Note                   string   `json:"note"`
+}
+
+var errRuleVersionExists = errors.New("rule version exists")
+
+// defaultCompanyRule applies to days before the first stored version. Its
+// overtime rates and cap are the Labour Code limits.
+func defaultCompanyRule() models.CompanyRule {
+	return models.CompanyRule{
+		LateGraceMinutes:    0,
+		LatePenalty:         config.AppConfig.LatePenaltyAmount,
+		MaxUnpaidAbsences:   3,
+		StandardDailyHours:  8,
+		OvertimeWeekdayRate: 1.5,
+		OvertimeWeekendRate: 2,
+		OvertimeHolidayRate: 3,
+		OvertimeMonthlyCap:  40,
+	}
+}
+
+// companyRules holds every rule version, oldest first, for evaluating many
+// days at once
+type companyRules []models.CompanyRule
+
+func loadCompanyRules(tx *gorm.DB) (companyRules, error) {
+	var rules []models.CompanyRule
+	if err := tx.Order("effective_from").Find(&rules).Error; err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
+
+// on returns the version in force on day
+func (r companyRules) on(day time.Time) models.CompanyRule {
+	day = startOfDay(day.In(time.Local))
+	for i := len(r) - 1; i >= 0; i-- {
+		if !r[i].EffectiveFrom.After(day) {
+			return r[i]
+		}
+	}
+	return defaultCompanyRule()
+}
+
+// companyRuleOn returns the version in force on day
+func companyRuleOn(tx *gorm.DB, day time.Time) (models.CompanyRule, error) {
+	var rule models.CompanyRule
+	if err := tx.Where("effective_from <= ?", startOfDay(day.In(time.Local))).
+		Order("effective_from DESC").
+		Limit(1).
+		Find(&rule).Error; err != nil {
+		return rule, err
+	}
+	if rule.Version == 0 {
+		return defaultCompanyRule(), nil
+	}
+	return rule, nil
+}
+
+// lateGrace is how long after the expected time an arrival is still on time
+func lateGrace(rule models.CompanyRule) time.Duration {
+	return time.Duration(rule.LateGraceMinutes) * time.Minute
+}
+
+func validateCompanyRule(rule models.CompanyRule) string {
+	switch {
+	case rule.LateGraceMinutes < 0 || rule.LateGraceMinutes > 24*60:
+		return "late_grace_minutes must be between 0 and 1440"
+	case rule.LatePenalty < 0 || rule.EarlyLeavePenalty < 0 || rule.MissingCheckOutPenalty < 0 || rule.AbsencePenalty < 0:
+		return "Penalties cannot be negative"
+	case rule.MaxUnpaidAbsences < 0:
+		return "max_unpaid_absences cannot be negative"
+	case rule.StandardDailyHours <= 0 || rule.StandardDailyHours > 24:
+		return "standard_daily_hours must be between 0 and 24"
+	case rule.OvertimeWeekdayRate < 1 || rule.OvertimeWeekendRate < 1 || rule.OvertimeHolidayRate < 1:
+		return "Overtime rates must be at least 1"
+	case rule.OvertimeMonthlyCap <= 0 || rule.OvertimeMonthlyCap > 31*24:
+		return "overtime_monthly_cap must be a positive number of hours"
+	}
+	return ""
+}
+
+// UpdateCompanyRule adds a rule version taking effect on a future date or
+// today. Versions already in force are never changed.
 func UpdateCompanyRule(c *fiber.Ctx) error {
-	return c.JSON(fiber.Map{
-		"message": "Not implemented",
+	userID, _ := c.Locals("user_id").(string)
+
+	var req CompanyRuleRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrInvalidInput,
+		})
+	}
+
+	today := startOfDay(time.Now())
+	from := today
+	if req.EffectiveFrom != "" {
+		var err error
+		if from, err = time.ParseInLocation("2006-01-02", req.EffectiveFrom, time.Local); err != nil {
+			return c.Status(400).JSON(types.APIResponse{
+				Success: false,
+				Error:   "Invalid effective_from format. Use YYYY-MM-DD",
+			})
+		}
+	}
+	if from.Before(today) {
+		return c.Status(400).JSON(types.APIResponse{
+			Success: false,
+			Error:   "Rules cannot take effect in the past",
+		})
+	}
+
+	var rule models.CompanyRule
+	var invalid string
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		var existing int64
+		if err := tx.Model(&models.CompanyRule{}).Where("effective_from = ?", from).Count(&existing).Error; err != nil {
+			return err
+		}
+		if existing > 0 {
+			return errRuleVersionExists
+		}
+
+		base, err := companyRuleOn(tx, from)
+		if err != nil {
+			return err
+		}
+		rule = models.CompanyRule{
+			EffectiveFrom:          from,
+			LateGraceMinutes:       base.LateGraceMinutes,
+			LatePenalty:            base.LatePenalty,
+			MaxUnpaidAbsences:      base.MaxUnpaidAbsences,
+			StandardDailyHours:     base.StandardDailyHours,
+			EarlyLeavePenalty:      base.EarlyLeavePenalty,
+			MissingCheckOutPenalty: base.MissingCheckOutPenalty,
+			AbsencePenalty:         base.AbsencePenalty,
+			OvertimeWeekdayRate:    base.OvertimeWeekdayRate,
+			OvertimeWeekendRate:    base.OvertimeWeekendRate,
+			OvertimeHolidayRate:    base.OvertimeHolidayRate,
+			OvertimeMonthlyCap:     base.OvertimeMonthlyCap,
+			Note:                   req.Note,
+			CreatedAt:              time.Now(),
+		}
+		if userID != "" {
+			rule.CreatedBy = &userID
+		}
+		if req.LateGraceMinutes != nil {
+			rule.LateGraceMinutes = *req.LateGraceMinutes
+		}
+		if req.LatePenalty != nil {
+			rule.LatePenalty = *req.LatePenalty
+		}
+		if req.MaxUnpaidAbsences != nil {
+			rule.MaxUnpaidAbsences = *req.MaxUnpaidAbsences
+		}
+		if req.StandardDailyHours != nil {
+			rule.StandardDailyHours = *req.StandardDailyHours
+		}
+		if req.EarlyLeavePenalty != nil {
+			rule.EarlyLeavePenalty = *req.EarlyLeavePenalty
+		}
+		if req.MissingCheckOutPenalty != nil {
+			rule.MissingCheckOutPenalty = *req.MissingCheckOutPenalty
+		}
+		if req.AbsencePenalty != nil {
+			rule.AbsencePenalty = *req.AbsencePenalty
+		}
+		if req.OvertimeWeekdayRate != nil {
+			rule.OvertimeWeekdayRate = *req.OvertimeWeekdayRate
+		}
+		if req.OvertimeWeekendRate != nil {
+			rule.OvertimeWeekendRate = *req.OvertimeWeekendRate
+		}
+		if req.OvertimeHolidayRate != nil {
+			rule.OvertimeHolidayRate = *req.OvertimeHolidayRate
+		}
+		if req.OvertimeMonthlyCap != nil {
+			rule.OvertimeMonthlyCap = *req.OvertimeMonthlyCap
+		}
+		if invalid = validateCompanyRule(rule); invalid != "" {
+			return nil
+		}
+
+		return tx.Omit(clause.Associations).Create(&rule).Error
+	})
+	switch {
+	case errors.Is(err, errRuleVersionExists):
+		return c.Status(409).JSON(types.APIResponse{
+			Success: false,
+			Error:   "A rule version already takes effect on that date",
+		})
+	case err != nil:
+		utils.Logger.Error("Failed to create company rule", zap.Error(err))
+		return c.Status(500).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrDatabaseError,
+		})
+	case invalid != "":
+		return c.Status(400).JSON(types.APIResponse{
+			Success: false,
+			Error:   invalid,
+		})
+	}
+
+	return c.JSON(types.APIResponse{
+		Success: true,
+		Message: "Company rules updated successfully",
+		Data:    rule,
 	})
 }
 
-func RecordViolation(c *fiber.Ctx) error {
-	return c.JSON(fiber.Map{
-		"message": "Not implemented",
+// ListCompanyRules returns every rule version, newest first
+func ListCompanyRules(c *fiber.Ctx) error {
+	var rules []models.CompanyRule
+	if err := DB.Order("effective_from DESC").Find(&rules).Error; err != nil {
+		utils.Logger.Error("Failed to fetch company rules", zap.Error(err))
+		return c.Status(500).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrDatabaseError,
+		})
+	}
+
+	return c.JSON(types.APIResponse{
+		Success: true,
+		Data:    rules,
+	})
+}
+
+// GetCompanyRule returns the rules in force on the date query parameter
+// (YYYY-MM-DD), today by default. Version 0 is the built-in default.
+func GetCompanyRule(c *fiber.Ctx) error {
+	day := time.Now()
+	if date := c.Query("date"); date != "" {
+		var err error
+		if day, err = time.ParseInLocation("2006-01-02", date, time.Local); err != nil {
+			return c.Status(400).JSON(types.APIResponse{
+				Success: false,
+				Error:   "Invalid date format. Use YYYY-MM-DD",
+			})
+		}
+	}
+
+	rule, err := companyRuleOn(DB, day)
+	if err != nil {
+		utils.Logger.Error("Failed to fetch company rule", zap.Error(err))
+		return c.Status(500).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrDatabaseError,
+		})
+	}
+
+	return c.JSON(types.APIResponse{
+		Success: true,
+		Data:    rule,
 	})
 }
 
@@ -1,29 +1,226 @@
 package handlers
 
 import (
+	"dapp_timekeeping/models"
 	"dapp_timekeeping/types"
+	"dapp_timekeeping/utils"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
 )
 
+type UpdateSalaryRequest struct {
+	Salary float64 `json:"salary" validate:"required,gt=0"`
+}
+
+type ProcessSalaryRequest struct {
+	IDs []string `json:"ids" validate:"required"`
+}
+
 // Salary Management
 func UpdateSalary(c *fiber.Ctx) error {
+	var req UpdateSalaryRequest
+	if err := c.BodyParser(&req); err != nil || req.Salary <= 0 {
+		return c.Status(400).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrInvalidInput,
+		})
+	}
+
+	var employee models.User
+	if err := DB.First(&employee, "id = ?", c.Params("id")).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return c.Status(404).JSON(types.APIResponse{
+				Success: false,
+				Error:   "Employee not found",
+			})
+		}
+		utils.Logger.Error("Failed to fetch employee", zap.Error(err))
+		return c.Status(500).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrDatabaseError,
+		})
+	}
+
+	scope, _, err := permissionScope(c, "salary_management")
+	if err != nil {
+		utils.Logger.Error("Failed to resolve permissions", zap.Error(err))
+		return c.Status(500).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrDatabaseError,
+		})
+	}
+	if !scope.Allows(employee.Department) {
+		return c.Status(403).JSON(types.APIResponse{
+			Success: false,
+			Error:   "Employee is outside your departments",
+		})
+	}
+
+	if err := DB.Model(&employee).Updates(map[string]interface{}{
+		"salary":     req.Salary,
+		"updated_at": time.Now(),
+	}).Error; err != nil {
+		utils.Logger.Error("Failed to update salary", zap.Error(err))
+		return c.Status(500).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrDatabaseError,
+		})
+	}
+
 	return c.JSON(types.APIResponse{
 		Success: true,
-		Message: "Not implemented",
+		Message: "Salary updated successfully",
 	})
 }
 
+// salaryQuery lists salary records with their line items, filtered by the
+// optional month (YYYY-MM) and user_id query parameters
+func salaryQuery(c *fiber.Ctx) (*gorm.DB, error) {
+	query := DB.Preload("LineItems").Order("month DESC, user_id")
+	if month := c.Query("month"); month != "" {
+		start, err := parseMonth(month)
+		if err != nil {
+			return nil, err
+		}
+		query = query.Where("month = ?", start)
+	}
+	if userID := c.Query("user_id"); userID != "" {
+		query = query.Where("user_id = ?", userID)
+	}
+	return query, nil
+}
+
 func GetPendingSalaryApprovals(c *fiber.Ctx) error {
+	query, err := salaryQuery(c)
+	if err != nil {
+		return c.Status(400).JSON(types.APIResponse{
+			Success: false,
+			Error:   "Invalid month format. Use YYYY-MM",
+		})
+	}
+
+	var approvals []models.SalaryApproval
+	if err := query.Where("status = ?", "pending").Find(&approvals).Error; err != nil {
+		utils.Logger.Error("Failed to fetch pending salaries", zap.Error(err))
+		return c.Status(500).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrDatabaseError,
+		})
+	}
+
 	return c.JSON(types.APIResponse{
 		Success: true,
-		Message: "Not implemented",
+		Data:    approvals,
+	})
+}
+
+// GetPayrollHistory lists salary records of every status
+func GetPayrollHistory(c *fiber.Ctx) error {
+	query, err := salaryQuery(c)
+	if err != nil {
+		return c.Status(400).JSON(types.APIResponse{
+			Success: false,
+			Error:   "Invalid month format. Use YYYY-MM",
+		})
+	}
+	if status := c.Query("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+
+	var approvals []models.SalaryApproval
+	if err := query.Find(&approvals).Error; err != nil {
+		utils.Logger.Error("Failed to fetch payroll history", zap.Error(err))
+		return c.Status(500).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrDatabaseError,
+		})
+	}
+
+	return c.JSON(types.APIResponse{
+		Success: true,
+		Data:    approvals,
 	})
 }
 
 func ApproveSalary(c *fiber.Ctx) error {
+	return processSalaries(c, "approved")
+}
+
+// RejectSalary sends pending salaries back; the next payroll run recomputes them
+func RejectSalary(c *fiber.Ctx) error {
+	return processSalaries(c, "rejected")
+}
+
+// processSalaries moves the given pending salary records to status. Records
+// that are no longer pending are left unchanged.
+func processSalaries(c *fiber.Ctx, status string) error {
+	processorID, ok := c.Locals("user_id").(string)
+	if !ok || processorID == "" {
+		return c.Status(401).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrUnauthorized,
+		})
+	}
+
+	var req ProcessSalaryRequest
+	if err := c.BodyParser(&req); err != nil || len(req.IDs) == 0 {
+		return c.Status(400).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrInvalidInput,
+		})
+	}
+
+	// Each status change is queued for the chain in the same transaction
+	now := time.Now()
+	var processed int64
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		var approvals []models.SalaryApproval
+		if err := tx.Where("id IN ? AND status = ?", req.IDs, "pending").Find(&approvals).Error; err != nil {
+			return err
+		}
+		for _, approval := range approvals {
+			result := tx.Model(&models.SalaryApproval{}).
+				Where("id = ? AND status = ?", approval.ID, "pending").
+				Updates(map[string]interface{}{
+					"status":      status,
+					"approved_by": processorID,
+					"approved_at": now,
+					"updated_at":  now,
+				})
+			if result.Error != nil {
+				return result.Error
+			}
+			if result.RowsAffected == 0 {
+				continue
+			}
+
+			approval.Status = status
+			approval.ApprovedBy = &processorID
+			approval.ApprovedAt = &now
+			if err := enqueueOutbox(tx, "payroll", approval.ID, salaryEvent(approval)); err != nil {
+				return err
+			}
+			processed++
+		}
+		return nil
+	})
+	if err != nil {
+		utils.Logger.Error("Failed to process salaries", zap.Error(err))
+		return c.Status(500).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrDatabaseError,
+		})
+	}
+
 	return c.JSON(types.APIResponse{
 		Success: true,
-		Message: "Not implemented",
+		Message: "Salaries " + status + " successfully",
+		Data: map[string]interface{}{
+			"processed": processed,
+			"skipped":   int64(len(req.IDs)) - processed,
+		},
 	})
-}
\ No newline at end of file
+}
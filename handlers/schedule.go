@@ -0,0 +1,578 @@
+package handlers
+
+import (
+	"dapp_timekeeping/models"
+	"dapp_timekeeping/types"
+	"dapp_timekeeping/utils"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+type ShiftBreakRequest struct {
+	StartTime string `json:"start_time" validate:"required"` // HH:MM
+	EndTime   string `json:"end_time" validate:"required"`   // HH:MM
+}
+
+type CreateShiftRequest struct {
+	Name      string              `json:"name" validate:"required"`
+	StartTime string              `json:"start_time" validate:"required"` // HH:MM
+	EndTime   string              `json:"end_time" validate:"required"`   // HH:MM
+	WorkDays  []int               `json:"work_days"`                      // 0 = Sunday, defaults to Monday-Friday
+	Breaks    []ShiftBreakRequest `json:"breaks"`
+}
+
+type AssignShiftRequest struct {
+	ShiftID       string `json:"shift_id" validate:"required"`
+	UserID        string `json:"user_id"`
+	Department    string `json:"department"`
+	EffectiveFrom string `json:"effective_from" validate:"required"` // YYYY-MM-DD
+	EffectiveTo   string `json:"effective_to"`                       // YYYY-MM-DD, empty for open-ended
+}
+
+type ScheduleDay struct {
+	Date          string   `json:"date"` // YYYY-MM-DD
+	ShiftID       string   `json:"shift_id,omitempty"`
+	ShiftName     string   `json:"shift_name,omitempty"`
+	Start         string   `json:"start,omitempty"` // RFC3339
+	End           string   `json:"end,omitempty"`   // RFC3339
+	ExpectedHours float64  `json:"expected_hours"`
+	Breaks        []string `json:"breaks,omitempty"`   // HH:MM-HH:MM
+	Calendar      string   `json:"calendar,omitempty"` // Holiday, closure or compensatory working day name
+}
+
+// defaultShift applies to anyone without a shift assignment in force
+var defaultShift = models.Shift{
+	Name:      "default",
+	StartTime: "09:00",
+	EndTime:   "18:00",
+	WorkDays:  "1,2,3,4,5",
+	Breaks: []models.ShiftBreak{
+		{StartTime: "12:00", EndTime: "13:00"},
+	},
+}
+
+type timeWindow struct {
+	Start time.Time
+	End   time.Time
+}
+
+// overlap returns how much of [start, end) falls inside the window
+func (w timeWindow) overlap(start, end time.Time) time.Duration {
+	if start.Before(w.Start) {
+		start = w.Start
+	}
+	if end.After(w.End) {
+		end = w.End
+	}
+	if !end.After(start) {
+		return 0
+	}
+	return end.Sub(start)
+}
+
+// scheduledShift is a shift laid out on a concrete date
+type scheduledShift struct {
+	ShiftID string
+	Name    string
+	Window  timeWindow
+	Breaks  []timeWindow
+}
+
+// ExpectedDuration returns the scheduled working time excluding breaks
+func (s scheduledShift) ExpectedDuration() time.Duration {
+	d := s.Window.End.Sub(s.Window.Start)
+	for _, b := range s.Breaks {
+		d -= b.End.Sub(b.Start)
+	}
+	return d
+}
+
+// WorkedDuration returns the part of [in, out) spent inside the shift,
+// excluding breaks. Time outside the shift window is not counted.
+func (s scheduledShift) WorkedDuration(in, out time.Time) time.Duration {
+	if out.IsZero() || !out.After(in) {
+		return 0
+	}
+	d := s.Window.overlap(in, out)
+	for _, b := range s.Breaks {
+		d -= b.overlap(in, out)
+	}
+	return d
+}
+
+// parseClock parses an HH:MM string into hours and minutes
+func parseClock(value string) (int, int, error) {
+	t, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid time %q, use HH:MM", value)
+	}
+	return t.Hour(), t.Minute(), nil
+}
+
+// atClock returns the time of day on the date of day, rolling over to the
+// next day when it falls before notBefore
+func atClock(day time.Time, value string, notBefore time.Time) (time.Time, error) {
+	h, m, err := parseClock(value)
+	if err != nil {
+		return time.Time{}, err
+	}
+	t := time.Date(day.Year(), day.Month(), day.Day(), h, m, 0, 0, day.Location())
+	if !notBefore.IsZero() && t.Before(notBefore) {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t, nil
+}
+
+// parseWorkDays parses a comma separated list of weekday numbers
+func parseWorkDays(value string) (map[time.Weekday]bool, error) {
+	days := make(map[time.Weekday]bool)
+	for _, part := range strings.Split(value, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 || n > 6 {
+			return nil, fmt.Errorf("invalid work day %q", part)
+		}
+		days[time.Weekday(n)] = true
+	}
+	return days, nil
+}
+
+// layoutShift places the shift on the given day. It returns false when the
+// shift does not run on that weekday.
+func layoutShift(shift models.Shift, day time.Time) (scheduledShift, bool, error) {
+	days, err := parseWorkDays(shift.WorkDays)
+	if err != nil {
+		return scheduledShift{}, false, err
+	}
+	if !days[day.Weekday()] {
+		return scheduledShift{}, false, nil
+	}
+
+	start, err := atClock(day, shift.StartTime, time.Time{})
+	if err != nil {
+		return scheduledShift{}, false, err
+	}
+	end, err := atClock(day, shift.EndTime, start.Add(time.Minute))
+	if err != nil {
+		return scheduledShift{}, false, err
+	}
+
+	scheduled := scheduledShift{
+		ShiftID: shift.ID,
+		Name:    shift.Name,
+		Window:  timeWindow{Start: start, End: end},
+	}
+	for _, b := range shift.Breaks {
+		bStart, err := atClock(day, b.StartTime, start)
+		if err != nil {
+			return scheduledShift{}, false, err
+		}
+		bEnd, err := atClock(day, b.EndTime, bStart.Add(time.Minute))
+		if err != nil {
+			return scheduledShift{}, false, err
+		}
+		if bEnd.After(end) {
+			return scheduledShift{}, false, fmt.Errorf("break %s-%s is outside the shift", b.StartTime, b.EndTime)
+		}
+		scheduled.Breaks = append(scheduled.Breaks, timeWindow{Start: bStart, End: bEnd})
+	}
+
+	return scheduled, true, nil
+}
+
+// Work days of a shift laid out on a compensatory working day
+const everyWeekday = "0,1,2,3,4,5,6"
+
+// scheduleResolver answers which shift is in force for a user on a date,
+// using the assignments and calendar loaded for a date range
+type scheduleResolver struct {
+	assignments []models.ShiftAssignment
+	calendar    map[string]models.CalendarDay
+}
+
+// loadScheduleResolver loads every assignment in force and every calendar
+// day between from and to
+func loadScheduleResolver(tx *gorm.DB, from, to time.Time) (*scheduleResolver, error) {
+	var assignments []models.ShiftAssignment
+	err := tx.Preload("Shift.Breaks").
+		Where("effective_from < ?", startOfDay(to).AddDate(0, 0, 1)).
+		Where("effective_to IS NULL OR effective_to >= ?", startOfDay(from)).
+		Order("effective_from DESC, created_at DESC").
+		Find(&assignments).Error
+	if err != nil {
+		return nil, err
+	}
+	calendar, err := loadCalendar(tx, from, to)
+	if err != nil {
+		return nil, err
+	}
+	return &scheduleResolver{assignments: assignments, calendar: calendar}, nil
+}
+
+// calendarOn returns the calendar day in force for the department, which
+// replaces the company-wide one
+func (r *scheduleResolver) calendarOn(department string, day time.Time) (models.CalendarDay, bool) {
+	day = startOfDay(day.In(time.Local))
+	if department != "" {
+		if d, ok := r.calendar[calendarKey(day, department)]; ok {
+			return d, true
+		}
+	}
+	d, ok := r.calendar[calendarKey(day, "")]
+	return d, ok
+}
+
+// shiftOn returns the shift the user works on the given day. Assignments made
+// to the user take precedence over department assignments; the default shift
+// applies when neither exists. It returns false on a day off, including
+// holidays and closures; on a compensatory working day the shift runs
+// whatever the weekday.
+func (r *scheduleResolver) shiftOn(userID, department string, day time.Time) (scheduledShift, bool) {
+	day = startOfDay(day.In(time.Local))
+	special, onCalendar := r.calendarOn(department, day)
+	if onCalendar && special.Kind != "working_day" {
+		return scheduledShift{}, false
+	}
+
+	var userShifts, departmentShifts []models.Shift
+	for _, a := range r.assignments {
+		if !a.EffectiveFrom.Before(day.AddDate(0, 0, 1)) {
+			continue
+		}
+		if a.EffectiveTo != nil && a.EffectiveTo.Before(day) {
+			continue
+		}
+		if a.UserID != nil && *a.UserID == userID {
+			userShifts = append(userShifts, a.Shift)
+		} else if a.UserID == nil && department != "" && a.Department == department {
+			departmentShifts = append(departmentShifts, a.Shift)
+		}
+	}
+
+	candidates := userShifts
+	if len(candidates) == 0 {
+		candidates = departmentShifts
+	}
+	if len(candidates) == 0 {
+		candidates = []models.Shift{defaultShift}
+	}
+
+	for _, shift := range candidates {
+		if onCalendar {
+			shift.WorkDays = everyWeekday
+		}
+		scheduled, ok, err := layoutShift(shift, day)
+		if err != nil {
+			utils.Logger.Error("Invalid shift definition", zap.String("shift_id", shift.ID), zap.Error(err))
+			continue
+		}
+		if ok {
+			return scheduled, true
+		}
+	}
+	return scheduledShift{}, false
+}
+
+// shiftAt returns the shift a punch at t belongs to. A punch after midnight
+// belongs to the previous day's overnight shift while that shift is running.
+func (r *scheduleResolver) shiftAt(userID, department string, t time.Time) (scheduledShift, bool) {
+	t = t.In(time.Local)
+	if prev, ok := r.shiftOn(userID, department, t.AddDate(0, 0, -1)); ok && t.Before(prev.Window.End) {
+		return prev, true
+	}
+	return r.shiftOn(userID, department, t)
+}
+
+func startOfDay(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+}
+
+// formatHMS formats a duration as HH:MM:SS
+func formatHMS(d time.Duration) string {
+	seconds := int64(d.Round(time.Second) / time.Second)
+	return fmt.Sprintf("%02d:%02d:%02d", seconds/3600, seconds%3600/60, seconds%60)
+}
+
+// CreateShift defines a new named shift (root only)
+func CreateShift(c *fiber.Ctx) error {
+	var req CreateShiftRequest
+	if err := c.BodyParser(&req); err != nil || req.Name == "" {
+		return c.Status(400).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrInvalidInput,
+		})
+	}
+
+	shift := models.Shift{
+		ID:        uuid.New().String(),
+		Name:      req.Name,
+		StartTime: req.StartTime,
+		EndTime:   req.EndTime,
+		WorkDays:  defaultShift.WorkDays,
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+	}
+	if len(req.WorkDays) > 0 {
+		days := make([]string, len(req.WorkDays))
+		for i, d := range req.WorkDays {
+			days[i] = strconv.Itoa(d)
+		}
+		shift.WorkDays = strings.Join(days, ",")
+	}
+	for _, b := range req.Breaks {
+		shift.Breaks = append(shift.Breaks, models.ShiftBreak{
+			ID:        uuid.New().String(),
+			ShiftID:   shift.ID,
+			StartTime: b.StartTime,
+			EndTime:   b.EndTime,
+		})
+	}
+
+	// Lay the shift out on a day it runs to validate times and breaks
+	if _, _, err := layoutShift(models.Shift{
+		StartTime: shift.StartTime,
+		EndTime:   shift.EndTime,
+		WorkDays:  "0,1,2,3,4,5,6",
+		Breaks:    shift.Breaks,
+	}, time.Now()); err != nil {
+		return c.Status(400).JSON(types.APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+	}
+	if _, err := parseWorkDays(shift.WorkDays); err != nil {
+		return c.Status(400).JSON(types.APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+	}
+
+	if err := DB.Create(&shift).Error; err != nil {
+		utils.Logger.Error("Failed to create shift", zap.Error(err))
+		return c.Status(500).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrDatabaseError,
+		})
+	}
+
+	return c.JSON(types.APIResponse{
+		Success: true,
+		Message: "Shift created successfully",
+		Data:    shift,
+	})
+}
+
+// ListShifts returns all shifts with their breaks
+func ListShifts(c *fiber.Ctx) error {
+	var shifts []models.Shift
+	if err := DB.Preload("Breaks").Order("name").Find(&shifts).Error; err != nil {
+		utils.Logger.Error("Failed to fetch shifts", zap.Error(err))
+		return c.Status(500).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrDatabaseError,
+		})
+	}
+
+	return c.JSON(types.APIResponse{
+		Success: true,
+		Data:    shifts,
+	})
+}
+
+// AssignShift assigns a shift to a user or a department from a given date
+func AssignShift(c *fiber.Ctx) error {
+	var req AssignShiftRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrInvalidInput,
+		})
+	}
+
+	from, err := time.ParseInLocation("2006-01-02", req.EffectiveFrom, time.Local)
+	if err != nil {
+		return c.Status(400).JSON(types.APIResponse{
+			Success: false,
+			Error:   "Invalid effective_from format. Use YYYY-MM-DD",
+		})
+	}
+
+	assignment := models.ShiftAssignment{
+		ID:            uuid.New().String(),
+		ShiftID:       req.ShiftID,
+		Department:    req.Department,
+		EffectiveFrom: from,
+		CreatedAt:     time.Now(),
+		UpdatedAt:     time.Now(),
+	}
+	if req.UserID != "" {
+		assignment.UserID = &req.UserID
+	}
+	if req.EffectiveTo != "" {
+		to, err := time.ParseInLocation("2006-01-02", req.EffectiveTo, time.Local)
+		if err != nil {
+			return c.Status(400).JSON(types.APIResponse{
+				Success: false,
+				Error:   "Invalid effective_to format. Use YYYY-MM-DD",
+			})
+		}
+		assignment.EffectiveTo = &to
+	}
+
+	if err := DB.First(&models.Shift{}, "id = ?", req.ShiftID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return c.Status(404).JSON(types.APIResponse{
+				Success: false,
+				Error:   "Shift not found",
+			})
+		}
+		return c.Status(500).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrDatabaseError,
+		})
+	}
+	if req.UserID != "" {
+		if err := DB.First(&models.User{}, "id = ?", req.UserID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return c.Status(404).JSON(types.APIResponse{
+					Success: false,
+					Error:   "Employee not found",
+				})
+			}
+			return c.Status(500).JSON(types.APIResponse{
+				Success: false,
+				Error:   types.ErrDatabaseError,
+			})
+		}
+	}
+
+	if err := DB.Omit("Shift").Create(&assignment).Error; err != nil {
+		// BeforeSave validation errors are caller mistakes
+		utils.Logger.Warn("Failed to create shift assignment", zap.Error(err))
+		return c.Status(400).JSON(types.APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+	}
+
+	return c.JSON(types.APIResponse{
+		Success: true,
+		Message: "Shift assigned successfully",
+		Data:    assignment,
+	})
+}
+
+// ListShiftAssignments lists assignments, optionally filtered by user or department
+func ListShiftAssignments(c *fiber.Ctx) error {
+	query := DB.Preload("Shift.Breaks").Order("effective_from DESC")
+	if userID := c.Query("user_id"); userID != "" {
+		query = query.Where("user_id = ?", userID)
+	}
+	if department := c.Query("department"); department != "" {
+		query = query.Where("department = ?", department)
+	}
+
+	var assignments []models.ShiftAssignment
+	if err := query.Find(&assignments).Error; err != nil {
+		utils.Logger.Error("Failed to fetch shift assignments", zap.Error(err))
+		return c.Status(500).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrDatabaseError,
+		})
+	}
+
+	return c.JSON(types.APIResponse{
+		Success: true,
+		Data:    assignments,
+	})
+}
+
+// GetMySchedule returns the authenticated user's shifts for a date range
+// (defaults to the next 7 days)
+func GetMySchedule(c *fiber.Ctx) error {
+	userID, ok := c.Locals("user_id").(string)
+	if !ok || userID == "" {
+		return c.Status(401).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrUnauthorized,
+		})
+	}
+
+	from := startOfDay(time.Now())
+	to := from.AddDate(0, 0, 6)
+	var err error
+	if v := c.Query("from"); v != "" {
+		if from, err = time.ParseInLocation("2006-01-02", v, time.Local); err != nil {
+			return c.Status(400).JSON(types.APIResponse{
+				Success: false,
+				Error:   "Invalid from date format. Use YYYY-MM-DD",
+			})
+		}
+	}
+	if v := c.Query("to"); v != "" {
+		if to, err = time.ParseInLocation("2006-01-02", v, time.Local); err != nil {
+			return c.Status(400).JSON(types.APIResponse{
+				Success: false,
+				Error:   "Invalid to date format. Use YYYY-MM-DD",
+			})
+		}
+	}
+	if to.Before(from) || to.Sub(from) > 366*24*time.Hour {
+		return c.Status(400).JSON(types.APIResponse{
+			Success: false,
+			Error:   "Invalid date range",
+		})
+	}
+
+	var user models.User
+	if err := DB.First(&user, "id = ?", userID).Error; err != nil {
+		return c.Status(404).JSON(types.APIResponse{
+			Success: false,
+			Error:   "Employee not found",
+		})
+	}
+
+	resolver, err := loadScheduleResolver(DB, from, to)
+	if err != nil {
+		utils.Logger.Error("Failed to load schedule", zap.Error(err))
+		return c.Status(500).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrDatabaseError,
+		})
+	}
+
+	var days []ScheduleDay
+	for day := from; !day.After(to); day = day.AddDate(0, 0, 1) {
+		entry := ScheduleDay{Date: day.Format("2006-01-02")}
+		if special, ok := resolver.calendarOn(user.Department, day); ok {
+			entry.Calendar = special.Name
+		}
+		if shift, ok := resolver.shiftOn(user.ID, user.Department, day); ok {
+			entry.ShiftID = shift.ShiftID
+			entry.ShiftName = shift.Name
+			entry.Start = shift.Window.Start.Format(time.RFC3339)
+			entry.End = shift.Window.End.Format(time.RFC3339)
+			entry.ExpectedHours = shift.ExpectedDuration().Hours()
+			for _, b := range shift.Breaks {
+				entry.Breaks = append(entry.Breaks, b.Start.Format("15:04")+"-"+b.End.Format("15:04"))
+			}
+		}
+		days = append(days, entry)
+	}
+
+	return c.JSON(types.APIResponse{
+		Success: true,
+		Data:    days,
+	})
+}
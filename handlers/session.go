@@ -0,0 +1,339 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"time"
+
+	"dapp_timekeeping/auth"
+	"dapp_timekeeping/config"
+	"dapp_timekeeping/models"
+	"dapp_timekeeping/types"
+	"dapp_timekeeping/utils"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token" validate:"required"`
+}
+
+// LogoutRequest optionally names the refresh token to revoke. Without it the
+// session the access token belongs to is revoked.
+type LogoutRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+func hashRefreshToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// issueSession signs an access token and a refresh token for the user. An
+// empty familyID starts a new session.
+func issueSession(tx *gorm.DB, user models.User, familyID string) (LoginResponse, error) {
+	token, claims, err := auth.IssueToken(user.ID, user.Role)
+	if err != nil {
+		return LoginResponse{}, err
+	}
+
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return LoginResponse{}, err
+	}
+	refresh := base64.RawURLEncoding.EncodeToString(b)
+
+	if familyID == "" {
+		familyID = uuid.New().String()
+	}
+	now := time.Now()
+	record := models.RefreshToken{
+		ID:              uuid.New().String(),
+		UserID:          user.ID,
+		FamilyID:        familyID,
+		TokenHash:       hashRefreshToken(refresh),
+		AccessTokenID:   claims.ID,
+		AccessExpiresAt: claims.ExpiresAt.Time,
+		ExpiresAt:       now.Add(time.Duration(config.AppConfig.RefreshTokenTTL * float64(time.Hour))),
+		CreatedAt:       now,
+	}
+	if err := tx.Omit(clause.Associations).Create(&record).Error; err != nil {
+		return LoginResponse{}, err
+	}
+
+	return LoginResponse{
+		Token:            token,
+		ExpiresAt:        claims.ExpiresAt.Time,
+		RefreshToken:     refresh,
+		RefreshExpiresAt: record.ExpiresAt,
+		User:             user,
+	}, nil
+}
+
+// denyAccessTokens adds the unexpired access tokens of the refresh tokens
+// matched by query to the denylist
+func denyAccessTokens(tx *gorm.DB, query *gorm.DB, reason string) error {
+	now := time.Now()
+	var tokens []models.RefreshToken
+	if err := query.Where("access_expires_at > ?", now).Find(&tokens).Error; err != nil {
+		return err
+	}
+	for _, t := range tokens {
+		if err := denyAccessToken(tx, t.AccessTokenID, t.UserID, t.AccessExpiresAt, reason); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func denyAccessToken(tx *gorm.DB, jti, userID string, expiresAt time.Time, reason string) error {
+	return tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&models.RevokedToken{
+		JTI:       jti,
+		UserID:    userID,
+		ExpiresAt: expiresAt,
+		Reason:    reason,
+		CreatedAt: time.Now(),
+	}).Error
+}
+
+// revokeFamily revokes every refresh token of a session and its access tokens
+func revokeFamily(tx *gorm.DB, familyID, reason string) error {
+	if err := denyAccessTokens(tx, tx.Model(&models.RefreshToken{}).Where("family_id = ?", familyID), reason); err != nil {
+		return err
+	}
+	return tx.Model(&models.RefreshToken{}).
+		Where("family_id = ? AND revoked_at IS NULL", familyID).
+		Update("revoked_at", time.Now()).Error
+}
+
+// revokeUserSessions logs the user out of every session
+func revokeUserSessions(tx *gorm.DB, userID, reason string) error {
+	if err := denyAccessTokens(tx, tx.Model(&models.RefreshToken{}).Where("user_id = ?", userID), reason); err != nil {
+		return err
+	}
+	return tx.Model(&models.RefreshToken{}).
+		Where("user_id = ? AND revoked_at IS NULL", userID).
+		Update("revoked_at", time.Now()).Error
+}
+
+// revokeOtherSessions logs the user out of every session except the one the
+// access token with currentJTI belongs to
+func revokeOtherSessions(tx *gorm.DB, userID, currentJTI, reason string) error {
+	var current models.RefreshToken
+	err := tx.Where("user_id = ? AND access_token_id = ?", userID, currentJTI).First(&current).Error
+	if err != nil && err != gorm.ErrRecordNotFound {
+		return err
+	}
+	others := func() *gorm.DB {
+		query := tx.Model(&models.RefreshToken{}).Where("user_id = ?", userID)
+		if current.FamilyID != "" {
+			query = query.Where("family_id <> ?", current.FamilyID)
+		}
+		return query
+	}
+	if err := denyAccessTokens(tx, others(), reason); err != nil {
+		return err
+	}
+	return others().Where("revoked_at IS NULL").Update("revoked_at", time.Now()).Error
+}
+
+// TokenRevoked reports whether the access token with the jti was revoked
+func TokenRevoked(jti string) (bool, error) {
+	var count int64
+	err := DB.Model(&models.RevokedToken{}).Where("jti = ?", jti).Count(&count).Error
+	return count > 0, err
+}
+
+// RefreshSession trades a refresh token for a new access and refresh token.
+// Presenting a refresh token that was already used revokes its session, since
+// either the client or someone who stole the token is replaying it.
+func RefreshSession(c *fiber.Ctx) error {
+	var req RefreshRequest
+	if err := c.BodyParser(&req); err != nil || req.RefreshToken == "" {
+		return c.Status(400).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrInvalidInput,
+		})
+	}
+
+	invalid := func() error {
+		return c.Status(401).JSON(types.APIResponse{
+			Success: false,
+			Error:   "Invalid or expired refresh token",
+		})
+	}
+
+	var token models.RefreshToken
+	if err := DB.First(&token, "token_hash = ?", hashRefreshToken(req.RefreshToken)).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return invalid()
+		}
+		utils.Logger.Error("Failed to fetch refresh token", zap.Error(err))
+		return c.Status(500).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrDatabaseError,
+		})
+	}
+
+	now := time.Now()
+	if token.RevokedAt != nil || !token.ExpiresAt.After(now) {
+		return invalid()
+	}
+	if token.UsedAt != nil {
+		utils.Logger.Warn("Refresh token reused, revoking session",
+			zap.String("user_id", token.UserID),
+			zap.String("family_id", token.FamilyID),
+		)
+		if err := DB.Transaction(func(tx *gorm.DB) error {
+			return revokeFamily(tx, token.FamilyID, "refresh token reuse")
+		}); err != nil {
+			utils.Logger.Error("Failed to revoke session", zap.Error(err))
+		}
+		return invalid()
+	}
+
+	var user models.User
+	if err := DB.First(&user, "id = ?", token.UserID).Error; err != nil {
+		utils.Logger.Error("Failed to fetch user", zap.Error(err))
+		return c.Status(500).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrDatabaseError,
+		})
+	}
+	if !canLogIn(user) {
+		return invalid()
+	}
+
+	var session LoginResponse
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		// Guard on used_at so concurrent refreshes cannot both rotate
+		result := tx.Model(&models.RefreshToken{}).
+			Where("id = ? AND used_at IS NULL AND revoked_at IS NULL", token.ID).
+			Update("used_at", now)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+
+		var err error
+		session, err = issueSession(tx, user, token.FamilyID)
+		return err
+	})
+	if err == gorm.ErrRecordNotFound {
+		return invalid()
+	}
+	if err != nil {
+		utils.Logger.Error("Failed to refresh session", zap.Error(err))
+		return c.Status(500).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrDatabaseError,
+		})
+	}
+
+	return c.JSON(types.APIResponse{
+		Success: true,
+		Data:    session,
+	})
+}
+
+// Logout revokes the caller's access token and the session it belongs to
+func Logout(c *fiber.Ctx) error {
+	claims, ok := auth.ClaimsFrom(c)
+	if !ok || claims.UserID() == "" {
+		return c.Status(401).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrUnauthorized,
+		})
+	}
+
+	var req LogoutRequest
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(400).JSON(types.APIResponse{
+				Success: false,
+				Error:   types.ErrInvalidInput,
+			})
+		}
+	}
+
+	query := DB.Where("user_id = ?", claims.UserID())
+	if req.RefreshToken != "" {
+		query = query.Where("token_hash = ?", hashRefreshToken(req.RefreshToken))
+	} else {
+		query = query.Where("access_token_id = ?", claims.ID)
+	}
+	var token models.RefreshToken
+	if err := query.First(&token).Error; err != nil && err != gorm.ErrRecordNotFound {
+		utils.Logger.Error("Failed to fetch refresh token", zap.Error(err))
+		return c.Status(500).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrDatabaseError,
+		})
+	}
+
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		if err := denyAccessToken(tx, claims.ID, claims.UserID(), claims.ExpiresAt.Time, "logout"); err != nil {
+			return err
+		}
+		if token.FamilyID != "" {
+			if err := revokeFamily(tx, token.FamilyID, "logout"); err != nil {
+				return err
+			}
+		}
+		// Denied tokens past their expiry would be rejected anyway
+		return tx.Where("expires_at < ?", time.Now()).Delete(&models.RevokedToken{}).Error
+	})
+	if err != nil {
+		utils.Logger.Error("Failed to log out", zap.Error(err))
+		return c.Status(500).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrDatabaseError,
+		})
+	}
+
+	return c.JSON(types.APIResponse{
+		Success: true,
+		Message: "Logged out",
+	})
+}
+
+// LogoutEverywhere revokes every session of a user (root only)
+func LogoutEverywhere(c *fiber.Ctx) error {
+	var user models.User
+	if err := DB.First(&user, "id = ?", c.Params("id")).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return c.Status(404).JSON(types.APIResponse{
+				Success: false,
+				Error:   "Employee not found",
+			})
+		}
+		utils.Logger.Error("Failed to fetch user", zap.Error(err))
+		return c.Status(500).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrDatabaseError,
+		})
+	}
+
+	if err := DB.Transaction(func(tx *gorm.DB) error {
+		return revokeUserSessions(tx, user.ID, "logged out everywhere")
+	}); err != nil {
+		utils.Logger.Error("Failed to revoke sessions", zap.Error(err))
+		return c.Status(500).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrDatabaseError,
+		})
+	}
+
+	return c.JSON(types.APIResponse{
+		Success: true,
+		Message: "Employee logged out of every session",
+	})
+}
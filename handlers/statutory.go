@@ -0,0 +1,162 @@
+package handlers
+
+import (
+	"dapp_timekeeping/config"
+	"dapp_timekeeping/models"
+	"dapp_timekeeping/types"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// StatutoryInput is what a statutory deduction sees of one salary calculation
+type StatutoryInput struct {
+	User       models.User
+	Month      time.Time
+	Gross      float64                 // Earnings after absence deductions, penalties and bonuses
+	NonTaxable float64                 // Part of Gross exempt from income tax
+	UnpaidDays float64                 // Scheduled days neither worked nor paid
+	Items      []models.SalaryLineItem // Statutory items produced so far
+}
+
+// StatutoryDeduction computes one legally required contribution or tax
+type StatutoryDeduction interface {
+	Apply(rates config.StatutoryRates, in StatutoryInput) []models.SalaryLineItem
+}
+
+// statutoryDeductions run in order. Income tax comes last so it can take the
+// employee's insurance contributions off the taxable income.
+var statutoryDeductions = []StatutoryDeduction{
+	socialInsurance{},
+	personalIncomeTax{},
+}
+
+// statutoryItems applies every statutory deduction in force for the month
+func statutoryItems(in StatutoryInput) []models.SalaryLineItem {
+	rates, ok := config.StatutoryRatesFor(in.Month)
+	if !ok {
+		return nil
+	}
+	for _, d := range statutoryDeductions {
+		in.Items = append(in.Items, d.Apply(rates, in)...)
+	}
+	return in.Items
+}
+
+// socialInsurance covers the compulsory BHXH, BHYT and BHTN contributions.
+// They are charged on the contract salary up to the statutory caps; employees
+// without an insurance number are not enrolled in that scheme.
+type socialInsurance struct{}
+
+func (socialInsurance) Apply(rates config.StatutoryRates, in StatutoryInput) []models.SalaryLineItem {
+	if in.UnpaidDays >= rates.ExemptUnpaidDays || in.User.Salary <= 0 {
+		return nil
+	}
+
+	baseCap := rates.BaseSalary * rates.SalaryCapMultiple
+	regionCap := rates.RegionalMinimumWage[config.AppConfig.WageRegion] * rates.SalaryCapMultiple
+
+	type scheme struct {
+		code, name         string
+		enrolled           bool
+		cap                float64
+		employee, employer float64
+	}
+	schemes := []scheme{
+		{"bhxh", "Social insurance", in.User.SocialInsuranceID != "", baseCap, rates.Employee.SocialInsurance, rates.Employer.SocialInsurance},
+		{"bhyt", "Health insurance", in.User.HealthInsuranceID != "", baseCap, rates.Employee.HealthInsurance, rates.Employer.HealthInsurance},
+		{"bhtn", "Unemployment insurance", in.User.SocialInsuranceID != "", regionCap, rates.Employee.UnemploymentInsurance, rates.Employer.UnemploymentInsurance},
+	}
+
+	var items []models.SalaryLineItem
+	for _, s := range schemes {
+		if !s.enrolled {
+			continue
+		}
+		base := in.User.Salary
+		if s.cap > 0 && base > s.cap {
+			base = s.cap
+		}
+		items = append(items,
+			models.SalaryLineItem{
+				Kind:        "deduction",
+				Code:        s.code,
+				Description: fmt.Sprintf("%s %.1f%% (rates %s)", s.name, s.employee*100, rates.Version),
+				Quantity:    base,
+				Unit:        "vnd",
+				Amount:      roundMoney(base * s.employee),
+			},
+			models.SalaryLineItem{
+				Kind:        "employer",
+				Code:        s.code + "_employer",
+				Description: fmt.Sprintf("%s employer share %.1f%% (rates %s)", s.name, s.employer*100, rates.Version),
+				Quantity:    base,
+				Unit:        "vnd",
+				Amount:      roundMoney(base * s.employer),
+			},
+		)
+	}
+	return items
+}
+
+// personalIncomeTax withholds progressive PIT on income after exempt income,
+// the employee's insurance contributions and the personal and dependent
+// allowances
+type personalIncomeTax struct{}
+
+func (personalIncomeTax) Apply(rates config.StatutoryRates, in StatutoryInput) []models.SalaryLineItem {
+	taxable := in.Gross - in.NonTaxable - rates.PersonalAllowance - rates.DependentAllowance*float64(in.User.NumberOfDependents)
+	for _, item := range in.Items {
+		if item.Kind == "deduction" {
+			taxable -= item.Amount
+		}
+	}
+	if taxable <= 0 {
+		return nil
+	}
+
+	tax := progressiveTax(rates.TaxBrackets, taxable)
+	if tax <= 0 {
+		return nil
+	}
+
+	description := fmt.Sprintf("Personal income tax (rates %s)", rates.Version)
+	if in.User.TaxID != "" {
+		description += ", tax ID " + in.User.TaxID
+	}
+	return []models.SalaryLineItem{{
+		Kind:        "deduction",
+		Code:        "pit",
+		Description: description,
+		Quantity:    roundMoney(taxable),
+		Unit:        "vnd",
+		Amount:      roundMoney(tax),
+	}}
+}
+
+// progressiveTax applies each bracket's rate to the slice of income inside it
+func progressiveTax(brackets []config.TaxBracket, income float64) float64 {
+	var tax, lower float64
+	for _, b := range brackets {
+		upper := b.UpTo
+		if upper == 0 {
+			upper = math.Inf(1)
+		}
+		if income <= lower {
+			break
+		}
+		tax += (math.Min(income, upper) - lower) * b.Rate
+		lower = upper
+	}
+	return tax
+}
+
+// ListStatutoryRates returns the configured statutory rate versions
+func ListStatutoryRates(c *fiber.Ctx) error {
+	return c.JSON(types.APIResponse{
+		Success: true,
+		Data:    config.StatutoryRateVersions(),
+	})
+}
@@ -0,0 +1,650 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"dapp_timekeeping/models"
+	"dapp_timekeeping/types"
+	"dapp_timekeeping/utils"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Finished days the detection job looks back over on each run, so that
+// records corrected after the fact are still picked up
+const violationLookbackDays = 7
+
+// Late arrivals and early leaves beyond this are major rather than minor
+const majorViolationThreshold = time.Hour
+
+// Serializes the detection job and runs started from the API
+var violationMu sync.Mutex
+
+type RecordViolationRequest struct {
+	UserID     string   `json:"user_id" validate:"required"`
+	Type       string   `json:"type" validate:"required"`
+	Date       string   `json:"date" validate:"required"` // YYYY-MM-DD
+	Severity   string   `json:"severity" validate:"required"`
+	Penalty    *float64 `json:"penalty"` // The rule's penalty for the type when omitted
+	SourceType string   `json:"source_type"`
+	SourceID   string   `json:"source_id"`
+	Note       string   `json:"note"`
+}
+
+type DetectViolationsRequest struct {
+	From string `json:"from" validate:"required"` // YYYY-MM-DD
+	To   string `json:"to" validate:"required"`   // YYYY-MM-DD
+}
+
+var violationSeverities = map[string]bool{"minor": true, "major": true, "critical": true}
+
+// violationPenalty returns the penalty rule sets for a violation type
+func violationPenalty(rule models.CompanyRule, violationType string) (float64, bool) {
+	switch violationType {
+	case "late_arrival":
+		return rule.LatePenalty, true
+	case "early_leave":
+		return rule.EarlyLeavePenalty, true
+	case "missing_check_out":
+		return rule.MissingCheckOutPenalty, true
+	case "unauthorized_absence":
+		return rule.AbsencePenalty, true
+	}
+	return 0, false
+}
+
+// violationLineItem is the payroll line item a violation type is charged as
+var violationLineItem = map[string]struct{ code, description string }{
+	"late_arrival":         {"late_penalty", "Late arrival without permission"},
+	"early_leave":          {"early_leave_penalty", "Early leave without permission"},
+	"missing_check_out":    {"missing_check_out_penalty", "Missing check-out"},
+	"unauthorized_absence": {"absence_penalty", "Absence without permission"},
+}
+
+func minorOrMajor(by time.Duration) string {
+	if by > majorViolationThreshold {
+		return "major"
+	}
+	return "minor"
+}
+
+// detectViolations raises the violations of the attendance and absence
+// records from one day to another, inclusive, judged by the rules in force on
+// each record's day. Finished scheduled days with neither attendance nor
+// leave are unauthorized absences too. Violations already raised are left
+// alone, so it can run over the same days again.
+func detectViolations(tx *gorm.DB, from, to time.Time) (int, error) {
+	from, end := startOfDay(from), startOfDay(to).AddDate(0, 0, 1)
+
+	rules, err := loadCompanyRules(tx)
+	if err != nil {
+		return 0, err
+	}
+	resolver, err := loadScheduleResolver(tx, from.AddDate(0, 0, -1), end)
+	if err != nil {
+		return 0, err
+	}
+
+	var users []models.User
+	if err := tx.Select("id", "role", "department", "status", "onboard_date").Find(&users).Error; err != nil {
+		return 0, err
+	}
+	resignations, err := resignationDates(tx)
+	if err != nil {
+		return 0, err
+	}
+	departments := make(map[string]string, len(users))
+	for _, u := range users {
+		departments[u.ID] = u.Department
+	}
+
+	var absences []models.Absence
+	if err := tx.Where("status <> ? AND type IN ?", "rejected", []string{"late_with_permission", "late_without_permission", "leave_with_permission", "leave_without_permission"}).
+		Where("(start_date < ? AND end_date >= ?) OR (date >= ? AND date < ?)", end, from, from, end).
+		Order("start_date, date").
+		Find(&absences).Error; err != nil {
+		return 0, err
+	}
+
+	// Days on which lateness or leaving early is already accounted for
+	lateExcused := make(map[string]bool)
+	leaveExcused := make(map[string]bool)
+	// Days on which not turning up is accounted for, by leave or a recorded
+	// absence without permission. Leave still pending defers the no-show
+	// until it is decided; a later run or the payroll run picks it up.
+	absent := make(map[string]bool)
+	absenceDays := func(a models.Absence) []time.Time {
+		start, last := a.StartDate, a.EndDate
+		if start.IsZero() {
+			start, last = a.Date, a.Date
+		}
+		var days []time.Time
+		for day := startOfDay(start.In(time.Local)); !day.After(last); day = day.AddDate(0, 0, 1) {
+			days = append(days, day)
+		}
+		return days
+	}
+	excuse := func(excused map[string]bool, a models.Absence) {
+		for _, day := range absenceDays(a) {
+			excused[a.UserID+"|"+day.Format("2006-01-02")] = true
+		}
+	}
+
+	var violations []models.Violation
+	raise := func(userID, violationType, severity string, day time.Time, sourceType, sourceID string) {
+		day = startOfDay(day.In(time.Local))
+		rule := rules.on(day)
+		penalty, _ := violationPenalty(rule, violationType)
+		violations = append(violations, models.Violation{
+			UserID:      userID,
+			Type:        violationType,
+			Date:        day,
+			Severity:    severity,
+			Penalty:     roundMoney(penalty),
+			SourceType:  &sourceType,
+			SourceID:    &sourceID,
+			Origin:      "detected",
+			RuleVersion: rule.Version,
+		})
+	}
+
+	for _, a := range absences {
+		switch a.Type {
+		case "late_with_permission":
+			if a.Status == "approved" {
+				excuse(lateExcused, a)
+			}
+		case "late_without_permission":
+			excuse(lateExcused, a)
+			if !a.Date.Before(from) && a.Date.Before(end) {
+				raise(a.UserID, "late_arrival", "minor", a.Date, "absence", a.ID)
+			}
+		case "leave_with_permission":
+			if a.Status == "approved" {
+				excuse(leaveExcused, a)
+			}
+			excuse(absent, a)
+		case "leave_without_permission":
+			excuse(leaveExcused, a)
+			excuse(absent, a)
+			// One violation per working day covered; nothing to be absent
+			// from on a day off
+			for _, day := range absenceDays(a) {
+				if day.Before(from) || !day.Before(end) {
+					continue
+				}
+				if _, working := resolver.shiftOn(a.UserID, departments[a.UserID], day); working {
+					raise(a.UserID, "unauthorized_absence", "major", day, "absence", a.ID)
+				}
+			}
+		}
+	}
+
+	var attendances []models.Attendance
+	if err := tx.Where("check_in_time >= ? AND check_in_time < ?", from, end).
+		Order("check_in_time").
+		Find(&attendances).Error; err != nil {
+		return 0, err
+	}
+	present := make(map[string]bool)
+	for _, a := range attendances {
+		shift, scheduled := resolver.shiftAt(a.UserID, departments[a.UserID], a.CheckInTime)
+		day := startOfDay(a.CheckInTime.In(time.Local))
+		if scheduled {
+			day = startOfDay(shift.Window.Start)
+		}
+		key := a.UserID + "|" + day.Format("2006-01-02")
+		present[key] = true
+
+		if !a.OnTime && !lateExcused[key] {
+			raise(a.UserID, "late_arrival", minorOrMajor(a.CheckInTime.Sub(a.ExpectedTime)), day, "attendance", a.ID)
+		}
+		switch {
+		case !a.CheckOutTime.After(time.Time{}):
+			raise(a.UserID, "missing_check_out", "minor", day, "attendance", a.ID)
+		case scheduled && a.CheckOutTime.Before(shift.Window.End) && !leaveExcused[key]:
+			raise(a.UserID, "early_leave", minorOrMajor(shift.Window.End.Sub(a.CheckOutTime)), day, "attendance", a.ID)
+		}
+	}
+
+	// No-shows, over the days whose shifts have all ended. Root accounts
+	// are administrators, not scheduled staff.
+	lastDay := lastFinishedDay(time.Now())
+	for _, u := range users {
+		resigned, hasResigned := resignations[u.ID]
+		if u.Role == "root" || !(u.Status == "active" || u.Status == "left_company" && hasResigned) {
+			continue
+		}
+		for day := from; day.Before(end) && !day.After(lastDay); day = day.AddDate(0, 0, 1) {
+			if hasResigned && day.After(resigned) {
+				break
+			}
+			if !u.OnboardDate.IsZero() && day.Before(startOfDay(u.OnboardDate.In(time.Local))) {
+				continue
+			}
+			key := u.ID + "|" + day.Format("2006-01-02")
+			if present[key] || absent[key] {
+				continue
+			}
+			shift, working := resolver.shiftOn(u.ID, u.Department, day)
+			if !working {
+				continue
+			}
+			source := shift.ShiftID
+			if source == "" {
+				source = defaultShift.Name
+			}
+			raise(u.ID, "unauthorized_absence", "major", day, "schedule", source)
+		}
+	}
+
+	// Oldest first, so that the monthly count for severity sees earlier days
+	sort.SliceStable(violations, func(i, j int) bool {
+		return violations[i].Date.Before(violations[j].Date)
+	})
+
+	created := 0
+	now := time.Now()
+	for _, v := range violations {
+		// Repeated unauthorized absences in a month beyond the rule's limit are critical
+		if v.Type == "unauthorized_absence" {
+			monthStart := time.Date(v.Date.Year(), v.Date.Month(), 1, 0, 0, 0, 0, time.Local)
+			var count int64
+			if err := tx.Model(&models.Violation{}).
+				Where("user_id = ? AND type = ? AND status = ? AND date >= ? AND date < ?", v.UserID, v.Type, "active", monthStart, v.Date).
+				Count(&count).Error; err != nil {
+				return created, err
+			}
+			if int(count) >= rules.on(v.Date).MaxUnpaidAbsences {
+				v.Severity = "critical"
+			}
+		}
+
+		v.ID = uuid.New().String()
+		v.CreatedAt, v.UpdatedAt = now, now
+		result := tx.Omit(clause.Associations).Clauses(clause.OnConflict{DoNothing: true}).Create(&v)
+		if result.Error != nil {
+			return created, result.Error
+		}
+		if result.RowsAffected == 0 {
+			continue
+		}
+		created++
+		if _, err := escalate(tx, v); err != nil {
+			return created, err
+		}
+	}
+	return created, nil
+}
+
+// detectRecentViolations runs detection over the last finished days
+func detectRecentViolations(now time.Time) (int, error) {
+	violationMu.Lock()
+	defer violationMu.Unlock()
+
+	last := lastFinishedDay(now)
+	var created int
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		created, err = detectViolations(tx, last.AddDate(0, 0, 1-violationLookbackDays), last)
+		return err
+	})
+	return created, err
+}
+
+// RunViolationJob detects violations every interval until ctx is done
+func RunViolationJob(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if n, err := detectRecentViolations(time.Now()); err != nil {
+			utils.Logger.Error("Failed to detect violations", zap.Error(err))
+		} else if n > 0 {
+			utils.Logger.Info("Detected violations", zap.Int("violations", n))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// DetectViolations runs detection over a range of finished days, for
+// backfilling or after records were corrected
+func DetectViolations(c *fiber.Ctx) error {
+	var req DetectViolationsRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrInvalidInput,
+		})
+	}
+	from, err := time.ParseInLocation("2006-01-02", req.From, time.Local)
+	if err != nil {
+		return c.Status(400).JSON(types.APIResponse{
+			Success: false,
+			Error:   "Invalid from format. Use YYYY-MM-DD",
+		})
+	}
+	to, err := time.ParseInLocation("2006-01-02", req.To, time.Local)
+	if err != nil || to.Before(from) {
+		return c.Status(400).JSON(types.APIResponse{
+			Success: false,
+			Error:   "Invalid to date. Use YYYY-MM-DD, not before from",
+		})
+	}
+	// Days with shifts still running are left to a later run
+	if last := lastFinishedDay(time.Now()); to.After(last) {
+		to = last
+	}
+
+	violationMu.Lock()
+	defer violationMu.Unlock()
+
+	created := 0
+	if !to.Before(from) {
+		err = DB.Transaction(func(tx *gorm.DB) error {
+			var err error
+			created, err = detectViolations(tx, from, to)
+			return err
+		})
+	}
+	if err != nil {
+		utils.Logger.Error("Failed to detect violations", zap.Error(err))
+		return c.Status(500).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrDatabaseError,
+		})
+	}
+
+	return c.JSON(types.APIResponse{
+		Success: true,
+		Data:    fiber.Map{"created": created, "to": to.Format("2006-01-02")},
+	})
+}
+
+// RecordViolation records a violation by hand. The source record, when
+// given, must belong to the employee.
+func RecordViolation(c *fiber.Ctx) error {
+	recorderID, ok := c.Locals("user_id").(string)
+	if !ok || recorderID == "" {
+		return c.Status(401).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrUnauthorized,
+		})
+	}
+
+	var req RecordViolationRequest
+	if err := c.BodyParser(&req); err != nil || req.UserID == "" {
+		return c.Status(400).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrInvalidInput,
+		})
+	}
+	if _, ok := violationLineItem[req.Type]; !ok {
+		return c.Status(400).JSON(types.APIResponse{
+			Success: false,
+			Error:   "Type must be late_arrival, early_leave, missing_check_out or unauthorized_absence",
+		})
+	}
+	if !violationSeverities[req.Severity] {
+		return c.Status(400).JSON(types.APIResponse{
+			Success: false,
+			Error:   "Severity must be minor, major or critical",
+		})
+	}
+	if req.Penalty != nil && *req.Penalty < 0 {
+		return c.Status(400).JSON(types.APIResponse{
+			Success: false,
+			Error:   "Penalty cannot be negative",
+		})
+	}
+	day, err := time.ParseInLocation("2006-01-02", req.Date, time.Local)
+	if err != nil {
+		return c.Status(400).JSON(types.APIResponse{
+			Success: false,
+			Error:   "Invalid date format. Use YYYY-MM-DD",
+		})
+	}
+
+	var employee models.User
+	if err := DB.First(&employee, "id = ?", req.UserID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return c.Status(404).JSON(types.APIResponse{
+				Success: false,
+				Error:   "Employee not found",
+			})
+		}
+		utils.Logger.Error("Failed to fetch employee", zap.Error(err))
+		return c.Status(500).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrDatabaseError,
+		})
+	}
+
+	scope, _, err := permissionScope(c, "violation_management")
+	if err != nil {
+		utils.Logger.Error("Failed to resolve permissions", zap.Error(err))
+		return c.Status(500).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrDatabaseError,
+		})
+	}
+	if !scope.Allows(employee.Department) {
+		return c.Status(403).JSON(types.APIResponse{
+			Success: false,
+			Error:   "Employee is outside your departments",
+		})
+	}
+
+	rule, err := companyRuleOn(DB, day)
+	if err != nil {
+		utils.Logger.Error("Failed to load company rules", zap.Error(err))
+		return c.Status(500).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrDatabaseError,
+		})
+	}
+	penalty, _ := violationPenalty(rule, req.Type)
+	if req.Penalty != nil {
+		penalty = *req.Penalty
+	}
+
+	now := time.Now()
+	violation := models.Violation{
+		ID:          uuid.New().String(),
+		UserID:      employee.ID,
+		Type:        req.Type,
+		Date:        day,
+		Severity:    req.Severity,
+		Penalty:     roundMoney(penalty),
+		Origin:      "manual",
+		RuleVersion: rule.Version,
+		Note:        req.Note,
+		RecordedBy:  &recorderID,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+
+	if req.SourceType != "" || req.SourceID != "" {
+		var owner string
+		var query *gorm.DB
+		switch req.SourceType {
+		case "attendance":
+			query = DB.Model(&models.Attendance{})
+		case "absence":
+			query = DB.Model(&models.Absence{})
+		default:
+			return c.Status(400).JSON(types.APIResponse{
+				Success: false,
+				Error:   "source_type must be attendance or absence",
+			})
+		}
+		if err := query.Where("id = ?", req.SourceID).Limit(1).Pluck("user_id", &owner).Error; err != nil {
+			utils.Logger.Error("Failed to fetch violation source", zap.Error(err))
+			return c.Status(500).JSON(types.APIResponse{
+				Success: false,
+				Error:   types.ErrDatabaseError,
+			})
+		}
+		if owner != employee.ID {
+			return c.Status(404).JSON(types.APIResponse{
+				Success: false,
+				Error:   "Source record not found for this employee",
+			})
+		}
+		violation.SourceType = &req.SourceType
+		violation.SourceID = &req.SourceID
+
+		var count int64
+		if err := DB.Model(&models.Violation{}).
+			Where("user_id = ? AND type = ? AND date = ? AND source_type = ? AND source_id = ?", employee.ID, req.Type, day, req.SourceType, req.SourceID).
+			Count(&count).Error; err != nil {
+			utils.Logger.Error("Failed to check existing violations", zap.Error(err))
+			return c.Status(500).JSON(types.APIResponse{
+				Success: false,
+				Error:   types.ErrDatabaseError,
+			})
+		}
+		if count > 0 {
+			return c.Status(409).JSON(types.APIResponse{
+				Success: false,
+				Error:   "This violation is already recorded for the source record",
+			})
+		}
+	}
+
+	err = DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Omit(clause.Associations).Create(&violation).Error; err != nil {
+			return err
+		}
+		_, err := escalate(tx, violation)
+		return err
+	})
+	if err != nil {
+		utils.Logger.Error("Failed to record violation", zap.Error(err))
+		return c.Status(500).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrDatabaseError,
+		})
+	}
+
+	return c.JSON(types.APIResponse{
+		Success: true,
+		Message: "Violation recorded successfully",
+		Data:    violation,
+	})
+}
+
+// violationQuery filters violations by the optional user_id, type, severity,
+// status, from and to (YYYY-MM-DD) query parameters
+func violationQuery(c *fiber.Ctx) (*gorm.DB, error) {
+	query := DB.Preload("Appeal").Order("date DESC, created_at DESC")
+	if userID := c.Query("user_id"); userID != "" {
+		query = query.Where("user_id = ?", userID)
+	}
+	if violationType := c.Query("type"); violationType != "" {
+		query = query.Where("type = ?", violationType)
+	}
+	if severity := c.Query("severity"); severity != "" {
+		query = query.Where("severity = ?", severity)
+	}
+	if status := c.Query("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+	if v := c.Query("from"); v != "" {
+		from, err := time.ParseInLocation("2006-01-02", v, time.Local)
+		if err != nil {
+			return nil, err
+		}
+		query = query.Where("date >= ?", from)
+	}
+	if v := c.Query("to"); v != "" {
+		to, err := time.ParseInLocation("2006-01-02", v, time.Local)
+		if err != nil {
+			return nil, err
+		}
+		query = query.Where("date < ?", to.AddDate(0, 0, 1))
+	}
+	return query, nil
+}
+
+// ListViolations lists violations of employees in the caller's departments
+func ListViolations(c *fiber.Ctx) error {
+	query, err := violationQuery(c)
+	if err != nil {
+		return c.Status(400).JSON(types.APIResponse{
+			Success: false,
+			Error:   "Invalid date format. Use YYYY-MM-DD",
+		})
+	}
+
+	scope, _, err := permissionScope(c, "violation_management")
+	if err != nil {
+		utils.Logger.Error("Failed to resolve permissions", zap.Error(err))
+		return c.Status(500).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrDatabaseError,
+		})
+	}
+	if !scope.All {
+		query = query.Where("user_id IN (?)", scope.restrict(DB.Model(&models.User{}).Select("id"), "department"))
+	}
+
+	var violations []models.Violation
+	if err := query.Find(&violations).Error; err != nil {
+		utils.Logger.Error("Failed to fetch violations", zap.Error(err))
+		return c.Status(500).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrDatabaseError,
+		})
+	}
+
+	return c.JSON(types.APIResponse{
+		Success: true,
+		Data:    violations,
+	})
+}
+
+// GetMyViolations lists the authenticated user's violations
+func GetMyViolations(c *fiber.Ctx) error {
+	userID, ok := c.Locals("user_id").(string)
+	if !ok || userID == "" {
+		return c.Status(401).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrUnauthorized,
+		})
+	}
+
+	query, err := violationQuery(c)
+	if err != nil {
+		return c.Status(400).JSON(types.APIResponse{
+			Success: false,
+			Error:   "Invalid date format. Use YYYY-MM-DD",
+		})
+	}
+
+	var violations []models.Violation
+	if err := query.Where("user_id = ?", userID).Find(&violations).Error; err != nil {
+		utils.Logger.Error("Failed to fetch violations", zap.Error(err))
+		return c.Status(500).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrDatabaseError,
+		})
+	}
+
+	return c.JSON(types.APIResponse{
+		Success: true,
+		Data:    violations,
+	})
+}
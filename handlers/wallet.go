@@ -0,0 +1,200 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"dapp_timekeeping/auth"
+	"dapp_timekeeping/config"
+	"dapp_timekeeping/models"
+	"dapp_timekeeping/types"
+	"dapp_timekeeping/utils"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+type WalletChallengeRequest struct {
+	Address string `json:"address" validate:"required"`
+}
+
+type WalletLoginRequest struct {
+	Address   string `json:"address" validate:"required"`
+	Nonce     string `json:"nonce" validate:"required"`
+	Signature string `json:"signature" validate:"required"` // Hex personal_sign signature of the challenge message
+}
+
+type WalletChallengeResponse struct {
+	Nonce     string    `json:"nonce"`
+	Message   string    `json:"message"` // Sign exactly this text with personal_sign
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+func walletChallengeMessage(address, nonce string, expiresAt time.Time) string {
+	return fmt.Sprintf("Sign in to DApp Timekeeping\n\nAddress: %s\nNonce: %s\nExpires: %s",
+		address, nonce, expiresAt.UTC().Format(time.RFC3339))
+}
+
+// IssueWalletChallenge returns a message for the wallet to sign. Challenges
+// are issued for any address so that they do not reveal which addresses
+// belong to employees.
+func IssueWalletChallenge(c *fiber.Ctx) error {
+	var req WalletChallengeRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrInvalidInput,
+		})
+	}
+	address, ok := auth.NormalizeAddress(req.Address)
+	if !ok {
+		return c.Status(400).JSON(types.APIResponse{
+			Success: false,
+			Error:   "Invalid wallet address",
+		})
+	}
+
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		utils.Logger.Error("Failed to generate nonce", zap.Error(err))
+		return c.Status(500).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrInternalError,
+		})
+	}
+
+	now := time.Now()
+	nonce := hex.EncodeToString(b)
+	expiresAt := now.Add(time.Duration(config.AppConfig.WalletChallengeTTL * float64(time.Minute)))
+	challenge := models.WalletChallenge{
+		ID:        uuid.New().String(),
+		Address:   address,
+		Nonce:     nonce,
+		Message:   walletChallengeMessage(address, nonce, expiresAt),
+		ExpiresAt: expiresAt,
+		CreatedAt: now,
+	}
+
+	// Expired challenges can never be used again
+	if err := DB.Where("expires_at <= ?", now).Delete(&models.WalletChallenge{}).Error; err != nil {
+		utils.Logger.Error("Failed to prune wallet challenges", zap.Error(err))
+	}
+	if err := DB.Create(&challenge).Error; err != nil {
+		utils.Logger.Error("Failed to save wallet challenge", zap.Error(err))
+		return c.Status(500).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrDatabaseError,
+		})
+	}
+
+	return c.JSON(types.APIResponse{
+		Success: true,
+		Data: WalletChallengeResponse{
+			Nonce:     challenge.Nonce,
+			Message:   challenge.Message,
+			ExpiresAt: challenge.ExpiresAt,
+		},
+	})
+}
+
+// LoginWithWallet signs in the employee whose WalletAddress signed the
+// challenge
+func LoginWithWallet(c *fiber.Ctx) error {
+	var req WalletLoginRequest
+	if err := c.BodyParser(&req); err != nil || req.Nonce == "" || req.Signature == "" {
+		return c.Status(400).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrInvalidInput,
+		})
+	}
+	address, ok := auth.NormalizeAddress(req.Address)
+	if !ok {
+		return c.Status(400).JSON(types.APIResponse{
+			Success: false,
+			Error:   "Invalid wallet address",
+		})
+	}
+
+	invalid := func() error {
+		return c.Status(401).JSON(types.APIResponse{
+			Success: false,
+			Error:   "Invalid or expired wallet signature",
+		})
+	}
+
+	now := time.Now()
+	var challenge models.WalletChallenge
+	if err := DB.Where("nonce = ? AND address = ?", req.Nonce, address).Limit(1).Find(&challenge).Error; err != nil {
+		utils.Logger.Error("Failed to fetch wallet challenge", zap.Error(err))
+		return c.Status(500).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrDatabaseError,
+		})
+	}
+	if challenge.ID == "" || challenge.UsedAt != nil || !challenge.ExpiresAt.After(now) {
+		return invalid()
+	}
+
+	signer, err := auth.RecoverAddress(challenge.Message, req.Signature)
+	if err != nil || signer != challenge.Address {
+		return invalid()
+	}
+
+	// Guard on used_at so the challenge cannot be used twice concurrently
+	result := DB.Model(&models.WalletChallenge{}).
+		Where("id = ? AND used_at IS NULL", challenge.ID).
+		Update("used_at", now)
+	if result.Error != nil {
+		utils.Logger.Error("Failed to use wallet challenge", zap.Error(result.Error))
+		return c.Status(500).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrDatabaseError,
+		})
+	}
+	if result.RowsAffected == 0 {
+		return invalid()
+	}
+
+	// Addresses are stored as entered at onboarding, so compare case-insensitively
+	var users []models.User
+	if err := DB.Where("LOWER(wallet_address) = ?", signer).Limit(2).Find(&users).Error; err != nil {
+		utils.Logger.Error("Failed to fetch user", zap.Error(err))
+		return c.Status(500).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrDatabaseError,
+		})
+	}
+	if len(users) != 1 {
+		if len(users) > 1 {
+			utils.Logger.Warn("Wallet address is shared by several users", zap.String("address", signer))
+		}
+		return invalid()
+	}
+	user := users[0]
+	if !canLogIn(user) {
+		return invalid()
+	}
+	if isLocked(user, now) {
+		return c.Status(423).JSON(types.APIResponse{
+			Success: false,
+			Error:   "Account is locked after too many failed logins, try again later",
+		})
+	}
+
+	session, err := issueSession(DB, user, "")
+	if err != nil {
+		utils.Logger.Error("Failed to issue token", zap.Error(err))
+		return c.Status(500).JSON(types.APIResponse{
+			Success: false,
+			Error:   types.ErrInternalError,
+		})
+	}
+
+	return c.JSON(types.APIResponse{
+		Success: true,
+		Data:    session,
+	})
+}
@@ -0,0 +1,154 @@
+// Package lunar converts dates of the Vietnamese lunisolar calendar to the
+// Gregorian calendar, for holidays such as Tết that follow the moon.
+//
+// New moons and solar terms are computed for the UTC+7 meridian following
+// Hồ Ngọc Đức's algorithm, which is why a few years differ from the Chinese
+// calendar computed for UTC+8.
+package lunar
+
+import (
+	"math"
+	"time"
+)
+
+// Vietnam's time zone, in hours east of UTC
+const timeZone = 7.0
+
+// Julian day number of 1970-01-01
+const unixEpochJD = 2440588
+
+func jdFromDate(year int, month time.Month, day int) int {
+	return int(time.Date(year, month, day, 0, 0, 0, 0, time.UTC).Unix()/86400) + unixEpochJD
+}
+
+func dateFromJD(jd int, loc *time.Location) time.Time {
+	return time.Date(1970, 1, 1+jd-unixEpochJD, 0, 0, 0, 0, loc)
+}
+
+// newMoon returns the Julian day of the k-th new moon after 1900-01-01
+func newMoon(k float64) float64 {
+	t := k / 1236.85
+	t2 := t * t
+	t3 := t2 * t
+	dr := math.Pi / 180
+	jd1 := 2415020.75933 + 29.53058868*k + 0.0001178*t2 - 0.000000155*t3
+	jd1 += 0.00033 * math.Sin((166.56+132.87*t-0.009173*t2)*dr)
+	m := 359.2242 + 29.10535608*k - 0.0000333*t2 - 0.00000347*t3
+	mpr := 306.0253 + 385.81691806*k + 0.0107306*t2 + 0.00001236*t3
+	f := 21.2964 + 390.67050646*k - 0.0016528*t2 - 0.00000239*t3
+	c1 := (0.1734-0.000393*t)*math.Sin(m*dr) + 0.0021*math.Sin(2*dr*m)
+	c1 = c1 - 0.4068*math.Sin(mpr*dr) + 0.0161*math.Sin(dr*2*mpr)
+	c1 = c1 - 0.0004*math.Sin(dr*3*mpr)
+	c1 = c1 + 0.0104*math.Sin(dr*2*f) - 0.0051*math.Sin(dr*(m+mpr))
+	c1 = c1 - 0.0074*math.Sin(dr*(m-mpr)) + 0.0004*math.Sin(dr*(2*f+m))
+	c1 = c1 - 0.0004*math.Sin(dr*(2*f-m)) - 0.0006*math.Sin(dr*(2*f+mpr))
+	c1 = c1 + 0.0010*math.Sin(dr*(2*f-mpr)) + 0.0005*math.Sin(dr*(2*mpr+m))
+	var deltaT float64
+	if t < -11 {
+		deltaT = 0.001 + 0.000839*t + 0.0002261*t2 - 0.00000845*t3 - 0.000000081*t*t3
+	} else {
+		deltaT = -0.000278 + 0.000265*t + 0.000262*t2
+	}
+	return jd1 + c1 - deltaT
+}
+
+// sunLongitude returns the sun's longitude in radians at Julian day jdn
+func sunLongitude(jdn float64) float64 {
+	t := (jdn - 2451545.0) / 36525
+	t2 := t * t
+	dr := math.Pi / 180
+	m := 357.52910 + 35999.05030*t - 0.0001559*t2 - 0.00000048*t*t2
+	l0 := 280.46645 + 36000.76983*t + 0.0003032*t2
+	dl := (1.914600 - 0.004817*t - 0.000014*t2) * math.Sin(dr*m)
+	dl += (0.019993-0.000101*t)*math.Sin(dr*2*m) + 0.000290*math.Sin(dr*3*m)
+	l := (l0 + dl) * dr
+	return l - 2*math.Pi*math.Floor(l/(2*math.Pi))
+}
+
+// newMoonDay returns the local day number of the k-th new moon
+func newMoonDay(k int) int {
+	return int(math.Floor(newMoon(float64(k)) + 0.5 + timeZone/24))
+}
+
+// solarTerm returns which of the twelve 30° sectors the sun is in at the
+// start of the local day
+func solarTerm(day int) int {
+	return int(math.Floor(sunLongitude(float64(day)-0.5-timeZone/24) / math.Pi * 6))
+}
+
+// month11 returns the first day of the eleventh lunar month, the one
+// holding the winter solstice, of the given year
+func month11(year int) int {
+	off := jdFromDate(year, 12, 31) - 2415021
+	k := int(math.Floor(float64(off) / 29.530588853))
+	nm := newMoonDay(k)
+	if solarTerm(nm) >= 9 {
+		nm = newMoonDay(k - 1)
+	}
+	return nm
+}
+
+// leapMonthOffset returns the position after month 11 of the first month
+// without a major solar term, which is the leap month
+func leapMonthOffset(a11 int) int {
+	k := int(math.Floor((float64(a11)-2415021.076998695)/29.530588853 + 0.5))
+	i := 1
+	arc := solarTerm(newMoonDay(k + i))
+	for {
+		last := arc
+		i++
+		arc = solarTerm(newMoonDay(k + i))
+		if arc == last || i >= 14 {
+			break
+		}
+	}
+	return i - 1
+}
+
+// ToSolar returns the Gregorian date of a day of the lunar year, in loc.
+// Leap selects the intercalary copy of month; it reports false when the
+// year has no such leap month or the date is out of range.
+func ToSolar(year, month, day int, leap bool, loc *time.Location) (time.Time, bool) {
+	if month < 1 || month > 12 || day < 1 || day > 30 {
+		return time.Time{}, false
+	}
+
+	var a11, b11 int
+	if month < 11 {
+		a11, b11 = month11(year-1), month11(year)
+	} else {
+		a11, b11 = month11(year), month11(year+1)
+	}
+	k := int(math.Floor(0.5 + (float64(a11)-2415021.076998695)/29.530588853))
+	off := month - 11
+	if off < 0 {
+		off += 12
+	}
+	if b11-a11 > 365 {
+		leapOff := leapMonthOffset(a11)
+		leapMonth := leapOff - 2
+		if leapMonth < 0 {
+			leapMonth += 12
+		}
+		if leap && month != leapMonth {
+			return time.Time{}, false
+		}
+		if leap || off >= leapOff {
+			off++
+		}
+	} else if leap {
+		return time.Time{}, false
+	}
+
+	start := newMoonDay(k + off)
+	if day == 30 && newMoonDay(k+off+1)-start < 30 {
+		return time.Time{}, false
+	}
+	return dateFromJD(start+day-1, loc), true
+}
+
+// NewYear returns the first day of the lunar year, Tết Nguyên Đán
+func NewYear(year int, loc *time.Location) time.Time {
+	t, _ := ToSolar(year, 1, 1, false, loc)
+	return t
+}
@@ -1,11 +1,16 @@
 package main
 
 import (
+	"context"
+	"dapp_timekeeping/anchor"
 	"dapp_timekeeping/config"
 	"dapp_timekeeping/handlers"
 	"dapp_timekeeping/middleware"
 	"dapp_timekeeping/models"
+	"dapp_timekeeping/utils"
+	"fmt"
 	"log"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"gorm.io/driver/sqlite"
@@ -22,54 +27,152 @@ func initServices() error {
 		return err
 	}
 
+	// User.Permissions is backed by UserPermission, which carries grant details
+	if err := DB.SetupJoinTable(&models.User{}, "Permissions", &models.UserPermission{}); err != nil {
+		return err
+	}
+
 	// Auto-migrate models
 	DB.AutoMigrate(
 		&models.User{},
 		&models.Permission{},
 		&models.Department{},
 		&models.SalaryApproval{},
+		&models.SalaryLineItem{},
+		&models.PayrollBonus{},
 		&models.Attendance{},
-		&models.LeaveRequest{},
+		&models.Shift{},
+		&models.ShiftBreak{},
+		&models.ShiftAssignment{},
 		&models.Violation{},
-		&models.Report{},
+		&models.ViolationAppeal{},
+		&models.EscalationStep{},
+		&models.DisciplinaryAction{},
+		&models.CalendarDay{},
+		&models.OvertimeRequest{},
 		&models.CompanyRule{},
 		&models.Absence{},
 		&models.UserPermission{},
+		&models.LeaveLedgerEntry{},
 		&models.ReferralCode{},
-		&models.PayrollApproval{},
+		&models.ReferralAttempt{},
+		&models.LoginCode{},
+		&models.Kiosk{},
+		&models.KioskScan{},
+		&models.RefreshToken{},
+		&models.RevokedToken{},
+		&models.WalletChallenge{},
+		&models.LedgerEntry{},
+		&models.AttendanceAnchor{},
+		&models.AttendanceAnchorLeaf{},
+		&models.OutboxEntry{},
 	)
 
+	handlers.InitHandlers(DB)
+
+	client, err := newChainClient()
+	if err != nil {
+		return err
+	}
+	handlers.SetAnchorer(client)
+	handlers.SetSubmitter(client)
+
 	return nil
 }
 
+// newChainClient returns the configured backend for daily attendance roots
+// and outbox events
+func newChainClient() (anchor.Client, error) {
+	switch config.AppConfig.AnchorBackend {
+	case "icp":
+		client, err := anchor.NewICPAnchorer(config.AppConfig.ICPHost, config.AppConfig.CanisterID, config.AppConfig.AnchorMethod)
+		if err != nil {
+			return nil, err
+		}
+		client.EventMethod = config.AppConfig.AnchorEventMethod
+		return client, nil
+	case "local":
+		return anchor.NewLocalAnchorer(config.AppConfig.AnchorFile)
+	}
+	return nil, fmt.Errorf("unknown anchor backend %q", config.AppConfig.AnchorBackend)
+}
+
 func setupRoutes(app *fiber.App) {
 	// Public routes
 	app.Post("/login", handlers.Login)
+	app.Post("/login/code", handlers.LoginWithCode)
+	app.Post("/login/wallet/challenge", handlers.IssueWalletChallenge)
+	app.Post("/login/wallet", handlers.LoginWithWallet)
 	app.Post("/register", handlers.Register)
-	app.Post("/logout", handlers.Logout)
+	app.Post("/referrals/:code/redeem", handlers.RedeemReferralCode)
+	app.Post("/refresh", handlers.RefreshSession)
+	app.Post("/logout", middleware.RequireAuth, handlers.Logout)
 
-	// Root only routes
-	root := app.Group("/root", middleware.RequireRoot)
-	root.Post("/employees", handlers.AddEmployee)
-	root.Post("/rules", handlers.UpdateCompanyRule)
-	root.Get("/reports", handlers.GenerateReports)
+	// HR routes, open to HR managers and delegates within their departments
+	hr := app.Group("/hr")
+	hr.Get("/violations", middleware.RequirePermission("violation_management"), handlers.ListViolations)
+	hr.Post("/violations", middleware.RequirePermission("violation_management"), handlers.RecordViolation)
+	hr.Get("/appeals", middleware.RequirePermission("violation_management"), handlers.ListAppeals)
+	hr.Post("/appeals/:id/accept", middleware.RequirePermission("violation_management"), handlers.AcceptAppeal)
+	hr.Post("/appeals/:id/deny", middleware.RequirePermission("violation_management"), handlers.DenyAppeal)
+	hr.Get("/disciplinary-actions", middleware.RequirePermission("violation_management"), handlers.ListDisciplinaryActions)
+	hr.Post("/disciplinary-actions/:id/resolve", middleware.RequirePermission("violation_management"), handlers.ResolveDisciplinaryAction)
+	hr.Get("/overtime-requests", middleware.RequirePermission("overtime_approval"), handlers.ListOvertimeRequests)
+	hr.Post("/overtime-requests/:id/approve", middleware.RequirePermission("overtime_approval"), handlers.ApproveOvertime)
+	hr.Post("/overtime-requests/:id/reject", middleware.RequirePermission("overtime_approval"), handlers.RejectOvertime)
+	hr.Get("/employees/:id/overtime", middleware.RequirePermission("overtime_approval"), handlers.GetEmployeeOvertime)
+	hr.Get("/absences/unprocessed", middleware.RequirePermission("attendance_approval"), handlers.GetUnprocessedAbsences)
+	hr.Post("/absences/:id/process", middleware.RequirePermission("attendance_approval"), handlers.ProcessAbsence)
+	hr.Get("/leaves/pending", middleware.RequirePermission("leave_approval"), handlers.GetPendingLeaves)
+	hr.Post("/leaves/:id/approve", middleware.RequirePermission("leave_approval"), handlers.ApproveLeave)
+	hr.Post("/leaves/:id/reject", middleware.RequirePermission("leave_approval"), handlers.RejectLeave)
+	hr.Get("/employees", middleware.RequirePermission("employee_management"), handlers.GetAllEmployees)
+	hr.Patch("/employees/:id", middleware.RequirePermission("employee_management"), handlers.UpdateEmployee)
+	hr.Put("/employees/:id/salary", middleware.RequirePermission("salary_management"), handlers.UpdateSalary)
 
-	// HR routes
-	hr := app.Group("/hr", middleware.RequireHR)
-	hr.Post("/attendance", handlers.RecordAttendance)
-	hr.Post("/violations", handlers.RecordViolation)
-	hr.Get("/leave-requests", handlers.GetLeaveRequests)
+	// Kiosk routes, authenticated with the token issued when the kiosk is registered
+	kiosk := app.Group("/kiosk", middleware.RequireKiosk)
+	kiosk.Get("/code", handlers.GetKioskCode)
 
 	// Employee routes
 	emp := app.Group("/employee", middleware.RequireAuth)
 	emp.Post("/check-in", handlers.CheckIn)
+	emp.Post("/check-in/kiosk", handlers.KioskCheckIn)
 	emp.Post("/check-out", handlers.CheckOut)
+	emp.Get("/attendance/:id/proof", handlers.GetAttendanceProof)
+	emp.Get("/schedule", handlers.GetMySchedule)
 	emp.Post("/leave-request", handlers.RequestLeave)
-	emp.Get("/salary", handlers.GetSalaryInfo)
+	emp.Get("/leave-requests", handlers.GetMyLeaveRequests)
+	emp.Delete("/leave-requests/:id", handlers.CancelLeaveRequest)
+	emp.Get("/leave-balance", handlers.GetLeaveBalance)
+	emp.Get("/leave-ledger", handlers.GetLeaveLedger)
+	emp.Get("/payslips", handlers.GetMyPayslips)
+	emp.Get("/payslips/:month", handlers.GetMyPayslip)
+	emp.Get("/violations", handlers.GetMyViolations)
+	emp.Post("/violations/:id/appeal", handlers.AppealViolation)
+	emp.Get("/overtime", handlers.GetMyOvertime)
+	emp.Get("/calendar", handlers.GetMyCalendar)
+	emp.Get("/overtime-requests", handlers.GetMyOvertimeRequests)
+	emp.Post("/overtime-requests", handlers.RequestOvertime)
+	emp.Delete("/overtime-requests/:id", handlers.CancelOvertimeRequest)
+	emp.Put("/password", handlers.ChangePassword)
 }
 
 func setupRootRoutes(app *fiber.App) {
 	root := app.Group("/root", middleware.RequireRoot)
+	root.Get("/rules", handlers.ListCompanyRules)
+	root.Get("/rules/current", handlers.GetCompanyRule)
+	root.Post("/rules", handlers.UpdateCompanyRule)
+	root.Post("/violations/detect", handlers.DetectViolations)
+	root.Get("/escalation-steps", handlers.ListEscalationSteps)
+	root.Post("/escalation-steps", handlers.CreateEscalationStep)
+	root.Delete("/escalation-steps/:id", handlers.DeleteEscalationStep)
+	root.Get("/calendar", handlers.ListCalendar)
+	root.Post("/calendar", handlers.CreateCalendarDay)
+	root.Post("/calendar/defaults", handlers.LoadDefaultHolidays)
+	root.Post("/calendar/import", handlers.ImportCalendar)
+	root.Delete("/calendar/:id", handlers.DeleteCalendarDay)
+	root.Get("/reports", handlers.GenerateReports)
 
 	// // Dashboard Statistics
 	// root.Get("/dashboard", handlers.GetDashboardStats)
@@ -88,42 +191,90 @@ func setupRootRoutes(app *fiber.App) {
 	attendance := root.Group("/attendance")
 	// attendance.Get("/late", handlers.GetLateEmployees)
 	attendance.Get("/absences", handlers.GetAbsences)
-	// attendance.Get("/unprocessed", handlers.GetUnprocessedAbsences)
-	// attendance.Post("/process/:id", handlers.ProcessAbsence)
+	attendance.Get("/unprocessed", handlers.GetUnprocessedAbsences)
+	attendance.Post("/process/:id", handlers.ProcessAbsence)
+	attendance.Get("/:id/proof", handlers.GetAttendanceProof)
 	// attendance.Get("/department/:id", handlers.GetDepartmentAttendance)
 
-	// // Leave Management
-	// leaves := root.Group("/leaves")
-	// leaves.Get("/pending", handlers.GetPendingLeaves)
+	// Ledger of attendance and absence writes
+	ledger := root.Group("/ledger")
+	ledger.Get("/", handlers.GetLedgerEntries)
+	ledger.Get("/verify", handlers.VerifyLedger)
+
+	// Daily attendance roots anchored on chain
+	anchors := root.Group("/anchors")
+	anchors.Get("/", handlers.ListAnchors)
+	anchors.Post("/run", handlers.RunAnchoring)
+
+	// Events waiting for delivery to the chain
+	outbox := root.Group("/outbox")
+	outbox.Get("/", handlers.ListOutbox)
+	outbox.Post("/deliver", handlers.DeliverOutbox)
+	outbox.Post("/replay", handlers.ReplayFailedOutbox)
+	outbox.Get("/:id", handlers.GetOutboxEntry)
+	outbox.Post("/:id/replay", handlers.ReplayOutboxEntry)
+
+	// Shift Management
+	shifts := root.Group("/shifts")
+	shifts.Get("/", handlers.ListShifts)
+	shifts.Post("/", handlers.CreateShift)
+	shifts.Get("/assignments", handlers.ListShiftAssignments)
+	shifts.Post("/assignments", handlers.AssignShift)
+
+	// Leave Management
+	leaves := root.Group("/leaves")
+	leaves.Get("/pending", handlers.GetPendingLeaves)
 	// leaves.Get("/approved", handlers.GetApprovedLeaves)
-	// leaves.Post("/:id/approve", handlers.ApproveLeave)
-	// leaves.Post("/:id/reject", handlers.RejectLeave)
+	leaves.Post("/:id/approve", handlers.ApproveLeave)
+	leaves.Post("/:id/reject", handlers.RejectLeave)
+	leaves.Get("/balance/:id", handlers.GetLeaveBalance)
+	leaves.Get("/ledger/:id", handlers.GetLeaveLedger)
 
 	// Employee Management
 	employees := root.Group("/employees")
 	employees.Get("/", handlers.GetAllEmployees)
 	employees.Post("/", handlers.AddEmployee)
 	employees.Patch("/:id", handlers.UpdateEmployee)
-	employees.Delete("/:id", handlers.DeleteEmployee)
 	employees.Put("/:id/salary", handlers.UpdateSalary)
+	employees.Post("/:id/logout-everywhere", handlers.LogoutEverywhere)
 
-	// // Permission Management
-	// permissions := root.Group("/permissions")
-	// permissions.Post("/grant", handlers.GrantPermission)
-	// permissions.Delete("/revoke", handlers.RevokePermission)
-	// permissions.Get("/user/:id", handlers.GetUserPermissions)
+	// Permission Management
+	permissions := root.Group("/permissions")
+	permissions.Get("/", handlers.ListPermissions)
+	permissions.Post("/grant", handlers.GrantPermission)
+	permissions.Delete("/revoke", handlers.RevokePermission)
+	permissions.Get("/user/:id", handlers.GetUserPermissions)
 
-	// // Referral Management
-	// referrals := root.Group("/referrals")
-	// referrals.Post("/generate", handlers.GenerateReferralCode)
-	// referrals.Get("/", handlers.ListReferralCodes)
-	// referrals.Delete("/:code", handlers.DeleteReferralCode)
+	// Kiosks
+	kiosks := root.Group("/kiosks")
+	kiosks.Post("/", handlers.CreateKiosk)
+	kiosks.Get("/", handlers.ListKiosks)
+	kiosks.Delete("/:id", handlers.DeactivateKiosk)
 
-	// // Payroll Management
-	// payroll := root.Group("/payroll")
-	// payroll.Get("/pending", handlers.GetPendingPayroll)
-	// payroll.Post("/approve", handlers.ApprovePayroll)
-	// payroll.Get("/history", handlers.GetPayrollHistory)
+	// Login Codes
+	loginCodes := root.Group("/login-codes")
+	loginCodes.Post("/", handlers.IssueLoginCode)
+	loginCodes.Get("/", handlers.ListLoginCodes)
+	loginCodes.Delete("/:id", handlers.RevokeLoginCode)
+
+	// Referral Management
+	referrals := root.Group("/referrals")
+	referrals.Post("/generate", handlers.GenerateReferralCode)
+	referrals.Get("/", handlers.ListReferralCodes)
+	referrals.Delete("/:code", handlers.DeleteReferralCode)
+
+	// Payroll Management
+	payroll := root.Group("/payroll")
+	payroll.Post("/run", handlers.RunPayroll)
+	payroll.Get("/pending", handlers.GetPendingSalaryApprovals)
+	payroll.Post("/approve", handlers.ApproveSalary)
+	payroll.Post("/reject", handlers.RejectSalary)
+	payroll.Get("/history", handlers.GetPayrollHistory)
+	payroll.Get("/bonuses", handlers.ListPayrollBonuses)
+	payroll.Post("/bonuses", handlers.CreatePayrollBonus)
+	payroll.Delete("/bonuses/:id", handlers.DeletePayrollBonus)
+	payroll.Get("/statutory-rates", handlers.ListStatutoryRates)
+	payroll.Get("/payslips/:month", handlers.ExportPayslips)
 
 	// // Reports
 	// reports := root.Group("/reports")
@@ -136,13 +287,24 @@ func setupRootRoutes(app *fiber.App) {
 func main() {
 	// Load configuration
 	config.LoadConfig()
+	utils.InitLogger()
 
 	if err := initServices(); err != nil {
 		log.Fatal("Failed to initialize services:", err)
 	}
 
+	if interval := config.AppConfig.AnchorInterval; interval > 0 {
+		go handlers.RunAnchorJob(context.Background(), time.Duration(interval*float64(time.Minute)))
+	}
+	if interval := config.AppConfig.ViolationInterval; interval > 0 {
+		go handlers.RunViolationJob(context.Background(), time.Duration(interval*float64(time.Hour)))
+	}
+	if interval := config.AppConfig.OutboxInterval; interval > 0 {
+		go handlers.RunOutboxWorker(context.Background(), time.Duration(interval*float64(time.Second)))
+	}
+
 	app := fiber.New()
-	// setupRoutes(app)
+	setupRoutes(app)
 	setupRootRoutes(app)
 	log.Fatal(app.Listen(":" + config.AppConfig.Port))
 }
@@ -3,10 +3,12 @@ package middleware
 import (
 	"strings"
 
-	"dapp_timekeeping/config"
+	"dapp_timekeeping/auth"
+	"dapp_timekeeping/handlers"
+	"dapp_timekeeping/utils"
 
 	"github.com/gofiber/fiber/v2"
-	"github.com/golang-jwt/jwt/v5"
+	"go.uber.org/zap"
 )
 
 func extractToken(c *fiber.Ctx) (string, error) {
@@ -23,32 +25,50 @@ func extractToken(c *fiber.Ctx) (string, error) {
 	return parts[1], nil
 }
 
-func RequireAuth(c *fiber.Ctx) error {
+// authenticate verifies the bearer token and stores its claims in the context
+func authenticate(c *fiber.Ctx) error {
 	token, err := extractToken(c)
 	if err != nil {
 		return err
 	}
 
-	claims := jwt.MapClaims{}
-	_, err = jwt.ParseWithClaims(token, claims, func(t *jwt.Token) (interface{}, error) {
-		return []byte(config.AppConfig.JWTSecret), nil
-	})
+	claims, err := auth.ParseToken(token)
+	if err != nil {
+		return fiber.NewError(fiber.StatusUnauthorized, "Invalid or expired token")
+	}
 
+	revoked, err := handlers.TokenRevoked(claims.ID)
 	if err != nil {
-		return c.Status(401).JSON(fiber.Map{
-			"error": "Invalid or expired token",
-		})
+		utils.Logger.Error("Failed to check token revocation", zap.Error(err))
+		return fiber.NewError(fiber.StatusInternalServerError, "Failed to verify token")
+	}
+	if revoked {
+		return fiber.NewError(fiber.StatusUnauthorized, "Token has been revoked")
 	}
 
 	// Add claims to context for use in handlers
-	c.Locals("user_id", claims["user_id"])
-	c.Locals("role", claims["role"])
+	auth.SetClaims(c, claims)
+
+	return nil
+}
+
+func RequireAuth(c *fiber.Ctx) error {
+	if err := authenticate(c); err != nil {
+		return err
+	}
+
+	// Kiosk tokens identify a device, not an employee
+	if c.Locals("role") == "kiosk" {
+		return c.Status(403).JSON(fiber.Map{
+			"error": "Employee access required",
+		})
+	}
 
 	return c.Next()
 }
 
 func RequireRoot(c *fiber.Ctx) error {
-	if err := RequireAuth(c); err != nil {
+	if err := authenticate(c); err != nil {
 		return err
 	}
 
@@ -62,11 +82,11 @@ func RequireRoot(c *fiber.Ctx) error {
 }
 
 func RequireHR(c *fiber.Ctx) error {
-	if err := RequireAuth(c); err != nil {
+	if err := authenticate(c); err != nil {
 		return err
 	}
 
-	role := c.Locals("role").(string)
+	role, _ := c.Locals("role").(string)
 	if role != "hr_manager" && role != "root" {
 		return c.Status(403).JSON(fiber.Map{
 			"error": "HR access required",
@@ -75,3 +95,17 @@ func RequireHR(c *fiber.Ctx) error {
 
 	return c.Next()
 }
+
+func RequireKiosk(c *fiber.Ctx) error {
+	if err := authenticate(c); err != nil {
+		return err
+	}
+
+	if c.Locals("role") != "kiosk" {
+		return c.Status(403).JSON(fiber.Map{
+			"error": "Kiosk access required",
+		})
+	}
+
+	return c.Next()
+}
@@ -0,0 +1,37 @@
+package middleware
+
+import (
+	"dapp_timekeeping/handlers"
+	"dapp_timekeeping/utils"
+
+	"github.com/gofiber/fiber/v2"
+	"go.uber.org/zap"
+)
+
+// RequirePermission allows users who hold the named permission, through their
+// role or a delegated grant, in at least one department. Handlers narrow the
+// request to the departments the permission covers.
+func RequirePermission(name string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if err := authenticate(c); err != nil {
+			return err
+		}
+
+		userID, _ := c.Locals("user_id").(string)
+		permissions, err := handlers.ResolvePermissions(handlers.DB, userID)
+		if err != nil {
+			utils.Logger.Error("Failed to resolve permissions", zap.Error(err))
+			return fiber.NewError(fiber.StatusInternalServerError, "Failed to resolve permissions")
+		}
+
+		scope, ok := permissions[name]
+		if !ok || (!scope.All && len(scope.Departments) == 0) {
+			return c.Status(403).JSON(fiber.Map{
+				"error": "Permission required: " + name,
+			})
+		}
+
+		c.Locals("permissions", permissions)
+		return c.Next()
+	}
+}
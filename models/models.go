@@ -4,7 +4,6 @@ import (
 	"errors"
 	"time"
 
-	"github.com/google/uuid"
 	"gorm.io/gorm"
 )
 
@@ -29,6 +28,10 @@ type User struct {
 	WalletAddress      string       `gorm:"type:text;default:''" json:"wallet_address"`
 	Salary             float64      `gorm:"default:0" json:"salary"`
 	Status             string       `gorm:"type:text;not null;default:'active'" json:"status"`
+	PasswordHash       string       `gorm:"type:text;default:''" json:"-"`
+	PasswordChangedAt  *time.Time   `json:"-"`
+	FailedLogins       int          `gorm:"default:0" json:"-"`
+	LockedUntil        *time.Time   `json:"-"`
 	Permissions        []Permission `gorm:"many2many:user_permissions;constraint:OnUpdate:CASCADE,OnDelete:CASCADE" json:"permissions"`
 	CreatedAt          time.Time    `gorm:"not null" json:"created_at"`
 	UpdatedAt          time.Time    `gorm:"not null" json:"updated_at"`
@@ -51,31 +54,62 @@ type Department struct {
 
 // For tracking delegated permissions
 type PermissionGrant struct {
-	ID           string    `gorm:"type:text;primary_key" json:"id"`
-	GrantedBy    string    `gorm:"type:text" json:"granted_by"`
-	GrantedTo    string    `gorm:"type:text" json:"granted_to"`
-	PermissionID uint      `json:"permission_id"`
-	ExpiresAt    time.Time `json:"expires_at"`
-	CreatedAt    time.Time
-	UpdatedAt    time.Time
+	ID           string     `gorm:"type:text;primary_key" json:"id"`
+	GrantedBy    string     `gorm:"type:text;not null" json:"granted_by"`
+	GrantedTo    string     `gorm:"type:text;not null;index" json:"granted_to"`
+	PermissionID string     `gorm:"type:text;not null" json:"permission_id"`
+	Permission   Permission `gorm:"foreignKey:PermissionID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE" json:"-"`
+	Departments  string     `gorm:"type:text;default:''" json:"departments"` // Comma separated, empty for every department
+	ExpiresAt    *time.Time `json:"expires_at"`                              // Nil for grants that do not expire
+	RevokedBy    *string    `gorm:"type:text" json:"revoked_by"`
+	RevokedAt    *time.Time `json:"revoked_at"`
+	CreatedAt    time.Time  `json:"created_at"`
+	UpdatedAt    time.Time  `json:"updated_at"`
 }
 
 // For salary approval workflow
 type SalaryApproval struct {
-	ID          string    `gorm:"type:text;primary_key" json:"id"`
-	UserID      string    `gorm:"type:text;not null" json:"user_id"`
-	User        User      `gorm:"foreignKey:UserID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE"`
-	ApprovedBy  string    `gorm:"type:text" json:"approved_by"`
-	Approver    User      `gorm:"foreignKey:ApprovedBy;constraint:OnUpdate:CASCADE,OnDelete:SET NULL"`
-	Month       time.Time `gorm:"not null" json:"month"`
-	BaseSalary  float64   `json:"base_salary"`
-	Deductions  float64   `json:"deductions"`
-	Bonus       float64   `json:"bonus"`
-	FinalSalary float64   `json:"final_salary"`
-	Status      string    `gorm:"not null;default:'pending'" json:"status"` // pending, approved, rejected
-	ApprovedAt  time.Time `json:"approved_at"`
-	CreatedAt   time.Time `gorm:"not null" json:"created_at"`
-	UpdatedAt   time.Time `gorm:"not null" json:"updated_at"`
+	ID          string           `gorm:"type:text;primary_key" json:"id"`
+	UserID      string           `gorm:"type:text;not null;uniqueIndex:idx_salary_user_month" json:"user_id"`
+	User        User             `gorm:"foreignKey:UserID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE" json:"-"`
+	ApprovedBy  *string          `gorm:"type:text" json:"approved_by"`
+	Approver    User             `gorm:"foreignKey:ApprovedBy;constraint:OnUpdate:CASCADE,OnDelete:SET NULL" json:"-"`
+	Month       time.Time        `gorm:"not null;uniqueIndex:idx_salary_user_month" json:"month"` // First day of the pay month
+	BaseSalary  float64          `json:"base_salary"`
+	Deductions  float64          `json:"deductions"`
+	Bonus       float64          `json:"bonus"`
+	FinalSalary float64          `json:"final_salary"`
+	Status      string           `gorm:"not null;default:'pending'" json:"status"` // pending, approved, rejected
+	ApprovedAt  *time.Time       `json:"approved_at"`
+	LineItems   []SalaryLineItem `gorm:"foreignKey:SalaryApprovalID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE" json:"line_items,omitempty"`
+	CreatedAt   time.Time        `gorm:"not null" json:"created_at"`
+	UpdatedAt   time.Time        `gorm:"not null" json:"updated_at"`
+}
+
+// SalaryLineItem is one line of a payroll calculation. Employer items record
+// contributions paid on top of the salary and do not affect FinalSalary.
+type SalaryLineItem struct {
+	ID               string  `gorm:"type:text;primary_key" json:"id"`
+	SalaryApprovalID string  `gorm:"type:text;not null;index" json:"salary_approval_id"`
+	Kind             string  `gorm:"type:text;not null;check:kind IN ('earning','deduction','bonus','employer')" json:"kind"`
+	Code             string  `gorm:"type:text;not null" json:"code"` // base_salary, unpaid_absence, late_penalty, pit, bhxh, ...
+	Description      string  `gorm:"type:text;default:''" json:"description"`
+	Quantity         float64 `json:"quantity"`
+	Unit             string  `gorm:"type:text;default:'';check:unit IN ('','day','hour','incident','vnd')" json:"unit"` // Empty when the quantity carries no meaning
+	Amount           float64 `gorm:"not null" json:"amount"`
+}
+
+// PayrollBonus is a configured bonus added by the payroll run. Empty UserID and
+// Department apply to everyone; a nil Month recurs every month.
+type PayrollBonus struct {
+	ID         string     `gorm:"type:text;primary_key" json:"id"`
+	Name       string     `gorm:"type:text;not null" json:"name"`
+	Amount     float64    `gorm:"not null" json:"amount"`
+	UserID     *string    `gorm:"type:text;index" json:"user_id,omitempty"`
+	Department string     `gorm:"type:text;default:''" json:"department,omitempty"`
+	Month      *time.Time `json:"month,omitempty"` // First day of the month it applies to
+	CreatedAt  time.Time  `gorm:"not null" json:"created_at"`
+	UpdatedAt  time.Time  `gorm:"not null" json:"updated_at"`
 }
 
 type Attendance struct {
@@ -84,12 +118,65 @@ type Attendance struct {
 	CheckInTime  time.Time `json:"check_in_time"`  // Will be NULL by default
 	CheckOutTime time.Time `json:"check_out_time"` // Will be NULL by default
 	ExpectedTime time.Time `json:"expected_time" gorm:"not null"`
-	OnTime       bool      `json:"on_time" gorm:"default:true"`
+	OnTime       bool      `json:"on_time" gorm:"not null"`
+	Method       string    `json:"method" gorm:"type:text;not null;default:'app';check:method IN ('app','kiosk')"` // How the check-in was made
 	CreatedAt    time.Time `json:"created_at" gorm:"not null"`
 	UpdatedAt    time.Time `json:"updated_at" gorm:"not null"`
 	User         User      `json:"-" gorm:"foreignKey:UserID"`
 }
 
+// Shift is a named working pattern, e.g. morning, evening or weekend.
+// Times are HH:MM in local time; an EndTime before StartTime ends the next day.
+type Shift struct {
+	ID        string       `gorm:"type:text;primary_key" json:"id"`
+	Name      string       `gorm:"type:text;unique;not null" json:"name"`
+	StartTime string       `gorm:"type:text;not null" json:"start_time"`
+	EndTime   string       `gorm:"type:text;not null" json:"end_time"`
+	WorkDays  string       `gorm:"type:text;not null;default:'1,2,3,4,5'" json:"work_days"` // comma separated weekdays, 0 = Sunday
+	Breaks    []ShiftBreak `gorm:"foreignKey:ShiftID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE" json:"breaks"`
+	CreatedAt time.Time    `gorm:"not null" json:"created_at"`
+	UpdatedAt time.Time    `gorm:"not null" json:"updated_at"`
+}
+
+type ShiftBreak struct {
+	ID        string `gorm:"type:text;primary_key" json:"id"`
+	ShiftID   string `gorm:"type:text;not null;index" json:"shift_id"`
+	StartTime string `gorm:"type:text;not null" json:"start_time"`
+	EndTime   string `gorm:"type:text;not null" json:"end_time"`
+}
+
+// ShiftAssignment puts a user, or everyone in a department, on a shift.
+// Department matches User.Department. EffectiveTo nil means open-ended.
+type ShiftAssignment struct {
+	ID            string     `gorm:"type:text;primary_key" json:"id"`
+	ShiftID       string     `gorm:"type:text;not null;index" json:"shift_id"`
+	Shift         Shift      `gorm:"foreignKey:ShiftID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE" json:"shift"`
+	UserID        *string    `gorm:"type:text;index" json:"user_id,omitempty"`
+	Department    string     `gorm:"type:text;default:''" json:"department,omitempty"`
+	EffectiveFrom time.Time  `gorm:"not null" json:"effective_from"`
+	EffectiveTo   *time.Time `json:"effective_to,omitempty"`
+	CreatedAt     time.Time  `gorm:"not null" json:"created_at"`
+	UpdatedAt     time.Time  `gorm:"not null" json:"updated_at"`
+}
+
+// BeforeSave hook to validate the assignment target and dates
+func (a *ShiftAssignment) BeforeSave(tx *gorm.DB) error {
+	if a.ShiftID == "" {
+		return errors.New("shift ID is required")
+	}
+	hasUser := a.UserID != nil && *a.UserID != ""
+	if hasUser == (a.Department != "") {
+		return errors.New("exactly one of user_id or department is required")
+	}
+	if a.EffectiveFrom.IsZero() {
+		return errors.New("effective_from is required")
+	}
+	if a.EffectiveTo != nil && a.EffectiveTo.Before(a.EffectiveFrom) {
+		return errors.New("effective_to must not be before effective_from")
+	}
+	return nil
+}
+
 type Absence struct {
 	ID          string     `gorm:"type:text;primary_key" json:"id"`
 	UserID      string     `gorm:"type:text;references:users(id);not null" json:"user_id"`
@@ -140,14 +227,321 @@ func (a *Absence) BeforeSave(tx *gorm.DB) error {
 	return nil
 }
 
+// LeaveLedgerEntry is an append-only movement of annual leave days.
+// A user's balance is the sum of Days over their entries.
+type LeaveLedgerEntry struct {
+	ID            string    `gorm:"type:text;primary_key" json:"id"`
+	UserID        string    `gorm:"type:text;not null;uniqueIndex:idx_leave_ledger_ref" json:"user_id"`
+	Kind          string    `gorm:"type:text;not null;uniqueIndex:idx_leave_ledger_ref;check:kind IN ('accrual','expiry','debit','adjustment')" json:"kind"`
+	Reference     string    `gorm:"type:text;not null;uniqueIndex:idx_leave_ledger_ref" json:"reference"` // accrual month, expiry year or absence ID
+	Days          float64   `gorm:"not null" json:"days"`
+	EffectiveDate time.Time `gorm:"not null;index" json:"effective_date"`
+	Note          string    `gorm:"type:text;default:''" json:"note"`
+	CreatedAt     time.Time `gorm:"not null" json:"created_at"`
+}
+
+// UserPermission is a permission currently held by a user, the join table
+// behind User.Permissions. It lapses at ExpiresAt.
 type UserPermission struct {
-	ID           string `gorm:"type:text;primary_key" json:"id"`
-	UserID       string `gorm:"type:text;primary_key" json:"user_id"`
-	User         User   `gorm:"foreignKey:UserID"`
-	PermissionID uint
-	GrantedBy    uuid.UUID `gorm:"type:uuid" json:"granted_by"`
-	Granter      User      `gorm:"foreignKey:GrantedBy"`
-	ExpiresAt    time.Time
-	CreatedAt    time.Time
-	UpdatedAt    time.Time
+	UserID       string     `gorm:"type:text;primaryKey" json:"user_id"`
+	User         User       `gorm:"foreignKey:UserID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE" json:"-"`
+	PermissionID string     `gorm:"type:text;primaryKey" json:"permission_id"`
+	Permission   Permission `gorm:"foreignKey:PermissionID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE" json:"-"`
+	GrantedBy    string     `gorm:"type:text;not null" json:"granted_by"`
+	Granter      User       `gorm:"foreignKey:GrantedBy" json:"-"`
+	Departments  string     `gorm:"type:text;default:''" json:"departments"` // Comma separated, empty for every department
+	ExpiresAt    *time.Time `gorm:"index" json:"expires_at"`                 // Nil for permissions that do not expire
+	CreatedAt    time.Time  `json:"created_at"`
+	UpdatedAt    time.Time  `json:"updated_at"`
+}
+
+// ReferralCode lets a new hire complete the profile of their pending employee
+// record. A code can be redeemed once, before ExpiresAt.
+type ReferralCode struct {
+	Code      string     `gorm:"type:text;primaryKey" json:"code"`
+	UserID    string     `gorm:"type:text;not null;index" json:"user_id"`
+	User      User       `gorm:"foreignKey:UserID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE" json:"-"`
+	CreatedBy string     `gorm:"type:text;not null" json:"created_by"`
+	ExpiresAt time.Time  `gorm:"not null" json:"expires_at"`
+	UsedAt    *time.Time `json:"used_at"`
+	CreatedAt time.Time  `gorm:"not null" json:"created_at"`
+}
+
+// ReferralAttempt counts failed referral code redemptions from a client
+// address. Like an account after failed logins, the address is locked out
+// once MaxFailedLogins is reached.
+type ReferralAttempt struct {
+	ClientIP    string     `gorm:"type:text;primaryKey" json:"client_ip"`
+	Failed      int        `gorm:"not null;default:0" json:"failed"`
+	LockedUntil *time.Time `json:"locked_until"`
+	UpdatedAt   time.Time  `gorm:"not null" json:"updated_at"`
+}
+
+// LoginCode is a one-time code that signs a user in without a password, for
+// example on a shared attendance device. Only a keyed hash of the code is
+// stored.
+type LoginCode struct {
+	ID        string     `gorm:"type:text;primaryKey" json:"id"`
+	UserID    string     `gorm:"type:text;not null;index" json:"user_id"`
+	User      User       `gorm:"foreignKey:UserID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE" json:"-"`
+	Device    string     `gorm:"type:text;default:''" json:"device"` // Optional label of the device the code is for
+	CodeHash  string     `gorm:"type:text;not null;uniqueIndex" json:"-"`
+	CreatedBy string     `gorm:"type:text;not null" json:"created_by"`
+	ExpiresAt time.Time  `gorm:"not null" json:"expires_at"`
+	UsedAt    *time.Time `json:"used_at"`
+	CreatedAt time.Time  `gorm:"not null" json:"created_at"`
+}
+
+// Kiosk is an office device showing a rotating QR code that employees scan
+// to check in, proving they are at Location
+type Kiosk struct {
+	ID        string    `gorm:"type:text;primaryKey" json:"id"`
+	Name      string    `gorm:"type:text;not null" json:"name"`
+	Location  string    `gorm:"type:text;not null" json:"location"`
+	Active    bool      `gorm:"not null;default:true" json:"active"`
+	CreatedBy string    `gorm:"type:text;not null" json:"created_by"`
+	CreatedAt time.Time `gorm:"not null" json:"created_at"`
+	UpdatedAt time.Time `gorm:"not null" json:"updated_at"`
+}
+
+// KioskScan is a check-in made with a kiosk QR code. Each code, identified by
+// its kiosk and rotation window, can be used once per user.
+type KioskScan struct {
+	ID           string     `gorm:"type:text;primaryKey" json:"id"`
+	KioskID      string     `gorm:"type:text;not null;uniqueIndex:idx_kiosk_scan" json:"kiosk_id"`
+	Kiosk        Kiosk      `gorm:"foreignKey:KioskID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE" json:"-"`
+	CodeWindow   int64      `gorm:"not null;uniqueIndex:idx_kiosk_scan" json:"code_window"`
+	UserID       string     `gorm:"type:text;not null;uniqueIndex:idx_kiosk_scan" json:"user_id"`
+	AttendanceID string     `gorm:"type:text;not null" json:"attendance_id"`
+	Attendance   Attendance `gorm:"foreignKey:AttendanceID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE" json:"-"`
+	CreatedAt    time.Time  `gorm:"not null" json:"created_at"`
+}
+
+// RefreshToken is a single-use token that trades for a new access and
+// refresh token. Tokens rotated from the same login share a FamilyID, so a
+// reused token revokes the whole family. Only a hash of the token is stored.
+type RefreshToken struct {
+	ID              string     `gorm:"type:text;primaryKey" json:"id"`
+	UserID          string     `gorm:"type:text;not null;index" json:"user_id"`
+	User            User       `gorm:"foreignKey:UserID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE" json:"-"`
+	FamilyID        string     `gorm:"type:text;not null;index" json:"family_id"`
+	TokenHash       string     `gorm:"type:text;not null;uniqueIndex" json:"-"`
+	AccessTokenID   string     `gorm:"type:text;not null;index" json:"access_token_id"` // jti of the access token issued with it
+	AccessExpiresAt time.Time  `gorm:"not null" json:"access_expires_at"`
+	ExpiresAt       time.Time  `gorm:"not null" json:"expires_at"`
+	UsedAt          *time.Time `json:"used_at"`
+	RevokedAt       *time.Time `json:"revoked_at"`
+	CreatedAt       time.Time  `gorm:"not null" json:"created_at"`
+}
+
+// RevokedToken denies an access token, by its jti, until it expires
+type RevokedToken struct {
+	JTI       string    `gorm:"column:jti;type:text;primaryKey" json:"jti"`
+	UserID    string    `gorm:"type:text;not null;index" json:"user_id"`
+	ExpiresAt time.Time `gorm:"not null;index" json:"expires_at"`
+	Reason    string    `gorm:"type:text;default:''" json:"reason"`
+	CreatedAt time.Time `gorm:"not null" json:"created_at"`
+}
+
+// WalletChallenge is a nonce a client signs with its wallet key to log in.
+// Each challenge is bound to one address and can be used once.
+type WalletChallenge struct {
+	ID        string     `gorm:"type:text;primaryKey" json:"id"`
+	Address   string     `gorm:"type:text;not null;index" json:"address"` // Lower case 0x address
+	Nonce     string     `gorm:"type:text;not null;uniqueIndex" json:"nonce"`
+	Message   string     `gorm:"type:text;not null" json:"message"` // The exact text to sign
+	ExpiresAt time.Time  `gorm:"not null" json:"expires_at"`
+	UsedAt    *time.Time `json:"used_at"`
+	CreatedAt time.Time  `gorm:"not null" json:"created_at"`
+}
+
+// LedgerEntry is an append-only record of a write to an attendance or
+// absence record. Each entry hashes the record's content and the previous
+// entry, so editing a record or an entry breaks the chain.
+type LedgerEntry struct {
+	Seq        uint64    `gorm:"primaryKey;autoIncrement:false" json:"seq"`
+	RecordType string    `gorm:"type:text;not null;index:idx_ledger_record" json:"record_type"` // attendance or absence
+	RecordID   string    `gorm:"type:text;not null;index:idx_ledger_record" json:"record_id"`
+	Action     string    `gorm:"type:text;not null" json:"action"` // create, update or delete
+	RecordHash string    `gorm:"type:text;not null" json:"record_hash"`
+	PrevHash   string    `gorm:"type:text;not null" json:"prev_hash"`
+	EntryHash  string    `gorm:"type:text;not null;uniqueIndex" json:"entry_hash"`
+	CreatedAt  time.Time `gorm:"not null" json:"created_at"`
+}
+
+// AttendanceAnchor is the Merkle root of a day's attendance records and its
+// submission to the anchoring backend
+type AttendanceAnchor struct {
+	Day        string     `gorm:"type:text;primaryKey" json:"day"` // YYYY-MM-DD, local time
+	Root       string     `gorm:"type:text;not null;default:''" json:"root"`
+	LeafCount  int        `gorm:"not null" json:"leaf_count"`
+	Status     string     `gorm:"type:text;not null;check:status IN ('pending','anchored','failed','empty')" json:"status"`
+	Backend    string     `gorm:"type:text;default:''" json:"backend"`
+	TxID       string     `gorm:"type:text;default:''" json:"tx_id"`
+	Error      string     `gorm:"type:text;default:''" json:"error,omitempty"`
+	AnchoredAt *time.Time `json:"anchored_at"`
+	CreatedAt  time.Time  `gorm:"not null" json:"created_at"`
+	UpdatedAt  time.Time  `gorm:"not null" json:"updated_at"`
+}
+
+// AttendanceAnchorLeaf is an attendance record as it was when its day was
+// anchored, kept so that inclusion proofs do not depend on the current row
+type AttendanceAnchorLeaf struct {
+	Day          string           `gorm:"type:text;primaryKey" json:"day"`
+	Position     int              `gorm:"primaryKey;autoIncrement:false" json:"position"`
+	Anchor       AttendanceAnchor `gorm:"foreignKey:Day;constraint:OnUpdate:CASCADE,OnDelete:CASCADE" json:"-"`
+	AttendanceID string           `gorm:"type:text;not null;index" json:"attendance_id"`
+	RecordHash   string           `gorm:"type:text;not null" json:"record_hash"`
+}
+
+// OutboxEntry is an event waiting to be delivered to the chain. It is written
+// in the transaction of the change it describes and delivered afterwards, so
+// that requests never wait on the chain and no committed change is lost.
+type OutboxEntry struct {
+	ID            uint64     `gorm:"primaryKey" json:"id"`
+	Topic         string     `gorm:"type:text;not null;index" json:"topic"` // attendance, absence or payroll
+	RecordID      string     `gorm:"type:text;not null;index" json:"record_id"`
+	Payload       string     `gorm:"type:text;not null" json:"payload"`
+	Status        string     `gorm:"type:text;not null;default:'pending';index:idx_outbox_due;check:status IN ('pending','delivered','failed')" json:"status"`
+	Attempts      int        `gorm:"not null;default:0" json:"attempts"`
+	NextAttemptAt time.Time  `gorm:"not null;index:idx_outbox_due" json:"next_attempt_at"`
+	LastError     string     `gorm:"type:text;default:''" json:"last_error,omitempty"`
+	Backend       string     `gorm:"type:text;default:''" json:"backend"`
+	TxID          string     `gorm:"type:text;default:''" json:"tx_id"`
+	DeliveredAt   *time.Time `json:"delivered_at"`
+	CreatedAt     time.Time  `gorm:"not null" json:"created_at"`
+	UpdatedAt     time.Time  `gorm:"not null" json:"updated_at"`
+}
+
+// CompanyRule is one version of the company's attendance and pay rules. A
+// version applies from EffectiveFrom until the next one takes over, and
+// versions are never edited, so past days keep the rules they were judged by.
+type CompanyRule struct {
+	Version                uint      `gorm:"primaryKey" json:"version"`
+	EffectiveFrom          time.Time `gorm:"not null;uniqueIndex" json:"effective_from"` // Start of a local day
+	LateGraceMinutes       int       `gorm:"not null" json:"late_grace_minutes"`         // Minutes after shift start still counted on time
+	LatePenalty            float64   `gorm:"not null" json:"late_penalty"`               // Per late arrival without permission
+	MaxUnpaidAbsences      int       `gorm:"not null" json:"max_unpaid_absences"`        // Unpaid absences in a month before a warning
+	StandardDailyHours     float64   `gorm:"not null" json:"standard_daily_hours"`
+	EarlyLeavePenalty      float64   `gorm:"not null;default:0" json:"early_leave_penalty"`
+	MissingCheckOutPenalty float64   `gorm:"not null;default:0" json:"missing_check_out_penalty"`
+	AbsencePenalty         float64   `gorm:"not null;default:0" json:"absence_penalty"` // Per unauthorized absence, on top of the unpaid days
+	OvertimeWeekdayRate    float64   `gorm:"not null" json:"overtime_weekday_rate"`     // Multipliers of the hourly rate
+	OvertimeWeekendRate    float64   `gorm:"not null" json:"overtime_weekend_rate"`
+	OvertimeHolidayRate    float64   `gorm:"not null" json:"overtime_holiday_rate"`
+	OvertimeMonthlyCap     float64   `gorm:"not null;default:40" json:"overtime_monthly_cap"` // Hours of overtime paid per month
+	Note                   string    `gorm:"type:text;default:''" json:"note"`
+	CreatedBy              *string   `gorm:"type:text" json:"created_by"`
+	Creator                User      `gorm:"foreignKey:CreatedBy;constraint:OnUpdate:CASCADE,OnDelete:SET NULL" json:"-"`
+	CreatedAt              time.Time `gorm:"not null" json:"created_at"`
+}
+
+// CalendarDay overrides the shift schedule on a date. Holidays and closures
+// are days off; a compensatory working day is worked even when the shift
+// does not run on that weekday. An entry for a department takes precedence
+// over the company-wide one, which has no department.
+type CalendarDay struct {
+	ID         string    `gorm:"type:text;primary_key" json:"id"`
+	Date       time.Time `gorm:"not null;uniqueIndex:idx_calendar_day" json:"date"` // Start of a local day
+	Department string    `gorm:"type:text;not null;default:'';uniqueIndex:idx_calendar_day" json:"department"`
+	Kind       string    `gorm:"type:text;not null;check:kind IN ('holiday','closure','working_day')" json:"kind"`
+	Name       string    `gorm:"type:text;not null" json:"name"`
+	Source     string    `gorm:"type:text;not null;default:'manual';check:source IN ('manual','default','ical')" json:"source"`
+	CreatedAt  time.Time `gorm:"not null" json:"created_at"`
+}
+
+// OvertimeRequest asks a manager to pre-approve overtime on a day. Only
+// approved hours of work beyond the schedule are paid.
+type OvertimeRequest struct {
+	ID            string     `gorm:"type:text;primary_key" json:"id"`
+	UserID        string     `gorm:"type:text;not null;index" json:"user_id"`
+	User          User       `gorm:"foreignKey:UserID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE" json:"-"`
+	Date          time.Time  `gorm:"not null;index" json:"date"` // Start of a local day
+	Hours         float64    `gorm:"not null" json:"hours"`
+	ApprovedHours float64    `gorm:"not null;default:0" json:"approved_hours"`
+	Reason        string     `gorm:"type:text;not null" json:"reason"`
+	Status        string     `gorm:"type:text;not null;default:'pending';check:status IN ('pending','approved','rejected','cancelled')" json:"status"`
+	ReviewedBy    *string    `gorm:"type:text" json:"reviewed_by"`
+	Reviewer      User       `gorm:"foreignKey:ReviewedBy;constraint:OnUpdate:CASCADE,OnDelete:SET NULL" json:"-"`
+	ReviewNote    string     `gorm:"type:text;default:''" json:"review_note"`
+	ReviewedAt    *time.Time `json:"reviewed_at"`
+	CreatedAt     time.Time  `gorm:"not null" json:"created_at"`
+	UpdatedAt     time.Time  `gorm:"not null" json:"updated_at"`
+}
+
+// Violation is a breach of the company rules, detected from an attendance or
+// absence record or entered by HR. Detected violations link to their source
+// record, which each type can only be raised against once.
+type Violation struct {
+	ID          string           `gorm:"type:text;primary_key" json:"id"`
+	UserID      string           `gorm:"type:text;not null;index;uniqueIndex:idx_violation_source" json:"user_id"`
+	User        User             `gorm:"foreignKey:UserID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE" json:"-"`
+	Type        string           `gorm:"type:text;not null;uniqueIndex:idx_violation_source;check:type IN ('late_arrival','early_leave','missing_check_out','unauthorized_absence')" json:"type"`
+	Date        time.Time        `gorm:"not null;index;uniqueIndex:idx_violation_source" json:"date"` // Start of the local day it happened
+	Severity    string           `gorm:"type:text;not null;check:severity IN ('minor','major','critical')" json:"severity"`
+	Penalty     float64          `gorm:"not null;default:0" json:"penalty"`
+	SourceType  *string          `gorm:"type:text;uniqueIndex:idx_violation_source;check:source_type IN ('attendance','absence','schedule')" json:"source_type"` // schedule for a scheduled day nobody turned up to
+	SourceID    *string          `gorm:"type:text;uniqueIndex:idx_violation_source" json:"source_id"`                                                            // The shift for a schedule source
+	Origin      string           `gorm:"type:text;not null;check:origin IN ('detected','manual')" json:"origin"`
+	Status      string           `gorm:"type:text;not null;default:'active';check:status IN ('active','voided')" json:"status"` // Voided by an accepted appeal
+	RuleVersion uint             `gorm:"not null;default:0" json:"rule_version"`                                                // Company rule version it was judged by, 0 for the default
+	Note        string           `gorm:"type:text;default:''" json:"note"`
+	RecordedBy  *string          `gorm:"type:text" json:"recorded_by"`
+	Recorder    User             `gorm:"foreignKey:RecordedBy;constraint:OnUpdate:CASCADE,OnDelete:SET NULL" json:"-"`
+	Appeal      *ViolationAppeal `gorm:"foreignKey:ViolationID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE" json:"appeal,omitempty"`
+	CreatedAt   time.Time        `gorm:"not null" json:"created_at"`
+	UpdatedAt   time.Time        `gorm:"not null" json:"updated_at"`
+}
+
+// ViolationAppeal is an employee's appeal against a violation. A violation
+// can be appealed once; accepting the appeal voids it.
+type ViolationAppeal struct {
+	ID          string     `gorm:"type:text;primary_key" json:"id"`
+	ViolationID string     `gorm:"type:text;not null;uniqueIndex" json:"violation_id"`
+	UserID      string     `gorm:"type:text;not null;index" json:"user_id"`
+	Reason      string     `gorm:"type:text;not null" json:"reason"`
+	Status      string     `gorm:"type:text;not null;default:'pending';check:status IN ('pending','accepted','denied')" json:"status"`
+	ReviewedBy  *string    `gorm:"type:text" json:"reviewed_by"`
+	Reviewer    User       `gorm:"foreignKey:ReviewedBy;constraint:OnUpdate:CASCADE,OnDelete:SET NULL" json:"-"`
+	ReviewNote  string     `gorm:"type:text;default:''" json:"review_note"`
+	ReviewedAt  *time.Time `json:"reviewed_at"`
+	CreatedAt   time.Time  `gorm:"not null" json:"created_at"`
+	UpdatedAt   time.Time  `gorm:"not null" json:"updated_at"`
+}
+
+// EscalationStep is a rung of the disciplinary ladder: Threshold active
+// violations of ViolationType, or of any type when empty, within WindowDays
+// days lead to Action.
+type EscalationStep struct {
+	ID            string    `gorm:"type:text;primary_key" json:"id"`
+	ViolationType string    `gorm:"type:text;not null;default:''" json:"violation_type"`
+	Threshold     int       `gorm:"not null" json:"threshold"`
+	WindowDays    int       `gorm:"not null" json:"window_days"`
+	Action        string    `gorm:"type:text;not null;check:action IN ('written_warning','review_flag')" json:"action"`
+	CreatedAt     time.Time `gorm:"not null" json:"created_at"`
+}
+
+// DisciplinaryAction is a written warning or review flag raised when an
+// employee's violations reach a step of the ladder. It is withdrawn when
+// appeals bring the count back below the step's threshold.
+type DisciplinaryAction struct {
+	ID             string         `gorm:"type:text;primary_key" json:"id"`
+	UserID         string         `gorm:"type:text;not null;index" json:"user_id"`
+	User           User           `gorm:"foreignKey:UserID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE" json:"-"`
+	StepID         *string        `gorm:"type:text;index" json:"step_id"`
+	Step           EscalationStep `gorm:"foreignKey:StepID;constraint:OnUpdate:CASCADE,OnDelete:SET NULL" json:"-"`
+	Action         string         `gorm:"type:text;not null;check:action IN ('written_warning','review_flag')" json:"action"`
+	ViolationType  string         `gorm:"type:text;not null;default:''" json:"violation_type"`
+	Threshold      int            `gorm:"not null" json:"threshold"`
+	ViolationCount int            `gorm:"not null" json:"violation_count"`
+	WindowStart    time.Time      `gorm:"not null" json:"window_start"`
+	WindowEnd      time.Time      `gorm:"not null" json:"window_end"`
+	ViolationID    string         `gorm:"type:text;not null" json:"violation_id"` // The violation that reached the threshold
+	Status         string         `gorm:"type:text;not null;default:'open';check:status IN ('open','resolved','withdrawn')" json:"status"`
+	ResolvedBy     *string        `gorm:"type:text" json:"resolved_by"`
+	Resolver       User           `gorm:"foreignKey:ResolvedBy;constraint:OnUpdate:CASCADE,OnDelete:SET NULL" json:"-"`
+	ResolutionNote string         `gorm:"type:text;default:''" json:"resolution_note"`
+	ResolvedAt     *time.Time     `json:"resolved_at"`
+	CreatedAt      time.Time      `gorm:"not null" json:"created_at"`
+	UpdatedAt      time.Time      `gorm:"not null" json:"updated_at"`
 }
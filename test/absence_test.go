@@ -1,7 +1,9 @@
 package test
 
 import (
+	"bytes"
 	"dapp_timekeeping/handlers"
+	"dapp_timekeeping/middleware"
 	"dapp_timekeeping/models"
 	"dapp_timekeeping/types"
 	"encoding/json"
@@ -32,6 +34,7 @@ func TestGetAbsences(t *testing.T) {
 	// Create root user first
 	rootUser := models.User{
 		ID:                uuid.New().String(),
+		Nickname:          "absence_root",
 		FullName:          "Root Admin",
 		Email:             "root@company.com",
 		PhoneNumber:       "+1234567890",
@@ -57,6 +60,7 @@ func TestGetAbsences(t *testing.T) {
 	// Create test user
 	employee1 := models.User{
 		ID:                uuid.New().String(),
+		Nickname:          "absence_employee",
 		FullName:          "Test Employee 1",
 		Email:             "emp1@company.com",
 		PhoneNumber:       "+9876543210",
@@ -88,7 +92,7 @@ func TestGetAbsences(t *testing.T) {
 		ID:     uuid.New().String(),
 		UserID: employee1.ID,
 		Date:   time.Now(),
-		Type:   "without_permission",
+		Type:   "leave_without_permission",
 		Reason: "Personal emergency",
 		Status: "pending",
 		// Don't set ProcessedBy for pending status
@@ -141,7 +145,7 @@ func TestGetAbsences(t *testing.T) {
 		},
 		{
 			name:           "Filter by type",
-			queryParams:    "?type=without_permission",
+			queryParams:    "?type=leave_without_permission",
 			expectedStatus: 200,
 			checkResponse: func(t *testing.T, response types.APIResponse) {
 				assert.True(t, response.Success)
@@ -149,7 +153,7 @@ func TestGetAbsences(t *testing.T) {
 				assert.True(t, ok)
 				for _, abs := range absences {
 					absMap := abs.(map[string]interface{})
-					assert.Equal(t, "without_permission", absMap["type"])
+					assert.Equal(t, "leave_without_permission", absMap["type"])
 				}
 			},
 		},
@@ -213,6 +217,7 @@ func TestGetEmployeeStatistics(t *testing.T) {
 	// Create root user
 	rootUser := models.User{
 		ID:          uuid.New().String(),
+		Nickname:    "statistics_root",
 		FullName:    "Root Admin",
 		Email:       "root@company.com",
 		PhoneNumber: "+1234567890",
@@ -228,6 +233,7 @@ func TestGetEmployeeStatistics(t *testing.T) {
 	// Create HR manager
 	hrManager := models.User{
 		ID:          uuid.New().String(),
+		Nickname:    "statistics_hr_manager",
 		FullName:    "HR Manager",
 		Email:       "hr@company.com",
 		PhoneNumber: "+1234567891",
@@ -243,6 +249,7 @@ func TestGetEmployeeStatistics(t *testing.T) {
 	// Create test employee
 	employee1 := models.User{
 		ID:          uuid.New().String(),
+		Nickname:    "statistics_employee",
 		FullName:    "Test Employee 1",
 		Email:       "emp1@company.com",
 		PhoneNumber: "+9876543210",
@@ -261,7 +268,7 @@ func TestGetEmployeeStatistics(t *testing.T) {
 			ID:          uuid.New().String(),
 			UserID:      employee1.ID,
 			Date:        time.Now(),
-			Type:        "with_permission",
+			Type:        "leave_with_permission",
 			Reason:      "Annual leave",
 			Status:      "approved",
 			ProcessedBy: strPtr(hrManager.ID),
@@ -273,7 +280,7 @@ func TestGetEmployeeStatistics(t *testing.T) {
 			ID:        uuid.New().String(),
 			UserID:    employee1.ID,
 			Date:      time.Now(),
-			Type:      "without_permission",
+			Type:      "leave_without_permission",
 			Reason:    "Family emergency",
 			Status:    "pending",
 			CreatedAt: time.Now(),
@@ -350,14 +357,14 @@ func TestGetEmployeeStatistics(t *testing.T) {
 
 	// Debug the actual SQL query
 	var debugStats struct {
-		WithPermission    int `gorm:"column:with_permission"`
-		WithoutPermission int `gorm:"column:without_permission"`
-		PendingLeaves     int `gorm:"column:pending_leaves"`
+		LeaveWithPermission    int `gorm:"column:leave_with_permission"`
+		LeaveWithoutPermission int `gorm:"column:leave_without_permission"`
+		PendingLeaves          int `gorm:"column:pending_leaves"`
 	}
 	db.Model(&models.Absence{}).
 		Select(`
-			COUNT(CASE WHEN type = 'with_permission' AND status = 'approved' THEN 1 END) as with_permission,
-			COUNT(CASE WHEN type = 'without_permission' THEN 1 END) as without_permission,
+			COUNT(CASE WHEN type = 'leave_with_permission' AND status = 'approved' THEN 1 END) as leave_with_permission,
+			COUNT(CASE WHEN type = 'leave_without_permission' THEN 1 END) as leave_without_permission,
 			COUNT(CASE WHEN status = 'pending' THEN 1 END) as pending_leaves
 		`).
 		Scan(&debugStats)
@@ -390,8 +397,8 @@ func TestGetEmployeeStatistics(t *testing.T) {
 
 	// Check leave statistics
 	leaveStats := stats["leave_stats"].(map[string]interface{})
-	assert.Equal(t, float64(1), leaveStats["with_permission"], "Should have 1 approved with_permission leave")
-	assert.Equal(t, float64(1), leaveStats["without_permission"], "Should have 1 without_permission leave")
+	assert.Equal(t, float64(1), leaveStats["leave_with_permission"], "Should have 1 approved with_permission leave")
+	assert.Equal(t, float64(1), leaveStats["leave_without_permission"], "Should have 1 without_permission leave")
 	assert.Equal(t, float64(2), leaveStats["pending_leaves"], "Should have 2 pending leaves")
 
 	// Check resign statistics
@@ -413,7 +420,7 @@ func TestGetEmployeeStatistics(t *testing.T) {
 			ID:     uuid.New().String(),
 			UserID: employee1.ID,
 			Date:   time.Now(),
-			Type:   "with_permission",
+			Type:   "leave_with_permission",
 			Status: "approved", // Approved but no ProcessedBy - should fail
 		}
 
@@ -427,7 +434,7 @@ func TestGetEmployeeStatistics(t *testing.T) {
 			ID:     uuid.New().String(),
 			UserID: employee1.ID,
 			Date:   time.Now(),
-			Type:   "with_permission",
+			Type:   "leave_with_permission",
 			Reason: "Test reason",
 			Status: "pending",
 			// Don't set ProcessedBy for pending
@@ -448,7 +455,7 @@ func TestGetEmployeeStatistics(t *testing.T) {
 			ID:          uuid.New().String(),
 			UserID:      employee1.ID,
 			Date:        time.Now(),
-			Type:        "with_permission",
+			Type:        "leave_with_permission",
 			Reason:      "Approved reason",
 			Status:      "approved",
 			ProcessedBy: strPtr(hrManager.ID),
@@ -478,6 +485,146 @@ func TestGetEmployeeStatistics(t *testing.T) {
 	db.Unscoped().Delete(&rootUser)
 }
 
+func TestProcessAbsence(t *testing.T) {
+	app, db := SetupTest(t)
+
+	root := app.Group("/root", middleware.RequireRoot)
+	root.Get("/attendance/unprocessed", handlers.GetUnprocessedAbsences)
+	root.Post("/attendance/process/:id", handlers.ProcessAbsence)
+	root.Post("/leaves/:id/approve", handlers.ApproveLeave)
+	root.Post("/leaves/:id/reject", handlers.RejectLeave)
+
+	rootUser := models.User{
+		ID:       uuid.New().String(),
+		Nickname: "root_processor",
+		FullName: "Root Admin",
+		Role:     "root",
+		Status:   "active",
+	}
+	employee := models.User{
+		ID:          uuid.New().String(),
+		Nickname:    "absent_employee",
+		FullName:    "Absent Employee",
+		Role:        "employee",
+		Department:  "IT",
+		Status:      "active",
+		OnboardDate: time.Now().AddDate(-1, 0, 0),
+	}
+	assert.NoError(t, db.Create(&rootUser).Error)
+	assert.NoError(t, db.Create(&employee).Error)
+
+	newAbsence := func(absenceType string, userID string) models.Absence {
+		absence := models.Absence{
+			ID:        uuid.New().String(),
+			UserID:    userID,
+			Date:      time.Now(),
+			StartDate: time.Now(),
+			EndDate:   time.Now(),
+			Type:      absenceType,
+			Reason:    "Doctor appointment",
+			Status:    "pending",
+		}
+		assert.NoError(t, db.Create(&absence).Error)
+		return absence
+	}
+	leave := newAbsence("leave_with_permission", employee.ID)
+	late := newAbsence("late_with_permission", employee.ID)
+	own := newAbsence("leave_with_permission", rootUser.ID)
+
+	rootToken := createTestToken(rootUser.ID, "root")
+
+	doRequest := func(method, path, token string, payload interface{}) (int, types.APIResponse) {
+		body := bytes.NewBuffer(nil)
+		if payload != nil {
+			b, _ := json.Marshal(payload)
+			body = bytes.NewBuffer(b)
+		}
+		req := httptest.NewRequest(method, path, body)
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := app.Test(req)
+		assert.NoError(t, err)
+
+		var response types.APIResponse
+		json.NewDecoder(resp.Body).Decode(&response)
+		t.Logf("%s %s -> %d %+v", method, path, resp.StatusCode, response)
+		return resp.StatusCode, response
+	}
+
+	t.Run("List Pending Queue", func(t *testing.T) {
+		status, response := doRequest("GET", "/root/attendance/unprocessed", rootToken, nil)
+		assert.Equal(t, 200, status)
+		assert.Len(t, response.Data.([]interface{}), 3)
+	})
+
+	t.Run("Employee Cannot Process", func(t *testing.T) {
+		status, _ := doRequest("POST", "/root/leaves/"+leave.ID+"/approve", createTestToken(employee.ID, "employee"), nil)
+		assert.Equal(t, 403, status)
+
+		var saved models.Absence
+		db.First(&saved, "id = ?", leave.ID)
+		assert.Equal(t, "pending", saved.Status)
+	})
+
+	t.Run("Approve Leave", func(t *testing.T) {
+		status, response := doRequest("POST", "/root/leaves/"+leave.ID+"/approve", rootToken, nil)
+		assert.Equal(t, 200, status)
+		assert.True(t, response.Success)
+
+		var saved models.Absence
+		db.First(&saved, "id = ?", leave.ID)
+		assert.Equal(t, "approved", saved.Status)
+		assert.NotNil(t, saved.ProcessedBy)
+		assert.Equal(t, rootUser.ID, *saved.ProcessedBy)
+		assert.NotNil(t, saved.ProcessedAt)
+	})
+
+	t.Run("Processed Absence Cannot Change", func(t *testing.T) {
+		status, _ := doRequest("POST", "/root/leaves/"+leave.ID+"/reject", rootToken, nil)
+		assert.Equal(t, 409, status)
+
+		var saved models.Absence
+		db.First(&saved, "id = ?", leave.ID)
+		assert.Equal(t, "approved", saved.Status)
+	})
+
+	t.Run("Process With Status", func(t *testing.T) {
+		status, _ := doRequest("POST", "/root/attendance/process/"+late.ID, rootToken, map[string]string{"status": "pending"})
+		assert.Equal(t, 400, status)
+
+		status, _ = doRequest("POST", "/root/attendance/process/"+late.ID, rootToken, map[string]string{"status": "rejected"})
+		assert.Equal(t, 200, status)
+
+		var saved models.Absence
+		db.First(&saved, "id = ?", late.ID)
+		assert.Equal(t, "rejected", saved.Status)
+	})
+
+	t.Run("Cannot Process Own Absence", func(t *testing.T) {
+		status, _ := doRequest("POST", "/root/leaves/"+own.ID+"/approve", rootToken, nil)
+		assert.Equal(t, 403, status)
+	})
+
+	t.Run("Unknown Absence", func(t *testing.T) {
+		status, _ := doRequest("POST", "/root/leaves/"+uuid.New().String()+"/approve", rootToken, nil)
+		assert.Equal(t, 404, status)
+	})
+
+	t.Run("Queue Only Holds Pending", func(t *testing.T) {
+		status, response := doRequest("GET", "/root/attendance/unprocessed", rootToken, nil)
+		assert.Equal(t, 200, status)
+		pending := response.Data.([]interface{})
+		assert.Len(t, pending, 1)
+		assert.Equal(t, own.ID, pending[0].(map[string]interface{})["id"])
+	})
+
+	// Cleanup
+	db.Exec("DELETE FROM leave_ledger_entries")
+	db.Unscoped().Delete(&models.Absence{}, "user_id IN (?)", []string{employee.ID, rootUser.ID})
+	db.Unscoped().Delete(&employee)
+	db.Unscoped().Delete(&rootUser)
+}
+
 // Helper function to create pointer to time.Time
 func ptr(t time.Time) *time.Time {
 	return &t
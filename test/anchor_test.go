@@ -0,0 +1,366 @@
+package test
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"dapp_timekeeping/anchor"
+	"dapp_timekeeping/handlers"
+	"dapp_timekeeping/middleware"
+	"dapp_timekeeping/models"
+	"dapp_timekeeping/types"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+type failingAnchorer struct{}
+
+func (failingAnchorer) Anchor(ctx context.Context, day, root string) (anchor.Receipt, error) {
+	return anchor.Receipt{}, errors.New("canister unavailable")
+}
+
+// cborEncode encodes what a replica answers with, map keys sorted
+func cborEncode(value interface{}) []byte {
+	head := func(major byte, n int) []byte {
+		switch {
+		case n < 24:
+			return []byte{major<<5 | byte(n)}
+		case n <= 0xff:
+			return []byte{major<<5 | 24, byte(n)}
+		}
+		return []byte{major<<5 | 25, byte(n >> 8), byte(n)}
+	}
+	switch v := value.(type) {
+	case uint64:
+		return head(0, int(v))
+	case []byte:
+		return append(head(2, len(v)), v...)
+	case string:
+		return append(head(3, len(v)), v...)
+	case []interface{}:
+		b := head(4, len(v))
+		for _, item := range v {
+			b = append(b, cborEncode(item)...)
+		}
+		return b
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		b := head(5, len(v))
+		for _, k := range keys {
+			b = append(append(b, cborEncode(k)...), cborEncode(v[k])...)
+		}
+		return b
+	}
+	panic("cannot encode")
+}
+
+// fakeReplica accepts canister calls with status and answers each read of a
+// call's status with the next of statuses, the last one repeating. An empty
+// status means the replica has no record of the call.
+type fakeReplica struct {
+	*httptest.Server
+	status            int
+	statuses          []string
+	reads             int
+	path, contentType string
+	body              []byte
+}
+
+func newFakeReplica() *fakeReplica {
+	f := &fakeReplica{status: http.StatusAccepted, statuses: []string{"replied"}}
+	f.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		raw, _ := io.ReadAll(r.Body)
+		if !strings.HasSuffix(r.URL.Path, "/read_state") {
+			f.path, f.contentType, f.body = r.URL.Path, r.Header.Get("Content-Type"), raw
+			w.WriteHeader(f.status)
+			return
+		}
+
+		// The request ID follows the request_status path label
+		label := append([]byte{0x4e}, "request_status"...)
+		i := bytes.Index(raw, label) + len(label) + 2
+		requestID := raw[i : i+32]
+		current := f.statuses[min(f.reads, len(f.statuses)-1)]
+		f.reads++
+
+		subtree := []interface{}{uint64(0)}
+		if current != "" {
+			subtree = []interface{}{uint64(2), []byte("status"), []interface{}{uint64(3), []byte(current)}}
+			if current == "rejected" {
+				subtree = []interface{}{uint64(1), subtree, []interface{}{uint64(2), []byte("reject_message"), []interface{}{uint64(3), []byte("trapped")}}}
+			}
+			subtree = []interface{}{uint64(2), requestID, subtree}
+		}
+		tree := []interface{}{uint64(1),
+			[]interface{}{uint64(2), []byte("request_status"), subtree},
+			[]interface{}{uint64(2), []byte("time"), []interface{}{uint64(3), []byte{0x01}}},
+		}
+		certificate := cborEncode(map[string]interface{}{"tree": tree, "signature": []byte{0x00}})
+		w.WriteHeader(http.StatusOK)
+		w.Write(append([]byte{0xd9, 0xd9, 0xf7}, cborEncode(map[string]interface{}{"certificate": certificate})...))
+	}))
+	return f
+}
+
+func TestMerkleProofs(t *testing.T) {
+	for n := 1; n <= 9; n++ {
+		leaves := make([][]byte, n)
+		for i := range leaves {
+			sum := sha256.Sum256([]byte{byte(i)})
+			leaves[i] = anchor.LeafHash(sum[:])
+		}
+		root := anchor.MerkleRoot(leaves)
+		for i := range leaves {
+			path := anchor.MerkleProof(leaves, i)
+			assert.True(t, anchor.VerifyProof(leaves[i], path, root), "leaf %d of %d", i, n)
+			assert.False(t, anchor.VerifyProof(leaves[(i+1)%n], path, root) && n > 1, "leaf %d of %d with the wrong leaf", i, n)
+		}
+	}
+	assert.Nil(t, anchor.MerkleRoot(nil))
+}
+
+func TestAnchorers(t *testing.T) {
+	t.Run("ICP Canister Call", func(t *testing.T) {
+		replica := newFakeReplica()
+		defer replica.Close()
+
+		_, err := anchor.NewICPAnchorer(replica.URL, "not-a-principal", "anchor_attendance_root")
+		assert.Error(t, err)
+
+		a, err := anchor.NewICPAnchorer(replica.URL, "rrkah-fqaaa-aaaaa-aaaaq-cai", "anchor_attendance_root")
+		assert.NoError(t, err)
+		a.PollInterval = time.Millisecond
+
+		replica.statuses = []string{"", "received", "processing", "replied"}
+		receipt, err := a.Anchor(context.Background(), "2024-03-01", "ab12")
+		assert.NoError(t, err)
+		assert.Equal(t, 4, replica.reads, "Polled until the canister replied")
+		assert.Equal(t, "/api/v2/canister/rrkah-fqaaa-aaaaa-aaaaq-cai/call", replica.path)
+		assert.Equal(t, "application/cbor", replica.contentType)
+		assert.True(t, bytes.HasPrefix(replica.body, []byte{0xd9, 0xd9, 0xf7}), "Self-described CBOR")
+		assert.Contains(t, string(replica.body), "anchor_attendance_root")
+		assert.Contains(t, string(replica.body), "DIDL\x00\x02\x71\x71\x0a2024-03-01\x04ab12")
+		assert.Equal(t, "icp", receipt.Backend)
+		assert.Len(t, receipt.TxID, 64)
+
+		replica.statuses, replica.reads = []string{"replied"}, 0
+		_, err = a.Submit(context.Background(), "attendance", "a1", `{"seq":1}`)
+		assert.NoError(t, err)
+		assert.Contains(t, string(replica.body), "record_event")
+		assert.Contains(t, string(replica.body), "DIDL\x00\x03\x71\x71\x71\x0aattendance\x02a1\x09{\"seq\":1}")
+
+		// Accepted by the replica, then trapped in the canister
+		replica.statuses, replica.reads = []string{"processing", "rejected"}, 0
+		_, err = a.Anchor(context.Background(), "2024-03-01", "ab12")
+		if assert.Error(t, err) {
+			assert.Contains(t, err.Error(), "trapped")
+		}
+		replica.statuses, replica.reads = []string{"done"}, 0
+		_, err = a.Submit(context.Background(), "attendance", "a1", `{"seq":1}`)
+		assert.Error(t, err, "The outcome is unknown")
+
+		replica.statuses, replica.reads = []string{"processing"}, 0
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		_, err = a.Anchor(ctx, "2024-03-01", "ab12")
+		cancel()
+		assert.Error(t, err)
+
+		replica.status = http.StatusBadRequest
+		_, err = a.Anchor(context.Background(), "2024-03-01", "ab12")
+		assert.Error(t, err)
+	})
+
+	t.Run("Local File", func(t *testing.T) {
+		file := filepath.Join(t.TempDir(), "anchors.jsonl")
+		a, err := anchor.NewLocalAnchorer(file)
+		assert.NoError(t, err)
+		receipt, err := a.Anchor(context.Background(), "2024-03-01", "ab12")
+		assert.NoError(t, err)
+		assert.Equal(t, "local", receipt.Backend)
+		_, err = a.Submit(context.Background(), "attendance", "a1", `{"seq":1}`)
+		assert.NoError(t, err)
+
+		reloaded, err := anchor.NewLocalAnchorer(file)
+		assert.NoError(t, err)
+		root, ok := reloaded.Root("2024-03-01")
+		assert.True(t, ok)
+		assert.Equal(t, "ab12", root)
+		if assert.Len(t, reloaded.Events(), 1) {
+			assert.Equal(t, "a1", reloaded.Events()[0].Key)
+		}
+	})
+}
+
+func TestAttendanceAnchoring(t *testing.T) {
+	app, db := SetupTest(t)
+
+	emp := app.Group("/employee", middleware.RequireAuth)
+	emp.Get("/attendance/:id/proof", handlers.GetAttendanceProof)
+	root := app.Group("/root", middleware.RequireRoot)
+	root.Get("/anchors", handlers.ListAnchors)
+	root.Post("/anchors/run", handlers.RunAnchoring)
+
+	rootUser := models.User{ID: uuid.New().String(), Nickname: "anchor_root", Role: "root", Status: "active"}
+	alice := models.User{ID: uuid.New().String(), Nickname: "alice", Role: "employee", Status: "active"}
+	bob := models.User{ID: uuid.New().String(), Nickname: "bob", Role: "employee", Status: "active"}
+	for _, u := range []*models.User{&rootUser, &alice, &bob} {
+		assert.NoError(t, db.Create(u).Error)
+	}
+	rootToken := createTestToken(rootUser.ID, "root")
+
+	// Three records four days ago, one record two days ago and one today
+	today := time.Now()
+	day := func(offset int, hour int) time.Time {
+		d := today.AddDate(0, 0, offset)
+		return time.Date(d.Year(), d.Month(), d.Day(), hour, 0, 0, 0, time.Local)
+	}
+	var attendances []models.Attendance
+	for _, a := range []struct {
+		user   models.User
+		offset int
+		hour   int
+	}{{alice, -4, 8}, {bob, -4, 9}, {rootUser, -4, 10}, {alice, -2, 8}, {alice, 0, 8}} {
+		in := day(a.offset, a.hour)
+		attendance := models.Attendance{
+			ID:           uuid.New().String(),
+			UserID:       a.user.ID,
+			CheckInTime:  in,
+			CheckOutTime: in.Add(8 * time.Hour),
+			ExpectedTime: in,
+			OnTime:       true,
+			CreatedAt:    in,
+			UpdatedAt:    in,
+		}
+		assert.NoError(t, db.Create(&attendance).Error)
+		attendances = append(attendances, attendance)
+	}
+
+	doRequest := func(method, path, token string) (int, types.APIResponse) {
+		req := httptest.NewRequest(method, path, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := app.Test(req, -1)
+		assert.NoError(t, err)
+
+		var response types.APIResponse
+		json.NewDecoder(resp.Body).Decode(&response)
+		t.Logf("%s %s -> %d %+v", method, path, resp.StatusCode, response.Error)
+		return resp.StatusCode, response
+	}
+
+	t.Run("Failed Submission Is Retried", func(t *testing.T) {
+		handlers.SetAnchorer(failingAnchorer{})
+		status, response := doRequest("POST", "/root/anchors/run", rootToken)
+		assert.Equal(t, 502, status)
+		assert.Equal(t, types.ErrBlockchainError, response.Error)
+
+		var failed models.AttendanceAnchor
+		db.First(&failed, "day = ?", day(-4, 0).Format("2006-01-02"))
+		assert.Equal(t, "failed", failed.Status)
+		assert.Equal(t, 3, failed.LeafCount)
+	})
+
+	local, err := anchor.NewLocalAnchorer("")
+	assert.NoError(t, err)
+	handlers.SetAnchorer(local)
+
+	t.Run("Anchor Finished Days", func(t *testing.T) {
+		status, response := doRequest("POST", "/root/anchors/run", rootToken)
+		assert.Equal(t, 200, status)
+		assert.GreaterOrEqual(t, response.Data.(map[string]interface{})["anchored"], float64(3))
+
+		var anchors []models.AttendanceAnchor
+		db.Order("day").Find(&anchors)
+		byDay := map[string]models.AttendanceAnchor{}
+		for _, a := range anchors {
+			byDay[a.Day] = a
+		}
+		assert.Equal(t, "anchored", byDay[day(-4, 0).Format("2006-01-02")].Status)
+		assert.Equal(t, "empty", byDay[day(-3, 0).Format("2006-01-02")].Status)
+		assert.Equal(t, "anchored", byDay[day(-2, 0).Format("2006-01-02")].Status)
+		_, anchoredToday := byDay[day(0, 0).Format("2006-01-02")]
+		assert.False(t, anchoredToday, "Days with open shifts are not anchored")
+
+		submitted, ok := local.Root(day(-4, 0).Format("2006-01-02"))
+		assert.True(t, ok)
+		assert.Equal(t, byDay[day(-4, 0).Format("2006-01-02")].Root, submitted)
+
+		// Nothing left to anchor
+		status, response = doRequest("POST", "/root/anchors/run", rootToken)
+		assert.Equal(t, 200, status)
+		assert.Equal(t, float64(0), response.Data.(map[string]interface{})["anchored"])
+
+		status, response = doRequest("GET", "/root/anchors", rootToken)
+		assert.Equal(t, 200, status)
+		assert.Len(t, response.Data, len(anchors))
+		for _, query := range []string{"?limit=0", "?limit=-1", "?limit=1"} {
+			status, response = doRequest("GET", "/root/anchors"+query, rootToken)
+			assert.Equal(t, 200, status)
+			assert.Len(t, response.Data, 1, "Clamped to at least one row")
+		}
+	})
+
+	t.Run("Proof Verifies Independently", func(t *testing.T) {
+		bobsRecord := attendances[1]
+		status, response := doRequest("GET", "/employee/attendance/"+bobsRecord.ID+"/proof", createTestToken(bob.ID, "employee"))
+		assert.Equal(t, 200, status)
+
+		var proof handlers.AttendanceProof
+		raw, _ := json.Marshal(response.Data)
+		assert.NoError(t, json.Unmarshal(raw, &proof))
+		assert.True(t, proof.RecordUnchanged)
+		assert.Equal(t, 3, proof.LeafCount)
+
+		recordHash := sha256.Sum256([]byte(proof.Record))
+		assert.Equal(t, proof.RecordHash, hex.EncodeToString(recordHash[:]))
+		node := sha256.Sum256(append([]byte{0x00}, recordHash[:]...))
+		for _, step := range proof.Path {
+			sibling, _ := hex.DecodeString(step.Hash)
+			if step.Position == "left" {
+				node = sha256.Sum256(append(append([]byte{0x01}, sibling...), node[:]...))
+			} else {
+				node = sha256.Sum256(append(append([]byte{0x01}, node[:]...), sibling...))
+			}
+		}
+		assert.Equal(t, proof.Root, hex.EncodeToString(node[:]))
+
+		status, _ = doRequest("GET", "/employee/attendance/"+bobsRecord.ID+"/proof", createTestToken(alice.ID, "employee"))
+		assert.Equal(t, 404, status, "Employees only get proofs of their own records")
+		status, _ = doRequest("GET", "/employee/attendance/"+attendances[4].ID+"/proof", createTestToken(alice.ID, "employee"))
+		assert.Equal(t, 404, status, "Today is not anchored yet")
+	})
+
+	t.Run("Edited Record No Longer Matches", func(t *testing.T) {
+		record := attendances[0]
+		db.Model(&models.Attendance{}).Where("id = ?", record.ID).Update("check_in_time", record.CheckInTime.Add(-time.Hour))
+
+		status, response := doRequest("GET", "/employee/attendance/"+record.ID+"/proof", createTestToken(alice.ID, "employee"))
+		assert.Equal(t, 200, status)
+		assert.Equal(t, false, response.Data.(map[string]interface{})["record_unchanged"])
+	})
+
+	handlers.SetAnchorer(nil)
+
+	// Cleanup
+	db.Where("1 = 1").Delete(&models.AttendanceAnchorLeaf{})
+	db.Where("1 = 1").Delete(&models.AttendanceAnchor{})
+	db.Where("1 = 1").Delete(&models.Attendance{})
+	for _, u := range []models.User{rootUser, alice, bob} {
+		db.Unscoped().Delete(&u)
+	}
+}
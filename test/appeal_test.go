@@ -0,0 +1,265 @@
+package test
+
+import (
+	"bytes"
+	"dapp_timekeeping/handlers"
+	"dapp_timekeeping/middleware"
+	"dapp_timekeeping/models"
+	"dapp_timekeeping/types"
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestViolationAppeals(t *testing.T) {
+	app, db := SetupTest(t)
+
+	hr := app.Group("/hr")
+	hr.Post("/violations", middleware.RequirePermission("violation_management"), handlers.RecordViolation)
+	hr.Get("/appeals", middleware.RequirePermission("violation_management"), handlers.ListAppeals)
+	hr.Post("/appeals/:id/accept", middleware.RequirePermission("violation_management"), handlers.AcceptAppeal)
+	hr.Post("/appeals/:id/deny", middleware.RequirePermission("violation_management"), handlers.DenyAppeal)
+	hr.Get("/disciplinary-actions", middleware.RequirePermission("violation_management"), handlers.ListDisciplinaryActions)
+	hr.Post("/disciplinary-actions/:id/resolve", middleware.RequirePermission("violation_management"), handlers.ResolveDisciplinaryAction)
+	emp := app.Group("/employee", middleware.RequireAuth)
+	emp.Get("/violations", handlers.GetMyViolations)
+	emp.Post("/violations/:id/appeal", handlers.AppealViolation)
+	root := app.Group("/root", middleware.RequireRoot)
+	root.Get("/escalation-steps", handlers.ListEscalationSteps)
+	root.Post("/escalation-steps", handlers.CreateEscalationStep)
+	root.Delete("/escalation-steps/:id", handlers.DeleteEscalationStep)
+	root.Post("/payroll/run", handlers.RunPayroll)
+
+	longAgo := time.Date(2020, 1, 1, 0, 0, 0, 0, time.Local)
+	rootUser := models.User{ID: uuid.New().String(), Nickname: "appeal_root", Role: "root", Status: "active"}
+	manager := models.User{ID: uuid.New().String(), Nickname: "appeal_manager", Role: "hr_manager", Department: "Sales", Status: "active"}
+	seller := models.User{ID: uuid.New().String(), Nickname: "appeal_seller", Role: "employee", Department: "Sales", Status: "active", OnboardDate: longAgo, Salary: 10000000}
+	other := models.User{ID: uuid.New().String(), Nickname: "appeal_other", Role: "employee", Department: "Sales", Status: "active", OnboardDate: longAgo}
+	for _, u := range []*models.User{&rootUser, &manager, &seller, &other} {
+		assert.NoError(t, db.Create(u).Error)
+	}
+	rootToken := createTestToken(rootUser.ID, "root")
+	managerToken := createTestToken(manager.ID, "hr_manager")
+	sellerToken := createTestToken(seller.ID, "employee")
+
+	rule := models.CompanyRule{EffectiveFrom: longAgo, LatePenalty: 50000, MaxUnpaidAbsences: 3, StandardDailyHours: 8, OvertimeWeekdayRate: 1.5, OvertimeWeekendRate: 2, OvertimeHolidayRate: 3, CreatedAt: longAgo}
+	assert.NoError(t, db.Create(&rule).Error)
+
+	doRequest := func(method, path, token string, payload interface{}) (int, types.APIResponse) {
+		b, _ := json.Marshal(payload)
+		req := httptest.NewRequest(method, path, bytes.NewBuffer(b))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := app.Test(req, -1)
+		assert.NoError(t, err)
+
+		var response types.APIResponse
+		json.NewDecoder(resp.Body).Decode(&response)
+		t.Logf("%s %s -> %d %+v", method, path, resp.StatusCode, response.Error)
+		return resp.StatusCode, response
+	}
+	day := func(offset int) string {
+		return time.Now().AddDate(0, 0, offset).Format("2006-01-02")
+	}
+	recordLate := func(date string) string {
+		status, response := doRequest("POST", "/hr/violations", managerToken, handlers.RecordViolationRequest{UserID: seller.ID, Type: "late_arrival", Date: date, Severity: "minor"})
+		assert.Equal(t, 200, status)
+		return response.Data.(map[string]interface{})["id"].(string)
+	}
+	appeal := func(violationID string) string {
+		status, response := doRequest("POST", "/employee/violations/"+violationID+"/appeal", sellerToken, handlers.AppealViolationRequest{Reason: "Traffic accident on the way"})
+		assert.Equal(t, 200, status)
+		return response.Data.(map[string]interface{})["id"].(string)
+	}
+	actions := func(status string) []models.DisciplinaryAction {
+		var list []models.DisciplinaryAction
+		db.Where("user_id = ? AND status = ?", seller.ID, status).Order("created_at").Find(&list)
+		return list
+	}
+
+	t.Run("Escalation Ladder", func(t *testing.T) {
+		status, _ := doRequest("POST", "/root/escalation-steps", rootToken, handlers.EscalationStepRequest{ViolationType: "late_arrival", Threshold: 3, WindowDays: 30, Action: "suspension"})
+		assert.Equal(t, 400, status)
+		status, _ = doRequest("POST", "/root/escalation-steps", rootToken, handlers.EscalationStepRequest{ViolationType: "late_arrival", Threshold: 0, WindowDays: 30, Action: "written_warning"})
+		assert.Equal(t, 400, status)
+		for _, step := range []handlers.EscalationStepRequest{
+			{ViolationType: "late_arrival", Threshold: 3, WindowDays: 30, Action: "written_warning"},
+			{ViolationType: "late_arrival", Threshold: 5, WindowDays: 30, Action: "review_flag"},
+		} {
+			status, _ = doRequest("POST", "/root/escalation-steps", rootToken, step)
+			assert.Equal(t, 200, status)
+		}
+		status, response := doRequest("GET", "/root/escalation-steps", rootToken, nil)
+		assert.Equal(t, 200, status)
+		assert.Len(t, response.Data, 2)
+	})
+
+	var lates []string
+
+	t.Run("Third Late Arrival Raises A Warning", func(t *testing.T) {
+		lates = append(lates, recordLate(day(-10)), recordLate(day(-5)))
+		assert.Empty(t, actions("open"))
+
+		lates = append(lates, recordLate(day(-2)))
+		open := actions("open")
+		if assert.Len(t, open, 1) {
+			assert.Equal(t, "written_warning", open[0].Action)
+			assert.Equal(t, 3, open[0].ViolationCount)
+			assert.Equal(t, lates[2], open[0].ViolationID)
+		}
+
+		// The step fires once per window
+		lates = append(lates, recordLate(day(-1)))
+		assert.Len(t, actions("open"), 1)
+
+		status, response := doRequest("GET", "/hr/disciplinary-actions", managerToken, nil)
+		assert.Equal(t, 200, status)
+		assert.Len(t, response.Data, 1)
+	})
+
+	var appealID string
+
+	t.Run("Submit Appeal", func(t *testing.T) {
+		status, _ := doRequest("POST", "/employee/violations/"+lates[0]+"/appeal", sellerToken, handlers.AppealViolationRequest{})
+		assert.Equal(t, 400, status, "Reason required")
+		status, _ = doRequest("POST", "/employee/violations/"+lates[0]+"/appeal", createTestToken(other.ID, "employee"), handlers.AppealViolationRequest{Reason: "Not mine"})
+		assert.Equal(t, 404, status, "Only your own violations")
+
+		appealID = appeal(lates[0])
+		status, _ = doRequest("POST", "/employee/violations/"+lates[0]+"/appeal", sellerToken, handlers.AppealViolationRequest{Reason: "Again"})
+		assert.Equal(t, 409, status)
+
+		status, response := doRequest("GET", "/hr/appeals?status=pending", managerToken, nil)
+		assert.Equal(t, 200, status)
+		assert.Len(t, response.Data, 1)
+
+		status, response = doRequest("GET", "/employee/violations", sellerToken, nil)
+		assert.Equal(t, 200, status)
+		for _, v := range response.Data.([]interface{}) {
+			v := v.(map[string]interface{})
+			if v["id"] == lates[0] {
+				assert.Equal(t, "pending", v["appeal"].(map[string]interface{})["status"])
+			}
+		}
+	})
+
+	t.Run("Deny Appeal", func(t *testing.T) {
+		denied := appeal(lates[1])
+		status, _ := doRequest("POST", "/hr/appeals/"+denied+"/deny", managerToken, handlers.ReviewAppealRequest{})
+		assert.Equal(t, 400, status, "Note required")
+		status, _ = doRequest("POST", "/hr/appeals/"+denied+"/deny", managerToken, handlers.ReviewAppealRequest{Note: "No evidence"})
+		assert.Equal(t, 200, status)
+		status, _ = doRequest("POST", "/hr/appeals/"+denied+"/accept", managerToken, handlers.ReviewAppealRequest{})
+		assert.Equal(t, 409, status, "Already reviewed")
+
+		var v models.Violation
+		db.First(&v, "id = ?", lates[1])
+		assert.Equal(t, "active", v.Status)
+	})
+
+	t.Run("Accepted Appeal Voids The Violation", func(t *testing.T) {
+		status, response := doRequest("POST", "/hr/appeals/"+appealID+"/accept", managerToken, handlers.ReviewAppealRequest{Note: "Police report attached"})
+		assert.Equal(t, 200, status)
+		assert.Equal(t, float64(1), response.Data.(map[string]interface{})["withdrawn_actions"])
+
+		var v models.Violation
+		db.First(&v, "id = ?", lates[0])
+		assert.Equal(t, "voided", v.Status)
+
+		// The later lates still make three within 30 days
+		open := actions("open")
+		if assert.Len(t, open, 1) {
+			assert.Equal(t, lates[3], open[0].ViolationID)
+		}
+	})
+
+	t.Run("Warning Withdrawn Below Threshold", func(t *testing.T) {
+		status, response := doRequest("POST", "/hr/appeals/"+appeal(lates[2])+"/accept", managerToken, handlers.ReviewAppealRequest{})
+		assert.Equal(t, 200, status)
+		assert.Equal(t, float64(1), response.Data.(map[string]interface{})["withdrawn_actions"])
+		assert.Empty(t, actions("open"))
+		assert.Len(t, actions("withdrawn"), 2)
+
+		// Back at the threshold, the step fires again
+		recordLate(day(0))
+		open := actions("open")
+		if assert.Len(t, open, 1) {
+			status, _ = doRequest("POST", "/hr/disciplinary-actions/"+open[0].ID+"/resolve", managerToken, handlers.ResolveDisciplinaryActionRequest{Note: "Warning letter signed"})
+			assert.Equal(t, 200, status)
+			status, _ = doRequest("POST", "/hr/disciplinary-actions/"+open[0].ID+"/resolve", managerToken, handlers.ResolveDisciplinaryActionRequest{})
+			assert.Equal(t, 409, status)
+		}
+		assert.Len(t, actions("resolved"), 1)
+	})
+
+	t.Run("Pending Salary Is Recomputed", func(t *testing.T) {
+		date := time.Date(2024, 5, 10, 0, 0, 0, 0, time.Local)
+		v := models.Violation{ID: uuid.New().String(), UserID: seller.ID, Type: "late_arrival", Date: date, Severity: "minor", Penalty: 50000, Origin: "manual", RuleVersion: rule.Version, CreatedAt: date, UpdatedAt: date}
+		assert.NoError(t, db.Create(&v).Error)
+		status, _ := doRequest("POST", "/root/payroll/run", rootToken, handlers.RunPayrollRequest{Month: "2024-05"})
+		assert.Equal(t, 200, status)
+		var before models.SalaryApproval
+		db.First(&before, "user_id = ? AND month = ?", seller.ID, time.Date(2024, 5, 1, 0, 0, 0, 0, time.Local))
+
+		status, response := doRequest("POST", "/hr/appeals/"+appeal(v.ID)+"/accept", managerToken, handlers.ReviewAppealRequest{})
+		assert.Equal(t, 200, status)
+		assert.Equal(t, true, response.Data.(map[string]interface{})["salary_recomputed"])
+
+		var after models.SalaryApproval
+		db.Preload("LineItems").First(&after, "id = ?", before.ID)
+		for _, item := range after.LineItems {
+			assert.NotEqual(t, "late_penalty", item.Code)
+		}
+		assert.Less(t, after.Deductions, before.Deductions)
+	})
+
+	t.Run("Approved Salary Is Refunded", func(t *testing.T) {
+		date := time.Date(2024, 3, 10, 0, 0, 0, 0, time.Local)
+		month := time.Date(2024, 3, 1, 0, 0, 0, 0, time.Local)
+		v := models.Violation{ID: uuid.New().String(), UserID: seller.ID, Type: "late_arrival", Date: date, Severity: "minor", Penalty: 50000, Origin: "manual", RuleVersion: rule.Version, CreatedAt: date, UpdatedAt: date}
+		assert.NoError(t, db.Create(&v).Error)
+		salary := models.SalaryApproval{ID: uuid.New().String(), UserID: seller.ID, Month: month, BaseSalary: 10000000, Deductions: 50000, FinalSalary: 9950000, Status: "approved", CreatedAt: month, UpdatedAt: month}
+		assert.NoError(t, db.Create(&salary).Error)
+
+		status, response := doRequest("POST", "/hr/appeals/"+appeal(v.ID)+"/accept", managerToken, handlers.ReviewAppealRequest{})
+		assert.Equal(t, 200, status)
+		refund := response.Data.(map[string]interface{})["refund"].(map[string]interface{})
+		assert.Equal(t, float64(50000), refund["amount"])
+		assert.Equal(t, seller.ID, refund["user_id"])
+
+		var bonus models.PayrollBonus
+		assert.NoError(t, db.First(&bonus, "id = ?", refund["id"]).Error)
+		assert.Equal(t, "2024-04", bonus.Month.Format("2006-01"), "First month after the approved one")
+
+		var unchanged models.SalaryApproval
+		db.First(&unchanged, "id = ?", salary.ID)
+		assert.Equal(t, salary.FinalSalary, unchanged.FinalSalary)
+	})
+
+	t.Run("Reviewers Cannot Review Their Own Appeal", func(t *testing.T) {
+		date := time.Now()
+		v := models.Violation{ID: uuid.New().String(), UserID: manager.ID, Type: "early_leave", Date: date, Severity: "minor", Origin: "manual", RuleVersion: rule.Version, CreatedAt: date, UpdatedAt: date}
+		assert.NoError(t, db.Create(&v).Error)
+		status, response := doRequest("POST", "/employee/violations/"+v.ID+"/appeal", managerToken, handlers.AppealViolationRequest{Reason: "Client meeting"})
+		assert.Equal(t, 200, status)
+		id := response.Data.(map[string]interface{})["id"].(string)
+		status, _ = doRequest("POST", "/hr/appeals/"+id+"/accept", managerToken, handlers.ReviewAppealRequest{})
+		assert.Equal(t, 403, status)
+	})
+
+	// Cleanup
+	db.Where("1 = 1").Delete(&models.DisciplinaryAction{})
+	db.Where("1 = 1").Delete(&models.EscalationStep{})
+	db.Where("1 = 1").Delete(&models.ViolationAppeal{})
+	db.Where("1 = 1").Delete(&models.Violation{})
+	db.Where("1 = 1").Delete(&models.PayrollBonus{})
+	db.Where("1 = 1").Delete(&models.SalaryApproval{})
+	db.Where("1 = 1").Delete(&models.CompanyRule{})
+	for _, u := range []models.User{rootUser, manager, seller, other} {
+		db.Unscoped().Delete(&u)
+	}
+}
@@ -0,0 +1,147 @@
+package test
+
+import (
+	"dapp_timekeeping/handlers"
+	"dapp_timekeeping/middleware"
+	"dapp_timekeeping/models"
+	"dapp_timekeeping/types"
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckInCheckOut(t *testing.T) {
+	app, db := SetupTest(t)
+
+	emp := app.Group("/employee", middleware.RequireAuth)
+	emp.Post("/check-in", handlers.CheckIn)
+	emp.Post("/check-out", handlers.CheckOut)
+
+	employee := models.User{
+		ID:       uuid.New().String(),
+		Nickname: "punch_tester",
+		FullName: "Punch Tester",
+		Role:     "employee",
+		Status:   "active",
+	}
+	if err := db.Create(&employee).Error; err != nil {
+		t.Fatalf("Failed to create employee: %v", err)
+	}
+	token := createTestToken(employee.ID, "employee")
+
+	doRequest := func(path, token string) (int, types.APIResponse) {
+		req := httptest.NewRequest("POST", path, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := app.Test(req)
+		assert.NoError(t, err)
+
+		var response types.APIResponse
+		json.NewDecoder(resp.Body).Decode(&response)
+		t.Logf("%s -> %d %+v", path, resp.StatusCode, response)
+		return resp.StatusCode, response
+	}
+
+	t.Run("Requires Token", func(t *testing.T) {
+		status, _ := doRequest("/employee/check-in", "")
+		assert.Equal(t, 401, status)
+	})
+
+	t.Run("Only Active Employees Check In", func(t *testing.T) {
+		for _, status := range []string{"pending", "left_company"} {
+			db.Model(&employee).Update("status", status)
+			code, _ := doRequest("/employee/check-in", token)
+			assert.Equal(t, 403, code, status)
+		}
+		db.Model(&employee).Update("status", "active")
+	})
+
+	t.Run("Check In", func(t *testing.T) {
+		status, response := doRequest("/employee/check-in", token)
+		assert.Equal(t, 200, status)
+		assert.True(t, response.Success)
+
+		var attendance models.Attendance
+		err := db.Where("user_id = ?", employee.ID).First(&attendance).Error
+		assert.NoError(t, err)
+		assert.Equal(t, !attendance.CheckInTime.After(attendance.ExpectedTime), attendance.OnTime)
+		assert.True(t, attendance.CheckOutTime.IsZero())
+	})
+
+	t.Run("Duplicate Check In Rejected", func(t *testing.T) {
+		status, response := doRequest("/employee/check-in", token)
+		assert.Equal(t, 400, status)
+		assert.Equal(t, "Already checked in", response.Error)
+
+		var count int64
+		db.Model(&models.Attendance{}).Where("user_id = ?", employee.ID).Count(&count)
+		assert.Equal(t, int64(1), count)
+	})
+
+	t.Run("Check Out", func(t *testing.T) {
+		status, response := doRequest("/employee/check-out", token)
+		assert.Equal(t, 200, status)
+		assert.True(t, response.Success)
+
+		var attendance models.Attendance
+		db.Where("user_id = ?", employee.ID).First(&attendance)
+		assert.False(t, attendance.CheckOutTime.IsZero())
+	})
+
+	t.Run("Duplicate Check Out Rejected", func(t *testing.T) {
+		status, response := doRequest("/employee/check-out", token)
+		assert.Equal(t, 400, status)
+		assert.Equal(t, "No open check-in found", response.Error)
+	})
+
+	t.Run("Second Check In Same Day Rejected", func(t *testing.T) {
+		status, response := doRequest("/employee/check-in", token)
+		assert.Equal(t, 400, status)
+		assert.Equal(t, "Already checked in today", response.Error)
+	})
+
+	t.Run("Missed Check Out", func(t *testing.T) {
+		forgetful := models.User{
+			ID:       uuid.New().String(),
+			Nickname: "forgetful",
+			Role:     "employee",
+			Status:   "active",
+		}
+		assert.NoError(t, db.Create(&forgetful).Error)
+		forgetfulToken := createTestToken(forgetful.ID, "employee")
+
+		// Checked in yesterday and never checked out
+		yesterday := time.Now().AddDate(0, 0, -1)
+		stale := models.Attendance{
+			ID:           uuid.New().String(),
+			UserID:       forgetful.ID,
+			CheckInTime:  yesterday,
+			ExpectedTime: yesterday,
+			OnTime:       true,
+		}
+		assert.NoError(t, db.Create(&stale).Error)
+
+		status, _ := doRequest("/employee/check-out", forgetfulToken)
+		assert.Equal(t, 400, status)
+
+		status, _ = doRequest("/employee/check-in", forgetfulToken)
+		assert.Equal(t, 200, status)
+
+		// The stale record keeps its missing check-out
+		var saved models.Attendance
+		db.First(&saved, "id = ?", stale.ID)
+		assert.True(t, saved.CheckOutTime.IsZero())
+
+		db.Unscoped().Delete(&models.Attendance{}, "user_id = ?", forgetful.ID)
+		db.Unscoped().Delete(&forgetful)
+	})
+
+	// Cleanup
+	db.Unscoped().Delete(&models.Attendance{}, "user_id = ?", employee.ID)
+	db.Unscoped().Delete(&employee)
+}
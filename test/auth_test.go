@@ -2,15 +2,20 @@ package test
 
 import (
 	"bytes"
+	"dapp_timekeeping/auth"
+	"dapp_timekeeping/config"
 	"dapp_timekeeping/handlers"
+	"dapp_timekeeping/middleware"
 	"dapp_timekeeping/models"
 	"dapp_timekeeping/types"
 	"encoding/json"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
-	"github.com/golang-jwt/jwt/v4"
+	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
 	"github.com/stretchr/testify/assert"
 )
@@ -18,95 +23,326 @@ import (
 func TestLoginCodeFlow(t *testing.T) {
 	app, db := SetupTest(t)
 
-	// Create root user first
-	rootUser := models.User{
-		ID:       uuid.New().String(),
-		FullName: "Root Admin",
-		Email:    "root@company.com",
-		Role:     "root",
-		Status:   "active",
+	rootUser := models.User{ID: uuid.New().String(), Nickname: "code_root", Role: "root", Status: "active"}
+	employee := models.User{ID: uuid.New().String(), Nickname: "kiosk_user", Role: "employee", Status: "active"}
+	colleague := models.User{ID: uuid.New().String(), Nickname: "colleague", Role: "employee", Status: "active"}
+	for _, u := range []*models.User{&rootUser, &employee, &colleague} {
+		assert.NoError(t, db.Create(u).Error)
 	}
-	if err := db.Create(&rootUser).Error; err != nil {
-		t.Fatalf("Failed to create root user: %v", err)
+
+	root := app.Group("/root", middleware.RequireRoot)
+	root.Post("/login-codes", handlers.IssueLoginCode)
+	root.Get("/login-codes", handlers.ListLoginCodes)
+	root.Delete("/login-codes/:id", handlers.RevokeLoginCode)
+	app.Post("/login/code", handlers.LoginWithCode)
+	rootToken := createTestToken(rootUser.ID, "root")
+
+	doRequest := func(method, path, token string, payload interface{}) (int, types.APIResponse) {
+		b, _ := json.Marshal(payload)
+		req := httptest.NewRequest(method, path, bytes.NewBuffer(b))
+		req.Header.Set("Content-Type", "application/json")
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := app.Test(req)
+		assert.NoError(t, err)
+
+		var response types.APIResponse
+		json.NewDecoder(resp.Body).Decode(&response)
+		t.Logf("%s %s -> %d %s", method, path, resp.StatusCode, response.Error)
+		return resp.StatusCode, response
+	}
+	issue := func(userID, device string) (id, code string) {
+		status, response := doRequest("POST", "/root/login-codes", rootToken, handlers.IssueLoginCodeRequest{UserID: userID, Device: device})
+		assert.Equal(t, 200, status)
+		data := response.Data.(map[string]interface{})
+		return data["id"].(string), data["code"].(string)
 	}
-	t.Logf("Created root user: %+v", rootUser)
-
-	// Set up routes with middleware
-	app.Get("/auth/active-code", func(c *fiber.Ctx) error {
-		c.Locals("claims", jwt.MapClaims{
-			"role": "root",
-			"id":   rootUser.ID,
-		})
-		return handlers.GetActiveCode(c)
+	login := func(nickname, code string) (int, types.APIResponse) {
+		return doRequest("POST", "/login/code", "", handlers.LoginWithCodeRequest{Nickname: nickname, Code: code})
+	}
+
+	t.Run("Code Logs In Its User Once", func(t *testing.T) {
+		_, code := issue(employee.ID, "front-desk")
+
+		var stored models.LoginCode
+		assert.NoError(t, db.First(&stored, "user_id = ?", employee.ID).Error)
+		assert.NotContains(t, stored.CodeHash, code, "Only a hash of the code is stored")
+
+		status, _ := login("colleague", code)
+		assert.Equal(t, 401, status, "Codes only work for the user they were issued to")
+
+		status, response := login("kiosk_user", code)
+		assert.Equal(t, 200, status)
+		claims, err := auth.ParseToken(response.Data.(map[string]interface{})["token"].(string))
+		assert.NoError(t, err)
+		assert.Equal(t, employee.ID, claims.UserID())
+		assert.Equal(t, "employee", claims.Role)
+
+		status, _ = login("kiosk_user", code)
+		assert.Equal(t, 401, status, "Codes are single use")
 	})
-	app.Post("/auth/login-with-code", handlers.LoginWithCode)
 
-	// Generate root token for auth
-	rootToken := createTestToken(rootUser.ID, "root")
+	t.Run("Codes Per Device", func(t *testing.T) {
+		_, desk := issue(employee.ID, "front-desk")
+		_, replaced := issue(employee.ID, "warehouse")
+		_, warehouse := issue(employee.ID, "warehouse")
+
+		status, _ := login("kiosk_user", replaced)
+		assert.Equal(t, 401, status, "A new code replaces the unused one for the device")
+		status, _ = login("kiosk_user", warehouse)
+		assert.Equal(t, 200, status)
+		status, _ = login("kiosk_user", desk)
+		assert.Equal(t, 200, status)
+	})
+
+	t.Run("Expired And Revoked Codes", func(t *testing.T) {
+		_, expired := issue(employee.ID, "")
+		db.Model(&models.LoginCode{}).Where("used_at IS NULL").Update("expires_at", time.Now().Add(-time.Minute))
+		status, _ := login("kiosk_user", expired)
+		assert.Equal(t, 401, status)
 
-	// Root gets initial active code
-	req := httptest.NewRequest("GET", "/auth/active-code", nil)
-	req.Header.Set("Authorization", "Bearer "+rootToken)
-	resp, err := app.Test(req)
-	assert.NoError(t, err)
-	assert.Equal(t, 200, resp.StatusCode)
-
-	var codeResponse types.APIResponse
-	err = json.NewDecoder(resp.Body).Decode(&codeResponse)
-	assert.NoError(t, err)
-	assert.True(t, codeResponse.Success)
-
-	initialCodeData := codeResponse.Data.(map[string]interface{})
-	code := initialCodeData["code"].(string)
-	t.Logf("Initial active code generated: %s", code)
-	t.Logf("Root sees initial code details: %+v", initialCodeData)
-
-	// Simulate employee using the code to login
-	loginReq := struct {
-		Code string `json:"code"`
-	}{
-		Code: code,
+		id, revoked := issue(employee.ID, "")
+		status, _ = doRequest("DELETE", "/root/login-codes/"+id, rootToken, nil)
+		assert.Equal(t, 200, status)
+		status, _ = login("kiosk_user", revoked)
+		assert.Equal(t, 401, status)
+
+		status, response := doRequest("GET", "/root/login-codes?user_id="+employee.ID, rootToken, nil)
+		assert.Equal(t, 200, status)
+		for _, c := range response.Data.([]interface{}) {
+			assert.NotContains(t, c.(map[string]interface{}), "code", "Listed codes cannot be read back")
+		}
+	})
+
+	t.Run("Brute Force Locks Account", func(t *testing.T) {
+		_, code := issue(colleague.ID, "")
+		db.Model(&models.User{}).Where("id = ?", colleague.ID).Update("failed_logins", 0)
+		for i := 0; i < config.AppConfig.MaxFailedLogins; i++ {
+			status, _ := login("colleague", "AAAAAAAA")
+			assert.Equal(t, 401, status)
+		}
+		status, _ := login("colleague", code)
+		assert.Equal(t, 423, status)
+	})
+
+	// Cleanup
+	db.Exec("DELETE FROM login_codes")
+	for _, u := range []models.User{rootUser, employee, colleague} {
+		db.Unscoped().Delete(&u)
+	}
+}
+
+func TestAccessTokens(t *testing.T) {
+	app, _ := SetupTest(t)
+	app.Get("/me", middleware.RequireAuth, func(c *fiber.Ctx) error {
+		claims, ok := auth.ClaimsFrom(c)
+		if !ok {
+			return c.SendStatus(500)
+		}
+		return c.JSON(fiber.Map{"sub": claims.UserID(), "role": claims.Role, "user_id": c.Locals("user_id")})
+	})
+
+	get := func(token string) (int, map[string]interface{}) {
+		req := httptest.NewRequest("GET", "/me", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := app.Test(req)
+		assert.NoError(t, err)
+		var body map[string]interface{}
+		json.NewDecoder(resp.Body).Decode(&body)
+		return resp.StatusCode, body
 	}
-	body, _ := json.Marshal(loginReq)
-	req = httptest.NewRequest("POST", "/auth/login-with-code", bytes.NewBuffer(body))
-	req.Header.Set("Content-Type", "application/json")
-
-	resp, err = app.Test(req)
-	assert.NoError(t, err)
-	assert.Equal(t, 200, resp.StatusCode)
-
-	// Verify login response
-	var loginResponse types.APIResponse
-	err = json.NewDecoder(resp.Body).Decode(&loginResponse)
-	assert.NoError(t, err)
-	assert.True(t, loginResponse.Success)
-
-	// Log response data
-	t.Logf("Login response: %+v", loginResponse.Data)
-
-	// Get the new code from login response
-	responseData := loginResponse.Data.(map[string]interface{})
-	assert.NotEmpty(t, responseData["token"])
-	newCodeData := responseData["newCode"].(map[string]interface{})
-	assert.NotEmpty(t, newCodeData["code"])
-
-	// After login, verify root sees the same new code
-	req = httptest.NewRequest("GET", "/auth/active-code", nil)
-	req.Header.Set("Authorization", "Bearer "+rootToken)
-	resp, err = app.Test(req)
-	assert.NoError(t, err)
-	assert.Equal(t, 200, resp.StatusCode)
-
-	var activeCodeResp types.APIResponse
-	err = json.NewDecoder(resp.Body).Decode(&activeCodeResp)
-	assert.NoError(t, err)
-	assert.True(t, activeCodeResp.Success)
-
-	// Compare the code from login response with what root sees
-	activeCode := activeCodeResp.Data.(map[string]interface{})
-	assert.Equal(t, newCodeData["code"], activeCode["code"], "Root should see the same code as login response")
-	t.Logf("Root can see active code: %v", activeCode)
+
+	userID := uuid.New().String()
+
+	t.Run("Standard Claims", func(t *testing.T) {
+		token, claims, err := auth.IssueToken(userID, "hr_manager")
+		assert.NoError(t, err)
+		assert.Equal(t, userID, claims.Subject)
+		assert.NotEmpty(t, claims.ID)
+		assert.NotNil(t, claims.IssuedAt)
+		assert.True(t, claims.ExpiresAt.After(time.Now()))
+
+		parsed, err := auth.ParseToken(token)
+		assert.NoError(t, err)
+		assert.Equal(t, claims.ID, parsed.ID)
+		assert.Equal(t, "hr_manager", parsed.Role)
+
+		_, again, _ := auth.IssueToken(userID, "hr_manager")
+		assert.NotEqual(t, claims.ID, again.ID, "Every token has its own ID")
+	})
+
+	t.Run("Claims In Context", func(t *testing.T) {
+		status, body := get(createTestToken(userID, "employee"))
+		assert.Equal(t, 200, status)
+		assert.Equal(t, userID, body["sub"])
+		assert.Equal(t, userID, body["user_id"])
+		assert.Equal(t, "employee", body["role"])
+	})
+
+	t.Run("Rejected Tokens", func(t *testing.T) {
+		sign := func(method jwt.SigningMethod, key interface{}, claims jwt.Claims) string {
+			token, err := jwt.NewWithClaims(method, claims).SignedString(key)
+			assert.NoError(t, err)
+			return token
+		}
+		valid := jwt.RegisteredClaims{
+			Subject:   userID,
+			ID:        uuid.New().String(),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+		}
+		expired := valid
+		expired.ExpiresAt = jwt.NewNumericDate(time.Now().Add(-time.Minute))
+		noExpiry := valid
+		noExpiry.ExpiresAt = nil
+
+		tokens := map[string]string{
+			"other secret": sign(jwt.SigningMethodHS256, []byte("another secret"), valid),
+			"unsigned":     sign(jwt.SigningMethodNone, jwt.UnsafeAllowNoneSignatureType, valid),
+			"expired":      sign(jwt.SigningMethodHS256, []byte(config.AppConfig.JWTSecret), expired),
+			"no expiry":    sign(jwt.SigningMethodHS256, []byte(config.AppConfig.JWTSecret), noExpiry),
+			"legacy":       sign(jwt.SigningMethodHS256, []byte(config.AppConfig.JWTSecret), jwt.MapClaims{"user_id": userID, "role": "root", "exp": time.Now().Add(time.Hour).Unix()}),
+		}
+		for name, token := range tokens {
+			status, _ := get(token)
+			assert.Equal(t, 401, status, name)
+		}
+	})
+}
+
+func TestPasswordLogin(t *testing.T) {
+	app, db := SetupTest(t)
+	app.Post("/register", handlers.Register)
+	app.Post("/login", handlers.Login)
+	app.Put("/employee/password", middleware.RequireAuth, handlers.ChangePassword)
+
+	pending := models.User{ID: uuid.New().String(), Nickname: "new_hire", Role: "employee", Status: "pending"}
+	leaver := models.User{ID: uuid.New().String(), Nickname: "leaver", Role: "employee", Status: "left_company"}
+	other := models.User{ID: uuid.New().String(), Nickname: "other_hire", Role: "employee", Status: "pending"}
+	for _, u := range []*models.User{&pending, &leaver, &other} {
+		assert.NoError(t, db.Create(u).Error)
+	}
+	codes := map[string]string{}
+	for _, u := range []models.User{pending, leaver, other} {
+		code := models.ReferralCode{Code: strings.ToUpper(u.Nickname), UserID: u.ID, ExpiresAt: time.Now().Add(time.Hour), CreatedAt: time.Now()}
+		assert.NoError(t, db.Omit("User").Create(&code).Error)
+		codes[u.Nickname] = code.Code
+	}
+
+	doRequest := func(method, path, token string, payload interface{}) (int, types.APIResponse) {
+		b, _ := json.Marshal(payload)
+		req := httptest.NewRequest(method, path, bytes.NewBuffer(b))
+		req.Header.Set("Content-Type", "application/json")
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := app.Test(req, -1)
+		assert.NoError(t, err)
+
+		var response types.APIResponse
+		json.NewDecoder(resp.Body).Decode(&response)
+		t.Logf("%s %s -> %d %+v", method, path, resp.StatusCode, response.Error)
+		return resp.StatusCode, response
+	}
+	login := func(password string) (int, types.APIResponse) {
+		return doRequest("POST", "/login", "", handlers.LoginRequest{Nickname: "new_hire", Password: password})
+	}
+
+	t.Run("Register Pending User", func(t *testing.T) {
+		status, _ := doRequest("POST", "/register", "", handlers.RegisterRequest{Nickname: "new_hire", Code: codes["new_hire"], Password: "short"})
+		assert.Equal(t, 400, status)
+		status, _ = doRequest("POST", "/register", "", handlers.RegisterRequest{Nickname: "new_hire", Password: "correct horse"})
+		assert.Equal(t, 400, status, "The referral code is required")
+		status, _ = doRequest("POST", "/register", "", handlers.RegisterRequest{Nickname: "nobody", Code: codes["new_hire"], Password: "correct horse"})
+		assert.Equal(t, 404, status)
+		status, _ = doRequest("POST", "/register", "", handlers.RegisterRequest{Nickname: "leaver", Code: codes["leaver"], Password: "correct horse"})
+		assert.Equal(t, 409, status)
+
+		// Another new hire knows the nickname but only holds their own code
+		status, _ = doRequest("POST", "/register", "", handlers.RegisterRequest{Nickname: "new_hire", Code: codes["other_hire"], Password: "stolen account"})
+		assert.Equal(t, 403, status, "A pending record cannot be claimed without its code")
+		status, _ = doRequest("POST", "/register", "", handlers.RegisterRequest{Nickname: "new_hire", Code: "WRONGONE", Password: "stolen account"})
+		assert.Equal(t, 403, status)
+		status, _ = doRequest("POST", "/login", "", handlers.LoginRequest{Nickname: "new_hire", Password: "stolen account"})
+		assert.Equal(t, 401, status)
+
+		status, _ = doRequest("POST", "/register", "", handlers.RegisterRequest{Nickname: "new_hire", Code: strings.ToLower(codes["new_hire"]), Password: "correct horse"})
+		assert.Equal(t, 200, status)
+		status, _ = doRequest("POST", "/register", "", handlers.RegisterRequest{Nickname: "new_hire", Code: codes["new_hire"], Password: "battery staple"})
+		assert.Equal(t, 409, status, "A registered account cannot be taken over")
+
+		var user models.User
+		db.First(&user, "id = ?", pending.ID)
+		assert.NotEmpty(t, user.PasswordHash)
+		assert.NotContains(t, user.PasswordHash, "correct horse")
+	})
+
+	t.Run("Login Returns Token", func(t *testing.T) {
+		status, _ := login("correct horse")
+		assert.Equal(t, 401, status, "Pending users sign in only after redeeming their referral code")
+		db.Model(&models.User{}).Where("id = ?", pending.ID).Update("status", "active")
+
+		status, response := login("correct horse")
+		assert.Equal(t, 200, status)
+		data := response.Data.(map[string]interface{})
+		claims, err := auth.ParseToken(data["token"].(string))
+		assert.NoError(t, err)
+		assert.Equal(t, pending.ID, claims.UserID())
+		assert.Equal(t, "employee", claims.Role)
+		assert.NotContains(t, data["user"], "password_hash")
+
+		status, _ = login("wrong password")
+		assert.Equal(t, 401, status)
+		status, _ = doRequest("POST", "/login", "", handlers.LoginRequest{Nickname: "nobody", Password: "correct horse"})
+		assert.Equal(t, 401, status)
+	})
+
+	t.Run("Change Password", func(t *testing.T) {
+		_, current := login("correct horse")
+		_, other := login("correct horse")
+		token := current.Data.(map[string]interface{})["token"].(string)
+		otherToken := other.Data.(map[string]interface{})["token"].(string)
+
+		status, _ := doRequest("PUT", "/employee/password", token, handlers.ChangePasswordRequest{CurrentPassword: "wrong password", NewPassword: "battery staple"})
+		assert.Equal(t, 401, status)
+		status, _ = doRequest("PUT", "/employee/password", token, handlers.ChangePasswordRequest{CurrentPassword: "correct horse", NewPassword: "battery staple"})
+		assert.Equal(t, 200, status)
+
+		// Only the session that changed the password stays signed in
+		status, _ = doRequest("PUT", "/employee/password", otherToken, handlers.ChangePasswordRequest{CurrentPassword: "battery staple", NewPassword: "battery staple"})
+		assert.Equal(t, 401, status)
+		var live int64
+		db.Model(&models.RefreshToken{}).Where("user_id = ? AND revoked_at IS NULL", pending.ID).Count(&live)
+		assert.Equal(t, int64(1), live)
+
+		status, _ = login("correct horse")
+		assert.Equal(t, 401, status)
+		status, _ = login("battery staple")
+		assert.Equal(t, 200, status)
+	})
+
+	t.Run("Lockout After Failed Logins", func(t *testing.T) {
+		for i := 0; i < config.AppConfig.MaxFailedLogins; i++ {
+			status, _ := login("wrong password")
+			assert.Equal(t, 401, status)
+		}
+		status, _ := login("battery staple")
+		assert.Equal(t, 423, status, "Even the right password is refused while locked")
+
+		db.Model(&models.User{}).Where("id = ?", pending.ID).Update("locked_until", time.Now().Add(-time.Second))
+		status, _ = login("battery staple")
+		assert.Equal(t, 200, status)
+
+		var user models.User
+		db.First(&user, "id = ?", pending.ID)
+		assert.Equal(t, 0, user.FailedLogins)
+		assert.Nil(t, user.LockedUntil)
+	})
 
 	// Cleanup
-	db.Unscoped().Delete(&rootUser)
+	db.Where("1 = 1").Delete(&models.ReferralCode{})
+	for _, u := range []models.User{pending, leaver, other} {
+		db.Unscoped().Delete(&u)
+	}
 }
@@ -0,0 +1,281 @@
+package test
+
+import (
+	"bytes"
+	"dapp_timekeeping/handlers"
+	"dapp_timekeeping/lunar"
+	"dapp_timekeeping/middleware"
+	"dapp_timekeeping/models"
+	"dapp_timekeeping/types"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCalendar(t *testing.T) {
+	app, db := SetupTest(t)
+
+	emp := app.Group("/employee", middleware.RequireAuth)
+	emp.Get("/calendar", handlers.GetMyCalendar)
+	emp.Get("/schedule", handlers.GetMySchedule)
+	root := app.Group("/root", middleware.RequireRoot)
+	root.Get("/calendar", handlers.ListCalendar)
+	root.Post("/calendar", handlers.CreateCalendarDay)
+	root.Post("/calendar/defaults", handlers.LoadDefaultHolidays)
+	root.Post("/calendar/import", handlers.ImportCalendar)
+	root.Delete("/calendar/:id", handlers.DeleteCalendarDay)
+
+	rootUser := models.User{ID: uuid.New().String(), Nickname: "calendar_root", Role: "root", Status: "active"}
+	worker := models.User{ID: uuid.New().String(), Nickname: "calendar_worker", Role: "employee", Department: "Ops", Status: "active"}
+	outsider := models.User{ID: uuid.New().String(), Nickname: "calendar_outsider", Role: "employee", Department: "IT", Status: "active"}
+	for _, u := range []*models.User{&rootUser, &worker, &outsider} {
+		assert.NoError(t, db.Create(u).Error)
+	}
+	rootToken := createTestToken(rootUser.ID, "root")
+	workerToken := createTestToken(worker.ID, "employee")
+	outsiderToken := createTestToken(outsider.ID, "employee")
+
+	send := func(method, path, token, contentType string, body []byte) (int, types.APIResponse) {
+		req := httptest.NewRequest(method, path, bytes.NewBuffer(body))
+		req.Header.Set("Content-Type", contentType)
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := app.Test(req, -1)
+		assert.NoError(t, err)
+
+		var response types.APIResponse
+		json.NewDecoder(resp.Body).Decode(&response)
+		t.Logf("%s %s -> %d %+v", method, path, resp.StatusCode, response.Error)
+		return resp.StatusCode, response
+	}
+	doRequest := func(method, path, token string, payload interface{}) (int, types.APIResponse) {
+		b, _ := json.Marshal(payload)
+		return send(method, path, token, "application/json", b)
+	}
+
+	t.Run("Lunar New Year", func(t *testing.T) {
+		for year, want := range map[int]string{
+			2023: "2023-01-22",
+			2024: "2024-02-10",
+			2025: "2025-01-29",
+			2026: "2026-02-17",
+		} {
+			assert.Equal(t, want, lunar.NewYear(year, time.Local).Format("2006-01-02"))
+		}
+
+		leap, ok := lunar.ToSolar(2023, 2, 1, true, time.Local)
+		assert.True(t, ok, "2023 has a leap second month")
+		assert.Equal(t, "2023-03-22", leap.Format("2006-01-02"))
+		_, ok = lunar.ToSolar(2024, 2, 1, true, time.Local)
+		assert.False(t, ok)
+	})
+
+	t.Run("Default Holidays", func(t *testing.T) {
+		status, _ := doRequest("POST", "/root/calendar/defaults", workerToken, handlers.LoadDefaultHolidaysRequest{Year: 2024})
+		assert.Equal(t, 403, status)
+		status, _ = doRequest("POST", "/root/calendar/defaults", rootToken, handlers.LoadDefaultHolidaysRequest{Year: 1500})
+		assert.Equal(t, 400, status)
+
+		// Eleven holidays, three of them on a weekend: Tết day 1 and 2, and 1 September
+		status, response := doRequest("POST", "/root/calendar/defaults", rootToken, handlers.LoadDefaultHolidaysRequest{Year: 2024})
+		assert.Equal(t, 200, status)
+		assert.Equal(t, float64(14), response.Data.(map[string]interface{})["created"])
+		status, response = doRequest("POST", "/root/calendar/defaults", rootToken, handlers.LoadDefaultHolidaysRequest{Year: 2024})
+		assert.Equal(t, 200, status)
+		assert.Equal(t, float64(0), response.Data.(map[string]interface{})["created"])
+		assert.Equal(t, float64(14), response.Data.(map[string]interface{})["skipped"])
+
+		holidays := func(year string) map[string]string {
+			status, response := doRequest("GET", "/root/calendar?year="+year, rootToken, nil)
+			assert.Equal(t, 200, status)
+			dates := map[string]string{}
+			for _, d := range response.Data.([]interface{}) {
+				day := d.(map[string]interface{})
+				date, _ := time.Parse(time.RFC3339, day["date"].(string))
+				dates[date.In(time.Local).Format("2006-01-02")] = day["name"].(string)
+			}
+			return dates
+		}
+		dates := holidays("2024")
+		assert.Len(t, dates, 14)
+		for _, want := range []string{"2024-02-09", "2024-02-10", "2024-02-13", "2024-04-18", "2024-09-02"} {
+			assert.Contains(t, dates, want)
+		}
+		// Weekend Tết days are made up once Tết is over
+		assert.Equal(t, "Tết Nguyên Đán (day 1) (substitute day)", dates["2024-02-14"])
+		assert.Equal(t, "Tết Nguyên Đán (day 2) (substitute day)", dates["2024-02-15"])
+		assert.Equal(t, "National Day (substitute day)", dates["2024-09-03"])
+		assert.NotContains(t, dates, "2024-02-16")
+
+		status, _ = doRequest("POST", "/root/calendar/defaults", rootToken, handlers.LoadDefaultHolidaysRequest{Year: 2027})
+		assert.Equal(t, 200, status)
+		dates = holidays("2027")
+		assert.Equal(t, "International Labour Day (substitute day)", dates["2027-05-03"], "1 May 2027 is a Saturday")
+	})
+
+	t.Run("Manual Entries", func(t *testing.T) {
+		status, _ := doRequest("POST", "/root/calendar", rootToken, handlers.CreateCalendarDayRequest{Date: "2024-04-27", Kind: "party", Name: "Party"})
+		assert.Equal(t, 400, status)
+		status, _ = doRequest("POST", "/root/calendar", rootToken, handlers.CreateCalendarDayRequest{Date: "2024-04-30", Kind: "closure", Name: "Again"})
+		assert.Equal(t, 409, status)
+
+		for _, req := range []handlers.CreateCalendarDayRequest{
+			{Date: "2024-04-27", Kind: "working_day", Name: "Make-up day"},
+			{Date: "2024-04-26", Kind: "closure", Name: "Warehouse move", Department: "Ops"},
+			{Date: "2024-05-01", Kind: "working_day", Name: "Inventory", Department: "Ops"},
+		} {
+			status, _ = doRequest("POST", "/root/calendar", rootToken, req)
+			assert.Equal(t, 200, status)
+		}
+
+		status, response := doRequest("POST", "/root/calendar", rootToken, handlers.CreateCalendarDayRequest{Date: "2024-06-03", Kind: "closure", Name: "Mistake"})
+		assert.Equal(t, 200, status)
+		id := response.Data.(map[string]interface{})["id"].(string)
+		status, _ = doRequest("DELETE", "/root/calendar/"+id, rootToken, nil)
+		assert.Equal(t, 200, status)
+		status, _ = doRequest("DELETE", "/root/calendar/"+id, rootToken, nil)
+		assert.Equal(t, 404, status)
+	})
+
+	t.Run("Department Overrides", func(t *testing.T) {
+		status, response := doRequest("GET", "/employee/calendar?year=2024", workerToken, nil)
+		assert.Equal(t, 200, status)
+		kinds := map[string]string{}
+		for _, d := range response.Data.([]interface{}) {
+			day := d.(map[string]interface{})
+			date, _ := time.Parse(time.RFC3339, day["date"].(string))
+			kinds[date.In(time.Local).Format("2006-01-02")] = day["kind"].(string)
+		}
+		assert.Equal(t, "working_day", kinds["2024-05-01"])
+		assert.Equal(t, "closure", kinds["2024-04-26"])
+		assert.Equal(t, "holiday", kinds["2024-04-30"])
+
+		status, response = doRequest("GET", "/employee/calendar?year=2024", outsiderToken, nil)
+		assert.Equal(t, 200, status)
+		assert.Len(t, response.Data, 15, "Defaults and the make-up day only")
+	})
+
+	t.Run("Schedule Follows Calendar", func(t *testing.T) {
+		schedule := func(token string) map[string]map[string]interface{} {
+			status, response := doRequest("GET", "/employee/schedule?from=2024-04-26&to=2024-05-01", token, nil)
+			assert.Equal(t, 200, status)
+			days := map[string]map[string]interface{}{}
+			for _, d := range response.Data.([]interface{}) {
+				day := d.(map[string]interface{})
+				days[day["date"].(string)] = day
+			}
+			return days
+		}
+
+		ops := schedule(workerToken)
+		assert.Equal(t, float64(0), ops["2024-04-26"]["expected_hours"], "Department closure")
+		assert.Equal(t, "Warehouse move", ops["2024-04-26"]["calendar"])
+		assert.Equal(t, float64(8), ops["2024-04-27"]["expected_hours"], "Make-up Saturday")
+		assert.Equal(t, float64(0), ops["2024-04-28"]["expected_hours"])
+		assert.Equal(t, float64(8), ops["2024-04-29"]["expected_hours"])
+		assert.Equal(t, float64(0), ops["2024-04-30"]["expected_hours"], "Public holiday")
+		assert.Equal(t, float64(8), ops["2024-05-01"]["expected_hours"], "Department working day")
+
+		it := schedule(outsiderToken)
+		assert.Equal(t, float64(8), it["2024-04-26"]["expected_hours"])
+		assert.Equal(t, float64(8), it["2024-04-27"]["expected_hours"])
+		assert.Equal(t, float64(0), it["2024-05-01"]["expected_hours"])
+	})
+
+	t.Run("iCalendar Import", func(t *testing.T) {
+		ics := strings.Join([]string{
+			"BEGIN:VCALENDAR",
+			"VERSION:2.0",
+			"BEGIN:VEVENT",
+			"DTSTART;VALUE=DATE:20240610",
+			"DTEND;VALUE=DATE:20240613",
+			"SUMMARY:Server room\\, ",
+			" maintenance",
+			"END:VEVENT",
+			"BEGIN:VEVENT",
+			"DTSTART:20240701T090000Z",
+			"SUMMARY:Offsite",
+			"END:VEVENT",
+			"END:VCALENDAR",
+		}, "\r\n")
+
+		status, _ := send("POST", "/root/calendar/import", rootToken, "text/calendar", []byte("BEGIN:VCALENDAR\r\nEND:VCALENDAR"))
+		assert.Equal(t, 400, status)
+		status, _ = send("POST", "/root/calendar/import?kind=party", rootToken, "text/calendar", []byte(ics))
+		assert.Equal(t, 400, status)
+
+		status, response := send("POST", "/root/calendar/import?kind=closure&department=IT", rootToken, "text/calendar", []byte(ics))
+		assert.Equal(t, 200, status)
+		assert.Equal(t, float64(4), response.Data.(map[string]interface{})["created"])
+		status, response = send("POST", "/root/calendar/import?kind=closure&department=IT", rootToken, "text/calendar", []byte(ics))
+		assert.Equal(t, 200, status)
+		assert.Equal(t, float64(4), response.Data.(map[string]interface{})["skipped"])
+
+		var days []models.CalendarDay
+		assert.NoError(t, db.Where("source = ?", "ical").Order("date").Find(&days).Error)
+		if assert.Len(t, days, 4) {
+			assert.Equal(t, "Server room, maintenance", days[0].Name)
+			assert.Equal(t, "IT", days[0].Department)
+			assert.Equal(t, "2024-06-12", days[2].Date.In(time.Local).Format("2006-01-02"))
+			assert.Equal(t, "Offsite", days[3].Name)
+		}
+	})
+
+	t.Run("iCalendar Time Zones", func(t *testing.T) {
+		vietnam, err := time.LoadLocation("Asia/Ho_Chi_Minh")
+		assert.NoError(t, err)
+		local := time.Local
+		time.Local = vietnam
+		defer func() { time.Local = local }()
+
+		ics := strings.Join([]string{
+			"BEGIN:VCALENDAR",
+			"BEGIN:VEVENT",
+			"DTSTART:20250101T170000Z",
+			"SUMMARY:UTC evening",
+			"END:VEVENT",
+			"BEGIN:VEVENT",
+			`DTSTART;TZID="America/New_York":20250110T230000`,
+			"DTEND;TZID=America/New_York:20250111T010000",
+			"SUMMARY:New York night",
+			"END:VEVENT",
+			"BEGIN:VEVENT",
+			"DTSTART:20250120T090000",
+			"DTEND:20250121T100000",
+			"SUMMARY:Overnight",
+			"END:VEVENT",
+			"END:VCALENDAR",
+		}, "\r\n")
+
+		unknown := strings.Replace(ics, "America/New_York", "Mars/Olympus_Mons", 1)
+		status, _ := send("POST", "/root/calendar/import?department=HR", rootToken, "text/calendar", []byte(unknown))
+		assert.Equal(t, 400, status)
+
+		status, response := send("POST", "/root/calendar/import?department=HR", rootToken, "text/calendar", []byte(ics))
+		assert.Equal(t, 200, status)
+		assert.Equal(t, float64(4), response.Data.(map[string]interface{})["created"])
+
+		var days []models.CalendarDay
+		assert.NoError(t, db.Where("department = ?", "HR").Order("date").Find(&days).Error)
+		var dates []string
+		for _, d := range days {
+			dates = append(dates, d.Date.In(vietnam).Format("2006-01-02")+" "+d.Name)
+		}
+		assert.Equal(t, []string{
+			"2025-01-02 UTC evening",
+			"2025-01-11 New York night",
+			"2025-01-20 Overnight",
+			"2025-01-21 Overnight",
+		}, dates)
+	})
+
+	// Cleanup
+	db.Where("1 = 1").Delete(&models.CalendarDay{})
+	for _, u := range []models.User{rootUser, worker, outsider} {
+		db.Unscoped().Delete(&u)
+	}
+}
@@ -1,6 +1,7 @@
 package test
 
 import (
+	"dapp_timekeeping/auth"
 	"dapp_timekeeping/config"
 	"dapp_timekeeping/handlers"
 	"dapp_timekeeping/models"
@@ -12,7 +13,7 @@ import (
 	"time"
 
 	"github.com/gofiber/fiber/v2"
-	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
 )
@@ -59,6 +60,31 @@ func init() {
 func SetupTest(t *testing.T) (*fiber.App, *gorm.DB) {
 	// Drop existing tables first
 	testDB.Migrator().DropTable(
+		&models.OvertimeRequest{},
+		&models.CalendarDay{},
+		&models.DisciplinaryAction{},
+		&models.EscalationStep{},
+		&models.ViolationAppeal{},
+		&models.Violation{},
+		&models.CompanyRule{},
+		&models.OutboxEntry{},
+		&models.AttendanceAnchorLeaf{},
+		&models.AttendanceAnchor{},
+		&models.LedgerEntry{},
+		&models.WalletChallenge{},
+		&models.RevokedToken{},
+		&models.RefreshToken{},
+		&models.KioskScan{},
+		&models.Kiosk{},
+		&models.LoginCode{},
+		&models.ReferralAttempt{},
+		&models.ReferralCode{},
+		&models.PayrollBonus{},
+		&models.SalaryLineItem{},
+		&models.LeaveLedgerEntry{},
+		&models.ShiftAssignment{},
+		&models.ShiftBreak{},
+		&models.Shift{},
 		&models.UserPermission{},
 		&models.Absence{},
 		&models.Attendance{},
@@ -70,6 +96,9 @@ func SetupTest(t *testing.T) (*fiber.App, *gorm.DB) {
 	)
 
 	// Then create tables in correct order
+	if err := testDB.SetupJoinTable(&models.User{}, "Permissions", &models.UserPermission{}); err != nil {
+		t.Fatalf("Failed to set up permission join table: %v", err)
+	}
 	err := testDB.AutoMigrate(
 		&models.User{},       // Users first
 		&models.Permission{}, // Independent tables
@@ -79,6 +108,31 @@ func SetupTest(t *testing.T) (*fiber.App, *gorm.DB) {
 		&models.UserPermission{},
 		&models.PermissionGrant{},
 		&models.SalaryApproval{},
+		&models.Shift{},
+		&models.ShiftBreak{},
+		&models.ShiftAssignment{},
+		&models.LeaveLedgerEntry{},
+		&models.SalaryLineItem{},
+		&models.PayrollBonus{},
+		&models.ReferralCode{},
+		&models.ReferralAttempt{},
+		&models.LoginCode{},
+		&models.Kiosk{},
+		&models.KioskScan{},
+		&models.RefreshToken{},
+		&models.RevokedToken{},
+		&models.WalletChallenge{},
+		&models.LedgerEntry{},
+		&models.AttendanceAnchor{},
+		&models.AttendanceAnchorLeaf{},
+		&models.OutboxEntry{},
+		&models.CompanyRule{},
+		&models.Violation{},
+		&models.ViolationAppeal{},
+		&models.EscalationStep{},
+		&models.DisciplinaryAction{},
+		&models.CalendarDay{},
+		&models.OvertimeRequest{},
 	)
 	if err != nil {
 		t.Fatalf("Failed to migrate test database: %v", err)
@@ -123,16 +177,55 @@ func GetTestApp() *fiber.App {
 
 // Helper function to create test JWT token
 func createTestToken(userID string, role string) string {
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"user_id": userID,
-		"role":    role,
-		"exp":     time.Now().Add(24 * time.Hour).Unix(),
-	})
-
-	tokenString, err := token.SignedString([]byte(os.Getenv("JWT_SECRET")))
+	token, _, err := auth.IssueToken(userID, role)
 	if err != nil {
 		log.Printf("Error creating test token: %v", err)
 		return ""
 	}
-	return tokenString
+	return token
+}
+
+// attendWorkDays records an on-time day of the default shift for the user on
+// every weekday of the month, except the days given, that has no attendance
+// yet. Payroll runs detect the days without one as no-shows.
+func attendWorkDays(t *testing.T, db *gorm.DB, userID string, month time.Time, except ...int) {
+	skip := make(map[int]bool, len(except))
+	for _, d := range except {
+		skip[d] = true
+	}
+	for day := month; day.Month() == month.Month(); day = day.AddDate(0, 0, 1) {
+		if day.Weekday() == time.Saturday || day.Weekday() == time.Sunday || skip[day.Day()] {
+			continue
+		}
+		var count int64
+		db.Model(&models.Attendance{}).Where("user_id = ? AND check_in_time >= ? AND check_in_time < ?", userID, day, day.AddDate(0, 0, 1)).Count(&count)
+		if count > 0 {
+			continue
+		}
+		checkIn := day.Add(9 * time.Hour)
+		if err := db.Create(&models.Attendance{ID: uuid.New().String(), UserID: userID, CheckInTime: checkIn, CheckOutTime: day.Add(18 * time.Hour), ExpectedTime: checkIn, OnTime: true, CreatedAt: checkIn, UpdatedAt: checkIn}).Error; err != nil {
+			t.Fatalf("Failed to create attendance: %v", err)
+		}
+	}
+}
+
+// withTestRole stands in for the permission middleware in tests that call
+// handlers directly. The role comes from the X-Test-Role header: root holds
+// every permission, HR managers may update employee profiles.
+func withTestRole(defaultRole string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		role := c.Get("X-Test-Role", defaultRole)
+		permissions := handlers.PermissionSet{}
+		switch role {
+		case "root":
+			for _, name := range []string{"attendance_approval", "leave_approval", "salary_management", "employee_management"} {
+				permissions[name] = handlers.PermissionScope{All: true}
+			}
+		case "hr":
+			permissions["employee_management"] = handlers.PermissionScope{All: true}
+		}
+		c.Locals("role", role)
+		c.Locals("permissions", permissions)
+		return c.Next()
+	}
 }
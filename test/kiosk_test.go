@@ -0,0 +1,151 @@
+package test
+
+import (
+	"bytes"
+	"dapp_timekeeping/config"
+	"dapp_timekeeping/handlers"
+	"dapp_timekeeping/middleware"
+	"dapp_timekeeping/models"
+	"dapp_timekeeping/types"
+	"encoding/base64"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKioskCheckIn(t *testing.T) {
+	app, db := SetupTest(t)
+
+	root := app.Group("/root", middleware.RequireRoot)
+	root.Post("/kiosks", handlers.CreateKiosk)
+	root.Delete("/kiosks/:id", handlers.DeactivateKiosk)
+	app.Get("/kiosk/code", middleware.RequireKiosk, handlers.GetKioskCode)
+	emp := app.Group("/employee", middleware.RequireAuth)
+	emp.Post("/check-in", handlers.CheckIn)
+	emp.Post("/check-in/kiosk", handlers.KioskCheckIn)
+
+	rootUser := models.User{ID: uuid.New().String(), Nickname: "kiosk_root", Role: "root", Status: "active"}
+	alice := models.User{ID: uuid.New().String(), Nickname: "alice", Role: "employee", Status: "active"}
+	bob := models.User{ID: uuid.New().String(), Nickname: "bob", Role: "employee", Status: "active"}
+	carol := models.User{ID: uuid.New().String(), Nickname: "carol", Role: "employee", Status: "active"}
+	for _, u := range []*models.User{&rootUser, &alice, &bob, &carol} {
+		assert.NoError(t, db.Create(u).Error)
+	}
+
+	doRequest := func(method, path, token string, payload interface{}) (int, types.APIResponse) {
+		b, _ := json.Marshal(payload)
+		req := httptest.NewRequest(method, path, bytes.NewBuffer(b))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := app.Test(req)
+		assert.NoError(t, err)
+
+		var response types.APIResponse
+		json.NewDecoder(resp.Body).Decode(&response)
+		t.Logf("%s %s -> %d %s", method, path, resp.StatusCode, response.Error)
+		return resp.StatusCode, response
+	}
+
+	status, response := doRequest("POST", "/root/kiosks", createTestToken(rootUser.ID, "root"), handlers.CreateKioskRequest{Name: "Lobby", Location: "HQ"})
+	assert.Equal(t, 200, status)
+	created := response.Data.(map[string]interface{})
+	kioskToken := created["token"].(string)
+	kioskID := created["kiosk"].(map[string]interface{})["id"].(string)
+
+	currentCode := func() string {
+		status, response := doRequest("GET", "/kiosk/code", kioskToken, nil)
+		assert.Equal(t, 200, status)
+		return response.Data.(map[string]interface{})["payload"].(string)
+	}
+	scan := func(user models.User, payload string) (int, types.APIResponse) {
+		return doRequest("POST", "/employee/check-in/kiosk", createTestToken(user.ID, "employee"), handlers.KioskCheckInRequest{Payload: payload})
+	}
+
+	t.Run("Kiosk Token Is Not An Employee Token", func(t *testing.T) {
+		status, _ := doRequest("POST", "/employee/check-in/kiosk", kioskToken, handlers.KioskCheckInRequest{Payload: currentCode()})
+		assert.Equal(t, 403, status)
+		status, _ = doRequest("GET", "/kiosk/code", createTestToken(alice.ID, "employee"), nil)
+		assert.Equal(t, 403, status)
+	})
+
+	t.Run("Check In With Scanned Code", func(t *testing.T) {
+		code := currentCode()
+		status, _ := scan(alice, code)
+		assert.Equal(t, 200, status)
+
+		var scans []models.KioskScan
+		db.Find(&scans, "user_id = ?", alice.ID)
+		if assert.Len(t, scans, 1) {
+			assert.Equal(t, kioskID, scans[0].KioskID)
+			assert.NotEmpty(t, scans[0].AttendanceID)
+		}
+		var attendance models.Attendance
+		assert.NoError(t, db.First(&attendance, "user_id = ?", alice.ID).Error)
+		assert.Equal(t, "kiosk", attendance.Method)
+
+		status, _ = scan(alice, code)
+		assert.Equal(t, 409, status, "A code cannot be replayed")
+	})
+
+	t.Run("Tampered Code", func(t *testing.T) {
+		// Move the code to another location, keeping the signature
+		body, signature, _ := strings.Cut(currentCode(), ".")
+		raw, _ := base64.RawURLEncoding.DecodeString(body)
+		moved := strings.Replace(string(raw), `"l":"HQ"`, `"l":"Home"`, 1)
+		assert.NotEqual(t, string(raw), moved)
+		forged := base64.RawURLEncoding.EncodeToString([]byte(moved)) + "." + signature
+		status, _ := scan(bob, forged)
+		assert.Equal(t, 400, status)
+		status, _ = scan(bob, "not-a-code")
+		assert.Equal(t, 400, status)
+	})
+
+	t.Run("Expired Code", func(t *testing.T) {
+		interval := config.AppConfig.KioskCodeInterval
+		config.AppConfig.KioskCodeInterval = 1
+		defer func() { config.AppConfig.KioskCodeInterval = interval }()
+
+		code := currentCode()
+		time.Sleep(2100 * time.Millisecond)
+		status, _ := scan(bob, code)
+		assert.Equal(t, 410, status)
+	})
+
+	t.Run("Deactivated Kiosk", func(t *testing.T) {
+		code := currentCode()
+		status, _ := doRequest("DELETE", "/root/kiosks/"+kioskID, createTestToken(rootUser.ID, "root"), nil)
+		assert.Equal(t, 200, status)
+
+		status, _ = scan(carol, code)
+		assert.Equal(t, 400, status)
+		status, _ = doRequest("GET", "/kiosk/code", kioskToken, nil)
+		assert.Equal(t, 403, status)
+	})
+
+	t.Run("Kiosk Required", func(t *testing.T) {
+		required := config.AppConfig.RequireKioskCheckIn
+		defer func() { config.AppConfig.RequireKioskCheckIn = required }()
+
+		config.AppConfig.RequireKioskCheckIn = true
+		status, _ := doRequest("POST", "/employee/check-in", createTestToken(bob.ID, "employee"), nil)
+		assert.Equal(t, 403, status, "The plain check-in is closed")
+		config.AppConfig.RequireKioskCheckIn = false
+
+		status, response := doRequest("POST", "/employee/check-in", createTestToken(bob.ID, "employee"), nil)
+		assert.Equal(t, 200, status)
+		assert.Equal(t, "app", response.Data.(map[string]interface{})["method"])
+	})
+
+	// Cleanup
+	db.Exec("DELETE FROM kiosk_scans")
+	db.Exec("DELETE FROM kiosks")
+	db.Exec("DELETE FROM attendances")
+	for _, u := range []models.User{rootUser, alice, bob, carol} {
+		db.Unscoped().Delete(&u)
+	}
+}
@@ -0,0 +1,304 @@
+package test
+
+import (
+	"bytes"
+	"dapp_timekeeping/handlers"
+	"dapp_timekeeping/middleware"
+	"dapp_timekeeping/models"
+	"dapp_timekeeping/types"
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEmployeeLeaveRequests(t *testing.T) {
+	app, db := SetupTest(t)
+
+	emp := app.Group("/employee", middleware.RequireAuth)
+	emp.Post("/leave-request", handlers.RequestLeave)
+	emp.Get("/leave-requests", handlers.GetMyLeaveRequests)
+	emp.Delete("/leave-requests/:id", handlers.CancelLeaveRequest)
+
+	employee := models.User{
+		ID:          uuid.New().String(),
+		Nickname:    "leave_taker",
+		FullName:    "Leave Taker",
+		Role:        "employee",
+		Status:      "active",
+		OnboardDate: time.Now().AddDate(-1, 0, 0),
+	}
+	colleague := models.User{
+		ID:          uuid.New().String(),
+		Nickname:    "colleague",
+		FullName:    "Colleague",
+		Role:        "employee",
+		Status:      "active",
+		OnboardDate: time.Now().AddDate(-1, 0, 0),
+	}
+	assert.NoError(t, db.Create(&employee).Error)
+	assert.NoError(t, db.Create(&colleague).Error)
+	token := createTestToken(employee.ID, "employee")
+
+	doRequest := func(method, path, token string, payload interface{}) (int, types.APIResponse) {
+		body := bytes.NewBuffer(nil)
+		if payload != nil {
+			b, _ := json.Marshal(payload)
+			body = bytes.NewBuffer(b)
+		}
+		req := httptest.NewRequest(method, path, body)
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := app.Test(req)
+		assert.NoError(t, err)
+
+		var response types.APIResponse
+		json.NewDecoder(resp.Body).Decode(&response)
+		t.Logf("%s %s -> %d %+v", method, path, resp.StatusCode, response)
+		return resp.StatusCode, response
+	}
+
+	day := func(offset int) string {
+		return time.Now().AddDate(0, 0, offset).Format("2006-01-02")
+	}
+
+	var leaveID string
+
+	t.Run("Submit Multi-Day Leave", func(t *testing.T) {
+		status, response := doRequest("POST", "/employee/leave-request", token, handlers.LeaveRequest{
+			Type:      "leave_with_permission",
+			StartDate: day(10),
+			EndDate:   day(12),
+			Reason:    "Family trip",
+		})
+		assert.Equal(t, 200, status)
+		leaveID = response.Data.(map[string]interface{})["id"].(string)
+
+		var saved models.Absence
+		assert.NoError(t, db.First(&saved, "id = ?", leaveID).Error)
+		assert.Equal(t, employee.ID, saved.UserID)
+		assert.Equal(t, "pending", saved.Status)
+		assert.Equal(t, day(10), saved.StartDate.Local().Format("2006-01-02"))
+		assert.Equal(t, day(12), saved.EndDate.Local().Format("2006-01-02"))
+	})
+
+	t.Run("Reject Invalid Requests", func(t *testing.T) {
+		cases := []handlers.LeaveRequest{
+			{Type: "resign", StartDate: day(20), Reason: "Not self-service"},
+			{Type: "leave_with_permission", StartDate: day(20), EndDate: day(19), Reason: "Backwards"},
+			{Type: "leave_with_permission", StartDate: day(-2), Reason: "In the past"},
+			{Type: "late_with_permission", StartDate: day(20), EndDate: day(21), Reason: "Late for two days"},
+			{Type: "leave_with_permission", StartDate: day(20)},
+		}
+		for _, req := range cases {
+			status, _ := doRequest("POST", "/employee/leave-request", token, req)
+			assert.Equal(t, 400, status, "Request %+v should be rejected", req)
+		}
+	})
+
+	t.Run("Reject Overlap", func(t *testing.T) {
+		status, _ := doRequest("POST", "/employee/leave-request", token, handlers.LeaveRequest{
+			Type:      "late_with_permission",
+			StartDate: day(12),
+			Reason:    "Dentist",
+		})
+		assert.Equal(t, 409, status)
+
+		// Other employees are unaffected
+		status, _ = doRequest("POST", "/employee/leave-request", createTestToken(colleague.ID, "employee"), handlers.LeaveRequest{
+			Type:      "leave_with_permission",
+			StartDate: day(10),
+			EndDate:   day(12),
+			Reason:    "Conference",
+		})
+		assert.Equal(t, 200, status)
+	})
+
+	t.Run("List Own Requests", func(t *testing.T) {
+		status, response := doRequest("GET", "/employee/leave-requests", token, nil)
+		assert.Equal(t, 200, status)
+		requests := response.Data.([]interface{})
+		assert.Len(t, requests, 1)
+		assert.Equal(t, leaveID, requests[0].(map[string]interface{})["id"])
+	})
+
+	t.Run("Cannot Cancel Others Request", func(t *testing.T) {
+		status, _ := doRequest("DELETE", "/employee/leave-requests/"+leaveID, createTestToken(colleague.ID, "employee"), nil)
+		assert.Equal(t, 404, status)
+	})
+
+	t.Run("Cancel Pending Request", func(t *testing.T) {
+		status, _ := doRequest("DELETE", "/employee/leave-requests/"+leaveID, token, nil)
+		assert.Equal(t, 200, status)
+
+		var count int64
+		db.Model(&models.Absence{}).Where("id = ?", leaveID).Count(&count)
+		assert.Equal(t, int64(0), count)
+	})
+
+	t.Run("Cannot Cancel Processed Request", func(t *testing.T) {
+		approved := models.Absence{
+			ID:          uuid.New().String(),
+			UserID:      employee.ID,
+			Date:        time.Now(),
+			StartDate:   time.Now(),
+			EndDate:     time.Now(),
+			Type:        "leave_with_permission",
+			Reason:      "Approved already",
+			Status:      "approved",
+			ProcessedBy: strPtr(colleague.ID),
+		}
+		assert.NoError(t, db.Create(&approved).Error)
+
+		status, _ := doRequest("DELETE", "/employee/leave-requests/"+approved.ID, token, nil)
+		assert.Equal(t, 409, status)
+	})
+
+	// Cleanup
+	db.Unscoped().Delete(&models.Absence{}, "user_id IN (?)", []string{employee.ID, colleague.ID})
+	db.Unscoped().Delete(&employee)
+	db.Unscoped().Delete(&colleague)
+}
+
+func TestLeaveBalance(t *testing.T) {
+	app, db := SetupTest(t)
+
+	emp := app.Group("/employee", middleware.RequireAuth)
+	emp.Post("/leave-request", handlers.RequestLeave)
+	emp.Get("/leave-balance", handlers.GetLeaveBalance)
+	emp.Get("/leave-ledger", handlers.GetLeaveLedger)
+	root := app.Group("/root", middleware.RequireRoot)
+	root.Post("/leaves/:id/approve", handlers.ApproveLeave)
+	root.Get("/leaves/balance/:id", handlers.GetLeaveBalance)
+	root.Get("/leaves/ledger/:id", handlers.GetLeaveLedger)
+
+	now := time.Now()
+	rootUser := models.User{ID: uuid.New().String(), Nickname: "leave_root", Role: "root", Status: "active"}
+	newcomer := models.User{
+		ID:          uuid.New().String(),
+		Nickname:    "newcomer",
+		Role:        "employee",
+		Status:      "active",
+		OnboardDate: now.AddDate(0, -2, -3),
+	}
+	veteran := models.User{
+		ID:          uuid.New().String(),
+		Nickname:    "veteran",
+		Role:        "employee",
+		Status:      "active",
+		OnboardDate: time.Date(now.Year()-2, 1, 15, 0, 0, 0, 0, time.Local),
+	}
+	for _, u := range []*models.User{&rootUser, &newcomer, &veteran} {
+		assert.NoError(t, db.Create(u).Error)
+	}
+	rootToken := createTestToken(rootUser.ID, "root")
+	newcomerToken := createTestToken(newcomer.ID, "employee")
+
+	doRequest := func(method, path, token string, payload interface{}) (int, types.APIResponse) {
+		body := bytes.NewBuffer(nil)
+		if payload != nil {
+			b, _ := json.Marshal(payload)
+			body = bytes.NewBuffer(b)
+		}
+		req := httptest.NewRequest(method, path, body)
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := app.Test(req)
+		assert.NoError(t, err)
+
+		var response types.APIResponse
+		json.NewDecoder(resp.Body).Decode(&response)
+		t.Logf("%s %s -> %d %+v", method, path, resp.StatusCode, response)
+		return resp.StatusCode, response
+	}
+
+	// Monday at least a week out, so the default Monday-Friday schedule applies
+	monday := now.AddDate(0, 0, 7)
+	for monday.Weekday() != time.Monday {
+		monday = monday.AddDate(0, 0, 1)
+	}
+
+	t.Run("Monthly Accrual", func(t *testing.T) {
+		status, response := doRequest("GET", "/employee/leave-balance", newcomerToken, nil)
+		assert.Equal(t, 200, status)
+		balance := response.Data.(map[string]interface{})
+		assert.Equal(t, float64(2), balance["balance"], "Two full months of service accrue two days")
+		assert.Equal(t, float64(2), balance["available"])
+	})
+
+	t.Run("Overdraw Blocked", func(t *testing.T) {
+		status, response := doRequest("POST", "/employee/leave-request", newcomerToken, handlers.LeaveRequest{
+			Type:      "leave_with_permission",
+			StartDate: monday.Format("2006-01-02"),
+			EndDate:   monday.AddDate(0, 0, 2).Format("2006-01-02"),
+			Reason:    "Three day trip",
+		})
+		assert.Equal(t, 409, status)
+		assert.Equal(t, "Insufficient leave balance", response.Error)
+	})
+
+	t.Run("Approval Debits Balance", func(t *testing.T) {
+		status, response := doRequest("POST", "/employee/leave-request", newcomerToken, handlers.LeaveRequest{
+			Type:      "leave_with_permission",
+			StartDate: monday.Format("2006-01-02"),
+			Reason:    "Day off",
+		})
+		assert.Equal(t, 200, status)
+		absenceID := response.Data.(map[string]interface{})["id"].(string)
+
+		_, response = doRequest("GET", "/employee/leave-balance", newcomerToken, nil)
+		balance := response.Data.(map[string]interface{})
+		assert.Equal(t, float64(1), balance["pending_days"])
+		assert.Equal(t, float64(1), balance["available"])
+
+		status, _ = doRequest("POST", "/root/leaves/"+absenceID+"/approve", rootToken, nil)
+		assert.Equal(t, 200, status)
+
+		_, response = doRequest("GET", "/root/leaves/balance/"+newcomer.ID, rootToken, nil)
+		balance = response.Data.(map[string]interface{})
+		assert.Equal(t, float64(1), balance["balance"])
+		assert.Equal(t, float64(0), balance["pending_days"])
+
+		_, response = doRequest("GET", "/employee/leave-ledger", newcomerToken, nil)
+		ledger := response.Data.([]interface{})
+		assert.Len(t, ledger, 3)
+		last := ledger[2].(map[string]interface{})
+		assert.Equal(t, "debit", last["kind"])
+		assert.Equal(t, absenceID, last["reference"])
+		assert.Equal(t, float64(1), last["balance"])
+	})
+
+	t.Run("Carry Over Capped", func(t *testing.T) {
+		// The veteran accrued 11 days in their first year and 12 in the second;
+		// only 5 carry into each new year
+		accruedThisYear := int(now.Month())
+		if now.Day() < 15 {
+			accruedThisYear--
+		}
+
+		for i := 0; i < 2; i++ {
+			status, response := doRequest("GET", "/root/leaves/balance/"+veteran.ID, rootToken, nil)
+			assert.Equal(t, 200, status)
+			balance := response.Data.(map[string]interface{})
+			assert.Equal(t, float64(5+accruedThisYear), balance["balance"])
+		}
+
+		var expiries []models.LeaveLedgerEntry
+		db.Where("user_id = ? AND kind = ?", veteran.ID, "expiry").Order("effective_date").Find(&expiries)
+		assert.Len(t, expiries, 2)
+		if len(expiries) == 2 {
+			assert.Equal(t, float64(-6), expiries[0].Days)
+			assert.Equal(t, float64(-12), expiries[1].Days)
+		}
+	})
+
+	// Cleanup
+	db.Exec("DELETE FROM leave_ledger_entries")
+	db.Unscoped().Delete(&models.Absence{}, "user_id = ?", newcomer.ID)
+	for _, u := range []models.User{rootUser, newcomer, veteran} {
+		db.Unscoped().Delete(&u)
+	}
+}
@@ -0,0 +1,158 @@
+package test
+
+import (
+	"bytes"
+	"dapp_timekeeping/handlers"
+	"dapp_timekeeping/middleware"
+	"dapp_timekeeping/models"
+	"dapp_timekeeping/types"
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLedger(t *testing.T) {
+	app, db := SetupTest(t)
+
+	emp := app.Group("/employee", middleware.RequireAuth)
+	emp.Post("/check-in", handlers.CheckIn)
+	emp.Post("/check-out", handlers.CheckOut)
+	emp.Post("/leave-request", handlers.RequestLeave)
+	emp.Delete("/leave-requests/:id", handlers.CancelLeaveRequest)
+	root := app.Group("/root", middleware.RequireRoot)
+	root.Post("/leaves/:id/approve", handlers.ApproveLeave)
+	root.Get("/ledger", handlers.GetLedgerEntries)
+	root.Get("/ledger/verify", handlers.VerifyLedger)
+
+	rootUser := models.User{ID: uuid.New().String(), Nickname: "ledger_root", Role: "root", Status: "active"}
+	employee := models.User{ID: uuid.New().String(), Nickname: "ledger_employee", Role: "employee", Status: "active", OnboardDate: time.Now().AddDate(-1, 0, 0)}
+	for _, u := range []*models.User{&rootUser, &employee} {
+		assert.NoError(t, db.Create(u).Error)
+	}
+	rootToken := createTestToken(rootUser.ID, "root")
+	token := createTestToken(employee.ID, "employee")
+
+	doRequest := func(method, path, token string, payload interface{}) (int, types.APIResponse) {
+		b, _ := json.Marshal(payload)
+		req := httptest.NewRequest(method, path, bytes.NewBuffer(b))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := app.Test(req, -1)
+		assert.NoError(t, err)
+
+		var response types.APIResponse
+		json.NewDecoder(resp.Body).Decode(&response)
+		t.Logf("%s %s -> %d %+v", method, path, resp.StatusCode, response.Error)
+		return resp.StatusCode, response
+	}
+	verify := func() map[string]interface{} {
+		status, response := doRequest("GET", "/root/ledger/verify", rootToken, nil)
+		assert.Equal(t, 200, status)
+		return response.Data.(map[string]interface{})
+	}
+	day := func(offset int) string {
+		return time.Now().AddDate(0, 0, offset).Format("2006-01-02")
+	}
+
+	var attendanceID, lateID string
+
+	t.Run("Writes Append Entries", func(t *testing.T) {
+		status, response := doRequest("POST", "/employee/check-in", token, nil)
+		assert.Equal(t, 200, status)
+		attendanceID = response.Data.(map[string]interface{})["id"].(string)
+		status, _ = doRequest("POST", "/employee/check-out", token, nil)
+		assert.Equal(t, 200, status)
+
+		status, response = doRequest("POST", "/employee/leave-request", token, handlers.LeaveRequest{Type: "late_with_permission", StartDate: day(3), Reason: "Dentist"})
+		assert.Equal(t, 200, status)
+		lateID = response.Data.(map[string]interface{})["id"].(string)
+		status, _ = doRequest("POST", "/root/leaves/"+lateID+"/approve", rootToken, nil)
+		assert.Equal(t, 200, status)
+
+		status, response = doRequest("POST", "/employee/leave-request", token, handlers.LeaveRequest{Type: "late_with_permission", StartDate: day(5), Reason: "Traffic"})
+		assert.Equal(t, 200, status)
+		cancelID := response.Data.(map[string]interface{})["id"].(string)
+		status, _ = doRequest("DELETE", "/employee/leave-requests/"+cancelID, token, nil)
+		assert.Equal(t, 200, status)
+
+		var entries []models.LedgerEntry
+		db.Order("seq").Find(&entries)
+		if assert.Len(t, entries, 6) {
+			actions := []string{}
+			for i, e := range entries {
+				actions = append(actions, e.RecordType+" "+e.Action)
+				assert.Equal(t, uint64(i+1), e.Seq)
+				if i > 0 {
+					assert.Equal(t, entries[i-1].EntryHash, e.PrevHash)
+				}
+			}
+			assert.Equal(t, []string{
+				"attendance create", "attendance update",
+				"absence create", "absence update",
+				"absence create", "absence delete",
+			}, actions)
+		}
+
+		status, response = doRequest("GET", "/root/ledger?record_id="+attendanceID, rootToken, nil)
+		assert.Equal(t, 200, status)
+		assert.Len(t, response.Data, 2)
+
+		result := verify()
+		assert.Equal(t, true, result["valid"])
+		assert.Equal(t, float64(6), result["entries"])
+		assert.Equal(t, float64(3), result["records"])
+	})
+
+	t.Run("Detects Edited Record", func(t *testing.T) {
+		var original models.Attendance
+		db.First(&original, "id = ?", attendanceID)
+		db.Model(&models.Attendance{}).Where("id = ?", attendanceID).Update("check_in_time", original.CheckInTime.Add(-time.Hour))
+
+		result := verify()
+		assert.Equal(t, false, result["valid"])
+		assert.Equal(t, float64(2), result["broken_at"])
+		assert.Equal(t, attendanceID, result["record_id"])
+
+		db.Model(&models.Attendance{}).Where("id = ?", attendanceID).Update("check_in_time", original.CheckInTime)
+		assert.Equal(t, true, verify()["valid"])
+
+		// How the check-in was made is covered by the hash too
+		db.Model(&models.Attendance{}).Where("id = ?", attendanceID).Update("method", "kiosk")
+		assert.Equal(t, false, verify()["valid"])
+		db.Model(&models.Attendance{}).Where("id = ?", attendanceID).Update("method", original.Method)
+		assert.Equal(t, true, verify()["valid"])
+	})
+
+	t.Run("Detects Edited Entry", func(t *testing.T) {
+		var entry models.LedgerEntry
+		db.First(&entry, "seq = ?", 3)
+		db.Model(&models.LedgerEntry{}).Where("seq = ?", 3).Update("action", "delete")
+
+		result := verify()
+		assert.Equal(t, false, result["valid"])
+		assert.Equal(t, float64(3), result["broken_at"])
+
+		db.Model(&models.LedgerEntry{}).Where("seq = ?", 3).Update("action", entry.Action)
+		assert.Equal(t, true, verify()["valid"])
+	})
+
+	t.Run("Detects Removed Entry", func(t *testing.T) {
+		db.Where("seq = ?", 4).Delete(&models.LedgerEntry{})
+
+		result := verify()
+		assert.Equal(t, false, result["valid"])
+		assert.Equal(t, float64(5), result["broken_at"])
+	})
+
+	// Cleanup
+	db.Where("1 = 1").Delete(&models.LedgerEntry{})
+	db.Where("user_id = ?", employee.ID).Delete(&models.Attendance{})
+	db.Where("user_id = ?", employee.ID).Delete(&models.Absence{})
+	for _, u := range []models.User{rootUser, employee} {
+		db.Unscoped().Delete(&u)
+	}
+}
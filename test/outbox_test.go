@@ -0,0 +1,225 @@
+package test
+
+import (
+	"bytes"
+	"context"
+	"dapp_timekeeping/anchor"
+	"dapp_timekeeping/config"
+	"dapp_timekeeping/handlers"
+	"dapp_timekeeping/middleware"
+	"dapp_timekeeping/models"
+	"dapp_timekeeping/types"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+type failingSubmitter struct{}
+
+func (failingSubmitter) Submit(ctx context.Context, topic, key, payload string) (anchor.Receipt, error) {
+	return anchor.Receipt{}, errors.New("canister unavailable")
+}
+
+func TestOutbox(t *testing.T) {
+	app, db := SetupTest(t)
+
+	emp := app.Group("/employee", middleware.RequireAuth)
+	emp.Post("/check-in", handlers.CheckIn)
+	root := app.Group("/root", middleware.RequireRoot)
+	root.Post("/payroll/approve", handlers.ApproveSalary)
+	root.Get("/outbox", handlers.ListOutbox)
+	root.Post("/outbox/deliver", handlers.DeliverOutbox)
+	root.Post("/outbox/replay", handlers.ReplayFailedOutbox)
+	root.Get("/outbox/:id", handlers.GetOutboxEntry)
+	root.Post("/outbox/:id/replay", handlers.ReplayOutboxEntry)
+
+	rootUser := models.User{ID: uuid.New().String(), Nickname: "outbox_root", Role: "root", Status: "active"}
+	employee := models.User{ID: uuid.New().String(), Nickname: "outbox_employee", Role: "employee", Status: "active", OnboardDate: time.Now().AddDate(-1, 0, 0)}
+	for _, u := range []*models.User{&rootUser, &employee} {
+		assert.NoError(t, db.Create(u).Error)
+	}
+	rootToken := createTestToken(rootUser.ID, "root")
+	token := createTestToken(employee.ID, "employee")
+
+	doRequest := func(method, path, token string, payload interface{}) (int, types.APIResponse) {
+		b, _ := json.Marshal(payload)
+		req := httptest.NewRequest(method, path, bytes.NewBuffer(b))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := app.Test(req, -1)
+		assert.NoError(t, err)
+
+		var response types.APIResponse
+		json.NewDecoder(resp.Body).Decode(&response)
+		t.Logf("%s %s -> %d %+v", method, path, resp.StatusCode, response.Error)
+		return resp.StatusCode, response
+	}
+	entry := func(id uint64) models.OutboxEntry {
+		var e models.OutboxEntry
+		db.First(&e, "id = ?", id)
+		return e
+	}
+
+	var checkIn models.OutboxEntry
+
+	t.Run("Writes Are Queued In Their Transaction", func(t *testing.T) {
+		status, response := doRequest("POST", "/employee/check-in", token, nil)
+		assert.Equal(t, 200, status)
+		attendanceID := response.Data.(map[string]interface{})["id"].(string)
+
+		month := time.Date(2024, 3, 1, 0, 0, 0, 0, time.Local)
+		salary := models.SalaryApproval{ID: uuid.New().String(), UserID: employee.ID, Month: month, BaseSalary: 10000000, FinalSalary: 10000000, Status: "pending", CreatedAt: month, UpdatedAt: month}
+		assert.NoError(t, db.Create(&salary).Error)
+		status, _ = doRequest("POST", "/root/payroll/approve", rootToken, handlers.ProcessSalaryRequest{IDs: []string{salary.ID}})
+		assert.Equal(t, 200, status)
+		// Already approved, so nothing new is queued
+		status, _ = doRequest("POST", "/root/payroll/approve", rootToken, handlers.ProcessSalaryRequest{IDs: []string{salary.ID}})
+		assert.Equal(t, 200, status)
+
+		var entries []models.OutboxEntry
+		db.Order("id").Find(&entries)
+		if assert.Len(t, entries, 2) {
+			checkIn = entries[0]
+			assert.Equal(t, "attendance", checkIn.Topic)
+			assert.Equal(t, attendanceID, checkIn.RecordID)
+			assert.Equal(t, "pending", checkIn.Status)
+			assert.Contains(t, checkIn.Payload, `"action":"create"`)
+
+			assert.Equal(t, "payroll", entries[1].Topic)
+			assert.Equal(t, salary.ID, entries[1].RecordID)
+			assert.Contains(t, entries[1].Payload, `"status":"approved"`)
+			assert.NotContains(t, entries[1].Payload, "10000000", "Amounts stay off the chain")
+		}
+	})
+
+	t.Run("Failed Delivery Backs Off", func(t *testing.T) {
+		handlers.SetSubmitter(failingSubmitter{})
+		status, response := doRequest("POST", "/root/outbox/deliver", rootToken, nil)
+		assert.Equal(t, 200, status)
+		assert.Equal(t, float64(2), response.Data.(map[string]interface{})["failed"])
+
+		e := entry(checkIn.ID)
+		assert.Equal(t, "pending", e.Status)
+		assert.Equal(t, 1, e.Attempts)
+		assert.Equal(t, "canister unavailable", e.LastError)
+		assert.WithinDuration(t, time.Now().Add(time.Duration(config.AppConfig.OutboxRetryBase*float64(time.Second))), e.NextAttemptAt, 5*time.Second)
+
+		// Not due again yet
+		status, response = doRequest("POST", "/root/outbox/deliver", rootToken, nil)
+		assert.Equal(t, 200, status)
+		assert.Equal(t, float64(0), response.Data.(map[string]interface{})["failed"])
+
+		// The last attempt marks the entry failed
+		db.Model(&models.OutboxEntry{}).Where("1 = 1").Updates(map[string]interface{}{
+			"attempts":        config.AppConfig.OutboxMaxAttempts - 1,
+			"next_attempt_at": time.Now().Add(-time.Second),
+		})
+		doRequest("POST", "/root/outbox/deliver", rootToken, nil)
+		e = entry(checkIn.ID)
+		assert.Equal(t, "failed", e.Status)
+		assert.Equal(t, config.AppConfig.OutboxMaxAttempts, e.Attempts)
+
+		status, response = doRequest("GET", "/root/outbox?status=failed", rootToken, nil)
+		assert.Equal(t, 200, status)
+		assert.Len(t, response.Data, 2)
+	})
+
+	local, err := anchor.NewLocalAnchorer("")
+	assert.NoError(t, err)
+	handlers.SetSubmitter(local)
+
+	t.Run("Replay Delivers Failed Entries", func(t *testing.T) {
+		path := fmt.Sprintf("/root/outbox/%d/replay", checkIn.ID)
+		status, _ := doRequest("POST", path, rootToken, nil)
+		assert.Equal(t, 200, status)
+		e := entry(checkIn.ID)
+		assert.Equal(t, "pending", e.Status)
+		assert.Equal(t, 0, e.Attempts)
+
+		status, _ = doRequest("POST", path, rootToken, nil)
+		assert.Equal(t, 409, status, "Only failed entries can be replayed")
+		status, _ = doRequest("POST", "/root/outbox/999999/replay", rootToken, nil)
+		assert.Equal(t, 404, status)
+
+		status, response := doRequest("POST", "/root/outbox/replay", rootToken, nil)
+		assert.Equal(t, 200, status)
+		assert.Equal(t, float64(1), response.Data.(map[string]interface{})["replayed"])
+
+		status, response = doRequest("POST", "/root/outbox/deliver", rootToken, nil)
+		assert.Equal(t, 200, status)
+		assert.Equal(t, float64(2), response.Data.(map[string]interface{})["delivered"])
+
+		status, response = doRequest("GET", fmt.Sprintf("/root/outbox/%d", checkIn.ID), rootToken, nil)
+		assert.Equal(t, 200, status)
+		data := response.Data.(map[string]interface{})
+		assert.Equal(t, "delivered", data["status"])
+		assert.Equal(t, "local", data["backend"])
+		assert.NotEmpty(t, data["tx_id"])
+
+		events := local.Events()
+		if assert.Len(t, events, 2) {
+			assert.Equal(t, "attendance", events[0].Topic)
+			assert.Equal(t, checkIn.RecordID, events[0].Key)
+			assert.Equal(t, checkIn.Payload, events[0].Payload)
+			assert.Equal(t, data["tx_id"], events[0].TxID)
+		}
+	})
+
+	t.Run("Call Rejected After Acceptance Is Retried", func(t *testing.T) {
+		replica := newFakeReplica()
+		defer replica.Close()
+		icp, err := anchor.NewICPAnchorer(replica.URL, "rrkah-fqaaa-aaaaa-aaaaq-cai", "anchor_attendance_root")
+		assert.NoError(t, err)
+		icp.PollInterval = time.Millisecond
+		handlers.SetSubmitter(icp)
+
+		now := time.Now()
+		e := models.OutboxEntry{Topic: "attendance", RecordID: uuid.New().String(), Payload: `{"seq":2}`, Status: "pending", NextAttemptAt: now, CreatedAt: now, UpdatedAt: now}
+		assert.NoError(t, db.Create(&e).Error)
+
+		// The replica accepts the call but the canister traps
+		replica.statuses = []string{"processing", "rejected"}
+		status, response := doRequest("POST", "/root/outbox/deliver", rootToken, nil)
+		assert.Equal(t, 200, status)
+		assert.Equal(t, float64(0), response.Data.(map[string]interface{})["delivered"])
+		e = entry(e.ID)
+		assert.Equal(t, "pending", e.Status, "Not delivered until the canister replies")
+		assert.Equal(t, 1, e.Attempts)
+		assert.Contains(t, e.LastError, "trapped")
+
+		db.Model(&models.OutboxEntry{}).Where("id = ?", e.ID).Update("next_attempt_at", time.Now().Add(-time.Second))
+		replica.statuses, replica.reads = []string{"processing", "replied"}, 0
+		status, response = doRequest("POST", "/root/outbox/deliver", rootToken, nil)
+		assert.Equal(t, 200, status)
+		assert.Equal(t, float64(1), response.Data.(map[string]interface{})["delivered"])
+		e = entry(e.ID)
+		assert.Equal(t, "delivered", e.Status)
+		assert.Equal(t, "icp", e.Backend)
+		assert.Equal(t, 2, replica.reads)
+	})
+
+	t.Run("List Limit Is Clamped", func(t *testing.T) {
+		for query, want := range map[string]int{"": 3, "?limit=2": 2, "?limit=0": 1, "?limit=-1": 1} {
+			status, response := doRequest("GET", "/root/outbox"+query, rootToken, nil)
+			assert.Equal(t, 200, status)
+			assert.Len(t, response.Data, want, query)
+		}
+	})
+
+	handlers.SetSubmitter(nil)
+
+	// Cleanup
+	db.Where("1 = 1").Delete(&models.OutboxEntry{})
+	db.Where("1 = 1").Delete(&models.LedgerEntry{})
+	db.Where("1 = 1").Delete(&models.SalaryApproval{})
+	db.Where("1 = 1").Delete(&models.Attendance{})
+	for _, u := range []models.User{rootUser, employee} {
+		db.Unscoped().Delete(&u)
+	}
+}
@@ -0,0 +1,222 @@
+package test
+
+import (
+	"bytes"
+	"dapp_timekeeping/handlers"
+	"dapp_timekeeping/middleware"
+	"dapp_timekeeping/models"
+	"dapp_timekeeping/types"
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOvertime(t *testing.T) {
+	app, db := SetupTest(t)
+
+	hr := app.Group("/hr")
+	hr.Get("/overtime-requests", middleware.RequirePermission("overtime_approval"), handlers.ListOvertimeRequests)
+	hr.Post("/overtime-requests/:id/approve", middleware.RequirePermission("overtime_approval"), handlers.ApproveOvertime)
+	hr.Post("/overtime-requests/:id/reject", middleware.RequirePermission("overtime_approval"), handlers.RejectOvertime)
+	hr.Get("/employees/:id/overtime", middleware.RequirePermission("overtime_approval"), handlers.GetEmployeeOvertime)
+	emp := app.Group("/employee", middleware.RequireAuth)
+	emp.Get("/overtime", handlers.GetMyOvertime)
+	emp.Get("/overtime-requests", handlers.GetMyOvertimeRequests)
+	emp.Post("/overtime-requests", handlers.RequestOvertime)
+	emp.Delete("/overtime-requests/:id", handlers.CancelOvertimeRequest)
+	root := app.Group("/root", middleware.RequireRoot)
+	root.Post("/calendar", handlers.CreateCalendarDay)
+	root.Post("/payroll/run", handlers.RunPayroll)
+
+	longAgo := time.Date(2020, 1, 1, 0, 0, 0, 0, time.Local)
+	rootUser := models.User{ID: uuid.New().String(), Nickname: "overtime_root", Role: "root", Status: "active"}
+	manager := models.User{ID: uuid.New().String(), Nickname: "overtime_manager", Role: "hr_manager", Department: "Ops", Status: "active"}
+	worker := models.User{ID: uuid.New().String(), Nickname: "overtime_worker", Role: "employee", Department: "Ops", Status: "active", OnboardDate: longAgo, Salary: 20000000}
+	outsider := models.User{ID: uuid.New().String(), Nickname: "overtime_outsider", Role: "employee", Department: "IT", Status: "active", OnboardDate: longAgo}
+	for _, u := range []*models.User{&rootUser, &manager, &worker, &outsider} {
+		assert.NoError(t, db.Create(u).Error)
+	}
+	rootToken := createTestToken(rootUser.ID, "root")
+	managerToken := createTestToken(manager.ID, "hr_manager")
+	workerToken := createTestToken(worker.ID, "employee")
+
+	// Weekday 09:00-18:00 shift; 20,000,000 over March 2024's 20
+	// working days (21 less the holiday) gives an hourly rate of 125,000
+	shift := models.Shift{ID: uuid.New().String(), Name: "overtime_weekdays", StartTime: "09:00", EndTime: "18:00", WorkDays: "1,2,3,4,5"}
+	assert.NoError(t, db.Create(&shift).Error)
+	workerID := worker.ID
+	assert.NoError(t, db.Create(&models.ShiftAssignment{ID: uuid.New().String(), ShiftID: shift.ID, UserID: &workerID, EffectiveFrom: longAgo}).Error)
+	rule := models.CompanyRule{EffectiveFrom: longAgo, MaxUnpaidAbsences: 3, StandardDailyHours: 8, OvertimeWeekdayRate: 1.5, OvertimeWeekendRate: 2, OvertimeHolidayRate: 3, OvertimeMonthlyCap: 5, CreatedAt: longAgo}
+	assert.NoError(t, db.Create(&rule).Error)
+
+	doRequest := func(method, path, token string, payload interface{}) (int, types.APIResponse) {
+		b, _ := json.Marshal(payload)
+		req := httptest.NewRequest(method, path, bytes.NewBuffer(b))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := app.Test(req, -1)
+		assert.NoError(t, err)
+
+		var response types.APIResponse
+		json.NewDecoder(resp.Body).Decode(&response)
+		t.Logf("%s %s -> %d %+v", method, path, resp.StatusCode, response.Error)
+		return resp.StatusCode, response
+	}
+	day := func(offset int) string {
+		return time.Now().AddDate(0, 0, offset).Format("2006-01-02")
+	}
+	floatPtr := func(v float64) *float64 { return &v }
+
+	t.Run("Pre-approval", func(t *testing.T) {
+		status, _ := doRequest("POST", "/employee/overtime-requests", workerToken, handlers.RequestOvertimeRequest{Date: day(-1), Hours: 2, Reason: "Release"})
+		assert.Equal(t, 400, status, "Must be requested in advance")
+		status, _ = doRequest("POST", "/employee/overtime-requests", workerToken, handlers.RequestOvertimeRequest{Date: day(1), Hours: 20, Reason: "Release"})
+		assert.Equal(t, 400, status)
+
+		status, response := doRequest("POST", "/employee/overtime-requests", workerToken, handlers.RequestOvertimeRequest{Date: day(1), Hours: 3, Reason: "Release"})
+		assert.Equal(t, 200, status)
+		id := response.Data.(map[string]interface{})["id"].(string)
+		status, _ = doRequest("POST", "/employee/overtime-requests", workerToken, handlers.RequestOvertimeRequest{Date: day(1), Hours: 1, Reason: "More"})
+		assert.Equal(t, 409, status)
+
+		status, _ = doRequest("POST", "/hr/overtime-requests/"+id+"/approve", workerToken, handlers.ReviewOvertimeRequest{})
+		assert.Equal(t, 403, status)
+		status, response = doRequest("GET", "/hr/overtime-requests?status=pending", managerToken, nil)
+		assert.Equal(t, 200, status)
+		assert.Len(t, response.Data, 1)
+
+		status, _ = doRequest("POST", "/hr/overtime-requests/"+id+"/approve", managerToken, handlers.ReviewOvertimeRequest{Hours: floatPtr(4)})
+		assert.Equal(t, 400, status, "More than requested")
+		status, response = doRequest("POST", "/hr/overtime-requests/"+id+"/approve", managerToken, handlers.ReviewOvertimeRequest{Hours: floatPtr(2), Note: "Two hours is enough"})
+		assert.Equal(t, 200, status)
+		assert.Equal(t, float64(2), response.Data.(map[string]interface{})["approved_hours"])
+		status, _ = doRequest("POST", "/hr/overtime-requests/"+id+"/reject", managerToken, handlers.ReviewOvertimeRequest{})
+		assert.Equal(t, 409, status)
+
+		status, _ = doRequest("DELETE", "/employee/overtime-requests/"+id, workerToken, nil)
+		assert.Equal(t, 404, status, "Only pending requests can be cancelled")
+
+		status, response = doRequest("POST", "/employee/overtime-requests", workerToken, handlers.RequestOvertimeRequest{Date: day(2), Hours: 1, Reason: "Maybe"})
+		assert.Equal(t, 200, status)
+		status, _ = doRequest("DELETE", "/employee/overtime-requests/"+response.Data.(map[string]interface{})["id"].(string), workerToken, nil)
+		assert.Equal(t, 200, status)
+
+		status, response = doRequest("GET", "/employee/overtime-requests", workerToken, nil)
+		assert.Equal(t, 200, status)
+		assert.Len(t, response.Data, 2)
+
+		// Requests nobody approved in time, or for a month already paid
+		now := time.Now()
+		today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.Local)
+		late := models.OvertimeRequest{ID: uuid.New().String(), UserID: worker.ID, Date: today.AddDate(0, 0, -1), Hours: 2, Reason: "Release", Status: "pending", CreatedAt: now, UpdatedAt: now}
+		paidMonth := models.OvertimeRequest{ID: uuid.New().String(), UserID: worker.ID, Date: today, Hours: 2, Reason: "Release", Status: "pending", CreatedAt: now, UpdatedAt: now}
+		for _, r := range []*models.OvertimeRequest{&late, &paidMonth} {
+			assert.NoError(t, db.Create(r).Error)
+		}
+		salary := models.SalaryApproval{ID: uuid.New().String(), UserID: worker.ID, Month: time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.Local), Status: "approved", CreatedAt: now, UpdatedAt: now}
+		assert.NoError(t, db.Create(&salary).Error)
+
+		status, _ = doRequest("POST", "/hr/overtime-requests/"+late.ID+"/approve", managerToken, handlers.ReviewOvertimeRequest{})
+		assert.Equal(t, 409, status, "Approval after the day is not pre-approval")
+		status, _ = doRequest("POST", "/hr/overtime-requests/"+paidMonth.ID+"/approve", managerToken, handlers.ReviewOvertimeRequest{})
+		assert.Equal(t, 409, status, "The approved salary would not pay it")
+		status, _ = doRequest("POST", "/hr/overtime-requests/"+late.ID+"/reject", managerToken, handlers.ReviewOvertimeRequest{Note: "Too late"})
+		assert.Equal(t, 200, status)
+
+		db.Delete(&salary)
+		db.Where("1 = 1").Delete(&models.OvertimeRequest{})
+	})
+
+	status, _ := doRequest("POST", "/root/calendar", rootToken, handlers.CreateCalendarDayRequest{Date: "2024-03-08", Kind: "holiday", Name: "Company day"})
+	assert.Equal(t, 200, status)
+
+	// Monday: three hours after the shift, two approved. Friday holiday:
+	// two hours, none approved. Saturday: four hours approved, but only
+	// three remain under the monthly cap.
+	at := func(d, hour int) time.Time { return time.Date(2024, 3, d, hour, 0, 0, 0, time.Local) }
+	for _, a := range [][3]time.Time{
+		{at(4, 9), at(4, 21), at(4, 9)},
+		{at(8, 9), at(8, 11), at(8, 9)},
+		{at(9, 9), at(9, 13), at(9, 9)},
+	} {
+		assert.NoError(t, db.Create(&models.Attendance{ID: uuid.New().String(), UserID: worker.ID, CheckInTime: a[0], CheckOutTime: a[1], ExpectedTime: a[2], OnTime: true, CreatedAt: a[0], UpdatedAt: a[0]}).Error)
+	}
+	attendWorkDays(t, db, worker.ID, at(1, 0))
+	for _, r := range []models.OvertimeRequest{
+		{Date: at(4, 0), Hours: 2, ApprovedHours: 2},
+		{Date: at(9, 0), Hours: 4, ApprovedHours: 4},
+	} {
+		r.ID, r.UserID, r.Reason, r.Status = uuid.New().String(), worker.ID, "Stocktake", "approved"
+		r.CreatedAt, r.UpdatedAt = r.Date, r.Date
+		assert.NoError(t, db.Create(&r).Error)
+	}
+
+	t.Run("Monthly Overtime", func(t *testing.T) {
+		status, response := doRequest("GET", "/employee/overtime?month=2024-03", workerToken, nil)
+		assert.Equal(t, 200, status)
+		summary := response.Data.(map[string]interface{})
+		assert.Equal(t, float64(5), summary["cap_hours"])
+		entries := summary["entries"].([]interface{})
+		if assert.Len(t, entries, 3) {
+			for i, want := range []struct {
+				category               string
+				worked, approved, paid float64
+			}{
+				{"weekday", 3, 2, 2},
+				{"holiday", 2, 0, 0},
+				{"weekend", 4, 4, 3},
+			} {
+				e := entries[i].(map[string]interface{})
+				assert.Equal(t, want.category, e["category"])
+				assert.Equal(t, want.worked, e["worked_hours"])
+				assert.Equal(t, want.approved, e["approved_hours"])
+				assert.Equal(t, want.paid, e["paid_hours"])
+			}
+		}
+
+		status, _ = doRequest("GET", "/hr/employees/"+worker.ID+"/overtime?month=2024-03", managerToken, nil)
+		assert.Equal(t, 200, status)
+		status, _ = doRequest("GET", "/hr/employees/"+outsider.ID+"/overtime?month=2024-03", managerToken, nil)
+		assert.Equal(t, 403, status)
+	})
+
+	t.Run("Payroll Pays Overtime", func(t *testing.T) {
+		status, _ := doRequest("POST", "/root/payroll/run", rootToken, handlers.RunPayrollRequest{Month: "2024-03"})
+		assert.Equal(t, 200, status)
+
+		var approval models.SalaryApproval
+		assert.NoError(t, db.Preload("LineItems").First(&approval, "user_id = ?", worker.ID).Error)
+		overtime := map[string]models.SalaryLineItem{}
+		for _, item := range approval.LineItems {
+			overtime[item.Code] = item
+		}
+		assert.Equal(t, float64(2), overtime["overtime_weekday"].Quantity)
+		assert.Equal(t, float64(375000), overtime["overtime_weekday"].Amount)
+		assert.Equal(t, float64(3), overtime["overtime_weekend"].Quantity)
+		assert.Equal(t, float64(750000), overtime["overtime_weekend"].Amount)
+		assert.NotContains(t, overtime, "overtime_holiday")
+		assert.Equal(t, float64(1125000), approval.Bonus)
+
+		// Only normal pay for the hours is taxed: 21,125,000 less the
+		// 125,000 and 375,000 premiums and the 11,000,000 allowance
+		if assert.Contains(t, overtime, "pit") {
+			assert.Equal(t, float64(9625000), overtime["pit"].Quantity)
+		}
+	})
+
+	// Cleanup
+	db.Where("1 = 1").Delete(&models.SalaryApproval{})
+	db.Where("1 = 1").Delete(&models.OvertimeRequest{})
+	db.Where("1 = 1").Delete(&models.CalendarDay{})
+	db.Where("1 = 1").Delete(&models.Attendance{})
+	db.Where("1 = 1").Delete(&models.CompanyRule{})
+	db.Where("1 = 1").Delete(&models.ShiftAssignment{})
+	db.Where("1 = 1").Delete(&models.Shift{})
+	for _, u := range []models.User{rootUser, manager, worker, outsider} {
+		db.Unscoped().Delete(&u)
+	}
+}
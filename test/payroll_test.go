@@ -0,0 +1,197 @@
+package test
+
+import (
+	"bytes"
+	"dapp_timekeeping/config"
+	"dapp_timekeeping/handlers"
+	"dapp_timekeeping/middleware"
+	"dapp_timekeeping/models"
+	"dapp_timekeeping/types"
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMonthlyPayroll(t *testing.T) {
+	app, db := SetupTest(t)
+
+	root := app.Group("/root", middleware.RequireRoot)
+	root.Post("/payroll/run", handlers.RunPayroll)
+	root.Get("/payroll/pending", handlers.GetPendingSalaryApprovals)
+	root.Post("/payroll/approve", handlers.ApproveSalary)
+	root.Post("/payroll/bonuses", handlers.CreatePayrollBonus)
+	root.Put("/employees/:id/salary", handlers.UpdateSalary)
+
+	date := func(day int) time.Time {
+		return time.Date(2024, 2, day, 0, 0, 0, 0, time.Local)
+	}
+	longAgo := time.Date(2023, 1, 1, 0, 0, 0, 0, time.Local)
+
+	rootUser := models.User{ID: uuid.New().String(), Nickname: "payroll_root", Role: "root", Status: "active", OnboardDate: longAgo}
+	regular := models.User{ID: uuid.New().String(), Nickname: "regular", Department: "Sales", Role: "employee", Status: "active", Salary: 21000000, OnboardDate: longAgo}
+	newcomer := models.User{ID: uuid.New().String(), Nickname: "newcomer", Department: "Support", Role: "employee", Status: "active", Salary: 21000000, OnboardDate: date(15)}
+	leaver := models.User{ID: uuid.New().String(), Nickname: "leaver", Department: "Support", Role: "employee", Status: "left_company", Salary: 21000000, OnboardDate: longAgo}
+	applicant := models.User{ID: uuid.New().String(), Nickname: "applicant", Role: "employee", Status: "pending", Salary: 21000000}
+	for _, u := range []*models.User{&rootUser, &regular, &newcomer, &leaver, &applicant} {
+		assert.NoError(t, db.Create(u).Error)
+	}
+	rootToken := createTestToken(rootUser.ID, "root")
+
+	absences := []models.Absence{
+		// Monday and Tuesday away without permission
+		{UserID: regular.ID, Date: date(5), StartDate: date(5), EndDate: date(6), Type: "leave_without_permission", Reason: "No show", Status: "approved"},
+		{UserID: regular.ID, Date: date(7), StartDate: date(7), EndDate: date(7), Type: "late_without_permission", Reason: "Late", Status: "approved"},
+		// Rejected records are not charged
+		{UserID: regular.ID, Date: date(8), StartDate: date(8), EndDate: date(8), Type: "late_without_permission", Reason: "Traffic", Status: "rejected"},
+		// Last working day is Friday 9 February
+		{UserID: leaver.ID, Date: date(9), StartDate: date(9), EndDate: date(9), Type: "resign", Reason: "Moving on", Status: "approved"},
+	}
+	for i := range absences {
+		absences[i].ID = uuid.New().String()
+		absences[i].ProcessedBy = strPtr(rootUser.ID)
+		assert.NoError(t, db.Create(&absences[i]).Error)
+	}
+
+	// Regular misses Monday 12 February without a record, and Tuesday 13
+	// too but won the appeal against it. The no-show raised on Monday 5
+	// February overlaps the recorded absence and is not charged twice.
+	attendWorkDays(t, db, regular.ID, date(1), 5, 6, 12, 13)
+	attendWorkDays(t, db, newcomer.ID, date(1))
+	attendWorkDays(t, db, leaver.ID, date(1))
+	for _, v := range []models.Violation{
+		{UserID: regular.ID, Date: date(5), Status: "active"},
+		{UserID: regular.ID, Date: date(13), Status: "voided"},
+	} {
+		v.ID, v.Type, v.Severity, v.Origin = uuid.New().String(), "unauthorized_absence", "major", "detected"
+		v.SourceType, v.SourceID = strPtr("schedule"), strPtr("default")
+		assert.NoError(t, db.Create(&v).Error)
+	}
+
+	doRequest := func(method, path string, payload interface{}) (int, types.APIResponse) {
+		body := bytes.NewBuffer(nil)
+		if payload != nil {
+			b, _ := json.Marshal(payload)
+			body = bytes.NewBuffer(b)
+		}
+		req := httptest.NewRequest(method, path, body)
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+rootToken)
+		resp, err := app.Test(req)
+		assert.NoError(t, err)
+
+		var response types.APIResponse
+		json.NewDecoder(resp.Body).Decode(&response)
+		t.Logf("%s %s -> %d %+v", method, path, resp.StatusCode, response)
+		return resp.StatusCode, response
+	}
+
+	salaryOf := func(userID string) models.SalaryApproval {
+		var approval models.SalaryApproval
+		assert.NoError(t, db.Preload("LineItems").First(&approval, "user_id = ? AND month = ?", userID, date(1)).Error)
+		return approval
+	}
+
+	t.Run("Configure Bonuses", func(t *testing.T) {
+		status, _ := doRequest("POST", "/root/payroll/bonuses", handlers.CreatePayrollBonusRequest{Name: "Tet bonus", Amount: 500000, Month: "2024-02"})
+		assert.Equal(t, 200, status)
+		status, _ = doRequest("POST", "/root/payroll/bonuses", handlers.CreatePayrollBonusRequest{Name: "Support allowance", Amount: 300000, Department: "Support"})
+		assert.Equal(t, 200, status)
+		status, _ = doRequest("POST", "/root/payroll/bonuses", handlers.CreatePayrollBonusRequest{Name: "March only", Amount: 999, Month: "2024-03"})
+		assert.Equal(t, 200, status)
+	})
+
+	t.Run("Run Payroll", func(t *testing.T) {
+		status, _ := doRequest("POST", "/root/payroll/run", handlers.RunPayrollRequest{Month: "02/2024"})
+		assert.Equal(t, 400, status)
+
+		status, response := doRequest("POST", "/root/payroll/run", handlers.RunPayrollRequest{Month: "2024-02"})
+		assert.Equal(t, 200, status)
+		result := response.Data.(map[string]interface{})
+		assert.Equal(t, float64(4), result["created"], "Root, regular, newcomer and leaver are paid")
+		assert.Equal(t, float64(0), result["recomputed"])
+
+		// 21 working days at 1,000,000, three unpaid and one late arrival.
+		// Income tax on the 7.4M above the 11M personal allowance is 5% of 5M
+		// and 10% of 2.4M.
+		approval := salaryOf(regular.ID)
+		penalty := config.AppConfig.LatePenaltyAmount
+		pit := float64(490000)
+		assert.Equal(t, "pending", approval.Status)
+		assert.Equal(t, float64(21000000), approval.BaseSalary)
+		assert.Equal(t, 3000000+penalty+pit, approval.Deductions)
+		assert.Equal(t, float64(500000), approval.Bonus)
+		assert.Equal(t, 21000000-3000000-penalty+500000-pit, approval.FinalSalary)
+		assert.Len(t, approval.LineItems, 5)
+		units := map[string]string{}
+		quantities := map[string]float64{}
+		for _, item := range approval.LineItems {
+			units[item.Code] = item.Unit
+			quantities[item.Code] = item.Quantity
+		}
+		assert.Equal(t, map[string]string{"base_salary": "day", "unpaid_absence": "day", "late_penalty": "incident", "bonus": "", "pit": "vnd"}, units)
+		assert.Equal(t, float64(3), quantities["unpaid_absence"])
+
+		// Onboarded on the 15th: 11 of 21 working days, 0.8M taxed at 5%
+		approval = salaryOf(newcomer.ID)
+		assert.Equal(t, float64(11000000), approval.BaseSalary)
+		assert.Equal(t, float64(800000), approval.Bonus)
+		assert.Equal(t, float64(11760000), approval.FinalSalary)
+
+		// Resigned on the 9th: 7 of 21 working days
+		approval = salaryOf(leaver.ID)
+		assert.Equal(t, float64(7000000), approval.BaseSalary)
+
+		var count int64
+		db.Model(&models.SalaryApproval{}).Where("user_id = ?", applicant.ID).Count(&count)
+		assert.Equal(t, int64(0), count, "Pending users are not paid")
+	})
+
+	t.Run("Approve And Rerun", func(t *testing.T) {
+		approved := salaryOf(regular.ID)
+		status, response := doRequest("POST", "/root/payroll/approve", handlers.ProcessSalaryRequest{IDs: []string{approved.ID}})
+		assert.Equal(t, 200, status)
+		assert.Equal(t, float64(1), response.Data.(map[string]interface{})["processed"])
+
+		approved = salaryOf(regular.ID)
+		assert.Equal(t, "approved", approved.Status)
+		assert.Equal(t, rootUser.ID, *approved.ApprovedBy)
+
+		// Salary changes apply to records still pending
+		for _, id := range []string{regular.ID, newcomer.ID} {
+			status, _ = doRequest("PUT", "/root/employees/"+id+"/salary", handlers.UpdateSalaryRequest{Salary: 42000000})
+			assert.Equal(t, 200, status)
+		}
+
+		status, response = doRequest("POST", "/root/payroll/run", handlers.RunPayrollRequest{Month: "2024-02"})
+		assert.Equal(t, 200, status)
+		result := response.Data.(map[string]interface{})
+		assert.Equal(t, float64(0), result["created"])
+		assert.Equal(t, float64(3), result["recomputed"])
+		assert.Equal(t, float64(1), result["skipped"])
+
+		unchanged := salaryOf(regular.ID)
+		assert.Equal(t, approved.FinalSalary, unchanged.FinalSalary)
+		assert.Equal(t, "approved", unchanged.Status)
+		assert.Len(t, unchanged.LineItems, 5)
+
+		recomputed := salaryOf(newcomer.ID)
+		assert.Equal(t, float64(22000000), recomputed.BaseSalary)
+		assert.Len(t, recomputed.LineItems, 4, "Line items are replaced, not duplicated")
+
+		_, response = doRequest("GET", "/root/payroll/pending?month=2024-02", nil)
+		assert.Len(t, response.Data.([]interface{}), 3)
+	})
+
+	// Cleanup
+	db.Exec("DELETE FROM salary_line_items")
+	db.Exec("DELETE FROM salary_approvals")
+	db.Exec("DELETE FROM payroll_bonus")
+	db.Unscoped().Delete(&models.Absence{}, "user_id IN ?", []string{regular.ID, leaver.ID})
+	for _, u := range []models.User{rootUser, regular, newcomer, leaver, applicant} {
+		db.Unscoped().Delete(&u)
+	}
+}
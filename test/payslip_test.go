@@ -0,0 +1,175 @@
+package test
+
+import (
+	"archive/zip"
+	"bytes"
+	"dapp_timekeeping/handlers"
+	"dapp_timekeeping/middleware"
+	"dapp_timekeeping/models"
+	"dapp_timekeeping/types"
+	"encoding/json"
+	"io"
+	"net/http/httptest"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPayslips(t *testing.T) {
+	app, db := SetupTest(t)
+
+	emp := app.Group("/employee", middleware.RequireAuth)
+	emp.Get("/payslips", handlers.GetMyPayslips)
+	emp.Get("/payslips/:month", handlers.GetMyPayslip)
+	root := app.Group("/root", middleware.RequireRoot)
+	root.Get("/payroll/payslips/:month", handlers.ExportPayslips)
+
+	february := time.Date(2024, 2, 1, 0, 0, 0, 0, time.Local)
+	march := time.Date(2024, 3, 1, 0, 0, 0, 0, time.Local)
+
+	rootUser := models.User{ID: uuid.New().String(), Nickname: "payslip_root", Role: "root", Status: "active"}
+	employee := models.User{
+		ID:                 uuid.New().String(),
+		Nickname:           "an.nguyen",
+		FullName:           "Nguyễn Văn An",
+		Position:           "Developer",
+		Department:         "IT",
+		TaxID:              "8001234567",
+		NumberOfDependents: 1,
+		Role:               "employee",
+		Status:             "active",
+	}
+	colleague := models.User{ID: uuid.New().String(), Nickname: "binh", FullName: "Trần Bình", Role: "employee", Status: "active"}
+	for _, u := range []*models.User{&rootUser, &employee, &colleague} {
+		assert.NoError(t, db.Create(u).Error)
+	}
+
+	approvedAt := time.Date(2024, 3, 5, 10, 0, 0, 0, time.Local)
+	salary := func(userID string, month time.Time, status string, items ...models.SalaryLineItem) {
+		approval := models.SalaryApproval{
+			ID:          uuid.New().String(),
+			UserID:      userID,
+			Month:       month,
+			BaseSalary:  21000000,
+			Deductions:  2100000,
+			Bonus:       500000,
+			FinalSalary: 19400000,
+			Status:      status,
+			CreatedAt:   time.Now(),
+			UpdatedAt:   time.Now(),
+		}
+		if status == "approved" {
+			approval.ApprovedBy = &rootUser.ID
+			approval.ApprovedAt = &approvedAt
+		}
+		assert.NoError(t, db.Omit("User", "Approver", "LineItems").Create(&approval).Error)
+		for _, item := range items {
+			item.ID = uuid.New().String()
+			item.SalaryApprovalID = approval.ID
+			assert.NoError(t, db.Create(&item).Error)
+		}
+	}
+	salary(employee.ID, february, "approved",
+		models.SalaryLineItem{Kind: "earning", Code: "base_salary", Description: "Base salary for 21 of 21 working days", Quantity: 21, Unit: "day", Amount: 21000000},
+		models.SalaryLineItem{Kind: "deduction", Code: "unpaid_absence", Description: "Absence without permission", Quantity: 2, Unit: "day", Amount: 2000000},
+		models.SalaryLineItem{Kind: "deduction", Code: "late_penalty", Description: "Late arrival without permission", Quantity: 1, Unit: "incident", Amount: 100000},
+		models.SalaryLineItem{Kind: "bonus", Code: "bonus", Description: "Tet bonus", Quantity: 1, Amount: 500000},
+		models.SalaryLineItem{Kind: "employer", Code: "bhtn_employer", Description: "Unemployment insurance employer share", Quantity: 21000000, Unit: "vnd", Amount: 210000},
+		models.SalaryLineItem{Kind: "bonus", Code: "overtime_weekend", Description: "Weekend overtime", Quantity: 1200, Unit: "hour", Amount: 0},
+	)
+	salary(employee.ID, march, "pending")
+	salary(colleague.ID, february, "approved")
+
+	get := func(path, token string) (int, string, []byte) {
+		req := httptest.NewRequest("GET", path, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := app.Test(req)
+		assert.NoError(t, err)
+		body, _ := io.ReadAll(resp.Body)
+		t.Logf("GET %s -> %d %s", path, resp.StatusCode, resp.Header.Get("Content-Type"))
+		return resp.StatusCode, resp.Header.Get("Content-Type"), body
+	}
+	token := createTestToken(employee.ID, "employee")
+
+	t.Run("List Approved Payslips", func(t *testing.T) {
+		status, _, body := get("/employee/payslips", token)
+		assert.Equal(t, 200, status)
+
+		var response types.APIResponse
+		assert.NoError(t, json.Unmarshal(body, &response))
+		payslips := response.Data.([]interface{})
+		assert.Len(t, payslips, 1, "Pending salaries have no payslip")
+		assert.Equal(t, "2024-02", payslips[0].(map[string]interface{})["month"])
+	})
+
+	t.Run("Download PDF", func(t *testing.T) {
+		status, contentType, body := get("/employee/payslips/2024-02?format=pdf", token)
+		assert.Equal(t, 200, status)
+		assert.Equal(t, "application/pdf", contentType)
+		assert.True(t, bytes.HasPrefix(body, []byte("%PDF-1.4")))
+		assert.True(t, bytes.HasSuffix(body, []byte("%%EOF\n")))
+		for _, text := range []string{"(Nguyen Van An)", "(8001234567)", "(Tet bonus)", "(2,000,000)", "(19,400,000)"} {
+			assert.Contains(t, string(body), text)
+		}
+	})
+
+	t.Run("Download HTML By Default", func(t *testing.T) {
+		status, contentType, body := get("/employee/payslips/2024-02", token)
+		assert.Equal(t, 200, status)
+		assert.Equal(t, "text/html; charset=utf-8", contentType)
+		assert.Contains(t, string(body), "Nguyễn Văn An")
+		assert.Contains(t, string(body), "19,400,000")
+		assert.Contains(t, string(body), "February 2024")
+
+		// Quantities are rendered by their unit, not their size
+		assert.Contains(t, string(body), `<td class="amount">21,000,000</td><td class="amount">210,000</td>`)
+		assert.Contains(t, string(body), `<td class="amount">1200</td>`)
+		assert.Contains(t, string(body), `Tet bonus</td><td class="amount"></td>`)
+	})
+
+	t.Run("Unapproved Month", func(t *testing.T) {
+		status, _, _ := get("/employee/payslips/2024-03", token)
+		assert.Equal(t, 404, status)
+		status, _, _ = get("/employee/payslips/2024-02?format=docx", token)
+		assert.Equal(t, 400, status)
+	})
+
+	t.Run("Root Downloads Month As ZIP", func(t *testing.T) {
+		status, _, _ := get("/root/payroll/payslips/2024-02", token)
+		assert.Equal(t, 403, status)
+
+		status, contentType, body := get("/root/payroll/payslips/2024-02", createTestToken(rootUser.ID, "root"))
+		assert.Equal(t, 200, status)
+		assert.Equal(t, "application/zip", contentType)
+
+		archive, err := zip.NewReader(bytes.NewReader(body), int64(len(body)))
+		assert.NoError(t, err)
+		var names []string
+		for _, f := range archive.File {
+			names = append(names, f.Name)
+		}
+		sort.Strings(names)
+		assert.Equal(t, []string{"payslip-2024-02-an_nguyen.html", "payslip-2024-02-binh.html"}, names)
+
+		status, _, body = get("/root/payroll/payslips/2024-02?format=pdf", createTestToken(rootUser.ID, "root"))
+		assert.Equal(t, 200, status)
+		archive, err = zip.NewReader(bytes.NewReader(body), int64(len(body)))
+		assert.NoError(t, err)
+		names = nil
+		for _, f := range archive.File {
+			names = append(names, f.Name)
+		}
+		sort.Strings(names)
+		assert.Equal(t, []string{"payslip-2024-02-an_nguyen.pdf", "payslip-2024-02-binh.pdf"}, names)
+	})
+
+	// Cleanup
+	db.Exec("DELETE FROM salary_line_items")
+	db.Exec("DELETE FROM salary_approvals")
+	for _, u := range []models.User{rootUser, employee, colleague} {
+		db.Unscoped().Delete(&u)
+	}
+}
@@ -0,0 +1,328 @@
+package test
+
+import (
+	"bytes"
+	"dapp_timekeeping/handlers"
+	"dapp_timekeeping/middleware"
+	"dapp_timekeeping/models"
+	"dapp_timekeeping/types"
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDelegatedPermissions(t *testing.T) {
+	app, db := SetupTest(t)
+
+	root := app.Group("/root", middleware.RequireRoot)
+	root.Post("/permissions/grant", handlers.GrantPermission)
+	root.Delete("/permissions/revoke", handlers.RevokePermission)
+	root.Get("/permissions/user/:id", handlers.GetUserPermissions)
+
+	rootUser := models.User{ID: uuid.New().String(), Nickname: "perm_root", Role: "root", Status: "active"}
+	delegate := models.User{ID: uuid.New().String(), Nickname: "delegate", Role: "employee", Status: "active"}
+	for _, u := range []*models.User{&rootUser, &delegate} {
+		assert.NoError(t, db.Create(u).Error)
+	}
+	rootToken := createTestToken(rootUser.ID, "root")
+
+	doRequest := func(method, path string, payload interface{}) (int, types.APIResponse) {
+		body := bytes.NewBuffer(nil)
+		if payload != nil {
+			b, _ := json.Marshal(payload)
+			body = bytes.NewBuffer(b)
+		}
+		req := httptest.NewRequest(method, path, body)
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+rootToken)
+		resp, err := app.Test(req)
+		assert.NoError(t, err)
+
+		var response types.APIResponse
+		json.NewDecoder(resp.Body).Decode(&response)
+		t.Logf("%s %s -> %d %+v", method, path, resp.StatusCode, response)
+		return resp.StatusCode, response
+	}
+
+	held := func() []string {
+		status, response := doRequest("GET", "/root/permissions/user/"+delegate.ID, nil)
+		assert.Equal(t, 200, status)
+		var names []string
+		list, _ := response.Data.([]interface{})
+		for _, p := range list {
+			names = append(names, p.(map[string]interface{})["permission"].(string))
+		}
+		return names
+	}
+
+	inAnHour := time.Now().Add(time.Hour)
+
+	t.Run("Grant", func(t *testing.T) {
+		status, response := doRequest("POST", "/root/permissions/grant", handlers.GrantPermissionRequest{
+			UserID:     delegate.ID,
+			Permission: "attendance_approval",
+			ExpiresAt:  &inAnHour,
+		})
+		assert.Equal(t, 200, status)
+		assert.Equal(t, rootUser.ID, response.Data.(map[string]interface{})["granted_by"])
+
+		status, _ = doRequest("POST", "/root/permissions/grant", handlers.GrantPermissionRequest{
+			UserID:     delegate.ID,
+			Permission: "salary_management",
+		})
+		assert.Equal(t, 200, status)
+
+		assert.Equal(t, []string{"attendance_approval", "salary_management"}, held())
+
+		other := models.User{ID: uuid.New().String(), Nickname: "second_delegate", Role: "employee", Status: "active"}
+		assert.NoError(t, db.Create(&other).Error)
+		defer db.Unscoped().Delete(&other)
+		status, _ = doRequest("POST", "/root/permissions/grant", handlers.GrantPermissionRequest{
+			UserID:     other.ID,
+			Permission: "salary_management",
+		})
+		assert.Equal(t, 200, status, "A permission already granted once is reused")
+		var count int64
+		db.Model(&models.Permission{}).Where("name = ?", "salary_management").Count(&count)
+		assert.Equal(t, int64(1), count)
+
+		var user models.User
+		assert.NoError(t, db.Preload("Permissions").First(&user, "id = ?", delegate.ID).Error)
+		assert.Len(t, user.Permissions, 2)
+	})
+
+	t.Run("Reject Invalid Grants", func(t *testing.T) {
+		anHourAgo := time.Now().Add(-time.Hour)
+		status, _ := doRequest("POST", "/root/permissions/grant", handlers.GrantPermissionRequest{UserID: delegate.ID, Permission: "launch_rockets"})
+		assert.Equal(t, 400, status)
+		status, _ = doRequest("POST", "/root/permissions/grant", handlers.GrantPermissionRequest{UserID: delegate.ID, Permission: "leave_approval", ExpiresAt: &anHourAgo})
+		assert.Equal(t, 400, status)
+		status, _ = doRequest("POST", "/root/permissions/grant", handlers.GrantPermissionRequest{UserID: uuid.New().String(), Permission: "leave_approval"})
+		assert.Equal(t, 404, status)
+	})
+
+	t.Run("Expired Grants Lapse", func(t *testing.T) {
+		var permission models.Permission
+		assert.NoError(t, db.Where("name = ?", "attendance_approval").First(&permission).Error)
+		past := time.Now().Add(-time.Minute)
+		assert.NoError(t, db.Model(&models.UserPermission{}).
+			Where("user_id = ? AND permission_id = ?", delegate.ID, permission.ID).
+			Update("expires_at", past).Error)
+
+		assert.Equal(t, []string{"salary_management"}, held())
+
+		status, _ := doRequest("DELETE", "/root/permissions/revoke", handlers.RevokePermissionRequest{UserID: delegate.ID, Permission: "attendance_approval"})
+		assert.Equal(t, 404, status, "A lapsed permission cannot be revoked")
+	})
+
+	t.Run("Revoke", func(t *testing.T) {
+		status, _ := doRequest("DELETE", "/root/permissions/revoke", handlers.RevokePermissionRequest{UserID: delegate.ID, Permission: "salary_management"})
+		assert.Equal(t, 200, status)
+		assert.Empty(t, held())
+
+		var grants []models.PermissionGrant
+		db.Where("granted_to = ?", delegate.ID).Order("created_at").Find(&grants)
+		assert.Len(t, grants, 2, "Grants are kept as history")
+		if len(grants) == 2 {
+			assert.Nil(t, grants[0].RevokedAt, "The lapsed grant was never revoked")
+			assert.NotNil(t, grants[1].RevokedAt)
+			assert.Equal(t, rootUser.ID, *grants[1].RevokedBy)
+		}
+
+		status, _ = doRequest("DELETE", "/root/permissions/revoke", handlers.RevokePermissionRequest{UserID: delegate.ID, Permission: "salary_management"})
+		assert.Equal(t, 404, status)
+	})
+
+	t.Run("Effective Permissions", func(t *testing.T) {
+		manager := models.User{ID: uuid.New().String(), Nickname: "perm_manager", Role: "hr_manager", Department: "HR", Status: "active"}
+		assert.NoError(t, db.Create(&manager).Error)
+		defer db.Unscoped().Delete(&manager)
+		sales := models.Department{ID: uuid.New().String(), Name: "PermSales", ManagerID: manager.ID}
+		assert.NoError(t, db.Create(&sales).Error)
+		defer db.Delete(&sales)
+
+		status, _ := doRequest("POST", "/root/permissions/grant", handlers.GrantPermissionRequest{
+			UserID:      manager.ID,
+			Permission:  "leave_approval",
+			Departments: []string{"Ops"},
+		})
+		assert.Equal(t, 200, status)
+		status, _ = doRequest("POST", "/root/permissions/grant", handlers.GrantPermissionRequest{
+			UserID:     manager.ID,
+			Permission: "salary_management",
+		})
+		assert.Equal(t, 200, status)
+
+		status, response := doRequest("GET", "/root/permissions/user/"+manager.ID, nil)
+		assert.Equal(t, 200, status)
+		effective := map[string]map[string]interface{}{}
+		for _, p := range response.Data.([]interface{}) {
+			permission := p.(map[string]interface{})
+			effective[permission["permission"].(string)] = permission
+		}
+		assert.Len(t, effective, 5, "Four from the role and one delegated")
+
+		attendance := effective["attendance_approval"]
+		assert.Equal(t, true, attendance["from_role"])
+		assert.Equal(t, false, attendance["all"])
+		assert.Equal(t, []interface{}{"HR", "PermSales"}, attendance["departments"])
+		assert.Nil(t, attendance["grant"])
+
+		leave := effective["leave_approval"]
+		assert.Equal(t, true, leave["from_role"])
+		assert.Equal(t, []interface{}{"HR", "Ops", "PermSales"}, leave["departments"], "Grant scope adds to the role's")
+		assert.NotNil(t, leave["grant"])
+
+		salary := effective["salary_management"]
+		assert.Equal(t, false, salary["from_role"])
+		assert.Equal(t, true, salary["all"])
+		assert.Empty(t, salary["departments"])
+
+		status, response = doRequest("GET", "/root/permissions/user/"+rootUser.ID, nil)
+		assert.Equal(t, 200, status)
+		for _, p := range response.Data.([]interface{}) {
+			assert.Equal(t, true, p.(map[string]interface{})["all"])
+		}
+		assert.Len(t, response.Data, 6, "Root holds the whole catalog")
+
+		assert.NoError(t, db.Model(&manager).Update("status", "left_company").Error)
+		status, response = doRequest("GET", "/root/permissions/user/"+manager.ID, nil)
+		assert.Equal(t, 200, status)
+		assert.Empty(t, response.Data, "Users who are not active hold nothing")
+	})
+
+	// Cleanup
+	db.Exec("DELETE FROM permission_grants")
+	db.Exec("DELETE FROM user_permissions")
+	db.Exec("DELETE FROM permissions")
+	for _, u := range []models.User{rootUser, delegate} {
+		db.Unscoped().Delete(&u)
+	}
+}
+
+func TestRequirePermission(t *testing.T) {
+	app, db := SetupTest(t)
+
+	hr := app.Group("/hr")
+	hr.Get("/leaves/pending", middleware.RequirePermission("leave_approval"), handlers.GetPendingLeaves)
+	hr.Post("/leaves/:id/approve", middleware.RequirePermission("leave_approval"), handlers.ApproveLeave)
+	hr.Put("/employees/:id/salary", middleware.RequirePermission("salary_management"), handlers.UpdateSalary)
+
+	manager := models.User{ID: uuid.New().String(), Nickname: "it_manager", Role: "hr_manager", Department: "HR", Status: "active"}
+	developer := models.User{ID: uuid.New().String(), Nickname: "developer", Role: "employee", Department: "IT", Status: "active"}
+	accountant := models.User{ID: uuid.New().String(), Nickname: "accountant", Role: "employee", Department: "Finance", Status: "active"}
+	for _, u := range []*models.User{&manager, &developer, &accountant} {
+		assert.NoError(t, db.Create(u).Error)
+	}
+	assert.NoError(t, db.Create(&models.Department{
+		ID:        uuid.New().String(),
+		Name:      "IT",
+		ManagerID: manager.ID,
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+	}).Error)
+
+	leave := func(userID string) models.Absence {
+		absence := models.Absence{
+			ID:        uuid.New().String(),
+			UserID:    userID,
+			Date:      time.Now(),
+			StartDate: time.Now().AddDate(0, 0, 1),
+			EndDate:   time.Now().AddDate(0, 0, 1),
+			Type:      "leave_with_permission",
+			Reason:    "Family matters",
+			Status:    "pending",
+		}
+		assert.NoError(t, db.Create(&absence).Error)
+		return absence
+	}
+	itLeave := leave(developer.ID)
+	financeLeave := leave(accountant.ID)
+
+	doRequest := func(method, path, token string, payload interface{}) (int, types.APIResponse) {
+		body := bytes.NewBuffer(nil)
+		if payload != nil {
+			b, _ := json.Marshal(payload)
+			body = bytes.NewBuffer(b)
+		}
+		req := httptest.NewRequest(method, path, body)
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := app.Test(req)
+		assert.NoError(t, err)
+
+		var response types.APIResponse
+		json.NewDecoder(resp.Body).Decode(&response)
+		t.Logf("%s %s -> %d %+v", method, path, resp.StatusCode, response)
+		return resp.StatusCode, response
+	}
+	managerToken := createTestToken(manager.ID, "hr_manager")
+
+	t.Run("Manager Sees Own Departments", func(t *testing.T) {
+		status, response := doRequest("GET", "/hr/leaves/pending", managerToken, nil)
+		assert.Equal(t, 200, status)
+		list, _ := response.Data.([]interface{})
+		assert.Len(t, list, 1)
+		if len(list) == 1 {
+			assert.Equal(t, itLeave.ID, list[0].(map[string]interface{})["id"])
+		}
+	})
+
+	t.Run("Manager Approves Only Own Departments", func(t *testing.T) {
+		status, _ := doRequest("POST", "/hr/leaves/"+financeLeave.ID+"/approve", managerToken, nil)
+		assert.Equal(t, 403, status)
+		status, _ = doRequest("POST", "/hr/leaves/"+itLeave.ID+"/approve", managerToken, nil)
+		assert.Equal(t, 200, status)
+
+		var absence models.Absence
+		db.First(&absence, "id = ?", financeLeave.ID)
+		assert.Equal(t, "pending", absence.Status)
+	})
+
+	t.Run("Role Without Permission", func(t *testing.T) {
+		status, _ := doRequest("PUT", "/hr/employees/"+developer.ID+"/salary", managerToken, handlers.UpdateSalaryRequest{Salary: 20000000})
+		assert.Equal(t, 403, status)
+		status, _ = doRequest("GET", "/hr/leaves/pending", createTestToken(developer.ID, "employee"), nil)
+		assert.Equal(t, 403, status)
+	})
+
+	t.Run("Delegated Grant Scoped To Department", func(t *testing.T) {
+		permission := models.Permission{ID: uuid.New().String(), Name: "salary_management", Description: "Manage salaries"}
+		assert.NoError(t, db.Create(&permission).Error)
+		assert.NoError(t, db.Create(&models.UserPermission{
+			UserID:       accountant.ID,
+			PermissionID: permission.ID,
+			GrantedBy:    manager.ID,
+			Departments:  "IT",
+		}).Error)
+
+		token := createTestToken(accountant.ID, "employee")
+		status, _ := doRequest("PUT", "/hr/employees/"+developer.ID+"/salary", token, handlers.UpdateSalaryRequest{Salary: 20000000})
+		assert.Equal(t, 200, status)
+		status, _ = doRequest("PUT", "/hr/employees/"+manager.ID+"/salary", token, handlers.UpdateSalaryRequest{Salary: 20000000})
+		assert.Equal(t, 403, status)
+
+		var updated models.User
+		db.First(&updated, "id = ?", developer.ID)
+		assert.Equal(t, float64(20000000), updated.Salary)
+	})
+
+	t.Run("Inactive Users Hold Nothing", func(t *testing.T) {
+		db.Model(&manager).Update("status", "left_company")
+		status, _ := doRequest("GET", "/hr/leaves/pending", managerToken, nil)
+		assert.Equal(t, 403, status)
+	})
+
+	// Cleanup
+	db.Exec("DELETE FROM user_permissions")
+	db.Exec("DELETE FROM permissions")
+	db.Exec("DELETE FROM departments")
+	db.Unscoped().Delete(&models.Absence{}, "user_id IN (?)", []string{developer.ID, accountant.ID})
+	for _, u := range []models.User{manager, developer, accountant} {
+		db.Unscoped().Delete(&u)
+	}
+}
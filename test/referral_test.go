@@ -0,0 +1,208 @@
+package test
+
+import (
+	"bytes"
+	"dapp_timekeeping/config"
+	"dapp_timekeeping/handlers"
+	"dapp_timekeeping/middleware"
+	"dapp_timekeeping/models"
+	"dapp_timekeeping/types"
+	"encoding/json"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReferralOnboarding(t *testing.T) {
+	app, db := SetupTest(t)
+
+	app.Post("/login", handlers.Login)
+	app.Post("/register", handlers.Register)
+	app.Post("/referrals/:code/redeem", handlers.RedeemReferralCode)
+	root := app.Group("/root", middleware.RequireRoot)
+	root.Post("/referrals/generate", handlers.GenerateReferralCode)
+	root.Get("/referrals", handlers.ListReferralCodes)
+	root.Delete("/referrals/:code", handlers.DeleteReferralCode)
+
+	rootUser := models.User{ID: uuid.New().String(), Nickname: "referral_root", Role: "root", Status: "active"}
+	newHire := models.User{ID: uuid.New().String(), Nickname: "new_hire", Role: "employee", Status: "pending"}
+	veteran := models.User{ID: uuid.New().String(), Nickname: "veteran", Role: "employee", Status: "active"}
+	earlyBird := models.User{ID: uuid.New().String(), Nickname: "early_bird", Role: "employee", Status: "pending"}
+	for _, u := range []*models.User{&rootUser, &newHire, &veteran, &earlyBird} {
+		assert.NoError(t, db.Create(u).Error)
+	}
+	rootToken := createTestToken(rootUser.ID, "root")
+
+	doRequest := func(method, path, token string, payload interface{}) (int, types.APIResponse) {
+		b, _ := json.Marshal(payload)
+		req := httptest.NewRequest(method, path, bytes.NewBuffer(b))
+		req.Header.Set("Content-Type", "application/json")
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := app.Test(req, -1)
+		assert.NoError(t, err)
+
+		var response types.APIResponse
+		json.NewDecoder(resp.Body).Decode(&response)
+		t.Logf("%s %s -> %d %s", method, path, resp.StatusCode, response.Error)
+		return resp.StatusCode, response
+	}
+	generate := func(userID string, ttl float64) (int, string) {
+		status, response := doRequest("POST", "/root/referrals/generate", rootToken, handlers.GenerateReferralRequest{UserID: userID, TTLHours: ttl})
+		if data, ok := response.Data.(map[string]interface{}); ok {
+			return status, data["code"].(string)
+		}
+		return status, ""
+	}
+	listed := func(query string) []string {
+		status, response := doRequest("GET", "/root/referrals"+query, rootToken, nil)
+		assert.Equal(t, 200, status)
+		var codes []string
+		list, _ := response.Data.([]interface{})
+		for _, c := range list {
+			codes = append(codes, c.(map[string]interface{})["code"].(string))
+		}
+		return codes
+	}
+
+	profile := handlers.RedeemReferralRequest{
+		FullName:      "Lê Thị Hoa",
+		Email:         "hoa@company.com",
+		PhoneNumber:   "+84901234567",
+		Address:       "12 Nguyen Hue, District 1",
+		DateOfBirth:   time.Date(1996, 4, 12, 0, 0, 0, 0, time.UTC),
+		Gender:        "female",
+		TaxID:         "8009876543",
+		WalletAddress: "0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed",
+		Password:      "correct horse",
+	}
+
+	t.Run("Generate", func(t *testing.T) {
+		status, _ := generate(veteran.ID, 0)
+		assert.Equal(t, 409, status, "Active employees have no profile to complete")
+		status, _ = generate(uuid.New().String(), 0)
+		assert.Equal(t, 404, status)
+
+		status, first := generate(newHire.ID, 0)
+		assert.Equal(t, 200, status)
+		assert.Len(t, first, 8)
+		status, second := generate(newHire.ID, 0)
+		assert.Equal(t, 200, status)
+
+		assert.Equal(t, []string{second}, listed("?user_id="+newHire.ID), "A new code replaces the unused one")
+		status, _ = doRequest("POST", "/referrals/"+first+"/redeem", "", profile)
+		assert.Equal(t, 404, status)
+
+		status, _ = doRequest("POST", "/root/referrals/generate", createTestToken(veteran.ID, "employee"), handlers.GenerateReferralRequest{UserID: newHire.ID})
+		assert.Equal(t, 403, status)
+	})
+
+	t.Run("Expired Code", func(t *testing.T) {
+		_, code := generate(newHire.ID, 1)
+		db.Model(&models.ReferralCode{}).Where("code = ?", code).Update("expires_at", time.Now().Add(-time.Minute))
+		assert.Equal(t, []string{code}, listed("?status=expired"))
+
+		status, _ := doRequest("POST", "/referrals/"+code+"/redeem", "", profile)
+		assert.Equal(t, 410, status)
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		_, code := generate(newHire.ID, 0)
+		status, _ := doRequest("DELETE", "/root/referrals/"+code, rootToken, nil)
+		assert.Equal(t, 200, status)
+		status, _ = doRequest("DELETE", "/root/referrals/"+code, rootToken, nil)
+		assert.Equal(t, 404, status)
+		status, _ = doRequest("POST", "/referrals/"+code+"/redeem", "", profile)
+		assert.Equal(t, 404, status)
+	})
+
+	t.Run("Redeem Activates Employee", func(t *testing.T) {
+		_, code := generate(newHire.ID, 0)
+
+		incomplete := profile
+		incomplete.TaxID = ""
+		status, response := doRequest("POST", "/referrals/"+code+"/redeem", "", incomplete)
+		assert.Equal(t, 400, status)
+		assert.Equal(t, "Missing or invalid field: tax_id", response.Error)
+
+		// Fields root sets are ignored when the new hire sends them
+		var withRootFields map[string]interface{}
+		b, _ := json.Marshal(profile)
+		json.Unmarshal(b, &withRootFields)
+		withRootFields["department"] = "Finance"
+		withRootFields["position"] = "Director"
+		withRootFields["salary"] = 99000000
+		withRootFields["role"] = "root"
+		status, response = doRequest("POST", "/referrals/"+code+"/redeem", "", withRootFields)
+		assert.Equal(t, 200, status)
+		assert.Equal(t, "active", response.Data.(map[string]interface{})["status"])
+
+		var user models.User
+		db.First(&user, "id = ?", newHire.ID)
+		assert.Equal(t, "active", user.Status)
+		assert.Equal(t, "Lê Thị Hoa", user.FullName)
+		assert.Empty(t, user.Department, "Department is set by root")
+		assert.Empty(t, user.Position, "Position is set by root")
+		assert.Equal(t, "employee", user.Role, "Role is set by root")
+		assert.Equal(t, float64(0), user.Salary, "Salary is set by root")
+
+		status, _ = doRequest("POST", "/referrals/"+code+"/redeem", "", profile)
+		assert.Equal(t, 409, status, "Codes are single use")
+		assert.Equal(t, []string{code}, listed("?status=used"))
+
+		status, _ = doRequest("POST", "/login", "", handlers.LoginRequest{Nickname: "new_hire", Password: "correct horse"})
+		assert.Equal(t, 200, status)
+	})
+
+	t.Run("Redeem Replaces Registered Password", func(t *testing.T) {
+		_, code := generate(earlyBird.ID, 0)
+		status, _ := doRequest("POST", "/register", "", handlers.RegisterRequest{Nickname: "early_bird", Code: code, Password: "first password"})
+		assert.Equal(t, 200, status)
+		status, _ = doRequest("POST", "/login", "", handlers.LoginRequest{Nickname: "early_bird", Password: "first password"})
+		assert.Equal(t, 401, status, "Pending users cannot sign in before redeeming")
+
+		redeem := profile
+		redeem.Email = "early@company.com"
+		redeem.Password = "second password"
+		status, _ = doRequest("POST", "/referrals/"+code+"/redeem", "", redeem)
+		assert.Equal(t, 200, status)
+
+		status, _ = doRequest("POST", "/login", "", handlers.LoginRequest{Nickname: "early_bird", Password: "first password"})
+		assert.Equal(t, 401, status)
+		status, _ = doRequest("POST", "/login", "", handlers.LoginRequest{Nickname: "early_bird", Password: "second password"})
+		assert.Equal(t, 200, status)
+	})
+
+	t.Run("Failed Redemptions Lock Out", func(t *testing.T) {
+		db.Where("1 = 1").Delete(&models.ReferralAttempt{})
+		pendingHire := models.User{ID: uuid.New().String(), Nickname: "guessed_hire", Role: "employee", Status: "pending"}
+		assert.NoError(t, db.Create(&pendingHire).Error)
+		_, code := generate(pendingHire.ID, 0)
+
+		for i := 0; i < config.AppConfig.MaxFailedLogins; i++ {
+			status, _ := doRequest("POST", "/referrals/GUESSED"+strconv.Itoa(i)+"/redeem", "", profile)
+			assert.Equal(t, 404, status)
+		}
+		status, _ := doRequest("POST", "/referrals/"+code+"/redeem", "", profile)
+		assert.Equal(t, 429, status, "Even a valid code is refused while locked")
+
+		db.Model(&models.ReferralAttempt{}).Where("1 = 1").Update("locked_until", time.Now().Add(-time.Second))
+		status, _ = doRequest("POST", "/referrals/"+code+"/redeem", "", profile)
+		assert.Equal(t, 200, status)
+
+		db.Where("user_id = ?", pendingHire.ID).Delete(&models.ReferralCode{})
+		db.Unscoped().Delete(&pendingHire)
+	})
+
+	// Cleanup
+	db.Exec("DELETE FROM referral_codes")
+	db.Where("1 = 1").Delete(&models.RefreshToken{})
+	for _, u := range []models.User{rootUser, newHire, veteran, earlyBird} {
+		db.Unscoped().Delete(&u)
+	}
+}
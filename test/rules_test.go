@@ -0,0 +1,155 @@
+package test
+
+import (
+	"bytes"
+	"dapp_timekeeping/config"
+	"dapp_timekeeping/handlers"
+	"dapp_timekeeping/middleware"
+	"dapp_timekeeping/models"
+	"dapp_timekeeping/types"
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompanyRules(t *testing.T) {
+	app, db := SetupTest(t)
+
+	emp := app.Group("/employee", middleware.RequireAuth)
+	emp.Post("/check-in", handlers.CheckIn)
+	root := app.Group("/root", middleware.RequireRoot)
+	root.Get("/rules", handlers.ListCompanyRules)
+	root.Get("/rules/current", handlers.GetCompanyRule)
+	root.Post("/rules", handlers.UpdateCompanyRule)
+	root.Post("/payroll/run", handlers.RunPayroll)
+
+	longAgo := time.Date(2020, 1, 1, 0, 0, 0, 0, time.Local)
+	rootUser := models.User{ID: uuid.New().String(), Nickname: "rules_root", Role: "root", Status: "active", OnboardDate: longAgo}
+	punctual := models.User{ID: uuid.New().String(), Nickname: "punctual", Role: "employee", Status: "active", OnboardDate: longAgo, Salary: 21000000, NumberOfDependents: 3}
+	tardy := models.User{ID: uuid.New().String(), Nickname: "tardy", Role: "employee", Status: "active", OnboardDate: longAgo}
+	for _, u := range []*models.User{&rootUser, &punctual, &tardy} {
+		assert.NoError(t, db.Create(u).Error)
+	}
+	rootToken := createTestToken(rootUser.ID, "root")
+
+	doRequest := func(method, path, token string, payload interface{}) (int, types.APIResponse) {
+		b, _ := json.Marshal(payload)
+		req := httptest.NewRequest(method, path, bytes.NewBuffer(b))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := app.Test(req, -1)
+		assert.NoError(t, err)
+
+		var response types.APIResponse
+		json.NewDecoder(resp.Body).Decode(&response)
+		t.Logf("%s %s -> %d %+v", method, path, resp.StatusCode, response.Error)
+		return resp.StatusCode, response
+	}
+	intPtr := func(v int) *int { return &v }
+	floatPtr := func(v float64) *float64 { return &v }
+	day := func(offset int) string {
+		return time.Now().AddDate(0, 0, offset).Format("2006-01-02")
+	}
+
+	t.Run("Built-in Default", func(t *testing.T) {
+		status, response := doRequest("GET", "/root/rules/current", rootToken, nil)
+		assert.Equal(t, 200, status)
+		rule := response.Data.(map[string]interface{})
+		assert.Equal(t, float64(0), rule["version"])
+		assert.Equal(t, config.AppConfig.LatePenaltyAmount, rule["late_penalty"])
+		assert.Equal(t, float64(8), rule["standard_daily_hours"])
+	})
+
+	t.Run("Create Versions", func(t *testing.T) {
+		status, _ := doRequest("POST", "/root/rules", rootToken, handlers.CompanyRuleRequest{EffectiveFrom: day(-1), LateGraceMinutes: intPtr(5)})
+		assert.Equal(t, 400, status, "History is never rewritten")
+		status, _ = doRequest("POST", "/root/rules", rootToken, handlers.CompanyRuleRequest{OvertimeWeekdayRate: floatPtr(0.5)})
+		assert.Equal(t, 400, status)
+
+		status, response := doRequest("POST", "/root/rules", rootToken, handlers.CompanyRuleRequest{EffectiveFrom: day(10), LateGraceMinutes: intPtr(5), Note: "Grace period"})
+		assert.Equal(t, 200, status)
+		status, response = doRequest("POST", "/root/rules", rootToken, handlers.CompanyRuleRequest{EffectiveFrom: day(20), LatePenalty: floatPtr(250000)})
+		assert.Equal(t, 200, status)
+		rule := response.Data.(map[string]interface{})
+		assert.Equal(t, float64(5), rule["late_grace_minutes"], "Omitted rules carry over")
+		assert.Equal(t, float64(250000), rule["late_penalty"])
+
+		status, _ = doRequest("POST", "/root/rules", rootToken, handlers.CompanyRuleRequest{EffectiveFrom: day(20), LatePenalty: floatPtr(1)})
+		assert.Equal(t, 409, status)
+
+		status, response = doRequest("GET", "/root/rules/current?date="+day(15), rootToken, nil)
+		assert.Equal(t, 200, status)
+		assert.Equal(t, float64(5), response.Data.(map[string]interface{})["late_grace_minutes"])
+		assert.Equal(t, config.AppConfig.LatePenaltyAmount, response.Data.(map[string]interface{})["late_penalty"])
+
+		status, response = doRequest("GET", "/root/rules", rootToken, nil)
+		assert.Equal(t, 200, status)
+		assert.Len(t, response.Data, 2)
+
+		db.Where("1 = 1").Delete(&models.CompanyRule{})
+	})
+
+	// Versions already in force, as if created earlier
+	history := []models.CompanyRule{
+		{EffectiveFrom: longAgo, LateGraceMinutes: 10, LatePenalty: 50000, MaxUnpaidAbsences: 3, StandardDailyHours: 8, OvertimeWeekdayRate: 1.5, OvertimeWeekendRate: 2, OvertimeHolidayRate: 3},
+		{EffectiveFrom: time.Date(2024, 2, 15, 0, 0, 0, 0, time.Local), LateGraceMinutes: 10, LatePenalty: 200000, MaxUnpaidAbsences: 3, StandardDailyHours: 8, OvertimeWeekdayRate: 1.5, OvertimeWeekendRate: 2, OvertimeHolidayRate: 3},
+	}
+	for i := range history {
+		history[i].CreatedAt = history[i].EffectiveFrom
+		assert.NoError(t, db.Create(&history[i]).Error)
+	}
+
+	t.Run("Lateness Uses Grace Period", func(t *testing.T) {
+		now := time.Now()
+		for _, c := range []struct {
+			user   models.User
+			late   time.Duration
+			onTime bool
+		}{{punctual, 5 * time.Minute, true}, {tardy, 15 * time.Minute, false}} {
+			start := now.Add(-c.late)
+			shift := models.Shift{ID: uuid.New().String(), Name: "rules_" + c.user.Nickname, StartTime: start.Format("15:04"), EndTime: start.Add(8 * time.Hour).Format("15:04"), WorkDays: "0,1,2,3,4,5,6"}
+			assert.NoError(t, db.Create(&shift).Error)
+			userID := c.user.ID
+			assert.NoError(t, db.Create(&models.ShiftAssignment{ID: uuid.New().String(), ShiftID: shift.ID, UserID: &userID, EffectiveFrom: longAgo}).Error)
+
+			status, response := doRequest("POST", "/employee/check-in", createTestToken(c.user.ID, "employee"), nil)
+			assert.Equal(t, 200, status)
+			assert.Equal(t, c.onTime, response.Data.(map[string]interface{})["on_time"], c.user.Nickname)
+		}
+	})
+
+	t.Run("Payroll Uses Penalty Of The Day", func(t *testing.T) {
+		for _, d := range []int{7, 20} {
+			date := time.Date(2024, 2, d, 0, 0, 0, 0, time.Local)
+			assert.NoError(t, db.Create(&models.Absence{ID: uuid.New().String(), UserID: punctual.ID, Date: date, StartDate: date, EndDate: date, Type: "late_without_permission", Reason: "Late", Status: "approved", ProcessedBy: strPtr(rootUser.ID)}).Error)
+		}
+
+		status, _ := doRequest("POST", "/root/payroll/run", rootToken, handlers.RunPayrollRequest{Month: "2024-02"})
+		assert.Equal(t, 200, status)
+
+		var approval models.SalaryApproval
+		assert.NoError(t, db.Preload("LineItems").First(&approval, "user_id = ?", punctual.ID).Error)
+		var penalties []float64
+		for _, item := range approval.LineItems {
+			if item.Code == "late_penalty" {
+				penalties = append(penalties, item.Amount)
+			}
+		}
+		assert.Equal(t, []float64{50000, 200000}, penalties)
+	})
+
+	// Cleanup
+	db.Where("1 = 1").Delete(&models.CompanyRule{})
+	db.Where("1 = 1").Delete(&models.SalaryApproval{})
+	db.Where("1 = 1").Delete(&models.ShiftAssignment{})
+	db.Where("1 = 1").Delete(&models.Shift{})
+	db.Where("1 = 1").Delete(&models.Absence{})
+	db.Where("1 = 1").Delete(&models.Attendance{})
+	for _, u := range []models.User{rootUser, punctual, tardy} {
+		db.Unscoped().Delete(&u)
+	}
+}
@@ -0,0 +1,176 @@
+package test
+
+import (
+	"bytes"
+	"dapp_timekeeping/handlers"
+	"dapp_timekeeping/middleware"
+	"dapp_timekeeping/models"
+	"dapp_timekeeping/types"
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShiftSchedules(t *testing.T) {
+	app, db := SetupTest(t)
+
+	app.Post("/shifts", handlers.CreateShift)
+	app.Post("/shifts/assignments", handlers.AssignShift)
+	app.Get("/ranking", handlers.GetEmployeeWorkHoursRanking)
+	emp := app.Group("/employee", middleware.RequireAuth)
+	emp.Post("/check-in", handlers.CheckIn)
+	emp.Get("/schedule", handlers.GetMySchedule)
+
+	post := func(path string, payload interface{}) (int, types.APIResponse) {
+		body, _ := json.Marshal(payload)
+		req := httptest.NewRequest("POST", path, bytes.NewBuffer(body))
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := app.Test(req)
+		assert.NoError(t, err)
+
+		var response types.APIResponse
+		json.NewDecoder(resp.Body).Decode(&response)
+		t.Logf("POST %s -> %d %+v", path, resp.StatusCode, response)
+		return resp.StatusCode, response
+	}
+
+	employees := []models.User{
+		{ID: uuid.New().String(), Nickname: "morning", FullName: "Morning Worker", Department: "Support", Role: "employee", Status: "active"},
+		{ID: uuid.New().String(), Nickname: "night", FullName: "Night Worker", Department: "Support", Role: "employee", Status: "active"},
+	}
+	for i := range employees {
+		assert.NoError(t, db.Create(&employees[i]).Error)
+	}
+
+	var morningID, allDayID string
+
+	t.Run("Create Shifts", func(t *testing.T) {
+		status, response := post("/shifts", handlers.CreateShiftRequest{
+			Name:      "morning",
+			StartTime: "08:00",
+			EndTime:   "17:00",
+			WorkDays:  []int{0, 1, 2, 3, 4, 5, 6},
+			Breaks:    []handlers.ShiftBreakRequest{{StartTime: "12:00", EndTime: "13:00"}},
+		})
+		assert.Equal(t, 200, status)
+		morningID = response.Data.(map[string]interface{})["id"].(string)
+
+		status, response = post("/shifts", handlers.CreateShiftRequest{
+			Name:      "all-day",
+			StartTime: "00:00",
+			EndTime:   "23:59",
+			WorkDays:  []int{0, 1, 2, 3, 4, 5, 6},
+		})
+		assert.Equal(t, 200, status)
+		allDayID = response.Data.(map[string]interface{})["id"].(string)
+	})
+
+	t.Run("Reject Break Outside Shift", func(t *testing.T) {
+		status, _ := post("/shifts", handlers.CreateShiftRequest{
+			Name:      "broken",
+			StartTime: "08:00",
+			EndTime:   "12:00",
+			Breaks:    []handlers.ShiftBreakRequest{{StartTime: "11:30", EndTime: "12:30"}},
+		})
+		assert.Equal(t, 400, status)
+	})
+
+	t.Run("Assign Shifts", func(t *testing.T) {
+		// Whole department works mornings, the night worker has a personal override
+		status, _ := post("/shifts/assignments", handlers.AssignShiftRequest{
+			ShiftID:       morningID,
+			Department:    "Support",
+			EffectiveFrom: "2024-01-01",
+		})
+		assert.Equal(t, 200, status)
+
+		status, _ = post("/shifts/assignments", handlers.AssignShiftRequest{
+			ShiftID:       allDayID,
+			UserID:        employees[1].ID,
+			EffectiveFrom: "2024-01-01",
+		})
+		assert.Equal(t, 200, status)
+
+		status, _ = post("/shifts/assignments", handlers.AssignShiftRequest{
+			ShiftID:       morningID,
+			UserID:        employees[0].ID,
+			Department:    "Support",
+			EffectiveFrom: "2024-01-01",
+		})
+		assert.Equal(t, 400, status, "Assignment needs exactly one target")
+	})
+
+	t.Run("Schedule Reflects Assignment", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/employee/schedule?from=2024-02-01&to=2024-02-01", nil)
+		req.Header.Set("Authorization", "Bearer "+createTestToken(employees[0].ID, "employee"))
+		resp, err := app.Test(req)
+		assert.NoError(t, err)
+		assert.Equal(t, 200, resp.StatusCode)
+
+		var response types.APIResponse
+		json.NewDecoder(resp.Body).Decode(&response)
+		days := response.Data.([]interface{})
+		assert.Len(t, days, 1)
+		day := days[0].(map[string]interface{})
+		assert.Equal(t, "morning", day["shift_name"])
+		assert.Equal(t, float64(8), day["expected_hours"])
+	})
+
+	t.Run("Check In Uses Schedule", func(t *testing.T) {
+		req := httptest.NewRequest("POST", "/employee/check-in", nil)
+		req.Header.Set("Authorization", "Bearer "+createTestToken(employees[1].ID, "employee"))
+		resp, err := app.Test(req)
+		assert.NoError(t, err)
+		assert.Equal(t, 200, resp.StatusCode)
+
+		var attendance models.Attendance
+		assert.NoError(t, db.Where("user_id = ?", employees[1].ID).First(&attendance).Error)
+		now := time.Now()
+		midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.Local)
+		assert.True(t, attendance.ExpectedTime.Equal(midnight), "Expected time should be the all-day shift start")
+		assert.False(t, attendance.OnTime)
+
+		db.Unscoped().Delete(&attendance)
+	})
+
+	t.Run("Ranking Counts Scheduled Hours", func(t *testing.T) {
+		day := time.Date(2024, 2, 1, 0, 0, 0, 0, time.Local)
+		attendance := models.Attendance{
+			ID:           uuid.New().String(),
+			UserID:       employees[0].ID,
+			CheckInTime:  day.Add(8*time.Hour + 30*time.Minute),
+			CheckOutTime: day.Add(17*time.Hour + 30*time.Minute),
+			ExpectedTime: day.Add(8 * time.Hour),
+		}
+		assert.NoError(t, db.Create(&attendance).Error)
+
+		resp, err := app.Test(httptest.NewRequest("GET", "/ranking", nil))
+		assert.NoError(t, err)
+		assert.Equal(t, 200, resp.StatusCode)
+
+		var response types.APIResponse
+		json.NewDecoder(resp.Body).Decode(&response)
+		ranking := response.Data.([]interface{})
+		assert.Len(t, ranking, 2)
+
+		top := ranking[0].(map[string]interface{})
+		assert.Equal(t, employees[0].ID, top["employee_id"])
+		// 08:30-17:00 inside the shift minus the one hour break
+		assert.Equal(t, "07:30:00", top["work_hours"])
+		assert.Equal(t, "08:00:00", top["expected_hours"])
+
+		db.Unscoped().Delete(&attendance)
+	})
+
+	// Cleanup
+	db.Exec("DELETE FROM shift_assignments")
+	db.Exec("DELETE FROM shift_breaks")
+	db.Exec("DELETE FROM shifts")
+	for _, e := range employees {
+		db.Unscoped().Delete(&e)
+	}
+}
@@ -0,0 +1,144 @@
+package test
+
+import (
+	"bytes"
+	"dapp_timekeeping/handlers"
+	"dapp_timekeeping/middleware"
+	"dapp_timekeeping/models"
+	"dapp_timekeeping/types"
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestSessions(t *testing.T) {
+	app, db := SetupTest(t)
+	app.Post("/login", handlers.Login)
+	app.Post("/refresh", handlers.RefreshSession)
+	app.Post("/logout", middleware.RequireAuth, handlers.Logout)
+	app.Get("/employee/leave-requests", middleware.RequireAuth, handlers.GetMyLeaveRequests)
+	root := app.Group("/root", middleware.RequireRoot)
+	root.Put("/employees/:id", handlers.UpdateEmployee)
+	root.Post("/employees/:id/logout-everywhere", handlers.LogoutEverywhere)
+
+	hash, _ := bcrypt.GenerateFromPassword([]byte("correct horse"), bcrypt.MinCost)
+	rootUser := models.User{ID: uuid.New().String(), Nickname: "session_root", Role: "root", Status: "active"}
+	alice := models.User{ID: uuid.New().String(), Nickname: "alice", Role: "employee", Status: "active", PasswordHash: string(hash)}
+	for _, u := range []*models.User{&rootUser, &alice} {
+		assert.NoError(t, db.Create(u).Error)
+	}
+	rootToken := createTestToken(rootUser.ID, "root")
+
+	doRequest := func(method, path, token string, payload interface{}) (int, types.APIResponse) {
+		b, _ := json.Marshal(payload)
+		req := httptest.NewRequest(method, path, bytes.NewBuffer(b))
+		req.Header.Set("Content-Type", "application/json")
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := app.Test(req, -1)
+		assert.NoError(t, err)
+
+		var response types.APIResponse
+		json.NewDecoder(resp.Body).Decode(&response)
+		t.Logf("%s %s -> %d %+v", method, path, resp.StatusCode, response.Error)
+		return resp.StatusCode, response
+	}
+	login := func() (string, string) {
+		status, response := doRequest("POST", "/login", "", handlers.LoginRequest{Nickname: "alice", Password: "correct horse"})
+		assert.Equal(t, 200, status)
+		data := response.Data.(map[string]interface{})
+		return data["token"].(string), data["refresh_token"].(string)
+	}
+	refresh := func(refreshToken string) (int, string, string) {
+		status, response := doRequest("POST", "/refresh", "", handlers.RefreshRequest{RefreshToken: refreshToken})
+		if status != 200 {
+			return status, "", ""
+		}
+		data := response.Data.(map[string]interface{})
+		return status, data["token"].(string), data["refresh_token"].(string)
+	}
+	profile := func(token string) int {
+		status, _ := doRequest("GET", "/employee/leave-requests", token, nil)
+		return status
+	}
+
+	t.Run("Refresh Rotates Tokens", func(t *testing.T) {
+		_, first := login()
+		status, access, second := refresh(first)
+		assert.Equal(t, 200, status)
+		assert.NotEqual(t, first, second)
+		assert.Equal(t, 200, profile(access))
+
+		status, _, _ = refresh("not a refresh token")
+		assert.Equal(t, 401, status)
+
+		var stored int64
+		db.Model(&models.RefreshToken{}).Where("token_hash = ?", second).Count(&stored)
+		assert.Zero(t, stored, "Refresh tokens are stored hashed")
+	})
+
+	t.Run("Reuse Revokes Session", func(t *testing.T) {
+		_, first := login()
+		_, access, second := refresh(first)
+
+		status, _, _ := refresh(first)
+		assert.Equal(t, 401, status)
+		status, _, _ = refresh(second)
+		assert.Equal(t, 401, status, "The whole family is revoked")
+		assert.Equal(t, 401, profile(access))
+	})
+
+	t.Run("Logout", func(t *testing.T) {
+		access, refreshToken := login()
+		other, otherRefresh := login()
+		assert.Equal(t, 200, profile(access))
+
+		status, _ := doRequest("POST", "/logout", access, nil)
+		assert.Equal(t, 200, status)
+		assert.Equal(t, 401, profile(access))
+		status, _, _ = refresh(refreshToken)
+		assert.Equal(t, 401, status)
+
+		// Other sessions are untouched
+		assert.Equal(t, 200, profile(other))
+		status, _, _ = refresh(otherRefresh)
+		assert.Equal(t, 200, status)
+	})
+
+	t.Run("Logout Everywhere", func(t *testing.T) {
+		access, refreshToken := login()
+		other, _ := login()
+
+		status, _ := doRequest("POST", "/root/employees/"+alice.ID+"/logout-everywhere", rootToken, nil)
+		assert.Equal(t, 200, status)
+		assert.Equal(t, 401, profile(access))
+		assert.Equal(t, 401, profile(other))
+		status, _, _ = refresh(refreshToken)
+		assert.Equal(t, 401, status)
+
+		status, _ = doRequest("POST", "/root/employees/"+uuid.New().String()+"/logout-everywhere", rootToken, nil)
+		assert.Equal(t, 404, status)
+	})
+
+	t.Run("Leaving Revokes Sessions", func(t *testing.T) {
+		access, refreshToken := login()
+
+		status, _ := doRequest("PUT", "/root/employees/"+alice.ID, rootToken, map[string]interface{}{"status": "left_company"})
+		assert.Equal(t, 200, status)
+		assert.Equal(t, 401, profile(access))
+		status, _, _ = refresh(refreshToken)
+		assert.Equal(t, 401, status)
+	})
+
+	// Cleanup
+	db.Where("user_id = ?", alice.ID).Delete(&models.RefreshToken{})
+	db.Where("user_id = ?", alice.ID).Delete(&models.RevokedToken{})
+	for _, u := range []models.User{rootUser, alice} {
+		db.Unscoped().Delete(&u)
+	}
+}
@@ -0,0 +1,192 @@
+package test
+
+import (
+	"bytes"
+	"dapp_timekeeping/config"
+	"dapp_timekeeping/handlers"
+	"dapp_timekeeping/middleware"
+	"dapp_timekeeping/models"
+	"dapp_timekeeping/types"
+	"encoding/json"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStatutoryDeductions(t *testing.T) {
+	app, db := SetupTest(t)
+
+	root := app.Group("/root", middleware.RequireRoot)
+	root.Post("/payroll/run", handlers.RunPayroll)
+
+	longAgo := time.Date(2023, 1, 1, 0, 0, 0, 0, time.Local)
+	rootUser := models.User{ID: uuid.New().String(), Nickname: "statutory_root", Role: "root", Status: "active", OnboardDate: longAgo}
+	insured := models.User{
+		ID:                 uuid.New().String(),
+		Nickname:           "insured",
+		Role:               "employee",
+		Status:             "active",
+		Salary:             30000000,
+		OnboardDate:        longAgo,
+		TaxID:              "8001234567",
+		SocialInsuranceID:  "7912345678",
+		HealthInsuranceID:  "DN4797912345678",
+		NumberOfDependents: 2,
+	}
+	highEarner := models.User{
+		ID:                uuid.New().String(),
+		Nickname:          "high_earner",
+		Role:              "employee",
+		Status:            "active",
+		Salary:            100000000,
+		OnboardDate:       longAgo,
+		SocialInsuranceID: "7998765432",
+		HealthInsuranceID: "DN4797998765432",
+	}
+	for _, u := range []*models.User{&rootUser, &insured, &highEarner} {
+		assert.NoError(t, db.Create(u).Error)
+	}
+	rootToken := createTestToken(rootUser.ID, "root")
+
+	runPayroll := func(month string) {
+		body, _ := json.Marshal(handlers.RunPayrollRequest{Month: month})
+		req := httptest.NewRequest("POST", "/root/payroll/run", bytes.NewBuffer(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+rootToken)
+		resp, err := app.Test(req)
+		assert.NoError(t, err)
+		assert.Equal(t, 200, resp.StatusCode)
+
+		var response types.APIResponse
+		json.NewDecoder(resp.Body).Decode(&response)
+		t.Logf("POST /root/payroll/run %s -> %d %+v", month, resp.StatusCode, response)
+	}
+
+	salaryOf := func(userID string, month time.Time) (models.SalaryApproval, map[string]float64) {
+		var approval models.SalaryApproval
+		assert.NoError(t, db.Preload("LineItems").First(&approval, "user_id = ? AND month = ?", userID, month).Error)
+		amounts := make(map[string]float64)
+		for _, item := range approval.LineItems {
+			amounts[item.Code] = item.Amount
+		}
+		return approval, amounts
+	}
+
+	february := time.Date(2024, 2, 1, 0, 0, 0, 0, time.Local)
+	august := time.Date(2024, 8, 1, 0, 0, 0, 0, time.Local)
+	february2026 := time.Date(2026, 2, 1, 0, 0, 0, 0, time.Local)
+	for _, month := range []time.Time{february, august, february2026} {
+		attendWorkDays(t, db, insured.ID, month)
+		attendWorkDays(t, db, highEarner.ID, month)
+	}
+
+	t.Run("Contributions And Progressive Tax", func(t *testing.T) {
+		runPayroll("2024-02")
+
+		approval, amounts := salaryOf(insured.ID, february)
+		assert.Equal(t, float64(2400000), amounts["bhxh"])
+		assert.Equal(t, float64(450000), amounts["bhyt"])
+		assert.Equal(t, float64(300000), amounts["bhtn"])
+		assert.Equal(t, float64(5250000), amounts["bhxh_employer"])
+		// 30M - 3.15M insurance - 11M personal - 2 x 4.4M dependents = 7.05M taxable
+		assert.Equal(t, float64(455000), amounts["pit"])
+		assert.Equal(t, float64(3605000), approval.Deductions)
+		assert.Equal(t, float64(26395000), approval.FinalSalary, "Employer contributions are not deducted")
+	})
+
+	t.Run("Salary Caps Follow Rate Version", func(t *testing.T) {
+		// Capped at 20 x 1.8M in February, 20 x 2.34M from July 2024
+		_, amounts := salaryOf(highEarner.ID, february)
+		assert.Equal(t, float64(2880000), amounts["bhxh"])
+
+		runPayroll("2024-08")
+		_, amounts = salaryOf(highEarner.ID, august)
+		assert.Equal(t, float64(3744000), amounts["bhxh"])
+		assert.Equal(t, float64(702000), amounts["bhyt"])
+		assert.Equal(t, float64(992000), amounts["bhtn"], "Capped at 20 x region I minimum wage")
+		assert.Equal(t, float64(19396700), amounts["pit"])
+	})
+
+	t.Run("2026 Rates", func(t *testing.T) {
+		runPayroll("2026-02")
+
+		// 30M - 3.15M insurance - 15.5M personal - 2 x 6.2M dependents is below zero
+		_, amounts := salaryOf(insured.ID, february2026)
+		assert.Equal(t, float64(300000), amounts["bhtn"])
+		assert.Zero(t, amounts["pit"])
+
+		_, amounts = salaryOf(highEarner.ID, february2026)
+		assert.Equal(t, float64(3744000), amounts["bhxh"])
+		assert.Equal(t, float64(1000000), amounts["bhtn"], "Below the new cap of 20 x 5.31M")
+		// 100M - 5.446M insurance - 15.5M personal = 79.054M taxable: 0.5M + 2M + 6M + 30% of 19.054M
+		assert.Equal(t, float64(14216200), amounts["pit"])
+	})
+
+	t.Run("Rates Loaded From File", func(t *testing.T) {
+		original := config.StatutoryRateVersions()
+		restore := filepath.Join(t.TempDir(), "restore.json")
+		data, _ := json.Marshal(original)
+		assert.NoError(t, os.WriteFile(restore, data, 0o600))
+		defer config.LoadStatutoryRates(restore)
+
+		var raised config.StatutoryRates
+		for _, v := range original {
+			if v.Version == "2024-07" {
+				raised = v
+			}
+		}
+		raised.Version = "2024-08-test"
+		raised.EffectiveFrom = "2024-08-01"
+		raised.PersonalAllowance = 15500000
+		path := filepath.Join(t.TempDir(), "rates.json")
+		data, _ = json.Marshal(append(original, raised))
+		assert.NoError(t, os.WriteFile(path, data, 0o600))
+		assert.NoError(t, config.LoadStatutoryRates(path))
+
+		runPayroll("2024-08")
+		_, amounts := salaryOf(highEarner.ID, august)
+		assert.Equal(t, float64(17868600), amounts["pit"])
+
+		// Earlier months keep the rates that applied then
+		rates, ok := config.StatutoryRatesFor(february)
+		assert.True(t, ok)
+		assert.Equal(t, "2023-07", rates.Version)
+	})
+
+	t.Run("Invalid Rate Files", func(t *testing.T) {
+		valid := config.StatutoryRateVersions()[0]
+		for name, edit := range map[string]func(*config.StatutoryRates){
+			"bad date":       func(v *config.StatutoryRates) { v.EffectiveFrom = "2024-13-01" },
+			"no top bracket": func(v *config.StatutoryRates) { v.TaxBrackets = []config.TaxBracket{{UpTo: 5000000, Rate: 0.05}} },
+			"descending": func(v *config.StatutoryRates) {
+				v.TaxBrackets = []config.TaxBracket{{UpTo: 10000000, Rate: 0.05}, {UpTo: 5000000, Rate: 0.10}, {Rate: 0.35}}
+			},
+			"percent rate": func(v *config.StatutoryRates) {
+				v.TaxBrackets = []config.TaxBracket{{UpTo: 5000000, Rate: 5}, {Rate: 0.35}}
+			},
+			"negative contribution": func(v *config.StatutoryRates) { v.Employer.HealthInsurance = -0.03 },
+			"contribution percent":  func(v *config.StatutoryRates) { v.Employee.SocialInsurance = 8 },
+		} {
+			version := valid
+			version.Version = name
+			edit(&version)
+			path := filepath.Join(t.TempDir(), "rates.json")
+			data, _ := json.Marshal([]config.StatutoryRates{version})
+			assert.NoError(t, os.WriteFile(path, data, 0o600))
+			assert.Error(t, config.LoadStatutoryRates(path), name)
+		}
+		assert.Equal(t, valid.Version, config.StatutoryRateVersions()[0].Version, "A rejected file leaves the rates in place")
+	})
+
+	// Cleanup
+	db.Exec("DELETE FROM salary_line_items")
+	db.Exec("DELETE FROM salary_approvals")
+	for _, u := range []models.User{rootUser, insured, highEarner} {
+		db.Unscoped().Delete(&u)
+	}
+}
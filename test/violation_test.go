@@ -0,0 +1,179 @@
+package test
+
+import (
+	"bytes"
+	"dapp_timekeeping/handlers"
+	"dapp_timekeeping/middleware"
+	"dapp_timekeeping/models"
+	"dapp_timekeeping/types"
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestViolations(t *testing.T) {
+	app, db := SetupTest(t)
+
+	hr := app.Group("/hr")
+	hr.Get("/violations", middleware.RequirePermission("violation_management"), handlers.ListViolations)
+	hr.Post("/violations", middleware.RequirePermission("violation_management"), handlers.RecordViolation)
+	emp := app.Group("/employee", middleware.RequireAuth)
+	emp.Get("/violations", handlers.GetMyViolations)
+	root := app.Group("/root", middleware.RequireRoot)
+	root.Post("/violations/detect", handlers.DetectViolations)
+
+	longAgo := time.Date(2020, 1, 1, 0, 0, 0, 0, time.Local)
+	rootUser := models.User{ID: uuid.New().String(), Nickname: "violation_root", Role: "root", Status: "active"}
+	manager := models.User{ID: uuid.New().String(), Nickname: "sales_manager", Role: "hr_manager", Department: "Sales", Status: "active"}
+	seller := models.User{ID: uuid.New().String(), Nickname: "seller", Role: "employee", Department: "Sales", Status: "active", OnboardDate: longAgo}
+	engineer := models.User{ID: uuid.New().String(), Nickname: "engineer", Role: "employee", Department: "IT", Status: "active", OnboardDate: longAgo}
+	for _, u := range []*models.User{&rootUser, &manager, &seller, &engineer} {
+		assert.NoError(t, db.Create(u).Error)
+	}
+	rootToken := createTestToken(rootUser.ID, "root")
+	managerToken := createTestToken(manager.ID, "hr_manager")
+
+	// A 09:00-18:00 shift every day and penalties for each violation type
+	shift := models.Shift{ID: uuid.New().String(), Name: "violation_daily", StartTime: "09:00", EndTime: "18:00", WorkDays: "0,1,2,3,4,5,6"}
+	assert.NoError(t, db.Create(&shift).Error)
+	for _, u := range []models.User{seller, engineer} {
+		userID := u.ID
+		assert.NoError(t, db.Create(&models.ShiftAssignment{ID: uuid.New().String(), ShiftID: shift.ID, UserID: &userID, EffectiveFrom: longAgo}).Error)
+	}
+	rule := models.CompanyRule{EffectiveFrom: longAgo, LatePenalty: 50000, EarlyLeavePenalty: 30000, MissingCheckOutPenalty: 20000, MaxUnpaidAbsences: 3, StandardDailyHours: 8, OvertimeWeekdayRate: 1.5, OvertimeWeekendRate: 2, OvertimeHolidayRate: 3, CreatedAt: longAgo}
+	assert.NoError(t, db.Create(&rule).Error)
+
+	at := func(offset, hour, minute int) time.Time {
+		d := time.Now().AddDate(0, 0, offset)
+		return time.Date(d.Year(), d.Month(), d.Day(), hour, minute, 0, 0, time.Local)
+	}
+	attend := func(user models.User, in, out, expected time.Time) models.Attendance {
+		a := models.Attendance{ID: uuid.New().String(), UserID: user.ID, CheckInTime: in, CheckOutTime: out, ExpectedTime: expected, OnTime: !in.After(expected), CreatedAt: in, UpdatedAt: in}
+		assert.NoError(t, db.Create(&a).Error)
+		return a
+	}
+
+	// Seller: on leave, then not turning up, half an hour late and two
+	// hours early, no check-out and on leave again
+	lateAndEarly := attend(seller, at(-3, 9, 30), at(-3, 16, 0), at(-3, 9, 0))
+	attend(seller, at(-2, 8, 55), time.Time{}, at(-2, 9, 0))
+	// Engineer: not turning up, absent without permission for two days,
+	// late with permission, then a normal day
+	attend(engineer, at(-2, 10, 0), at(-2, 18, 0), at(-2, 9, 0))
+	attend(engineer, at(-1, 9, 0), at(-1, 18, 0), at(-1, 9, 0))
+	for _, a := range []models.Absence{
+		{UserID: seller.ID, Date: at(-5, 0, 0), StartDate: at(-5, 0, 0), EndDate: at(-5, 0, 0), Type: "leave_with_permission", Reason: "Holiday", Status: "approved"},
+		{UserID: seller.ID, Date: at(-1, 0, 0), StartDate: at(-1, 0, 0), EndDate: at(-1, 0, 0), Type: "leave_with_permission", Reason: "Holiday", Status: "approved"},
+		{UserID: manager.ID, Date: at(-5, 0, 0), StartDate: at(-5, 0, 0), EndDate: at(-1, 0, 0), Type: "leave_with_permission", Reason: "Holiday", Status: "approved"},
+		{UserID: engineer.ID, Date: at(-2, 0, 0), StartDate: at(-2, 0, 0), EndDate: at(-2, 0, 0), Type: "late_with_permission", Reason: "Doctor", Status: "approved"},
+		{UserID: engineer.ID, Date: at(-4, 0, 0), StartDate: at(-4, 0, 0), EndDate: at(-3, 0, 0), Type: "leave_without_permission", Reason: "No show", Status: "approved"},
+	} {
+		a.ID = uuid.New().String()
+		a.ProcessedBy = strPtr(rootUser.ID)
+		assert.NoError(t, db.Create(&a).Error)
+	}
+
+	doRequest := func(method, path, token string, payload interface{}) (int, types.APIResponse) {
+		b, _ := json.Marshal(payload)
+		req := httptest.NewRequest(method, path, bytes.NewBuffer(b))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := app.Test(req, -1)
+		assert.NoError(t, err)
+
+		var response types.APIResponse
+		json.NewDecoder(resp.Body).Decode(&response)
+		t.Logf("%s %s -> %d %+v", method, path, resp.StatusCode, response.Error)
+		return resp.StatusCode, response
+	}
+	day := func(offset int) string {
+		return time.Now().AddDate(0, 0, offset).Format("2006-01-02")
+	}
+
+	t.Run("Detect From Records", func(t *testing.T) {
+		detect := handlers.DetectViolationsRequest{From: day(-5), To: day(0)}
+		status, response := doRequest("POST", "/root/violations/detect", rootToken, detect)
+		assert.Equal(t, 200, status)
+		assert.Equal(t, float64(7), response.Data.(map[string]interface{})["created"])
+
+		var violations []models.Violation
+		db.Order("date, type").Find(&violations)
+		byType := map[string]models.Violation{}
+		absences := map[string]string{}
+		for _, v := range violations {
+			assert.Equal(t, "detected", v.Origin)
+			assert.Equal(t, rule.Version, v.RuleVersion)
+			if v.Type == "unauthorized_absence" {
+				absences[v.UserID+" "+v.Date.In(time.Local).Format("2006-01-02")] = *v.SourceType
+				continue
+			}
+			byType[v.Type] = v
+		}
+		assert.Equal(t, lateAndEarly.ID, *byType["late_arrival"].SourceID)
+		assert.Equal(t, "minor", byType["late_arrival"].Severity)
+		assert.Equal(t, float64(50000), byType["late_arrival"].Penalty)
+		assert.Equal(t, "major", byType["early_leave"].Severity, "Two hours early")
+		assert.Equal(t, float64(30000), byType["early_leave"].Penalty)
+		assert.Equal(t, seller.ID, byType["missing_check_out"].UserID)
+		assert.Equal(t, map[string]string{
+			seller.ID + " " + day(-4):   "schedule",
+			engineer.ID + " " + day(-5): "schedule",
+			engineer.ID + " " + day(-4): "absence",
+			engineer.ID + " " + day(-3): "absence",
+		}, absences, "No-shows and each day of the unauthorized absence; leave excuses the rest")
+
+		// Running again raises nothing new
+		status, response = doRequest("POST", "/root/violations/detect", rootToken, detect)
+		assert.Equal(t, 200, status)
+		assert.Equal(t, float64(0), response.Data.(map[string]interface{})["created"])
+	})
+
+	t.Run("Manual Entry", func(t *testing.T) {
+		penalty := 10000.0
+		status, _ := doRequest("POST", "/hr/violations", managerToken, handlers.RecordViolationRequest{UserID: seller.ID, Type: "late_arrival", Date: day(-3), Severity: "minor", SourceType: "attendance", SourceID: lateAndEarly.ID})
+		assert.Equal(t, 409, status, "Already detected")
+		status, _ = doRequest("POST", "/hr/violations", managerToken, handlers.RecordViolationRequest{UserID: engineer.ID, Type: "early_leave", Date: day(-1), Severity: "minor"})
+		assert.Equal(t, 403, status, "Outside the manager's departments")
+		status, _ = doRequest("POST", "/hr/violations", managerToken, handlers.RecordViolationRequest{UserID: seller.ID, Type: "early_leave", Date: day(-1), Severity: "severe"})
+		assert.Equal(t, 400, status)
+		status, _ = doRequest("POST", "/hr/violations", rootToken, handlers.RecordViolationRequest{UserID: engineer.ID, Type: "late_arrival", Date: day(-3), Severity: "minor", SourceType: "attendance", SourceID: lateAndEarly.ID})
+		assert.Equal(t, 404, status, "Source belongs to someone else")
+
+		status, response := doRequest("POST", "/hr/violations", managerToken, handlers.RecordViolationRequest{UserID: seller.ID, Type: "early_leave", Date: day(-1), Severity: "critical", Penalty: &penalty, Note: "Left without handover"})
+		assert.Equal(t, 200, status)
+		v := response.Data.(map[string]interface{})
+		assert.Equal(t, "manual", v["origin"])
+		assert.Equal(t, manager.ID, v["recorded_by"])
+		assert.Equal(t, penalty, v["penalty"])
+		assert.Nil(t, v["source_id"])
+	})
+
+	t.Run("List Within Scope", func(t *testing.T) {
+		status, response := doRequest("GET", "/hr/violations", managerToken, nil)
+		assert.Equal(t, 200, status)
+		assert.Len(t, response.Data, 5, "Only Sales")
+
+		status, response = doRequest("GET", "/hr/violations?type=early_leave", rootToken, nil)
+		assert.Equal(t, 200, status)
+		assert.Len(t, response.Data, 2)
+
+		status, response = doRequest("GET", "/employee/violations", createTestToken(engineer.ID, "employee"), nil)
+		assert.Equal(t, 200, status)
+		assert.Len(t, response.Data, 3)
+	})
+
+	// Cleanup
+	db.Where("1 = 1").Delete(&models.Violation{})
+	db.Where("1 = 1").Delete(&models.CompanyRule{})
+	db.Where("1 = 1").Delete(&models.ShiftAssignment{})
+	db.Where("1 = 1").Delete(&models.Shift{})
+	db.Where("1 = 1").Delete(&models.Absence{})
+	db.Where("1 = 1").Delete(&models.Attendance{})
+	for _, u := range []models.User{rootUser, manager, seller, engineer} {
+		db.Unscoped().Delete(&u)
+	}
+}
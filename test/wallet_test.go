@@ -0,0 +1,148 @@
+package test
+
+import (
+	"bytes"
+	"dapp_timekeeping/auth"
+	"dapp_timekeeping/handlers"
+	"dapp_timekeeping/middleware"
+	"dapp_timekeeping/models"
+	"dapp_timekeeping/types"
+	"encoding/hex"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/decred/dcrd/dcrec/secp256k1/v4"
+	"github.com/decred/dcrd/dcrec/secp256k1/v4/ecdsa"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+// personalSign signs message like an Ethereum wallet's personal_sign,
+// returning R || S || V with V 27 or 28
+func personalSign(key *secp256k1.PrivateKey, message string) string {
+	compact := ecdsa.SignCompact(key, auth.PersonalMessageHash(message), false)
+	sig := append(append([]byte{}, compact[1:]...), compact[0])
+	return "0x" + hex.EncodeToString(sig)
+}
+
+func TestWalletLogin(t *testing.T) {
+	app, db := SetupTest(t)
+	app.Post("/login/wallet/challenge", handlers.IssueWalletChallenge)
+	app.Post("/login/wallet", handlers.LoginWithWallet)
+	app.Get("/employee/leave-requests", middleware.RequireAuth, handlers.GetMyLeaveRequests)
+
+	// Well known test key and its address
+	known, _ := hex.DecodeString("4c0883a69102937d6231471b5dbb6204fe5129617082792ae468d01a3f362318")
+	aliceKey := secp256k1.PrivKeyFromBytes(known)
+	aliceAddress := "0x2c7536E3605D9C16a7a3D7b1898e529396a65c23"
+	strangerKey, err := secp256k1.GeneratePrivateKey()
+	assert.NoError(t, err)
+
+	alice := models.User{ID: uuid.New().String(), Nickname: "alice", Role: "employee", Status: "active", WalletAddress: aliceAddress}
+	leaver := models.User{ID: uuid.New().String(), Nickname: "leaver", Role: "employee", Status: "left_company"}
+	leaverKey, err := secp256k1.GeneratePrivateKey()
+	assert.NoError(t, err)
+	leaver.WalletAddress, err = auth.RecoverAddress("probe", personalSign(leaverKey, "probe"))
+	assert.NoError(t, err)
+	for _, u := range []*models.User{&alice, &leaver} {
+		assert.NoError(t, db.Create(u).Error)
+	}
+
+	doRequest := func(method, path, token string, payload interface{}) (int, types.APIResponse) {
+		b, _ := json.Marshal(payload)
+		req := httptest.NewRequest(method, path, bytes.NewBuffer(b))
+		req.Header.Set("Content-Type", "application/json")
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := app.Test(req, -1)
+		assert.NoError(t, err)
+
+		var response types.APIResponse
+		json.NewDecoder(resp.Body).Decode(&response)
+		t.Logf("%s %s -> %d %+v", method, path, resp.StatusCode, response.Error)
+		return resp.StatusCode, response
+	}
+	challenge := func(address string) (string, string) {
+		status, response := doRequest("POST", "/login/wallet/challenge", "", handlers.WalletChallengeRequest{Address: address})
+		assert.Equal(t, 200, status)
+		data := response.Data.(map[string]interface{})
+		return data["nonce"].(string), data["message"].(string)
+	}
+	login := func(address, nonce, signature string) (int, types.APIResponse) {
+		return doRequest("POST", "/login/wallet", "", handlers.WalletLoginRequest{Address: address, Nonce: nonce, Signature: signature})
+	}
+
+	t.Run("Recover Address", func(t *testing.T) {
+		signer, err := auth.RecoverAddress("hello", personalSign(aliceKey, "hello"))
+		assert.NoError(t, err)
+		assert.Equal(t, strings.ToLower(aliceAddress), signer)
+
+		_, err = auth.RecoverAddress("hello", "0x1234")
+		assert.Error(t, err)
+	})
+
+	t.Run("Login With Signature", func(t *testing.T) {
+		nonce, message := challenge(aliceAddress)
+		assert.Contains(t, message, nonce)
+		assert.Contains(t, message, strings.ToLower(aliceAddress))
+
+		status, response := login(aliceAddress, nonce, personalSign(aliceKey, message))
+		assert.Equal(t, 200, status)
+		data := response.Data.(map[string]interface{})
+		claims, err := auth.ParseToken(data["token"].(string))
+		assert.NoError(t, err)
+		assert.Equal(t, alice.ID, claims.UserID())
+		assert.NotEmpty(t, data["refresh_token"])
+
+		status, _ = doRequest("GET", "/employee/leave-requests", data["token"].(string), nil)
+		assert.Equal(t, 200, status)
+
+		status, _ = login(aliceAddress, nonce, personalSign(aliceKey, message))
+		assert.Equal(t, 401, status, "A challenge is used once")
+	})
+
+	t.Run("Rejects Wrong Signer", func(t *testing.T) {
+		nonce, message := challenge(aliceAddress)
+		status, _ := login(aliceAddress, nonce, personalSign(strangerKey, message))
+		assert.Equal(t, 401, status)
+
+		status, _ = login(aliceAddress, nonce, personalSign(aliceKey, "some other message"))
+		assert.Equal(t, 401, status)
+
+		// The challenge is still usable by its owner
+		status, _ = login(aliceAddress, nonce, personalSign(aliceKey, message))
+		assert.Equal(t, 200, status)
+	})
+
+	t.Run("Rejects Unknown And Inactive Wallets", func(t *testing.T) {
+		stranger, err := auth.RecoverAddress("probe", personalSign(strangerKey, "probe"))
+		assert.NoError(t, err)
+		nonce, message := challenge(stranger)
+		status, _ := login(stranger, nonce, personalSign(strangerKey, message))
+		assert.Equal(t, 401, status)
+
+		nonce, message = challenge(leaver.WalletAddress)
+		status, _ = login(leaver.WalletAddress, nonce, personalSign(leaverKey, message))
+		assert.Equal(t, 401, status)
+
+		status, _ = doRequest("POST", "/login/wallet/challenge", "", handlers.WalletChallengeRequest{Address: "not an address"})
+		assert.Equal(t, 400, status)
+	})
+
+	t.Run("Rejects Expired Challenge", func(t *testing.T) {
+		nonce, message := challenge(aliceAddress)
+		db.Model(&models.WalletChallenge{}).Where("nonce = ?", nonce).Update("expires_at", time.Now().Add(-time.Second))
+		status, _ := login(aliceAddress, nonce, personalSign(aliceKey, message))
+		assert.Equal(t, 401, status)
+	})
+
+	// Cleanup
+	db.Where("user_id = ?", alice.ID).Delete(&models.RefreshToken{})
+	for _, u := range []models.User{alice, leaver} {
+		db.Unscoped().Delete(&u)
+	}
+}
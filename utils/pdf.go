@@ -0,0 +1,124 @@
+package utils
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// PDF page size (A4) in points
+const (
+	PDFPageWidth  = 595.0
+	PDFPageHeight = 842.0
+)
+
+// PDF fonts, all standard Type 1 fonts so nothing needs embedding
+const (
+	FontRegular = "F1" // Helvetica
+	FontBold    = "F2" // Helvetica-Bold
+	FontMono    = "F3" // Courier
+)
+
+// PDFDocument builds simple text documents with the standard PDF fonts.
+// Those fonts cover ASCII only, so text is reduced to it; prefer HTML output
+// for anything that must keep Vietnamese accents. Coordinates are in points
+// from the bottom-left corner of the page.
+type PDFDocument struct {
+	pages []*bytes.Buffer
+}
+
+func NewPDFDocument() *PDFDocument {
+	doc := &PDFDocument{}
+	doc.AddPage()
+	return doc
+}
+
+// AddPage starts a new page; later drawing goes onto it
+func (d *PDFDocument) AddPage() {
+	d.pages = append(d.pages, &bytes.Buffer{})
+}
+
+func (d *PDFDocument) page() *bytes.Buffer {
+	return d.pages[len(d.pages)-1]
+}
+
+// Text draws s with its baseline starting at x, y
+func (d *PDFDocument) Text(font string, size, x, y float64, s string) {
+	fmt.Fprintf(d.page(), "BT /%s %.1f Tf %.2f %.2f Td (%s) Tj ET\n", font, size, x, y, pdfEscaper.Replace(pdfText(s)))
+}
+
+// TextRight draws s in the monospaced font so that it ends at x
+func (d *PDFDocument) TextRight(size, x, y float64, s string) {
+	// Courier glyphs are 600/1000 em wide
+	width := float64(len(pdfText(s))) * size * 0.6
+	d.Text(FontMono, size, x-width, y, s)
+}
+
+// Line draws a line from x1, y1 to x2, y2
+func (d *PDFDocument) Line(x1, y1, x2, y2 float64) {
+	fmt.Fprintf(d.page(), "%.2f %.2f m %.2f %.2f l S\n", x1, y1, x2, y2)
+}
+
+// Bytes serialises the document
+func (d *PDFDocument) Bytes() []byte {
+	var out bytes.Buffer
+	var offsets []int
+	object := func(body string) {
+		offsets = append(offsets, out.Len())
+		fmt.Fprintf(&out, "%d 0 obj\n%s\nendobj\n", len(offsets), body)
+	}
+
+	out.WriteString("%PDF-1.4\n")
+
+	// 1: catalog, 2: page tree, 3-5: fonts, then a page and its content per page
+	const firstPage = 6
+	kids := make([]string, len(d.pages))
+	for i := range d.pages {
+		kids[i] = fmt.Sprintf("%d 0 R", firstPage+2*i)
+	}
+	object("<< /Type /Catalog /Pages 2 0 R >>")
+	object(fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(d.pages)))
+	for _, name := range []string{"Helvetica", "Helvetica-Bold", "Courier"} {
+		object("<< /Type /Font /Subtype /Type1 /BaseFont /" + name + " /Encoding /WinAnsiEncoding >>")
+	}
+	for i, content := range d.pages {
+		object(fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %.0f %.0f] "+
+			"/Resources << /Font << /F1 3 0 R /F2 4 0 R /F3 5 0 R >> >> /Contents %d 0 R >>",
+			PDFPageWidth, PDFPageHeight, firstPage+2*i+1))
+		object(fmt.Sprintf("<< /Length %d >>\nstream\n%sendstream", content.Len(), content.String()))
+	}
+
+	xref := out.Len()
+	fmt.Fprintf(&out, "xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&out, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&out, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets)+1, xref)
+	return out.Bytes()
+}
+
+var pdfEscaper = strings.NewReplacer(`\`, `\\`, "(", `\(`, ")", `\)`)
+
+// pdfText reduces s to ASCII for the standard fonts. Accents are stripped
+// (Vietnamese "Nguyễn Đức" becomes "Nguyen Duc") and anything else outside
+// ASCII is replaced.
+func pdfText(s string) string {
+	var b strings.Builder
+	for _, r := range norm.NFD.String(s) {
+		switch {
+		case unicode.Is(unicode.Mn, r):
+			continue
+		case r == 'đ':
+			r = 'd'
+		case r == 'Đ':
+			r = 'D'
+		case r > unicode.MaxASCII || r < ' ':
+			r = '?'
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}